@@ -0,0 +1,148 @@
+package wal
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"sync"
+)
+
+// How many of the entries it has applied an Applier remembers by
+// default.
+const DefaultApplyWindow = 4096
+
+// ConflictError is returned by Applier.Apply for an entry that doesn't
+// match the one already applied for the same remote position, meaning
+// the remote WAL's history has forked from the one the local WAL
+// copied. It matches ErrReplicationConflict with errors.Is.
+type ConflictError struct {
+	// The node the entry came from.
+	Node string
+
+	// The entry's position in the remote WAL, and where the entry
+	// applied for that position is in the local one. Local is -1:-1 if
+	// nothing was applied for Remote, but entries after it were.
+	Remote Position
+	Local  Position
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("entry %s from %s conflicts with local entry %s", e.Remote, e.Node, e.Local)
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrReplicationConflict
+}
+
+// Applier writes entries replicated from another node's WAL into a
+// local one, recording each entry's origin as WriteOrigin does. Entries
+// sent again, such as after a replication stream reconnects, are
+// skipped if they match what was applied, and rejected with a
+// ConflictError if they don't, rather than appended as a forked
+// history. Remote positions may be any that increase with each entry
+// and identify it, such as those its reader reports.
+//
+// Conflicts are detected among the last Window entries applied. An
+// entry from before those is assumed to have been applied and skipped.
+type Applier struct {
+	w    *WALWriter
+	node string
+
+	// How many applied entries are remembered.
+	Window int
+
+	lock    sync.Mutex
+	applied map[Position]appliedEntry
+	order   []Position
+}
+
+type appliedEntry struct {
+	local Position
+	sum   uint32
+}
+
+// NewApplier returns an Applier of entries from node into w. The
+// entries from node already in w, such as from before a restart, are
+// read to pick up where applying left off.
+func NewApplier(w *WALWriter, node string) (*Applier, error) {
+	a := &Applier{
+		w:       w,
+		node:    node,
+		Window:  DefaultApplyWindow,
+		applied: make(map[Position]appliedEntry),
+	}
+
+	ro := DefaultReadOptions
+	ro.FS = w.opts.FS
+
+	r, err := NewReaderWithOptions(w.root, ro)
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	for r.Next() {
+		if origin := r.Header().Origin; origin.Node == node {
+			a.remember(origin.Pos, r.entryPos(), crc32.ChecksumIEEE(r.Value()))
+		}
+	}
+
+	if err := r.Error(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Apply writes value, the entry at remote in the remote WAL, unless it
+// was already applied.
+func (a *Applier) Apply(remote Position, value []byte) error {
+	return a.ApplyContext(context.Background(), remote, value)
+}
+
+// ApplyContext is like Apply, but any span created for the write is a
+// child of the one in ctx.
+func (a *Applier) ApplyContext(ctx context.Context, remote Position, value []byte) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	sum := crc32.ChecksumIEEE(value)
+
+	if ent, ok := a.applied[remote]; ok {
+		if ent.sum != sum {
+			return &ConflictError{Node: a.node, Remote: remote, Local: ent.local}
+		}
+
+		return nil
+	}
+
+	if n := len(a.order); n > 0 && remote.less(a.order[n-1]) {
+		if remote.less(a.order[0]) {
+			return nil
+		}
+
+		return &ConflictError{Node: a.node, Remote: remote, Local: Position{-1, -1}}
+	}
+
+	local, err := a.w.writeEntryAt(ctx, originType, frameOrigin(Origin{Node: a.node, Pos: remote}, value), nil)
+	if err != nil {
+		return err
+	}
+
+	a.remember(remote, local, sum)
+
+	return nil
+}
+
+// remember records that the entry at remote was applied at local,
+// forgetting the oldest beyond the window.
+func (a *Applier) remember(remote, local Position, sum uint32) {
+	a.applied[remote] = appliedEntry{local: local, sum: sum}
+	a.order = append(a.order, remote)
+
+	for len(a.order) > a.Window && a.Window > 0 {
+		delete(a.applied, a.order[0])
+		a.order = a.order[1:]
+	}
+}
@@ -0,0 +1,149 @@
+package wal
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestApplier(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	values := func() []string {
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		var values []string
+
+		for r.Next() {
+			values = append(values, string(r.Value()))
+		}
+
+		require.NoError(t, r.Error())
+
+		return values
+	}
+
+	n.It("applies entries with their origin, skipping repeats", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		a, err := NewApplier(wal, "primary")
+		require.NoError(t, err)
+
+		require.NoError(t, a.Apply(Position{0, 0}, []byte("one")))
+		require.NoError(t, a.Apply(Position{0, 10}, []byte("two")))
+
+		// Sent again after a reconnect.
+		require.NoError(t, a.Apply(Position{0, 10}, []byte("two")))
+		require.NoError(t, a.Apply(Position{0, 20}, []byte("three")))
+
+		require.NoError(t, wal.Close())
+
+		assert.Equal(t, []string{"one", "two", "three"}, values())
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.True(t, r.Next())
+		assert.Equal(t, Origin{Node: "primary", Pos: Position{0, 0}}, r.Header().Origin)
+	})
+
+	n.It("reports an entry that differs from the one applied", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		a, err := NewApplier(wal, "primary")
+		require.NoError(t, err)
+
+		require.NoError(t, a.Apply(Position{0, 0}, []byte("one")))
+
+		local, err := wal.Pos()
+		require.NoError(t, err)
+
+		require.NoError(t, a.Apply(Position{0, 10}, []byte("two")))
+		require.NoError(t, a.Apply(Position{0, 20}, []byte("three")))
+
+		err = a.Apply(Position{0, 10}, []byte("forked"))
+		require.True(t, errors.Is(err, ErrReplicationConflict))
+
+		var ce *ConflictError
+		require.True(t, errors.As(err, &ce))
+
+		assert.Equal(t, &ConflictError{Node: "primary", Remote: Position{0, 10}, Local: local}, ce)
+
+		// A position between two that were applied is a fork too.
+		err = a.Apply(Position{0, 15}, []byte("new"))
+		require.True(t, errors.As(err, &ce))
+		assert.Equal(t, Position{-1, -1}, ce.Local)
+	})
+
+	n.It("picks up where it left off after a restart", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		a, err := NewApplier(wal, "primary")
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("local")))
+		require.NoError(t, a.Apply(Position{0, 0}, []byte("one")))
+		require.NoError(t, wal.Close())
+
+		wal, err = New(path)
+		require.NoError(t, err)
+
+		a, err = NewApplier(wal, "primary")
+		require.NoError(t, err)
+
+		require.NoError(t, a.Apply(Position{0, 0}, []byte("one")))
+		assert.True(t, errors.Is(a.Apply(Position{0, 0}, []byte("other")), ErrReplicationConflict))
+		require.NoError(t, a.Apply(Position{0, 10}, []byte("two")))
+
+		require.NoError(t, wal.Close())
+
+		assert.Equal(t, []string{"local", "one", "two"}, values())
+	})
+
+	n.It("skips entries from before its window", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		a, err := NewApplier(wal, "primary")
+		require.NoError(t, err)
+
+		a.Window = 2
+
+		for i := int64(0); i < 4; i++ {
+			require.NoError(t, a.Apply(Position{0, i * 10}, []byte("entry")))
+		}
+
+		require.NoError(t, a.Apply(Position{0, 0}, []byte("anything")))
+	})
+
+	n.Meow()
+}
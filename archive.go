@@ -0,0 +1,432 @@
+package wal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Archiver is given each segment once it has been sealed by a rotation
+// so that it can be copied somewhere for safe keeping. Archive is called
+// before the segment can be pruned, but the segment may be removed as
+// soon as it returns, so implementations that copy it in the background
+// should open the file before returning.
+type Archiver interface {
+	Archive(index int, path string) error
+}
+
+// asyncArchiver is implemented by Archivers that copy segments in the
+// background, calling done with how each copy ended, so that the WAL
+// can log and count the copy itself rather than it being started.
+type asyncArchiver interface {
+	archiveAsync(index int, path string, done func(error)) error
+}
+
+// BlobStore stores named objects, such as archived segments. It's a
+// small interface so that any object store can be used for archiving
+// and restoring.
+type BlobStore interface {
+	// Put stores the contents of r under name, replacing any existing
+	// object.
+	Put(ctx context.Context, name string, r io.Reader) error
+
+	// Get returns the contents of the named object.
+	Get(ctx context.Context, name string) (io.ReadCloser, error)
+
+	// List returns the names of all objects that start with prefix, in
+	// lexical order.
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// DirBlobStore is a BlobStore that keeps each object as a file in a
+// directory. Object names may contain slashes, which become
+// subdirectories.
+type DirBlobStore struct {
+	dir string
+}
+
+func NewDirBlobStore(dir string) *DirBlobStore {
+	return &DirBlobStore{dir: dir}
+}
+
+func (d *DirBlobStore) Put(ctx context.Context, name string, r io.Reader) error {
+	path := filepath.Join(d.dir, filepath.FromSlash(name))
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it into place so a partial
+	// object is never visible.
+	tmp := path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func (d *DirBlobStore) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(d.dir, filepath.FromSlash(name)))
+}
+
+func (d *DirBlobStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+
+	err := filepath.Walk(d.dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		if fi.IsDir() || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(d.dir, path)
+		if err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(rel)
+
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+
+		return nil
+	})
+
+	sort.Strings(names)
+
+	return names, err
+}
+
+// MemoryBlobStore is a BlobStore that keeps objects in memory. It's
+// mostly useful for tests.
+type MemoryBlobStore struct {
+	lock    sync.Mutex
+	objects map[string][]byte
+}
+
+func NewMemoryBlobStore() *MemoryBlobStore {
+	return &MemoryBlobStore{objects: make(map[string][]byte)}
+}
+
+func (m *MemoryBlobStore) Put(ctx context.Context, name string, r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.objects[name] = data
+
+	return nil
+}
+
+func (m *MemoryBlobStore) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	data, ok := m.objects[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *MemoryBlobStore) List(ctx context.Context, prefix string) ([]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var names []string
+
+	for name := range m.objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+type BlobArchiveOptions struct {
+	// Prepended to the name of every segment, such as "wal/host1/".
+	Prefix string
+
+	// If true, segments are gzip compressed before being stored.
+	Compress bool
+
+	// The number of segments to store at once. If 0, 1 is used.
+	Concurrency int
+
+	// The most bytes a second read from the segments being stored,
+	// shared by all the copies running at once, so that archiving a
+	// backlog of segments doesn't starve writes of the disk. If 0, they
+	// are read as fast as the store takes them.
+	BytesPerSecond int64
+}
+
+const gzipSuffix = ".gz"
+
+// blobName returns the object name for a segment. The index is zero
+// padded so that names list in segment order.
+func blobName(prefix string, index int, compress bool) string {
+	name := fmt.Sprintf("%s%020d", prefix, index)
+	if compress {
+		name += gzipSuffix
+	}
+
+	return name
+}
+
+// BlobArchiver is an Archiver that copies sealed segments to a
+// BlobStore in the background.
+type BlobArchiver struct {
+	store BlobStore
+	opts  BlobArchiveOptions
+
+	sem   chan struct{}
+	wg    sync.WaitGroup
+	limit *rateLimiter
+
+	lock sync.Mutex
+	err  error
+}
+
+func NewBlobArchiver(store BlobStore, opts BlobArchiveOptions) *BlobArchiver {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+
+	return &BlobArchiver{
+		store: store,
+		opts:  opts,
+		sem:   make(chan struct{}, opts.Concurrency),
+		limit: newRateLimiter(opts.BytesPerSecond),
+	}
+}
+
+// Archive starts copying the segment at path to the store. The file is
+// opened before returning, so the copy completes even if the segment
+// is pruned in the meantime. It never waits for the store: if
+// Concurrency copies are already running, the copy is queued until one
+// finishes, as the WAL calls Archive while rotating and so holding up
+// writes.
+func (a *BlobArchiver) Archive(index int, path string) error {
+	return a.archiveAsync(index, path, nil)
+}
+
+func (a *BlobArchiver) archiveAsync(index int, path string, done func(error)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	a.wg.Add(1)
+
+	go func() {
+		defer a.wg.Done()
+		defer f.Close()
+
+		a.sem <- struct{}{}
+		defer func() { <-a.sem }()
+
+		body := a.body(f)
+
+		err := a.store.Put(context.Background(), blobName(a.opts.Prefix, index, a.opts.Compress), body)
+
+		// The store may not have read all of it, such as if it failed
+		// part way through.
+		body.Close()
+
+		if done != nil {
+			done(err)
+		}
+
+		if err != nil {
+			a.lock.Lock()
+			if a.err == nil {
+				a.err = err
+			}
+			a.lock.Unlock()
+		}
+	}()
+
+	return nil
+}
+
+// body returns the contents to store for f, compressing them on the fly
+// if configured to. Closing it stops the compression if the contents
+// weren't all read.
+func (a *BlobArchiver) body(f *os.File) io.ReadCloser {
+	var r io.Reader = f
+
+	if a.limit != nil {
+		r = &limitedReader{r: f, limit: a.limit}
+	}
+
+	if !a.opts.Compress {
+		return io.NopCloser(r)
+	}
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		gz := gzip.NewWriter(pw)
+
+		_, err := io.Copy(gz, r)
+		if err == nil {
+			err = gz.Close()
+		}
+
+		pw.CloseWithError(err)
+	}()
+
+	return pr
+}
+
+// Wait blocks until every copy started so far has finished and returns
+// the first error any of them hit.
+func (a *BlobArchiver) Wait() error {
+	a.wg.Wait()
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.err
+}
+
+// FetchSegment writes the archived copy of segment index to w, so that
+// a BlobArchiver can be the source the scrubber repairs segments from.
+func (a *BlobArchiver) FetchSegment(ctx context.Context, index int, w io.Writer) error {
+	rc, err := a.store.Get(ctx, blobName(a.opts.Prefix, index, a.opts.Compress))
+	if err != nil {
+		return err
+	}
+
+	defer rc.Close()
+
+	var r io.Reader = rc
+
+	if a.opts.Compress {
+		gz, err := gzip.NewReader(rc)
+		if err != nil {
+			return err
+		}
+
+		defer gz.Close()
+
+		r = gz
+	}
+
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// RestoreBlobs copies every segment stored under prefix in store into
+// dir, recreating a WAL directory that can be opened with NewReader.
+// Compressed segments are decompressed.
+func RestoreBlobs(ctx context.Context, store BlobStore, prefix, dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	names, err := store.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		err = restoreBlob(ctx, store, prefix, name, dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func restoreBlob(ctx context.Context, store BlobStore, prefix, name, dir string) error {
+	base := strings.TrimPrefix(name, prefix)
+	compressed := strings.HasSuffix(base, gzipSuffix)
+	base = strings.TrimSuffix(base, gzipSuffix)
+
+	// Skip anything that isn't a segment.
+	index, err := strconv.Atoi(base)
+	if err != nil {
+		return nil
+	}
+
+	rc, err := store.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	defer rc.Close()
+
+	var r io.Reader = rc
+
+	if compressed {
+		gz, err := gzip.NewReader(rc)
+		if err != nil {
+			return err
+		}
+
+		defer gz.Close()
+
+		r = gz
+	}
+
+	f, err := os.Create(filepath.Join(dir, strconv.Itoa(index)))
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
@@ -0,0 +1,80 @@
+package wal
+
+import (
+	"context"
+	"time"
+)
+
+// AutoTagOptions configures the tag a writer emits on its own, so that
+// recovery has a recent restart point even if the application forgets
+// to write one. Tag is written after whichever entry first takes the
+// WAL past a limit since it was last written, by the writer or the
+// application. A limit of 0 is no limit. Since tags are only written
+// after entries, Interval is the longest time the tag lags behind them,
+// not a period it's written on while the WAL is idle.
+type AutoTagOptions struct {
+	Tag []byte
+
+	// The most bytes of entries, not counting their framing.
+	Bytes int64
+
+	// The most entries.
+	Entries int64
+
+	// The longest time.
+	Interval time.Duration
+}
+
+func (a AutoTagOptions) enabled() bool {
+	return a.Tag != nil
+}
+
+func (a AutoTagOptions) valid() bool {
+	if a.Bytes < 0 || a.Entries < 0 || a.Interval < 0 {
+		return false
+	}
+
+	limited := a.Bytes > 0 || a.Entries > 0 || a.Interval > 0
+
+	return limited == a.enabled()
+}
+
+// autoTagState counts what's been written since the tag was last
+// written.
+type autoTagState struct {
+	bytes   int64
+	entries int64
+	last    time.Time
+}
+
+func (s *autoTagState) reset() {
+	*s = autoTagState{last: time.Now()}
+}
+
+// autoTag counts an entry of size bytes towards the automatic tag,
+// writing it if it's due. A failure to write the tag is logged rather
+// than failing the entry, which was written. The lock must be held.
+func (wal *WALWriter) autoTag(ctx context.Context, size int) {
+	a := wal.opts.AutoTag
+	if !a.enabled() {
+		return
+	}
+
+	s := &wal.autoTags
+
+	s.bytes += int64(size)
+	s.entries++
+
+	due := (a.Bytes > 0 && s.bytes >= a.Bytes) ||
+		(a.Entries > 0 && s.entries >= a.Entries) ||
+		(a.Interval > 0 && time.Since(s.last) >= a.Interval)
+
+	if !due {
+		return
+	}
+
+	err := wal.appendTag(ctx, tagType, a.Tag, tagKey(nil, a.Tag))
+	if err != nil {
+		wal.logger.Warn("failed to write automatic tag", "error", err)
+	}
+}
@@ -0,0 +1,134 @@
+package wal
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestAutoTag(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	tagged := func() []Position {
+		var positions []Position
+
+		err := ScanRecords(path, Position{}, func(rec Record) error {
+			if rec.Type == RecordTag && string(rec.Value) == "auto" {
+				positions = append(positions, rec.Pos)
+			}
+
+			return nil
+		})
+		require.NoError(t, err)
+
+		return positions
+	}
+
+	n.It("writes the tag every so many entries", func() {
+		wal, err := New(path, WithAutoTag([]byte("auto"), AutoTagOptions{Entries: 3}))
+		require.NoError(t, err)
+
+		for i := 0; i < 7; i++ {
+			require.NoError(t, wal.Write([]byte("hello")))
+		}
+
+		st, err := wal.Stats()
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(2), st.Tags)
+
+		pos, err := wal.TagPos([]byte("auto"))
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Close())
+
+		positions := tagged()
+		require.Equal(t, 2, len(positions))
+		assert.Equal(t, positions[1], pos)
+
+		// Recovery from the tag reads the one entry written after it.
+		r, err := BeginRecovery(path, []byte("auto"))
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		count := 0
+		for r.Next() {
+			count++
+		}
+
+		assert.Equal(t, 1, count)
+	})
+
+	n.It("writes the tag every so many bytes", func() {
+		wal, err := New(path, WithAutoTag([]byte("auto"), AutoTagOptions{Bytes: 10}))
+		require.NoError(t, err)
+
+		for i := 0; i < 4; i++ {
+			require.NoError(t, wal.Write([]byte("hello")))
+		}
+
+		require.NoError(t, wal.Close())
+
+		assert.Equal(t, 2, len(tagged()))
+	})
+
+	n.It("writes the tag once the interval has passed", func() {
+		wal, err := New(path, WithAutoTag([]byte("auto"), AutoTagOptions{Interval: 20 * time.Millisecond}))
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("hello")))
+
+		time.Sleep(30 * time.Millisecond)
+
+		require.NoError(t, wal.Write([]byte("hello")))
+		require.NoError(t, wal.Close())
+
+		assert.Equal(t, 1, len(tagged()))
+	})
+
+	n.It("counts from when the application last wrote the tag", func() {
+		wal, err := New(path, WithAutoTag([]byte("auto"), AutoTagOptions{Entries: 3}))
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("hello")))
+		require.NoError(t, wal.Write([]byte("hello")))
+		require.NoError(t, wal.WriteTag([]byte("auto")))
+		require.NoError(t, wal.Write([]byte("hello")))
+		require.NoError(t, wal.Write([]byte("hello")))
+		require.NoError(t, wal.Close())
+
+		assert.Equal(t, 1, len(tagged()))
+	})
+
+	n.It("needs a tag and a limit", func() {
+		wo := DefaultWriteOptions
+		wo.AutoTag = AutoTagOptions{Tag: []byte("auto")}
+
+		assert.True(t, errors.Is(wo.Validate(), ErrInvalidOptions))
+
+		wo.AutoTag = AutoTagOptions{Entries: 10}
+
+		assert.True(t, errors.Is(wo.Validate(), ErrInvalidOptions))
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,476 @@
+package wal
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// The name of the manifest within a backup archive.
+const manifestName = "MANIFEST.json"
+
+const manifestVersion = 1
+
+var (
+	ErrBadArchive  = errors.New("invalid backup archive")
+	ErrDirNotEmpty = errors.New("directory is not empty")
+	ErrBadChecksum = errors.New("segment checksum does not match manifest")
+)
+
+// Manifest describes the segments in a backup archive.
+type Manifest struct {
+	Version  int               `json:"version"`
+	Segments []ManifestSegment `json:"segments"`
+}
+
+type ManifestSegment struct {
+	Index  int    `json:"index"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// listSegments returns the indexes of the segments in root, in order.
+func listSegments(root string) ([]int, error) {
+	f, err := os.Open(root)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	var indexes []int
+
+	for _, name := range names {
+		i, err := strconv.Atoi(name)
+		if err == nil {
+			indexes = append(indexes, i)
+		}
+	}
+
+	sort.Ints(indexes)
+
+	return indexes, nil
+}
+
+// Export writes a gzipped tar archive of the WAL in root to w. The
+// archive holds every segment followed by a manifest of their sizes and
+// checksums, which Import verifies. The tag cache isn't included since
+// it's rebuilt on open.
+//
+// The directory should not be written to during the export, so run it
+// against a closed WAL or a filesystem snapshot. Use WALWriter.Snapshot
+// to back up a WAL that's in use.
+func Export(root string, w io.Writer) error {
+	indexes, err := listSegments(root)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	manifest := Manifest{Version: manifestVersion}
+
+	for _, index := range indexes {
+		seg, err := exportSegment(tw, root, index)
+		if err != nil {
+			return err
+		}
+
+		manifest.Segments = append(manifest.Segments, seg)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name: manifestName,
+		Mode: 0644,
+		Size: int64(len(data)),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = tw.Write(data)
+	if err != nil {
+		return err
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+func exportSegment(tw *tar.Writer, root string, index int) (ManifestSegment, error) {
+	name := strconv.Itoa(index)
+
+	f, err := os.Open(filepath.Join(root, name))
+	if err != nil {
+		return ManifestSegment{}, err
+	}
+
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return ManifestSegment{}, err
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+	})
+	if err != nil {
+		return ManifestSegment{}, err
+	}
+
+	h := sha256.New()
+
+	// Copy exactly the size in the header, so a segment that's grown
+	// since the stat doesn't corrupt the archive.
+	_, err = io.CopyN(io.MultiWriter(tw, h), f, fi.Size())
+	if err != nil {
+		return ManifestSegment{}, err
+	}
+
+	return ManifestSegment{
+		Index:  index,
+		Size:   fi.Size(),
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// Import restores a backup archive written by Export into root, which
+// must not exist or be empty. The archive is unpacked into a temporary
+// directory beside root and every segment is checked against the
+// manifest and read through to verify its entries before the directory
+// is moved into place, so root is never left partially restored.
+func Import(r io.Reader, root string) error {
+	err := checkEmptyDir(root)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempDir(filepath.Dir(root), filepath.Base(root)+".import")
+	if err != nil {
+		return err
+	}
+
+	// TempDir makes a directory only its owner can use. Give it the
+	// permissions a new WAL directory gets, or those of the empty one
+	// it's replacing.
+	mode := os.FileMode(0755)
+
+	if fi, err := os.Stat(root); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	err = os.Chmod(tmp, mode)
+	if err == nil {
+		err = importArchive(r, tmp)
+	}
+
+	if err == nil {
+		err = os.Remove(root)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	}
+
+	if err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, root)
+}
+
+// checkEmptyDir returns ErrDirNotEmpty if path is a directory with
+// anything in it.
+func checkEmptyDir(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	defer f.Close()
+
+	names, err := f.Readdirnames(1)
+	if len(names) > 0 {
+		return ErrDirNotEmpty
+	}
+
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	return nil
+}
+
+func importArchive(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+
+	var (
+		manifest  *Manifest
+		checksums = map[int]ManifestSegment{}
+	)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if hdr.Name == manifestName {
+			manifest = &Manifest{}
+
+			err = json.NewDecoder(tr).Decode(manifest)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		// Only accept segment names, which also keeps entries from
+		// escaping the directory.
+		index, err := strconv.Atoi(hdr.Name)
+		if err != nil || index < 0 || hdr.Typeflag != tar.TypeReg {
+			return fmt.Errorf("%w: unexpected entry %q", ErrBadArchive, hdr.Name)
+		}
+
+		seg, err := importSegment(tr, dir, index)
+		if err != nil {
+			return err
+		}
+
+		checksums[index] = seg
+	}
+
+	if manifest == nil {
+		return fmt.Errorf("%w: missing manifest", ErrBadArchive)
+	}
+
+	if manifest.Version != manifestVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrBadArchive, manifest.Version)
+	}
+
+	if len(manifest.Segments) != len(checksums) {
+		return fmt.Errorf("%w: manifest lists %d segments, archive has %d", ErrBadArchive, len(manifest.Segments), len(checksums))
+	}
+
+	for _, want := range manifest.Segments {
+		got, ok := checksums[want.Index]
+		if !ok {
+			return fmt.Errorf("%w: missing segment %d", ErrBadArchive, want.Index)
+		}
+
+		if got != want {
+			return fmt.Errorf("%w: segment %d", ErrBadChecksum, want.Index)
+		}
+
+		err = verifyEntries(filepath.Join(dir, strconv.Itoa(want.Index)))
+		if err != nil {
+			return fmt.Errorf("segment %d: %w", want.Index, err)
+		}
+	}
+
+	return syncDir(dir)
+}
+
+func importSegment(r io.Reader, dir string, index int) (ManifestSegment, error) {
+	h := sha256.New()
+
+	f, err := os.Create(filepath.Join(dir, strconv.Itoa(index)))
+	if err != nil {
+		return ManifestSegment{}, err
+	}
+
+	size, err := io.Copy(io.MultiWriter(f, h), r)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return ManifestSegment{}, err
+	}
+
+	return ManifestSegment{
+		Index:  index,
+		Size:   size,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// verifyEntries reads every entry in the segment at path, checking
+// their CRCs.
+func verifyEntries(path string) error {
+	sr, err := NewSegmentReader(path)
+	if err != nil {
+		return err
+	}
+
+	defer sr.Close()
+
+	for sr.Next() {
+	}
+
+	return sr.Error()
+}
+
+// Snapshot makes a point-in-time copy of the WAL in dst, which must not
+// already exist, without stopping writes for longer than it takes to
+// link the sealed segments. Sealed segments are hard linked where
+// possible and copied otherwise. The active segment is copied up to the
+// end of the last complete entry and sealed in the copy, so dst holds
+// a cleanly closed WAL that can be opened or exported.
+func (wal *WALWriter) Snapshot(dst string) error {
+	err := os.Mkdir(dst, 0755)
+	if err != nil {
+		return err
+	}
+
+	copies, active, index, size, err := wal.snapshotLinks(dst)
+
+	defer func() {
+		for _, f := range copies {
+			f.Close()
+		}
+
+		if active != nil {
+			active.Close()
+		}
+	}()
+
+	if err != nil {
+		return err
+	}
+
+	for index, f := range copies {
+		err = copySegment(filepath.Join(dst, strconv.Itoa(index)), f, -1, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = copySegment(filepath.Join(dst, strconv.Itoa(index)), active, size, true)
+	if err != nil {
+		return err
+	}
+
+	return syncDir(dst)
+}
+
+// snapshotLinks links the sealed segments into dst and opens the ones
+// that couldn't be linked, along with the active segment, recording how
+// much of it has been written. It holds the lock so that no segment is
+// rotated or pruned part way through.
+func (wal *WALWriter) snapshotLinks(dst string) (map[int]*os.File, *os.File, int, int64, error) {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	if wal.closed {
+		return nil, nil, 0, 0, ErrClosed
+	}
+
+	copies := map[int]*os.File{}
+
+	for i := wal.first; i < wal.index; i++ {
+		name := strconv.Itoa(i)
+		src := filepath.Join(wal.root, name)
+
+		err := os.Link(src, filepath.Join(dst, name))
+		if err == nil {
+			continue
+		}
+
+		f, err := os.Open(src)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return copies, nil, 0, 0, err
+		}
+
+		copies[i] = f
+	}
+
+	active, err := os.Open(wal.current)
+	if err != nil {
+		return copies, nil, 0, 0, err
+	}
+
+	return copies, active, wal.index, wal.segment.Size(), nil
+}
+
+// copySegment copies size bytes of src, or all of it if size is -1, to
+// a new file at path, sealing it with the closing magic if seal is set.
+func copySegment(path string, src *os.File, size int64, seal bool) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = src
+	if size >= 0 {
+		r = io.NewSectionReader(src, 0, size)
+	}
+
+	_, err = io.Copy(f, r)
+	if err == nil && seal {
+		_, err = f.Write(closingMagic)
+	}
+
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
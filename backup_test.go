@@ -0,0 +1,239 @@
+package wal
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestBackup(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+
+		opts := DefaultWriteOptions
+		opts.SegmentSize = 20
+
+		w, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		require.NoError(t, w.Write([]byte("this is the first value")))
+		require.NoError(t, w.Write([]byte("this is the second value")))
+		require.NoError(t, w.Close())
+	})
+
+	n.It("exports segments with a manifest", func() {
+		var buf bytes.Buffer
+
+		require.NoError(t, Export(path, &buf))
+
+		gz, err := gzip.NewReader(&buf)
+		require.NoError(t, err)
+
+		tr := tar.NewReader(gz)
+
+		sums := map[string]string{}
+
+		var manifest Manifest
+
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+
+			require.NoError(t, err)
+
+			data, err := ioutil.ReadAll(tr)
+			require.NoError(t, err)
+
+			if hdr.Name == manifestName {
+				require.NoError(t, json.Unmarshal(data, &manifest))
+				continue
+			}
+
+			sum := sha256.Sum256(data)
+			sums[hdr.Name] = hex.EncodeToString(sum[:])
+		}
+
+		assert.Equal(t, manifestVersion, manifest.Version)
+		require.Equal(t, 3, len(manifest.Segments))
+
+		for i, seg := range manifest.Segments {
+			assert.Equal(t, i, seg.Index)
+
+			fi, err := os.Stat(filepath.Join(path, fmt.Sprint(i)))
+			require.NoError(t, err)
+
+			assert.Equal(t, fi.Size(), seg.Size)
+			assert.Equal(t, sums[fmt.Sprint(i)], seg.SHA256)
+		}
+
+		_, ok := sums["tags"]
+		assert.False(t, ok)
+	})
+
+	n.It("imports an exported archive", func() {
+		var buf bytes.Buffer
+
+		require.NoError(t, Export(path, &buf))
+
+		restored := filepath.Join(dir, "restored")
+		defer os.RemoveAll(restored)
+
+		require.NoError(t, Import(&buf, restored))
+
+		r, err := NewReader(restored)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.True(t, r.Next())
+		assert.Equal(t, "this is the first value", string(r.Value()))
+
+		require.True(t, r.Next())
+		assert.Equal(t, "this is the second value", string(r.Value()))
+
+		assert.False(t, r.Next())
+
+		fi, err := os.Stat(restored)
+		require.NoError(t, err)
+
+		assert.Equal(t, os.FileMode(0755), fi.Mode().Perm())
+	})
+
+	n.It("keeps the permissions of the empty directory imported into", func() {
+		var buf bytes.Buffer
+
+		require.NoError(t, Export(path, &buf))
+
+		restored := filepath.Join(dir, "restored")
+		defer os.RemoveAll(restored)
+
+		require.NoError(t, os.Mkdir(restored, 0750))
+		require.NoError(t, os.Chmod(restored, 0750))
+
+		require.NoError(t, Import(&buf, restored))
+
+		fi, err := os.Stat(restored)
+		require.NoError(t, err)
+
+		assert.Equal(t, os.FileMode(0750), fi.Mode().Perm())
+	})
+
+	n.It("refuses to import into a directory with files", func() {
+		var buf bytes.Buffer
+
+		require.NoError(t, Export(path, &buf))
+
+		assert.Equal(t, ErrDirNotEmpty, Import(&buf, path))
+	})
+
+	n.It("rejects an archive that doesn't match its manifest", func() {
+		var buf bytes.Buffer
+
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+
+		data, err := ioutil.ReadFile(filepath.Join(path, "1"))
+		require.NoError(t, err)
+
+		manifest, err := json.Marshal(Manifest{
+			Version: manifestVersion,
+			Segments: []ManifestSegment{
+				{Index: 1, Size: int64(len(data)), SHA256: "bad"},
+			},
+		})
+		require.NoError(t, err)
+
+		for _, f := range []struct {
+			name string
+			data []byte
+		}{{"1", data}, {manifestName, manifest}} {
+			require.NoError(t, tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.data))}))
+
+			_, err = tw.Write(f.data)
+			require.NoError(t, err)
+		}
+
+		require.NoError(t, tw.Close())
+		require.NoError(t, gz.Close())
+
+		restored := filepath.Join(dir, "restored")
+		defer os.RemoveAll(restored)
+
+		err = Import(&buf, restored)
+		assert.True(t, errors.Is(err, ErrBadChecksum))
+
+		_, err = os.Stat(restored)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	n.It("snapshots a WAL that's being written", func() {
+		opts := DefaultWriteOptions
+		opts.SegmentSize = 20
+
+		w, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		defer w.Close()
+
+		require.NoError(t, w.Write([]byte("this is the third value")))
+
+		snap := filepath.Join(dir, "snap")
+		defer os.RemoveAll(snap)
+
+		require.NoError(t, w.Snapshot(snap))
+
+		require.NoError(t, w.Write([]byte("this is after the snapshot")))
+
+		r, err := NewReader(snap)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		var vals []string
+
+		for r.Next() {
+			vals = append(vals, string(r.Value()))
+		}
+
+		require.NoError(t, r.Error())
+
+		assert.Equal(t, []string{
+			"this is the first value",
+			"this is the second value",
+			"this is the third value",
+		}, vals)
+
+		var buf bytes.Buffer
+
+		require.NoError(t, Export(snap, &buf))
+
+		assert.Error(t, w.Snapshot(snap))
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,88 @@
+package wal
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+)
+
+var errMalformedBarrier = errors.New("malformed tag barrier")
+
+// WriteTags writes every tag in tags as a single record with a single
+// sync, such as a "commit" tag along with an "epoch-42" one, so after a
+// crash either all of them are in the WAL or none are. Each is then
+// found by SeekTag and TagPos as if written on its own with WriteTag.
+// With no tags, nothing is written.
+func (wal *WALWriter) WriteTags(tags ...[]byte) error {
+	return wal.WriteTagsContext(context.Background(), tags...)
+}
+
+// WriteTagsContext is like WriteTags, but any span created for the write
+// is a child of the one in ctx.
+func (wal *WALWriter) WriteTagsContext(ctx context.Context, tags ...[]byte) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	return wal.writeTag(ctx, barrierType, frameBarrier(tags), barrierKeys(tags)...)
+}
+
+// frameBarrier joins tags into the body of a barrier record, each
+// prefixed with its length.
+func frameBarrier(tags [][]byte) []byte {
+	size := 0
+	for _, tag := range tags {
+		size += binary.MaxVarintLen64 + len(tag)
+	}
+
+	body := make([]byte, 0, size)
+
+	for _, tag := range tags {
+		body = binary.AppendUvarint(body, uint64(len(tag)))
+		body = append(body, tag...)
+	}
+
+	return body
+}
+
+// splitBarrier splits the body of a barrier record into its tags, which
+// point into body.
+func splitBarrier(body []byte) ([][]byte, bool) {
+	var tags [][]byte
+
+	for len(body) > 0 {
+		tag, rest, ok := splitStreamEntry(body)
+		if !ok {
+			return nil, false
+		}
+
+		tags = append(tags, tag)
+		body = rest
+	}
+
+	return tags, true
+}
+
+// barrierKeys returns the tag cache keys of tags.
+func barrierKeys(tags [][]byte) []string {
+	keys := make([]string, len(tags))
+
+	for i, tag := range tags {
+		keys[i] = tagKey(nil, tag)
+	}
+
+	return keys
+}
+
+// Tags returns the tags of a record written with WriteTags, or the tag
+// of any other tag record, as Tag does.
+func (r Record) Tags() [][]byte {
+	if r.Type != RecordBarrier {
+		tag, _ := r.Tag()
+		return [][]byte{tag}
+	}
+
+	tags, _ := splitBarrier(r.Value)
+
+	return tags
+}
@@ -0,0 +1,126 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestWriteTags(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	n.It("writes several tags as one record", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("hello")))
+
+		pos, err := wal.Pos()
+		require.NoError(t, err)
+
+		require.NoError(t, wal.WriteTags([]byte("commit"), []byte("epoch-42")))
+		require.NoError(t, wal.Write([]byte("world")))
+
+		for _, tag := range []string{"commit", "epoch-42"} {
+			tpos, err := wal.TagPos([]byte(tag))
+			require.NoError(t, err)
+
+			assert.Equal(t, pos, tpos)
+		}
+
+		st, err := wal.Stats()
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(2), st.Tags)
+
+		require.NoError(t, wal.Close())
+
+		var recs []Record
+
+		err = ScanRecords(path, Position{}, func(rec Record) error {
+			if rec.Type != RecordData {
+				rec.Value = append([]byte(nil), rec.Value...)
+				recs = append(recs, rec)
+			}
+
+			return nil
+		})
+		require.NoError(t, err)
+
+		require.Equal(t, 1, len(recs))
+		assert.Equal(t, RecordBarrier, recs[0].Type)
+		assert.Equal(t, [][]byte{[]byte("commit"), []byte("epoch-42")}, recs[0].Tags())
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		for _, tag := range []string{"commit", "epoch-42"} {
+			rpos, err := r.SeekTag([]byte(tag))
+			require.NoError(t, err)
+
+			assert.Equal(t, pos, rpos)
+
+			require.True(t, r.Next())
+			assert.Equal(t, "world", string(r.Value()))
+		}
+	})
+
+	n.It("finds a tag written later on its own", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.WriteTags([]byte("commit"), []byte("epoch-42")))
+
+		pos, err := wal.Pos()
+		require.NoError(t, err)
+
+		require.NoError(t, wal.WriteTag([]byte("commit")))
+		require.NoError(t, wal.Close())
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		rpos, err := r.SeekTag([]byte("commit"))
+		require.NoError(t, err)
+
+		assert.Equal(t, pos, rpos)
+
+		rpos, err = r.SeekTag([]byte("epoch-42"))
+		require.NoError(t, err)
+
+		assert.Equal(t, Position{0, 0}, rpos)
+	})
+
+	n.It("writes nothing without tags", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		require.NoError(t, wal.WriteTags())
+
+		assert.Equal(t, int64(0), wal.segment.Size())
+	})
+
+	n.Meow()
+}
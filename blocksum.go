@@ -0,0 +1,288 @@
+package wal
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// The suffix of the block checksums kept beside each sealed segment.
+const blockSumSuffix = ".sums"
+
+// The largest block that can be checksummed, since the size is stored
+// in 32 bits.
+const maxChecksumBlockSize = 1<<32 - 1
+
+// BlockRange is a range of a segment covered by one block checksum.
+type BlockRange struct {
+	Offset int64 `json:"offset"`
+	Size   int64 `json:"size"`
+}
+
+func blockSumPath(root string, index int) string {
+	return filepath.Join(root, strconv.Itoa(index)+blockSumSuffix)
+}
+
+// writeBlockSums checksums each block of size bytes of the segment at
+// path, writing the checksums beside it. The file holds the block size
+// followed by the CRC of each block, all big endian; the last block may
+// be short.
+func writeBlockSums(fs FS, path string, size int64) error {
+	sums, err := sumFile(fs, path, size)
+	if err != nil {
+		return err
+	}
+
+	return saveBlockSums(fs, path, sums)
+}
+
+// sumFile returns the checksums of the file at path in blocks of size
+// bytes, as saved by saveBlockSums.
+func sumFile(fs FS, path string, size int64) ([]byte, error) {
+	f, err := fs.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	bs := newBlockSummer(size)
+
+	_, err = io.Copy(bs, f)
+	if err != nil {
+		return nil, err
+	}
+
+	return bs.finish(), nil
+}
+
+// saveBlockSums replaces the block checksums of the segment at path with
+// sums, as built by a blockSummer.
+func saveBlockSums(fs FS, path string, sums []byte) error {
+	tmp := path + blockSumSuffix + ".tmp"
+
+	err := writeFS(fs, tmp, sums)
+	if err == nil {
+		err = fs.Rename(tmp, path+blockSumSuffix)
+	}
+
+	if err != nil {
+		fs.Remove(tmp)
+	}
+
+	return err
+}
+
+// blockSummer checksums the bytes written to it in blocks of size
+// bytes, so a segment's block checksums can be kept as it's written
+// rather than by reading it back.
+type blockSummer struct {
+	size int64
+
+	// The encoded checksums of the blocks so far, and the checksum of
+	// the n bytes of the current one.
+	sums []byte
+	cur  hash.Hash32
+	n    int64
+}
+
+func newBlockSummer(size int64) *blockSummer {
+	return &blockSummer{
+		size: size,
+		sums: binary.BigEndian.AppendUint32(nil, uint32(size)),
+		cur:  crc32.NewIEEE(),
+	}
+}
+
+func (b *blockSummer) Write(p []byte) (int, error) {
+	total := len(p)
+
+	for len(p) > 0 {
+		k := b.size - b.n
+		if int64(len(p)) < k {
+			k = int64(len(p))
+		}
+
+		b.cur.Write(p[:k])
+		b.n += k
+		p = p[k:]
+
+		if b.n == b.size {
+			b.sums = binary.BigEndian.AppendUint32(b.sums, b.cur.Sum32())
+			b.cur.Reset()
+			b.n = 0
+		}
+	}
+
+	return total, nil
+}
+
+// finish returns the checksums, encoded as they're stored, including the
+// one of the last block if it's short.
+func (b *blockSummer) finish() []byte {
+	sums := append([]byte(nil), b.sums...)
+
+	if b.n > 0 {
+		sums = binary.BigEndian.AppendUint32(sums, b.cur.Sum32())
+	}
+
+	return sums
+}
+
+// writeFS writes data to a new file at path and syncs it.
+func writeFS(fs FS, path string, data []byte) error {
+	f, err := fs.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// readBlockSums returns the block size and checksums of the segment at
+// path, or a size of 0 if it has none.
+func readBlockSums(fs FS, path string) (int64, []uint32, error) {
+	f, err := fs.OpenFile(path+blockSumSuffix, os.O_RDONLY, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil, nil
+		}
+
+		return 0, nil, err
+	}
+
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(data) < 4 || binary.BigEndian.Uint32(data) == 0 {
+		return 0, nil, nil
+	}
+
+	size := int64(binary.BigEndian.Uint32(data))
+
+	sums := make([]uint32, (len(data)-4)/4)
+	for i := range sums {
+		sums[i] = binary.BigEndian.Uint32(data[4+i*4:])
+	}
+
+	return size, sums, nil
+}
+
+// checkBlockSums returns the blocks of the segment at path that don't
+// match their checksums, and whether the segment has any. Data past the last block,
+// or blocks missing from the end, are reported as a bad block too.
+func checkBlockSums(fs FS, path string) ([]BlockRange, bool, error) {
+	size, sums, err := readBlockSums(fs, path)
+	if err != nil || size == 0 {
+		return nil, false, err
+	}
+
+	f, err := fs.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, true, err
+	}
+
+	defer f.Close()
+
+	var (
+		bad   []BlockRange
+		off   int64
+		block = make([]byte, size)
+	)
+
+	for i, sum := range sums {
+		n, err := io.ReadFull(f, block)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, true, err
+		}
+
+		// Only the last block may be short; the segment has been cut
+		// short if any others are.
+		if n < len(block) && i < len(sums)-1 {
+			return appendBlockRange(bad, BlockRange{off, int64(len(sums)-i) * size}), true, nil
+		}
+
+		switch {
+		case n == 0:
+			return appendBlockRange(bad, BlockRange{off, size}), true, nil
+		case crc32.ChecksumIEEE(block[:n]) != sum:
+			bad = appendBlockRange(bad, BlockRange{off, int64(n)})
+		}
+
+		off += int64(n)
+	}
+
+	extra, err := io.Copy(io.Discard, f)
+	if err != nil {
+		return nil, true, err
+	}
+
+	if extra > 0 {
+		bad = appendBlockRange(bad, BlockRange{off, extra})
+	}
+
+	return bad, true, nil
+}
+
+// appendBlockRange appends r to bad, merging it into the last range if
+// they're adjacent.
+func appendBlockRange(bad []BlockRange, r BlockRange) []BlockRange {
+	if n := len(bad); n > 0 && bad[n-1].Offset+bad[n-1].Size == r.Offset {
+		bad[n-1].Size += r.Size
+		return bad
+	}
+
+	return append(bad, r)
+}
+
+// sumBlocks writes the block checksums of seg, segment index, which has
+// been rotated out, if the WAL keeps them. They're the ones seg kept as
+// it was written, unless it was reopened or truncated, when it's read
+// back instead. Failing to is logged, leaving the segment without them.
+func (wal *WALWriter) sumBlocks(index int, seg *SegmentWriter) {
+	if wal.opts.ChecksumBlockSize <= 0 {
+		return
+	}
+
+	path := filepath.Join(wal.root, strconv.Itoa(index))
+
+	var err error
+
+	if seg.blockSums != nil {
+		err = saveBlockSums(wal.fs, path, seg.blockSums.finish())
+	} else {
+		err = writeBlockSums(wal.fs, path, wal.opts.ChecksumBlockSize)
+	}
+
+	if err != nil {
+		wal.logger.Warn("failed to write block checksums", "segment", index, "error", err)
+	}
+}
+
+// resumBlocks rewrites the block checksums of a segment that's been
+// rewritten, if it had them.
+func resumBlocks(fs FS, path string) error {
+	size, _, err := readBlockSums(fs, path)
+	if err != nil || size == 0 {
+		return err
+	}
+
+	return writeBlockSums(fs, path, size)
+}
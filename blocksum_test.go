@@ -0,0 +1,228 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestBlockChecksums(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+	segment := filepath.Join(path, "0")
+
+	entry := func(i int) []byte {
+		return bytes.Repeat([]byte(fmt.Sprintf("entry%d ", i)), 20)
+	}
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+
+		wal, err := New(path, WithSegmentSize(1024), WithMaxSegments(100), WithCompression(false), WithBlockChecksums(128))
+		require.NoError(t, err)
+
+		for i := 0; i < 20; i++ {
+			require.NoError(t, wal.Write(entry(i)))
+		}
+
+		require.NoError(t, wal.Close())
+	})
+
+	n.It("checksums the blocks of rotated segments", func() {
+		first, last, err := rangeSegments(OSFS, path)
+		require.NoError(t, err)
+
+		require.True(t, last > first)
+
+		for i := first; i < last; i++ {
+			bad, ok, err := checkBlockSums(OSFS, filepath.Join(path, strconv.Itoa(i)))
+			require.NoError(t, err)
+
+			assert.True(t, ok, "segment %d", i)
+			assert.Empty(t, bad)
+		}
+
+		_, err = os.Stat(blockSumPath(path, last))
+		assert.True(t, os.IsNotExist(err))
+
+		report, err := Verify(path)
+		require.NoError(t, err)
+
+		assert.True(t, report.OK)
+	})
+
+	n.It("checksums a segment reopened before it's rotated", func() {
+		_, last, err := rangeSegments(OSFS, path)
+		require.NoError(t, err)
+
+		wal, err := New(path, WithSegmentSize(1024), WithMaxSegments(100), WithCompression(false), WithBlockChecksums(128), WithSealing(false))
+		require.NoError(t, err)
+
+		// Reopened with records in it, so its checksums aren't known
+		// until it's read back.
+		assert.Nil(t, wal.segment.blockSums)
+
+		for i := 20; wal.index == last; i++ {
+			require.NoError(t, wal.Write(entry(i)))
+		}
+
+		// The new segment's are kept as it's written.
+		require.NotNil(t, wal.segment.blockSums)
+
+		for wal.index == last+1 {
+			require.NoError(t, wal.Write(entry(0)))
+		}
+
+		require.NoError(t, wal.Close())
+
+		for i := last; i <= last+1; i++ {
+			bad, ok, err := checkBlockSums(OSFS, filepath.Join(path, strconv.Itoa(i)))
+			require.NoError(t, err)
+
+			assert.True(t, ok, "segment %d", i)
+			assert.Empty(t, bad)
+		}
+	})
+
+	n.It("narrows corruption down to the blocks it's in", func() {
+		data, err := ioutil.ReadFile(segment)
+		require.NoError(t, err)
+
+		require.True(t, len(data) > 300)
+
+		data[300] ^= 0xff
+
+		require.NoError(t, ioutil.WriteFile(segment, data, 0644))
+
+		report, err := Verify(path)
+		require.NoError(t, err)
+
+		assert.False(t, report.OK)
+
+		seg := report.Segments[0]
+
+		require.Error(t, seg.err)
+		assert.Equal(t, []BlockRange{{256, 128}}, seg.BadBlocks)
+
+		for _, other := range report.Segments[1:] {
+			assert.Empty(t, other.BadBlocks)
+		}
+	})
+
+	n.It("reports a segment cut short", func() {
+		fi, err := os.Stat(segment)
+		require.NoError(t, err)
+
+		require.NoError(t, os.Truncate(segment, 200))
+
+		bad, ok, err := checkBlockSums(OSFS, segment)
+		require.NoError(t, err)
+
+		require.True(t, ok)
+		require.Len(t, bad, 1)
+
+		assert.Equal(t, int64(128), bad[0].Offset)
+		assert.True(t, bad[0].Offset+bad[0].Size >= fi.Size())
+	})
+
+	n.It("reports data past the last block", func() {
+		f, err := os.OpenFile(segment, os.O_WRONLY|os.O_APPEND, 0)
+		require.NoError(t, err)
+
+		_, err = f.Write([]byte("extra"))
+		require.NoError(t, err)
+
+		require.NoError(t, f.Close())
+
+		fi, err := os.Stat(segment)
+		require.NoError(t, err)
+
+		report, err := Verify(path)
+		require.NoError(t, err)
+
+		assert.False(t, report.OK)
+
+		// The short last block now reads differently too.
+		bad := report.Segments[0].BadBlocks
+		require.Len(t, bad, 1)
+
+		assert.Equal(t, fi.Size(), bad[0].Offset+bad[0].Size)
+		assert.True(t, bad[0].Size > 5 && bad[0].Size <= 128+5)
+	})
+
+	n.It("flags checksums that don't match readable records", func() {
+		sums, err := ioutil.ReadFile(blockSumPath(path, 0))
+		require.NoError(t, err)
+
+		sums[len(sums)-1] ^= 0xff
+
+		require.NoError(t, ioutil.WriteFile(blockSumPath(path, 0), sums, 0644))
+
+		report, err := Verify(path)
+		require.NoError(t, err)
+
+		assert.False(t, report.OK)
+		assert.True(t, errors.Is(report.Segments[0].err, ErrBlockChecksum))
+	})
+
+	n.It("redoes the checksums of compacted segments", func() {
+		_, err := Compact(path, func(_ Position, value []byte) bool {
+			return !bytes.Equal(value, entry(0))
+		})
+		require.NoError(t, err)
+
+		report, err := Verify(path)
+		require.NoError(t, err)
+
+		assert.True(t, report.OK)
+
+		_, ok, err := checkBlockSums(OSFS, segment)
+		require.NoError(t, err)
+
+		assert.True(t, ok)
+	})
+
+	n.It("checksums the pieces of a split segment", func() {
+		split, err := SplitSegment(path, 0, 300)
+		require.NoError(t, err)
+
+		require.True(t, len(split.Starts) > 1)
+
+		for i := range split.Starts {
+			bad, ok, err := checkBlockSums(OSFS, filepath.Join(path, strconv.Itoa(i)))
+			require.NoError(t, err)
+
+			assert.True(t, ok)
+			assert.Empty(t, bad)
+		}
+
+		report, err := Verify(path)
+		require.NoError(t, err)
+
+		assert.True(t, report.OK)
+	})
+
+	n.It("rejects a negative block size", func() {
+		wo := DefaultWriteOptions
+		wo.ChecksumBlockSize = -1
+
+		assert.True(t, errors.Is(wo.Validate(), ErrInvalidOptions))
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,47 @@
+package wal
+
+import "sync/atomic"
+
+// MemoryBudget caps the memory held by the buffers of a group of
+// readers, typically every reader in the process. Readers share a
+// budget by setting ReadOptions.Budget. While the readers together hold
+// more than the limit, each one releases any buffers that have grown
+// past their initial size the next time it reads an entry, or as soon
+// as it reaches the end of what it can read, so usage falls back under
+// the limit as the readers make progress and idle readers don't hold on
+// to it. Entries read ahead by Prefetch count against the budget until
+// they're replaced by the next one.
+type MemoryBudget struct {
+	limit int64
+	used  int64
+}
+
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	return &MemoryBudget{limit: limit}
+}
+
+// Limit returns the number of bytes the budget allows readers to hold.
+func (b *MemoryBudget) Limit() int64 {
+	return b.limit
+}
+
+// Used returns the number of bytes currently held by readers.
+func (b *MemoryBudget) Used() int64 {
+	return atomic.LoadInt64(&b.used)
+}
+
+func (b *MemoryBudget) adjust(delta int64) {
+	if b == nil || delta == 0 {
+		return
+	}
+
+	atomic.AddInt64(&b.used, delta)
+}
+
+func (b *MemoryBudget) exceeded() bool {
+	if b == nil {
+		return false
+	}
+
+	return atomic.LoadInt64(&b.used) > b.limit
+}
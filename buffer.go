@@ -0,0 +1,87 @@
+package wal
+
+import "fmt"
+
+// BufferPolicy controls how the scratch buffers used to encode and
+// decode entries are sized. Buffers start at InitialSize and grow as
+// needed to fit larger entries. Any buffer that has grown past MaxSize
+// is released and replaced with a fresh InitialSize one once the entry
+// that required it has been processed, so an occasional huge entry
+// doesn't pin that memory for the life of the reader or writer.
+type BufferPolicy struct {
+	// The size of the buffers when first created. If 0, 16KB is used.
+	// Values below 64 bytes are rounded up.
+	InitialSize int
+
+	// The largest buffer that will be retained between entries. If 0,
+	// buffers are never shrunk.
+	MaxSize int
+}
+
+// The default policy retains buffers up to 1MB.
+var DefaultBufferPolicy = BufferPolicy{
+	InitialSize: bufferSize,
+	MaxSize:     1024 * 1024,
+}
+
+// Validate returns an error wrapping ErrInvalidOptions if the policy
+// can't be used: if either size is negative, or MaxSize is set below
+// the size buffers start at, which would release every buffer as soon
+// as it's used.
+func (p BufferPolicy) Validate() error {
+	switch {
+	case p.InitialSize < 0:
+		return fmt.Errorf("%w: BufferPolicy.InitialSize must not be negative, got %d", ErrInvalidOptions, p.InitialSize)
+	case p.MaxSize < 0:
+		return fmt.Errorf("%w: BufferPolicy.MaxSize must not be negative, got %d", ErrInvalidOptions, p.MaxSize)
+	case p.MaxSize > 0 && p.MaxSize < p.initialSize():
+		return fmt.Errorf("%w: BufferPolicy.MaxSize must be at least InitialSize (%d), got %d", ErrInvalidOptions, p.initialSize(), p.MaxSize)
+	}
+
+	return nil
+}
+
+// Large enough for an entry header and the closing magic.
+const minBufferSize = 64
+
+func (p BufferPolicy) initialSize() int {
+	switch {
+	case p.InitialSize <= 0:
+		return bufferSize
+	case p.InitialSize < minBufferSize:
+		return minBufferSize
+	default:
+		return p.InitialSize
+	}
+}
+
+// ensure returns buf if it can hold n bytes, otherwise a new buffer
+// that can.
+func (p BufferPolicy) ensure(buf []byte, n int) []byte {
+	if n <= len(buf) {
+		return buf
+	}
+
+	sz := len(buf) * 2
+	if sz < n {
+		sz = n
+	}
+
+	// Don't let the doubling push us past the cap if the entry itself
+	// fits under it.
+	if p.MaxSize > 0 && sz > p.MaxSize && n <= p.MaxSize {
+		sz = p.MaxSize
+	}
+
+	return make([]byte, sz)
+}
+
+// shrink releases buf in favor of an InitialSize one if it has grown
+// past MaxSize.
+func (p BufferPolicy) shrink(buf []byte) []byte {
+	if p.MaxSize > 0 && len(buf) > p.MaxSize {
+		return make([]byte, p.initialSize())
+	}
+
+	return buf
+}
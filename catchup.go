@@ -0,0 +1,80 @@
+package wal
+
+// StreamFrom seeks to pos and calls sink with every entry from there to
+// the end of the WAL, for catching a follower up. Each call gets the
+// position just past the entry, from which streaming can later resume,
+// and the entry's value, which is only valid until sink returns: values
+// aren't copied, and segments are read straight through, so catching up
+// allocates little however much there is to read.
+//
+// Between entries, sink is also called with a nil value to report
+// progress: once as the reader moves onto each new segment, with the
+// start of it, and once on reaching the end of the WAL, with where that
+// is. Stream readers skip the other streams' entries without calling
+// sink, so these let a follower record progress through long stretches
+// of them.
+//
+// StreamFrom returns the position it stopped at. With a nil error,
+// that's the end of the WAL, and the reader is left there, so the
+// caller can hand over to tailing the WAL from the same reader, or from
+// a paired reader at that position, without missing or repeating an
+// entry. If sink returns an error, StreamFrom stops and returns it,
+// along with the position just past the last entry sink accepted.
+func (r *WALReader) StreamFrom(pos Position, sink func(Position, []byte) error) (Position, error) {
+	return r.streamFrom(pos, r.Next, sink)
+}
+
+// StreamFrom is like WALReader.StreamFrom, but stops at the end of what
+// the writer has finished writing. Take then waits for what it writes
+// next.
+func (r *PairedReader) StreamFrom(pos Position, sink func(Position, []byte) error) (Position, error) {
+	return r.streamFrom(pos, r.Next, sink)
+}
+
+func (r *WALReader) streamFrom(pos Position, next func() bool, sink func(Position, []byte) error) (Position, error) {
+	err := r.Seek(pos)
+	if err != nil {
+		return pos, err
+	}
+
+	index := r.index
+
+	for next() {
+		if r.index != index {
+			index = r.index
+
+			err = sink(Position{index, 0}, nil)
+			if err != nil {
+				return pos, err
+			}
+		}
+
+		end, err := r.Pos()
+		if err != nil {
+			return pos, err
+		}
+
+		err = sink(end, r.Value())
+		if err != nil {
+			return pos, err
+		}
+
+		pos = end
+	}
+
+	if err := r.Error(); err != nil {
+		return pos, err
+	}
+
+	head, err := r.Pos()
+	if err != nil {
+		return pos, err
+	}
+
+	err = sink(head, nil)
+	if err != nil {
+		return pos, err
+	}
+
+	return head, nil
+}
@@ -0,0 +1,167 @@
+package wal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestStreamFrom(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	opts := DefaultWriteOptions
+	opts.SegmentSize = 200
+	opts.MaxSegments = 100
+	opts.NoCompression = true
+
+	write := func(wal interface{ Write([]byte) error }, from, to int) {
+		for i := from; i < to; i++ {
+			require.NoError(t, wal.Write(bytes.Repeat([]byte(fmt.Sprint(i)), 50)))
+		}
+	}
+
+	n.It("streams every entry from the position, with progress", func() {
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		write(wal, 0, 6)
+
+		require.NoError(t, wal.Close())
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		var (
+			values   []string
+			progress []Position
+			last     Position
+			before   int
+		)
+
+		head, err := r.StreamFrom(Position{}, func(pos Position, value []byte) error {
+			if value == nil {
+				if len(progress) == 0 {
+					before = len(values)
+				}
+
+				progress = append(progress, pos)
+				return nil
+			}
+
+			assert.True(t, last.less(pos))
+			last = pos
+
+			values = append(values, string(value[:1]))
+			return nil
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"0", "1", "2", "3", "4", "5"}, values)
+
+		require.True(t, len(progress) > 1)
+		assert.Equal(t, Position{1, 0}, progress[0])
+		assert.Equal(t, head, progress[len(progress)-1])
+		assert.Equal(t, last, head)
+
+		// Resuming from a position sink was given picks up after it.
+		rest := values[before:]
+		values = nil
+
+		_, err = r.StreamFrom(progress[0], func(pos Position, value []byte) error {
+			if value != nil {
+				values = append(values, string(value[:1]))
+			}
+			return nil
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, rest, values)
+	})
+
+	n.It("stops when sink fails, returning the last position it accepted", func() {
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		write(wal, 0, 3)
+
+		require.NoError(t, wal.Close())
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		var accepted Position
+
+		stop := errors.New("stop")
+
+		pos, err := r.StreamFrom(Position{}, func(pos Position, value []byte) error {
+			if value == nil {
+				return nil
+			}
+
+			if value[0] == '1' {
+				return stop
+			}
+
+			accepted = pos
+			return nil
+		})
+
+		assert.Equal(t, stop, err)
+		assert.Equal(t, accepted, pos)
+	})
+
+	n.It("hands over to tailing a paired writer", func() {
+		r, w, err := NewPair(path, opts)
+		require.NoError(t, err)
+
+		defer w.Close()
+		defer r.Close()
+
+		write(w, 0, 3)
+
+		count := 0
+
+		_, err = r.StreamFrom(Position{}, func(pos Position, value []byte) error {
+			if value != nil {
+				count++
+			}
+			return nil
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, 3, count)
+
+		write(w, 3, 4)
+
+		value, _, err := r.Take(context.Background())
+		require.NoError(t, err)
+
+		assert.Equal(t, byte('3'), value[0])
+	})
+
+	n.Meow()
+}
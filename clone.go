@@ -0,0 +1,149 @@
+package wal
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+)
+
+type CloneOptions struct {
+	// If set, only entries from this position on are copied.
+	From *Position
+
+	// If set, only entries from the last time this tag was written on
+	// are copied. Ignored if From is set.
+	FromTag []byte
+
+	// The options used to write the clone. If unset, DefaultWriteOptions
+	// is used.
+	WriteOptions *WriteOptions
+}
+
+// Clone copies the WAL in src into a new WAL in dst, which must not
+// exist or be empty. Entries and tags are rewritten rather than copied
+// byte for byte, so the clone's segments are numbered from 0.
+func Clone(src, dst string) error {
+	return CloneWithOptions(src, dst, CloneOptions{})
+}
+
+// CloneWithOptions is like Clone, but can start part way through src
+// and write the clone with different options.
+func CloneWithOptions(src, dst string, opts CloneOptions) error {
+	err := checkEmptyDir(dst)
+	if err != nil {
+		return err
+	}
+
+	start, err := cloneStart(src, opts)
+	if err != nil {
+		return err
+	}
+
+	indexes, err := listSegments(src)
+	if err != nil {
+		return err
+	}
+
+	wo := DefaultWriteOptions
+	if opts.WriteOptions != nil {
+		wo = *opts.WriteOptions
+	}
+
+	w, err := NewWithOptions(dst, wo)
+	if err != nil {
+		return err
+	}
+
+	for _, index := range indexes {
+		if index < start.Segment {
+			continue
+		}
+
+		var offset int64
+		if index == start.Segment {
+			offset = start.Offset
+		}
+
+		err = cloneSegment(w, filepath.Join(src, strconv.Itoa(index)), offset)
+		if err != nil {
+			w.Close()
+			return err
+		}
+	}
+
+	return w.Close()
+}
+
+// cloneStart returns the position in src to start copying from.
+func cloneStart(src string, opts CloneOptions) (Position, error) {
+	if opts.From != nil {
+		return *opts.From, nil
+	}
+
+	if opts.FromTag == nil {
+		return Position{}, nil
+	}
+
+	r, err := NewReader(src)
+	if err != nil {
+		return Position{}, err
+	}
+
+	defer r.Close()
+
+	return r.SeekTag(opts.FromTag)
+}
+
+func cloneSegment(w *WALWriter, path string, offset int64) error {
+	sr, err := NewSegmentReader(path)
+	if err != nil {
+		return err
+	}
+
+	defer sr.Close()
+
+	if offset > 0 {
+		err = sr.Seek(offset)
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		t, ok := sr.nextRecord()
+		if !ok {
+			return sr.Error()
+		}
+
+		switch t {
+		case tagType:
+			err = w.WriteTag(sr.Value())
+		case streamType, expiringType:
+			err = w.writeEntry(context.Background(), t, sr.Value())
+		case streamTagType:
+			err = w.writeTag(context.Background(), streamTagType, sr.Value(), streamTagKey(sr.Value()))
+		case payloadTagType:
+			tag, _, _ := splitStreamEntry(sr.Value())
+			err = w.writeTag(context.Background(), payloadTagType, sr.Value(), tagKey(nil, tag))
+		case originType:
+			var (
+				origin Origin
+				value  []byte
+			)
+
+			origin, value, err = entryOrigin(sr.Value(), sr.index, sr.Header().Offset)
+			if err == nil {
+				err = w.WriteOrigin(value, origin)
+			}
+		case barrierType:
+			tags, _ := splitBarrier(sr.Value())
+			err = w.writeTag(context.Background(), barrierType, sr.Value(), barrierKeys(tags)...)
+		default:
+			err = w.Write(sr.Value())
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
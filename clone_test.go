@@ -0,0 +1,120 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestClone(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+	clone := filepath.Join(dir, "clone")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+		os.RemoveAll(clone)
+
+		opts := DefaultWriteOptions
+		opts.SegmentSize = 20
+		opts.MaxSegments = 2
+
+		w, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		require.NoError(t, w.Write([]byte("this is the first value")))
+		require.NoError(t, w.Write([]byte("this is the second value")))
+		require.NoError(t, w.WriteTag([]byte("checkpoint")))
+		require.NoError(t, w.Write([]byte("this is the third value")))
+		require.NoError(t, w.Close())
+	})
+
+	values := func(root string) []string {
+		r, err := NewReader(root)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		var vals []string
+
+		for r.Next() {
+			vals = append(vals, string(r.Value()))
+		}
+
+		require.NoError(t, r.Error())
+
+		return vals
+	}
+
+	n.It("copies the entries into fresh segments", func() {
+		require.NoError(t, Clone(path, clone))
+
+		src, err := listSegments(path)
+		require.NoError(t, err)
+
+		assert.NotEqual(t, 0, src[0])
+
+		dst, err := listSegments(clone)
+		require.NoError(t, err)
+
+		assert.Equal(t, []int{0}, dst)
+
+		assert.Equal(t, values(path), values(clone))
+
+		r, err := NewReader(clone)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		pos, err := r.SeekTag([]byte("checkpoint"))
+		require.NoError(t, err)
+
+		assert.False(t, pos.None())
+	})
+
+	n.It("copies from a tag on", func() {
+		err := CloneWithOptions(path, clone, CloneOptions{FromTag: []byte("checkpoint")})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"this is the third value"}, values(clone))
+
+		os.RemoveAll(clone)
+
+		err = CloneWithOptions(path, clone, CloneOptions{FromTag: []byte("nope")})
+		assert.Equal(t, ErrTagNotFound, err)
+	})
+
+	n.It("copies from a position on", func() {
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		require.True(t, r.Next())
+
+		pos, err := r.Pos()
+		require.NoError(t, err)
+
+		r.Close()
+
+		err = CloneWithOptions(path, clone, CloneOptions{From: &pos})
+		require.NoError(t, err)
+
+		assert.Equal(t, values(path)[1:], values(clone))
+	})
+
+	n.It("refuses to clone into a directory with files", func() {
+		assert.Equal(t, ErrDirNotEmpty, Clone(path, path))
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/evanphx/wal"
+)
+
+func compact(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("compact", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: wal compact [flags] <dir>\n\n")
+		fmt.Fprintf(fs.Output(), "The WAL must not be open in any other process while it's compacted.\n\n")
+		fs.PrintDefaults()
+	}
+
+	var (
+		before = fs.String("before", "", "remove every segment that ends before this position (segment:offset)")
+		merge  = fs.Bool("merge", false, "rewrite the WAL into as few segments as possible; segments are renumbered from 0, so saved positions become invalid")
+		size   = fs.Int64("segment-size", wal.MaxSegmentSize, "the segment size to merge into")
+	)
+
+	err := fs.Parse(args)
+	if err != nil || fs.NArg() != 1 || (*before == "" && !*merge) {
+		if err == nil {
+			fs.Usage()
+		}
+
+		return errUsage
+	}
+
+	root := fs.Arg(0)
+
+	if *before != "" {
+		pos, err := wal.ParsePosition(*before)
+		if err != nil {
+			return err
+		}
+
+		n, err := pruneBefore(root, pos)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "removed %d segments\n", n)
+	}
+
+	if *merge {
+		from, to, err := mergeSegments(root, *size)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "merged %d segments into %d\n", from, to)
+	}
+
+	return nil
+}
+
+// segmentIndexes returns the indexes of the segments in root, in order.
+func segmentIndexes(root string) ([]int, error) {
+	rep, err := wal.Verify(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var indexes []int
+
+	for _, seg := range rep.Segments {
+		if seg.Error != wal.ErrSegmentMissing.Error() {
+			indexes = append(indexes, seg.Index)
+		}
+	}
+
+	return indexes, nil
+}
+
+// pruneBefore removes the segments before the one pos is in, always
+// keeping the newest.
+func pruneBefore(root string, pos wal.Position) (int, error) {
+	indexes, err := segmentIndexes(root)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+
+	for _, index := range indexes[:len(indexes)-1] {
+		if index >= pos.Segment {
+			break
+		}
+
+		err = os.Remove(filepath.Join(root, strconv.Itoa(index)))
+		if err != nil {
+			return n, err
+		}
+
+		n++
+	}
+
+	return n, nil
+}
+
+// mergeSegments rewrites the WAL in root into segments of up to size
+// bytes, swapping the new directory in once it's complete.
+func mergeSegments(root string, size int64) (int, int, error) {
+	indexes, err := segmentIndexes(root)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	tmp := root + ".compact"
+	old := root + ".old"
+
+	os.RemoveAll(tmp)
+
+	opts := wal.DefaultWriteOptions
+	opts.SegmentSize = size
+	opts.MaxSegments = math.MaxInt32
+
+	err = wal.CloneWithOptions(root, tmp, wal.CloneOptions{WriteOptions: &opts})
+	if err != nil {
+		os.RemoveAll(tmp)
+		return 0, 0, err
+	}
+
+	merged, err := segmentIndexes(tmp)
+	if err != nil {
+		os.RemoveAll(tmp)
+		return 0, 0, err
+	}
+
+	err = os.Rename(root, old)
+	if err != nil {
+		os.RemoveAll(tmp)
+		return 0, 0, err
+	}
+
+	err = os.Rename(tmp, root)
+	if err != nil {
+		// Put the original back.
+		os.Rename(old, root)
+		os.RemoveAll(tmp)
+		return 0, 0, err
+	}
+
+	return len(indexes), len(merged), os.RemoveAll(old)
+}
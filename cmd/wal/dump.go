@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/evanphx/wal"
+)
+
+func dump(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("dump", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: wal dump [flags] <dir>\n\n")
+		fs.PrintDefaults()
+	}
+
+	var (
+		showHex = fs.Bool("hex", false, "print each payload as a hex dump")
+		asJSON  = fs.Bool("json", false, "print each record as a line of JSON")
+		decode  = fs.String("decode", "", `decode each payload: "json" or "text"`)
+		from    = fs.String("from", "", "start at this position (segment:offset)")
+		to      = fs.String("to", "", "stop before this position (segment:offset)")
+		tag     = fs.String("tag", "", "start at the last occurrence of this tag")
+		only    = fs.String("type", "", `only print records of this type: "data", "tag", "stream", "stream-tag", "expiring", "payload-tag", "barrier" or "origin"`)
+	)
+
+	err := fs.Parse(args)
+	if err != nil || fs.NArg() != 1 {
+		if err == nil {
+			fs.Usage()
+		}
+
+		return errUsage
+	}
+
+	opts := wal.DumpOptions{Hex: *showHex}
+
+	if *asJSON {
+		opts.Format = wal.DumpJSON
+	}
+
+	switch *decode {
+	case "":
+	case "json":
+		opts.Decode = wal.DecodeJSON
+	case "text":
+		opts.Decode = wal.DecodeText
+	default:
+		return fmt.Errorf("unknown decoding: %s", *decode)
+	}
+
+	switch *only {
+	case "":
+	case "data":
+		opts.Type = wal.RecordData
+	case "tag":
+		opts.Type = wal.RecordTag
+	case "stream":
+		opts.Type = wal.RecordStream
+	case "stream-tag":
+		opts.Type = wal.RecordStreamTag
+	case "expiring":
+		opts.Type = wal.RecordExpiring
+	case "payload-tag":
+		opts.Type = wal.RecordPayloadTag
+	case "barrier":
+		opts.Type = wal.RecordBarrier
+	case "origin":
+		opts.Type = wal.RecordOrigin
+	default:
+		return fmt.Errorf("unknown record type: %s", *only)
+	}
+
+	root := fs.Arg(0)
+
+	opts.From, err = startPosition(root, *from, *tag)
+	if err != nil {
+		return err
+	}
+
+	if *to != "" {
+		pos, err := wal.ParsePosition(*to)
+		if err != nil {
+			return err
+		}
+
+		opts.To = &pos
+	}
+
+	return wal.Dump(root, out, opts)
+}
+
+// startPosition returns where to start dumping from the -from and -tag
+// flags.
+func startPosition(root, from, tag string) (wal.Position, error) {
+	if from != "" {
+		return wal.ParsePosition(from)
+	}
+
+	if tag == "" {
+		return wal.Position{}, nil
+	}
+
+	r, err := wal.NewReader(root)
+	if err != nil {
+		return wal.Position{}, err
+	}
+
+	defer r.Close()
+
+	pos, err := r.SeekTag([]byte(tag))
+	if err != nil {
+		return wal.Position{}, fmt.Errorf("%w: %s", err, tag)
+	}
+
+	return pos, nil
+}
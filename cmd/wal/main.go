@@ -0,0 +1,101 @@
+// Command wal inspects and maintains WAL directories.
+//
+// Usage:
+//
+//	wal <command> [flags] <dir>
+//
+// Run "wal <command> -h" for the flags each command accepts.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+type command struct {
+	usage string
+	run   func(args []string, out io.Writer) error
+}
+
+var commands = map[string]command{
+	"compact": {"remove old segments or merge small ones", compact},
+	"dump":    {"print the records in a WAL", dump},
+	"stat":    {"summarize the segments and tags of a WAL", stat},
+	"tail":    {"print the newest entries, optionally following new ones", tail},
+	"verify":  {"check the integrity of a WAL", verify},
+}
+
+// errUsage means the arguments were wrong and usage has been printed.
+var errUsage = errors.New("usage")
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "usage: wal <command> [flags] <dir>\n\ncommands:\n")
+
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-8s %s\n", name, commands[name].usage)
+	}
+}
+
+func run(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return errUsage
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		usage(os.Stderr)
+		return errUsage
+	}
+
+	return cmd.run(args[1:], out)
+}
+
+func main() {
+	err := run(os.Args[1:], os.Stdout)
+	if err != nil {
+		if err != errUsage && err != errCorrupt {
+			fmt.Fprintf(os.Stderr, "wal: %s\n", err)
+		}
+
+		os.Exit(exitCode(err))
+	}
+}
+
+// exitCode returns the status to exit with after err. Commands can
+// return an exitError to pick one.
+func exitCode(err error) int {
+	var ee *exitError
+	if errors.As(err, &ee) {
+		return ee.code
+	}
+
+	if err == errUsage {
+		return 2
+	}
+
+	return 1
+}
+
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evanphx/wal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestCommands(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+
+		w, err := wal.New(path)
+		require.NoError(t, err)
+
+		require.NoError(t, w.Write([]byte(`{"id": 1}`)))
+		require.NoError(t, w.WriteTag([]byte("checkpoint")))
+		require.NoError(t, w.Write([]byte(`{"id": 2}`)))
+		require.NoError(t, w.Close())
+	})
+
+	lines := func(args ...string) []string {
+		var out bytes.Buffer
+
+		require.NoError(t, run(args, &out))
+
+		return strings.Split(strings.TrimSpace(out.String()), "\n")
+	}
+
+	n.It("dumps every record", func() {
+		out := lines("dump", path)
+
+		require.Equal(t, 3, len(out))
+
+		assert.True(t, strings.HasPrefix(out[0], "0:0\tdata\t9\tcrc="))
+		assert.Contains(t, out[1], "\ttag\t10\t")
+		assert.Contains(t, out[2], "\tdata\t9\t")
+	})
+
+	n.It("decodes payloads", func() {
+		out := lines("dump", "-decode=json", "-type=data", path)
+
+		require.Equal(t, 2, len(out))
+
+		assert.True(t, strings.HasSuffix(out[0], `{"id":1}`))
+		assert.True(t, strings.HasSuffix(out[1], `{"id":2}`))
+
+		out = lines("dump", "-hex", "-type=tag", path)
+
+		require.Equal(t, 2, len(out))
+		assert.Contains(t, out[1], "|checkpoint|")
+	})
+
+	n.It("dumps records as JSON", func() {
+		out := lines("dump", "-json", "-decode=json", "-type=data", path)
+
+		require.Equal(t, 2, len(out))
+
+		assert.True(t, strings.HasPrefix(out[0], `{"pos":"0:0","type":"data"`))
+		assert.Contains(t, out[1], `"json":{"id":2}`)
+	})
+
+	n.It("dumps from a tag", func() {
+		out := lines("dump", "-decode=text", "-tag=checkpoint", path)
+
+		require.Equal(t, 2, len(out))
+		assert.Contains(t, out[0], `"checkpoint"`)
+	})
+
+	n.It("dumps a range of positions", func() {
+		all := lines("dump", path)
+
+		from := strings.Split(all[1], "\t")[0]
+		to := strings.Split(all[2], "\t")[0]
+
+		out := lines("dump", "-from="+from, "-to="+to, path)
+
+		assert.Equal(t, all[1:2], out)
+	})
+
+	n.It("rejects bad usage", func() {
+		assert.Equal(t, errUsage, run([]string{"nope"}, ioutil.Discard))
+		assert.Equal(t, errUsage, run([]string{"dump"}, ioutil.Discard))
+	})
+
+	n.It("verifies a WAL", func() {
+		out := lines("verify", path)
+
+		assert.Equal(t, "2 entries, 1 tags: ok", out[len(out)-1])
+
+		var buf bytes.Buffer
+
+		require.NoError(t, run([]string{"verify", "-json", path}, &buf))
+
+		var rep wal.VerifyReport
+
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &rep))
+		assert.True(t, rep.OK)
+
+		seg := filepath.Join(path, "0")
+
+		data, err := ioutil.ReadFile(seg)
+		require.NoError(t, err)
+
+		data[10] ^= 0xff
+
+		require.NoError(t, ioutil.WriteFile(seg, data, 0644))
+
+		err = run([]string{"verify", path}, ioutil.Discard)
+		assert.Equal(t, exitCorrupt, exitCode(err))
+	})
+
+	n.It("tails the newest entries", func() {
+		out := lines("tail", "-n", "1", path)
+
+		assert.Equal(t, []string{`{"id": 2}`}, out)
+
+		out = lines("tail", "-tag", "checkpoint", "-decode", "json", path)
+
+		assert.Equal(t, []string{`{"id":2}`}, out)
+	})
+
+	n.It("follows entries as they're written", func() {
+		pollInterval = time.Millisecond
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var out syncBuffer
+
+		done := make(chan error, 1)
+
+		end, err := endPosition(path)
+		require.NoError(t, err)
+
+		go func() {
+			done <- tailContext(ctx, []string{"-f", "-from", end.String(), path}, &out)
+		}()
+
+		w, err := wal.New(path)
+		require.NoError(t, err)
+
+		defer w.Close()
+
+		require.NoError(t, w.Write([]byte("live")))
+
+		deadline := time.Now().Add(5 * time.Second)
+
+		for !strings.Contains(out.String(), "live") {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for entry")
+			}
+
+			time.Sleep(time.Millisecond)
+		}
+
+		cancel()
+
+		require.NoError(t, <-done)
+
+		assert.Equal(t, "live\n", out.String())
+	})
+
+	n.It("summarizes a WAL", func() {
+		var buf bytes.Buffer
+
+		require.NoError(t, run([]string{"stat", "-json", path}, &buf))
+
+		var st statReport
+
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &st))
+
+		assert.Equal(t, 0, st.First)
+		assert.Equal(t, 2, st.Entries)
+		require.Equal(t, 1, len(st.Tags))
+		assert.Equal(t, "checkpoint", st.Tags[0].Tag)
+
+		out := lines("stat", path)
+		assert.Contains(t, out[len(out)-1], `"checkpoint"`)
+	})
+
+	n.It("compacts a WAL", func() {
+		os.RemoveAll(path)
+
+		opts := wal.DefaultWriteOptions
+		opts.SegmentSize = 20
+
+		w, err := wal.NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		for i := 0; i < 5; i++ {
+			require.NoError(t, w.Write([]byte(fmt.Sprintf("this is value number %d", i))))
+		}
+
+		require.NoError(t, w.Close())
+
+		out := lines("compact", "-before", "2:0", path)
+		assert.Equal(t, []string{"removed 2 segments"}, out)
+
+		out = lines("compact", "-merge", path)
+		assert.Equal(t, []string{"merged 4 segments into 1"}, out)
+
+		r, err := wal.NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		var vals []string
+
+		for r.Next() {
+			vals = append(vals, string(r.Value()))
+		}
+
+		assert.Equal(t, []string{
+			"this is value number 1",
+			"this is value number 2",
+			"this is value number 3",
+			"this is value number 4",
+		}, vals)
+
+		_, err = os.Stat(path + ".old")
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	n.Meow()
+}
+
+// syncBuffer is a bytes.Buffer that's safe to write and read from
+// different goroutines.
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.buf.String()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/evanphx/wal"
+)
+
+type statReport struct {
+	First    int                 `json:"first"`
+	Last     int                 `json:"last"`
+	Size     int64               `json:"size"`
+	Entries  int                 `json:"entries"`
+	Segments []wal.SegmentReport `json:"segments"`
+	Tags     []tagInfo           `json:"tags"`
+}
+
+type tagInfo struct {
+	Tag string       `json:"tag"`
+	Pos wal.Position `json:"pos"`
+}
+
+func stat(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("stat", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: wal stat [flags] <dir>\n\n")
+		fs.PrintDefaults()
+	}
+
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+
+	err := fs.Parse(args)
+	if err != nil || fs.NArg() != 1 {
+		if err == nil {
+			fs.Usage()
+		}
+
+		return errUsage
+	}
+
+	root := fs.Arg(0)
+
+	rep, err := wal.Verify(root)
+	if err != nil {
+		return err
+	}
+
+	st := statReport{
+		First:    rep.Segments[0].Index,
+		Last:     rep.Segments[len(rep.Segments)-1].Index,
+		Entries:  rep.Entries,
+		Segments: rep.Segments,
+	}
+
+	for _, seg := range rep.Segments {
+		st.Size += seg.Size
+	}
+
+	// Only the last position of each tag matters, but list them in the
+	// order they were first written.
+	seen := map[string]int{}
+
+	err = wal.ScanRecords(root, wal.Position{}, func(rec wal.Record) error {
+		if rec.Type != wal.RecordTag && rec.Type != wal.RecordPayloadTag && rec.Type != wal.RecordBarrier {
+			return nil
+		}
+
+		for _, name := range rec.Tags() {
+			tag := string(name)
+
+			if i, ok := seen[tag]; ok {
+				st.Tags[i].Pos = rec.Pos
+			} else {
+				seen[tag] = len(st.Tags)
+				st.Tags = append(st.Tags, tagInfo{Tag: tag, Pos: rec.Pos})
+			}
+		}
+
+		return nil
+	})
+
+	// Corruption is reported per segment, so only give up on tags for
+	// other errors.
+	if err != nil && rep.OK {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(st)
+	}
+
+	fmt.Fprintf(out, "segments %d-%d, %d bytes, %d entries\n\n", st.First, st.Last, st.Size, st.Entries)
+
+	err = printReport(out, rep)
+	if err != nil {
+		return err
+	}
+
+	if len(st.Tags) > 0 {
+		fmt.Fprintf(out, "\ntags:\n")
+
+		for _, tag := range st.Tags {
+			fmt.Fprintf(out, "  %q\t%s\n", tag.Tag, tag.Pos)
+		}
+	}
+
+	return nil
+}
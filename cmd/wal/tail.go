@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/evanphx/wal"
+)
+
+// How often tail -f checks for new entries.
+var pollInterval = wal.DefaultTailInterval
+
+func tail(args []string, out io.Writer) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	return tailContext(ctx, args, out)
+}
+
+func tailContext(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: wal tail [flags] <dir>\n\n")
+		fs.PrintDefaults()
+	}
+
+	var (
+		follow  = fs.Bool("f", false, "keep printing entries as they're written")
+		lines   = fs.Int("n", 10, "start with this many of the newest entries")
+		from    = fs.String("from", "", "start at this position (segment:offset)")
+		tag     = fs.String("tag", "", "start at the last occurrence of this tag")
+		showPos = fs.Bool("pos", false, "prefix each entry with the position after it, to resume from with -from")
+		decode  = fs.String("decode", "text", `how to print each entry: "text", "json", "quoted" or "hex"`)
+	)
+
+	err := fs.Parse(args)
+	if err != nil || fs.NArg() != 1 {
+		if err == nil {
+			fs.Usage()
+		}
+
+		return errUsage
+	}
+
+	switch *decode {
+	case "text", "json", "quoted", "hex":
+	default:
+		return fmt.Errorf("unknown decoding: %s", *decode)
+	}
+
+	root := fs.Arg(0)
+
+	start, err := tailStart(root, *from, *tag, *lines)
+	if err != nil {
+		return err
+	}
+
+	r, err := wal.NewReader(root)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	err = r.Seek(start)
+	if err != nil {
+		return err
+	}
+
+	show := func(pos wal.Position, value []byte) error {
+		return printValue(out, pos, value, *showPos, *decode)
+	}
+
+	if *follow {
+		return r.Tail(ctx, wal.TailOptions{PollInterval: pollInterval}, show)
+	}
+
+	for r.Next() {
+		pos, err := r.Pos()
+		if err != nil {
+			return err
+		}
+
+		err = show(pos, r.Value())
+		if err != nil {
+			return err
+		}
+	}
+
+	return r.Error()
+}
+
+// tailStart returns where to start from the flags. Without -from or
+// -tag, it's the start of the nth newest entry.
+func tailStart(root, from, tag string, n int) (wal.Position, error) {
+	if from != "" || tag != "" {
+		return startPosition(root, from, tag)
+	}
+
+	if n <= 0 {
+		return endPosition(root)
+	}
+
+	// Keep the start positions of the newest n entries.
+	var (
+		ring  = make([]wal.Position, n)
+		count int
+	)
+
+	err := wal.ScanRecords(root, wal.Position{}, func(rec wal.Record) error {
+		if rec.Type == wal.RecordData {
+			ring[count%n] = rec.Pos
+			count++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return wal.Position{}, err
+	}
+
+	if count < n {
+		return firstPosition(root)
+	}
+
+	return ring[count%n], nil
+}
+
+// firstPosition returns the start of the oldest segment.
+func firstPosition(root string) (wal.Position, error) {
+	r, err := wal.NewReader(root)
+	if err != nil {
+		return wal.Position{}, err
+	}
+
+	defer r.Close()
+
+	return r.Pos()
+}
+
+// endPosition returns the position after the last entry.
+func endPosition(root string) (wal.Position, error) {
+	r, err := wal.NewReader(root)
+	if err != nil {
+		return wal.Position{}, err
+	}
+
+	defer r.Close()
+
+	for r.Next() {
+	}
+
+	if err := r.Error(); err != nil {
+		return wal.Position{}, err
+	}
+
+	return r.Pos()
+}
+
+func printValue(out io.Writer, pos wal.Position, val []byte, showPos bool, decode string) error {
+	if showPos {
+		_, err := fmt.Fprintf(out, "%s\t", pos)
+		if err != nil {
+			return err
+		}
+	}
+
+	var err error
+
+	switch decode {
+	case "json":
+		var buf bytes.Buffer
+
+		if cerr := json.Compact(&buf, val); cerr != nil {
+			_, err = fmt.Fprintf(out, "(invalid json: %s)\n", cerr)
+		} else {
+			_, err = fmt.Fprintf(out, "%s\n", buf.Bytes())
+		}
+	case "quoted":
+		_, err = fmt.Fprintf(out, "%q\n", val)
+	case "hex":
+		_, err = io.WriteString(out, "\n"+hex.Dump(val))
+	default:
+		_, err = fmt.Fprintf(out, "%s\n", val)
+	}
+
+	return err
+}
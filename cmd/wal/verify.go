@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/evanphx/wal"
+)
+
+// The status verify exits with when it finds a problem, distinct from
+// failing to run at all.
+const exitCorrupt = 3
+
+var errCorrupt = &exitError{code: exitCorrupt, err: fmt.Errorf("verification failed")}
+
+func verify(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: wal verify [flags] <dir>\n\n")
+		fmt.Fprintf(fs.Output(), "Exits 0 if the WAL is intact, %d if a problem was found, and 1 if it\ncouldn't be checked.\n\n", exitCorrupt)
+		fs.PrintDefaults()
+	}
+
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+
+	err := fs.Parse(args)
+	if err != nil || fs.NArg() != 1 {
+		if err == nil {
+			fs.Usage()
+		}
+
+		return errUsage
+	}
+
+	rep, err := wal.Verify(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+
+		err = enc.Encode(rep)
+	} else {
+		err = printReport(out, rep)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !rep.OK {
+		return errCorrupt
+	}
+
+	return nil
+}
+
+func printReport(out io.Writer, rep *wal.VerifyReport) error {
+	for _, seg := range rep.Segments {
+		status := "ok"
+
+		switch {
+		case seg.Error != "":
+			status = fmt.Sprintf("error at offset %d: %s", seg.ErrorOffset, seg.Error)
+		case !seg.Sealed:
+			status = "ok (active)"
+		}
+
+		_, err := fmt.Fprintf(out, "segment %d\t%d bytes\t%d entries\t%d tags\t%s\n",
+			seg.Index, seg.Size, seg.Entries, seg.Tags, status)
+		if err != nil {
+			return err
+		}
+
+		for _, b := range seg.BadBlocks {
+			_, err = fmt.Fprintf(out, "\tbad block at offset %d, %d bytes\n", b.Offset, b.Size)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	result := "ok"
+	if !rep.OK {
+		result = "FAILED"
+	}
+
+	_, err := fmt.Fprintf(out, "%d entries, %d tags: %s\n", rep.Entries, rep.Tags, result)
+	return err
+}
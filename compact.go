@@ -0,0 +1,453 @@
+package wal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// CompactStats describes what Compact removed.
+type CompactStats struct {
+	// The segments that were rewritten. Those where keep rejected
+	// nothing are left as they were.
+	Segments int
+
+	// The entries dropped, and the bytes on disk freed by dropping them.
+	Entries int
+	Bytes   int64
+
+	// The generation recorded in the compaction manifest once this
+	// compaction finished, or 0 if it rewrote nothing.
+	Generation int
+
+	// The indexes of the segments that were rewritten.
+	rewritten []int
+}
+
+// The name of the compaction manifest in a WAL's root.
+const compactManifestName = "compaction.json"
+
+// CompactManifest records the compacted generation a WAL is at. Each
+// compaction that rewrites a segment produces a new generation, so
+// anything that saved positions within the WAL can tell whether they
+// may have been invalidated since.
+type CompactManifest struct {
+	// Bumped by each compaction that rewrites a segment.
+	Generation int `json:"generation"`
+
+	// The segments that the newest generation rewrote.
+	Segments []int `json:"segments"`
+}
+
+// ReadCompactManifest returns the compaction manifest of the WAL in
+// root, which is the zero CompactManifest if it's never been compacted.
+func ReadCompactManifest(root string) (CompactManifest, error) {
+	var manifest CompactManifest
+
+	data, err := ioutil.ReadFile(filepath.Join(root, compactManifestName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+
+		return manifest, err
+	}
+
+	err = json.Unmarshal(data, &manifest)
+
+	return manifest, err
+}
+
+// writeCompactManifest records a new generation for the segments stats
+// says were rewritten, if there are any, replacing the manifest with a
+// synced temporary copy.
+func writeCompactManifest(root string, stats *CompactStats) error {
+	if len(stats.rewritten) == 0 {
+		return nil
+	}
+
+	manifest, err := ReadCompactManifest(root)
+	if err != nil {
+		return err
+	}
+
+	manifest.Generation++
+	manifest.Segments = stats.rewritten
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(root, compactManifestName)
+	tmp := path + ".tmp"
+
+	err = copyToFile(tmp, bytes.NewReader(data))
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	stats.Generation = manifest.Generation
+
+	return syncDir(root)
+}
+
+// Compact rewrites the segments of the WAL in root without the data
+// entries that keep rejects, such as events for keys that have since
+// been overwritten or that have expired, so that a WAL can serve as a
+// compacting event store. keep is called with each entry's position and
+// value, which is only valid until it returns. Tags and the entries of
+// streams are always kept.
+//
+// Each segment is written to a temporary file that's renamed over it,
+// so a crash leaves every segment either as it was or fully compacted.
+// Segments keep their indexes, and their time indexes are updated, but
+// the offsets of entries within a compacted segment change, so
+// positions saved from before the compaction that point into one are no
+// longer valid. A compaction that rewrites any segment produces a new
+// generation, recorded along with those segments in the manifest that
+// ReadCompactManifest returns.
+//
+// The WAL must not be open for writing; ErrLocked is returned if it is.
+// Pass the options the WAL is opened with, such as WithLockPath, so the
+// same lock is taken.
+func Compact(root string, keep func(Position, []byte) bool, opts ...Option) (CompactStats, error) {
+	lockf, err := lockRoot(root, opts)
+	if err != nil {
+		return CompactStats{}, err
+	}
+
+	defer lockf.Close()
+
+	return compactSegments(root, func(t byte, pos Position, value []byte) bool {
+		switch t {
+		case dataType:
+			return keep(pos, value)
+		case expiringType:
+			_, value, err := splitExpiry(value)
+			return err != nil || keep(pos, value)
+		case originType:
+			_, value, err := splitOrigin(value)
+			return err != nil || keep(pos, value)
+		default:
+			return true
+		}
+	})
+}
+
+// CompactByKey rewrites the segments of the WAL in root keeping only the
+// newest entry for each key, as returned by key for an entry's value,
+// like log compaction in Kafka. The WAL then serves as a compacted
+// changelog that state can be rebuilt from without a snapshot. Entries
+// key returns nil for are always kept, as are tags and the entries of
+// streams.
+//
+// The segments are read once to find the newest entry for each key,
+// which is held in memory, and then compacted as Compact does, taking
+// the same lock.
+func CompactByKey(root string, key func([]byte) []byte, opts ...Option) (CompactStats, error) {
+	lockf, err := lockRoot(root, opts)
+	if err != nil {
+		return CompactStats{}, err
+	}
+
+	defer lockf.Close()
+
+	indexes, err := listSegments(root)
+	if err != nil {
+		return CompactStats{}, err
+	}
+
+	newest := map[string]Position{}
+
+	for _, index := range indexes {
+		err = scanSegment(filepath.Join(root, strconv.Itoa(index)), index, 0, func(rec Record) error {
+			k, ok := entryKey(byte(rec.Type), rec.Value, key)
+			if ok {
+				newest[string(k)] = rec.Pos
+			}
+
+			return nil
+		})
+		if err != nil {
+			return CompactStats{}, err
+		}
+	}
+
+	return compactSegments(root, func(t byte, pos Position, value []byte) bool {
+		k, ok := entryKey(t, value, key)
+		return !ok || newest[string(k)] == pos
+	})
+}
+
+// entryKey returns the key of a data entry, if it has one.
+func entryKey(t byte, value []byte, key func([]byte) []byte) ([]byte, bool) {
+	switch t {
+	case dataType:
+	case expiringType:
+		var err error
+
+		_, value, err = splitExpiry(value)
+		if err != nil {
+			return nil, false
+		}
+	case originType:
+		var err error
+
+		_, value, err = splitOrigin(value)
+		if err != nil {
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+
+	k := key(value)
+
+	return k, k != nil
+}
+
+// recordFilter decides whether compaction keeps a record of type t.
+type recordFilter func(t byte, pos Position, value []byte) bool
+
+// compactSegments compacts every segment in root with keep.
+func compactSegments(root string, keep recordFilter) (CompactStats, error) {
+	indexes, err := listSegments(root)
+	if err != nil {
+		return CompactStats{}, err
+	}
+
+	var stats CompactStats
+
+	for _, index := range indexes {
+		err = compactSegment(root, index, keep, &stats)
+		if err != nil {
+			return stats, fmt.Errorf("segment %d: %w", index, err)
+		}
+	}
+
+	return stats, writeCompactManifest(root, &stats)
+}
+
+// compactSegment rewrites one segment without the records keep
+// rejects, if there are any.
+func compactSegment(root string, index int, keep recordFilter, stats *CompactStats) error {
+	rw, err := rewriteSegment(root, index, keep)
+	if err != nil {
+		return err
+	}
+
+	if rw.dropped == 0 {
+		rw.discard()
+		return nil
+	}
+
+	freed, err := rw.commit()
+	if err != nil {
+		return err
+	}
+
+	stats.Segments++
+	stats.Entries += rw.dropped
+	stats.Bytes += freed
+	stats.rewritten = append(stats.rewritten, index)
+
+	return nil
+}
+
+// segmentRewrite is a copy of a segment being written by rewriteSegment,
+// which either replaces the segment or is discarded.
+type segmentRewrite struct {
+	root  string
+	index int
+	path  string
+	tmp   string
+
+	w *SegmentWriter
+
+	// How many records keep rejected, and how many of those kept were
+	// stored uncompressed.
+	dropped int
+	raw     int
+
+	// The size of the original, whether it was sealed, and whether it
+	// was sealed with a checksum as well.
+	size     int64
+	sealed   bool
+	checksum bool
+
+	// Where each record boundary in the original is in the copy.
+	offsets map[int64]int64
+}
+
+// rewriteSegment copies the records of segment index that keep accepts
+// into a temporary file beside it, compressing all of them.
+func rewriteSegment(root string, index int, keep recordFilter) (*segmentRewrite, error) {
+	path := filepath.Join(root, strconv.Itoa(index))
+
+	sr, err := NewSegmentReader(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer sr.Close()
+
+	fi, err := sr.f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	rw := &segmentRewrite{
+		root:    root,
+		index:   index,
+		path:    path,
+		tmp:     path + ".compact",
+		size:    fi.Size(),
+		offsets: map[int64]int64{},
+	}
+
+	// Left over from an interrupted rewrite.
+	os.Remove(rw.tmp)
+
+	rw.w, err = NewSegmentWriter(rw.tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		start := sr.Pos()
+
+		rw.offsets[start] = rw.w.Size()
+
+		t, ok := sr.nextRecord()
+		if !ok {
+			err = sr.Error()
+			break
+		}
+
+		if !keep(t, Position{index, start}, sr.Value()) {
+			rw.dropped++
+			continue
+		}
+
+		if !sr.Header().Compressed {
+			rw.raw++
+		}
+
+		err = rw.w.writeRecord(t, sr.Value())
+		if err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		end := sr.Pos()
+
+		rw.checksum = sr.pastSeal
+
+		rw.sealed, err = hasClosingMagic(sr.f, end, rw.size)
+		if err == nil && !rw.sealed && end != rw.size {
+			err = errTrailingData
+		}
+	}
+
+	if err != nil {
+		rw.discard()
+		return nil, err
+	}
+
+	return rw, nil
+}
+
+// newSize returns how large the copy will be once it's committed.
+func (rw *segmentRewrite) newSize() int64 {
+	if rw.sealed {
+		return rw.w.Size() + int64(len(closingMagic))
+	}
+
+	return rw.w.Size()
+}
+
+func (rw *segmentRewrite) discard() {
+	rw.w.noMagic = true
+	rw.w.Close()
+
+	os.Remove(rw.tmp)
+}
+
+// commit replaces the segment with the copy, remapping the offsets in
+// its time index and redoing its block checksums, and returns how many
+// bytes that freed.
+func (rw *segmentRewrite) commit() (int64, error) {
+	// Only a segment that was sealed is sealed again, so the newest
+	// segment of a WAL that wasn't closed cleanly is still treated as
+	// such.
+	rw.w.SetClosingMagic(rw.sealed)
+
+	var err error
+
+	if rw.checksum {
+		err = rw.w.seal(context.Background(), OSFS)
+	}
+
+	newSize := rw.newSize()
+
+	// The closing magic is synced with the rest, so a crash after the
+	// rename can't leave a copy that looks like it wasn't closed cleanly.
+	if err == nil {
+		err = rw.w.writeClosingMagic()
+	}
+	if err == nil {
+		err = rw.w.sync(context.Background())
+	}
+	if err == nil {
+		err = rw.w.Close()
+	} else {
+		rw.w.Close()
+	}
+
+	if err == nil {
+		err = os.Rename(rw.tmp, rw.path)
+	}
+
+	if err != nil {
+		os.Remove(rw.tmp)
+		return 0, err
+	}
+
+	err = remapTimeIndex(rw.root, rw.index, rw.offsets)
+	if err != nil {
+		return 0, err
+	}
+
+	err = resumBlocks(OSFS, rw.path)
+	if err != nil {
+		return 0, err
+	}
+
+	return rw.size - newSize, nil
+}
+
+// lockRoot takes the lock a writer holds on the WAL in root, for offline
+// operations that rewrite its segments. opts are those the WAL is
+// opened with, which say where the lock is kept.
+func lockRoot(root string, opts []Option) (io.Closer, error) {
+	wo := DefaultWriteOptions
+
+	for _, opt := range opts {
+		opt(&wo)
+	}
+
+	return lockWAL(OSFS, wo.lockPath(root))
+}
@@ -0,0 +1,287 @@
+package wal
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestCompact(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+
+		wal, err := New(path, WithSegmentSize(256), WithMaxSegments(100), WithTimeIndex(time.Nanosecond))
+		require.NoError(t, err)
+
+		s, err := wal.Stream("s")
+		require.NoError(t, err)
+
+		for i := 0; i < 20; i++ {
+			require.NoError(t, wal.Write([]byte(fmt.Sprintf("key%d=%d", i%4, i))))
+		}
+
+		require.NoError(t, s.Write([]byte("drop-stream")))
+		require.NoError(t, wal.WriteTag([]byte("tag")))
+		require.NoError(t, wal.Write([]byte("drop-last")))
+
+		require.NoError(t, wal.Close())
+	})
+
+	read := func() []string {
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		var values []string
+
+		for r.Next() {
+			values = append(values, string(r.Value()))
+		}
+
+		require.NoError(t, r.Error())
+
+		return values
+	}
+
+	n.It("drops the entries the filter rejects", func() {
+		before, err := Verify(path)
+		require.NoError(t, err)
+
+		var seen int
+
+		stats, err := Compact(path, func(pos Position, val []byte) bool {
+			seen++
+			return !strings.HasPrefix(string(val), "key1=") && !strings.HasPrefix(string(val), "drop")
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, 21, seen)
+		assert.Equal(t, 6, stats.Entries)
+		assert.True(t, stats.Bytes > 0)
+		assert.True(t, stats.Segments > 0)
+
+		values := read()
+		assert.Equal(t, 15, len(values))
+
+		for _, v := range values {
+			assert.False(t, strings.HasPrefix(v, "key1="))
+		}
+
+		after, err := Verify(path)
+		require.NoError(t, err)
+
+		assert.True(t, after.OK)
+		assert.Equal(t, len(before.Segments), len(after.Segments))
+		assert.Equal(t, before.Tags, after.Tags)
+
+		sr, err := NewStreamReader(path, "s", DefaultReadOptions)
+		require.NoError(t, err)
+
+		defer sr.Close()
+
+		require.True(t, sr.Next())
+		assert.Equal(t, "drop-stream", string(sr.Value()))
+
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		assert.True(t, wal.WasCleanShutdown())
+
+		pos, err := wal.TagPos([]byte("tag"))
+		require.NoError(t, err)
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.NoError(t, r.Seek(pos))
+
+		var rest []string
+
+		for r.Next() {
+			rest = append(rest, string(r.Value()))
+		}
+
+		assert.Equal(t, 0, len(rest))
+	})
+
+	n.It("passes each entry's position", func() {
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		want := map[Position]string{}
+
+		for pos, val := range r.All() {
+			want[pos] = string(val)
+		}
+
+		got := map[Position]string{}
+
+		_, err = Compact(path, func(pos Position, val []byte) bool {
+			got[pos] = string(val)
+			return true
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, want, got)
+	})
+
+	n.It("leaves segments with nothing to drop alone", func() {
+		first, _, err := rangeSegments(OSFS, path)
+		require.NoError(t, err)
+
+		fi, err := os.Stat(filepath.Join(path, "0"))
+		require.NoError(t, err)
+
+		stats, err := Compact(path, func(pos Position, val []byte) bool {
+			return pos.Segment != first+1
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, stats.Segments)
+
+		fi2, err := os.Stat(filepath.Join(path, "0"))
+		require.NoError(t, err)
+
+		assert.Equal(t, fi.ModTime(), fi2.ModTime())
+
+		_, err = os.Stat(timeIndexPath(path, first))
+		assert.NoError(t, err)
+
+		// The compacted segment's time index points at its new offsets.
+		points, err := readTimeIndex(OSFS, path, first+1)
+		require.NoError(t, err)
+		require.True(t, len(points) > 0)
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		for _, p := range points {
+			require.NoError(t, r.Seek(Position{first + 1, p.Offset}))
+
+			for r.Next() {
+			}
+
+			require.NoError(t, r.Error())
+		}
+	})
+
+	n.It("records each generation in the manifest", func() {
+		manifest, err := ReadCompactManifest(path)
+		require.NoError(t, err)
+
+		assert.Equal(t, CompactManifest{}, manifest)
+
+		first, _, err := rangeSegments(OSFS, path)
+		require.NoError(t, err)
+
+		stats, err := Compact(path, func(pos Position, val []byte) bool {
+			return pos.Segment != first+1
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, stats.Generation)
+
+		manifest, err = ReadCompactManifest(path)
+		require.NoError(t, err)
+
+		assert.Equal(t, CompactManifest{Generation: 1, Segments: []int{first + 1}}, manifest)
+
+		// Nothing left to drop, so no new generation.
+		stats, err = Compact(path, func(pos Position, val []byte) bool {
+			return pos.Segment != first+1
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, 0, stats.Generation)
+
+		stats, err = Compact(path, func(pos Position, val []byte) bool {
+			return pos.Segment != first
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, stats.Generation)
+
+		manifest, err = ReadCompactManifest(path)
+		require.NoError(t, err)
+
+		assert.Equal(t, CompactManifest{Generation: 2, Segments: []int{first}}, manifest)
+	})
+
+	n.It("leaves each compacted segment closed cleanly", func() {
+		first, _, err := rangeSegments(OSFS, path)
+		require.NoError(t, err)
+
+		_, err = Compact(path, func(pos Position, val []byte) bool {
+			return pos.Segment != first
+		})
+		require.NoError(t, err)
+
+		data, err := ioutil.ReadFile(filepath.Join(path, strconv.Itoa(first)))
+		require.NoError(t, err)
+
+		assert.True(t, bytes.HasSuffix(data, closingMagic))
+	})
+
+	n.It("refuses to compact an open WAL", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		_, err = Compact(path, func(Position, []byte) bool { return false })
+		assert.Equal(t, ErrLocked, err)
+	})
+
+	n.It("keeps only the newest entry for each key", func() {
+		key := func(val []byte) []byte {
+			if k, _, ok := strings.Cut(string(val), "="); ok {
+				return []byte(k)
+			}
+
+			return nil
+		}
+
+		stats, err := CompactByKey(path, key)
+		require.NoError(t, err)
+
+		assert.Equal(t, 16, stats.Entries)
+
+		assert.Equal(t, []string{"key0=16", "key1=17", "key2=18", "key3=19", "drop-last"}, read())
+
+		stats, err = CompactByKey(path, key)
+		require.NoError(t, err)
+
+		assert.Equal(t, 0, stats.Entries)
+		assert.Equal(t, 0, stats.Segments)
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,26 @@
+package wal
+
+// CompressionStats compares the size of compressed entries before and
+// after compression, to tell whether compression is paying for itself.
+// Entries stored uncompressed, whether written with WriteRaw or with
+// compression off, aren't counted.
+type CompressionStats struct {
+	// The bytes given to the compressor, and the bytes it produced.
+	Uncompressed int64 `json:"uncompressed"`
+	Compressed   int64 `json:"compressed"`
+}
+
+// Ratio returns Uncompressed divided by Compressed, so values above 1
+// mean compression is saving space. It's 0 if nothing was compressed.
+func (c CompressionStats) Ratio() float64 {
+	if c.Compressed == 0 {
+		return 0
+	}
+
+	return float64(c.Uncompressed) / float64(c.Compressed)
+}
+
+func (c *CompressionStats) add(uncompressed, compressed int64) {
+	c.Uncompressed += uncompressed
+	c.Compressed += compressed
+}
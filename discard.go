@@ -0,0 +1,223 @@
+package wal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// DiscardAfter removes everything written at or after pos, which must be
+// the start of an entry or the end of a segment's entries, such as the
+// position returned by BeginRecoveryUncommitted. Segments after pos's
+// are removed, pos's segment is cut off at pos and becomes the active
+// one, and writing continues from there. Tags written after pos are
+// dropped from the tag cache.
+//
+// A sealed segment is cut by replacing it with a copy, leaving the
+// original to any snapshot linked to it. Its seal is dropped along with
+// the entries, even if pos is after it, since it won't hold once more
+// entries are written.
+//
+// Discarded entries are gone from the WAL, but not from any archive or
+// replica they were copied to before the call.
+func (wal *WALWriter) DiscardAfter(pos Position) error {
+	wal.lockIO()
+	defer wal.unlockIO()
+
+	if wal.closed {
+		return ErrClosed
+	}
+
+	if pos.Segment < wal.first || pos.Segment > wal.index || pos.Offset < 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidPosition, pos)
+	}
+
+	err := wal.checkBoundary(pos)
+	if err != nil {
+		return err
+	}
+
+	if pos.Segment < wal.index {
+		err = wal.reopenSegment(pos.Segment, pos.Offset)
+		if err != nil {
+			return err
+		}
+	} else if pos.Offset < wal.segment.Size() {
+		wal.closeTimeIndex()
+
+		// Points past the end would send SeekTime beyond it.
+		wal.fs.Remove(timeIndexPath(wal.root, wal.index))
+
+		err = wal.segment.truncateTo(pos.Offset)
+		if err != nil {
+			return err
+		}
+
+		err = wal.segment.f.Sync()
+		if err != nil {
+			return err
+		}
+
+		wal.openTimeIndex()
+	}
+
+	wal.logger.Warn("discarded entries", "position", pos)
+
+	wal.dropTagsFrom(pos)
+
+	if wal.quotas != nil {
+		return wal.loadQuotas()
+	}
+
+	return nil
+}
+
+// checkBoundary returns ErrInvalidPosition unless pos is at the start of
+// an entry, or the end of its segment's entries.
+func (wal *WALWriter) checkBoundary(pos Position) error {
+	f, err := wal.fs.OpenFile(filepath.Join(wal.root, strconv.Itoa(pos.Segment)), os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	off, err := entryBoundary(f, pos.Offset)
+	if err != nil {
+		return err
+	}
+
+	if off != pos.Offset {
+		return fmt.Errorf("%w: %s", ErrInvalidPosition, pos)
+	}
+
+	return nil
+}
+
+// reopenSegment removes the segments after index, cuts segment index off
+// at end and makes it the active one again. The lock must be held.
+func (wal *WALWriter) reopenSegment(index int, end int64) error {
+	wal.closeTimeIndex()
+
+	err := wal.segment.Close()
+	if err != nil {
+		return err
+	}
+
+	for i := wal.index; i > index; i-- {
+		err = wal.fs.Remove(filepath.Join(wal.root, strconv.Itoa(i)))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		wal.fs.Remove(timeIndexPath(wal.root, i))
+		wal.fs.Remove(blockSumPath(wal.root, i))
+
+		wal.sealedBytes -= wal.segSizes[i]
+		delete(wal.segSizes, i)
+	}
+
+	path := filepath.Join(wal.root, strconv.Itoa(index))
+
+	seal, err := sealOffset(wal.fs, path)
+	if err != nil {
+		return err
+	}
+
+	if seal >= 0 && end > seal {
+		end = seal
+	}
+
+	// The copy is writable, unlike a sealed segment that was made
+	// read-only, and keeps the closing magic so it's reopened as one
+	// that was closed cleanly.
+	_, err = cutSegment(wal.fs, path, end, true)
+	if err != nil {
+		return err
+	}
+
+	// Points past the end would send SeekTime beyond it, and the block
+	// checksums won't hold once it's written to again.
+	wal.fs.Remove(timeIndexPath(wal.root, index))
+	wal.fs.Remove(blockSumPath(wal.root, index))
+
+	wal.sealedBytes -= wal.segSizes[index]
+	delete(wal.segSizes, index)
+
+	wal.index = index
+	wal.current = path
+
+	seg, err := wal.openSegment()
+	if err != nil {
+		return err
+	}
+
+	wal.segment = seg
+
+	wal.openTimeIndex()
+
+	return nil
+}
+
+// sealOffset returns where the seal record of the segment at path
+// starts, or -1 if it has none.
+func sealOffset(fs FS, path string) (int64, error) {
+	opts := DefaultReadOptions
+	opts.FS = fs
+
+	sr, err := NewSegmentReaderWithOptions(path, opts)
+	if err != nil {
+		return -1, err
+	}
+
+	defer sr.Close()
+
+	for {
+		e, err := sr.readRecord()
+		if err == io.EOF {
+			return -1, nil
+		}
+
+		if err != nil {
+			return -1, err
+		}
+
+		if e.entryType == sealType {
+			return e.offset, nil
+		}
+	}
+}
+
+// dropTagsFrom removes the tags at or after pos from the tag cache,
+// rewriting it. The lock must be held.
+func (wal *WALWriter) dropTagsFrom(pos Position) {
+	if wal.cacheFile == nil {
+		return
+	}
+
+	for key, p := range wal.cache.Tags {
+		if !p.less(pos) {
+			delete(wal.cache.Tags, key)
+		}
+	}
+
+	err := wal.cacheFile.Truncate(0)
+	if err == nil {
+		_, err = wal.cacheFile.Seek(0, io.SeekStart)
+	}
+
+	if err == nil {
+		err = wal.cacheEnc.Encode(&wal.cache)
+	}
+
+	if err != nil {
+		wal.logger.Warn("failed to rewrite tag cache", "error", err)
+		return
+	}
+
+	wal.cacheFile.Sync()
+
+	wal.cacheBytes, _ = wal.cacheFile.Seek(0, io.SeekCurrent)
+}
@@ -0,0 +1,209 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestDiscardAfter(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	readAll := func() []string {
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		var values []string
+
+		for r.Next() {
+			values = append(values, string(r.Value()))
+		}
+
+		require.NoError(t, r.Error())
+
+		return values
+	}
+
+	n.It("discards the entries written after the commit tag", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("one")))
+		require.NoError(t, wal.WriteTag([]byte("commit")))
+		require.NoError(t, wal.Write([]byte("two")))
+		require.NoError(t, wal.Write([]byte("three")))
+		require.NoError(t, wal.Close())
+
+		r, pos, err := BeginRecoveryUncommitted(path, []byte("commit"), DefaultReadOptions)
+		require.NoError(t, err)
+
+		var uncommitted []string
+
+		for r.Next() {
+			uncommitted = append(uncommitted, string(r.Value()))
+		}
+
+		require.NoError(t, r.Close())
+
+		assert.Equal(t, []string{"two", "three"}, uncommitted)
+
+		wal, err = New(path)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.DiscardAfter(pos))
+		require.NoError(t, wal.Write([]byte("four")))
+		require.NoError(t, wal.Close())
+
+		assert.Equal(t, []string{"one", "four"}, readAll())
+	})
+
+	n.It("removes the segments after the position", func() {
+		opts := DefaultWriteOptions
+		opts.SegmentSize = 200
+		opts.MaxSegments = 100
+		opts.NoCompression = true
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("one")))
+		require.NoError(t, wal.WriteTag([]byte("commit")))
+
+		for i := 0; i < 4; i++ {
+			require.NoError(t, wal.Write(bytes.Repeat([]byte("x"), 150)))
+		}
+
+		require.NoError(t, wal.WriteTag([]byte("later")))
+
+		r, pos, err := BeginRecoveryUncommitted(path, []byte("commit"), DefaultReadOptions)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+
+		assert.Equal(t, 0, pos.Segment)
+
+		require.NoError(t, wal.DiscardAfter(pos))
+
+		st, err := wal.Stats()
+		require.NoError(t, err)
+
+		assert.Equal(t, 0, st.Segment)
+		assert.Equal(t, 1, st.Segments)
+
+		_, err = os.Stat(filepath.Join(path, "1"))
+		assert.True(t, os.IsNotExist(err))
+
+		_, err = wal.TagPos([]byte("later"))
+		assert.Equal(t, ErrTagNotFound, err)
+
+		require.NoError(t, wal.Write([]byte("two")))
+		require.NoError(t, wal.Close())
+
+		assert.Equal(t, []string{"one", "two"}, readAll())
+
+		report, err := Verify(path)
+		require.NoError(t, err)
+
+		assert.True(t, report.OK)
+	})
+
+	n.It("cuts a sealed segment without touching its links or breaking its seal", func() {
+		wal, err := New(path, WithSegmentSize(200), WithMaxSegments(100), WithCompression(false), WithSealing(true))
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("one")))
+
+		for wal.index == 0 {
+			require.NoError(t, wal.Write(bytes.Repeat([]byte("x"), 150)))
+		}
+
+		require.NoError(t, wal.Write([]byte("discarded")))
+
+		segment := filepath.Join(path, "0")
+
+		sealed, err := ioutil.ReadFile(segment)
+		require.NoError(t, err)
+
+		link := filepath.Join(dir, "link")
+		defer os.Remove(link)
+
+		require.NoError(t, os.Link(segment, link))
+
+		// The end of the segment's records, past its seal.
+		end := Position{0, int64(len(sealed) - len(closingMagic))}
+
+		require.NoError(t, wal.DiscardAfter(end))
+		require.NoError(t, wal.Write([]byte("two")))
+		require.NoError(t, wal.Close())
+
+		data, err := ioutil.ReadFile(link)
+		require.NoError(t, err)
+
+		assert.True(t, bytes.Equal(sealed, data))
+
+		values := readAll()
+		require.True(t, len(values) > 2)
+
+		assert.Equal(t, "one", values[0])
+		assert.Equal(t, "two", values[len(values)-1])
+
+		report, err := Verify(path)
+		require.NoError(t, err)
+
+		assert.True(t, report.OK)
+	})
+
+	n.It("discards everything without the tag", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("one")))
+
+		r, pos, err := BeginRecoveryUncommitted(path, []byte("commit"), DefaultReadOptions)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+
+		assert.Equal(t, Position{0, 0}, pos)
+
+		require.NoError(t, wal.DiscardAfter(pos))
+		require.NoError(t, wal.Close())
+
+		assert.Empty(t, readAll())
+	})
+
+	n.It("rejects a position in the middle of an entry", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		require.NoError(t, wal.Write([]byte("hello")))
+
+		err = wal.DiscardAfter(Position{0, 1})
+		assert.True(t, errors.Is(err, ErrInvalidPosition))
+
+		err = wal.DiscardAfter(Position{5, 0})
+		assert.True(t, errors.Is(err, ErrInvalidPosition))
+	})
+
+	n.Meow()
+}
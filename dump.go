@@ -0,0 +1,153 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DumpFormat selects how Dump renders records.
+type DumpFormat int
+
+const (
+	// One tab separated line per record: its position, type, size and
+	// CRC, followed by the decoded value if requested.
+	DumpText DumpFormat = iota
+
+	// One JSON object per line, with the value base64 encoded.
+	DumpJSON
+)
+
+// DumpDecoding selects how Dump renders each record's value.
+type DumpDecoding int
+
+const (
+	DecodeNone DumpDecoding = iota
+
+	// The value is quoted as a Go string.
+	DecodeText
+
+	// The value is compacted JSON, or a note if it isn't valid JSON.
+	DecodeJSON
+)
+
+// DumpOptions controls what Dump prints.
+type DumpOptions struct {
+	Format DumpFormat
+	Decode DumpDecoding
+
+	// Follow each text record with a hex dump of its value.
+	Hex bool
+
+	// Only dump records from From, and before To if it's set.
+	From Position
+	To   *Position
+
+	// Only dump records of this type, if set.
+	Type RecordType
+}
+
+// errStopDump ends the scan once Dump reaches DumpOptions.To.
+var errStopDump = errors.New("stop dump")
+
+// dumpRecord is the form of a record written by DumpJSON.
+type dumpRecord struct {
+	Pos   string          `json:"pos"`
+	Type  string          `json:"type"`
+	Size  int             `json:"size"`
+	CRC   uint32          `json:"crc"`
+	Value []byte          `json:"value"`
+	Text  string          `json:"text,omitempty"`
+	JSON  json.RawMessage `json:"json,omitempty"`
+}
+
+// Dump writes a human readable listing of the records in the WAL in
+// root, including tags, to w. It's what the wal command's dump
+// subcommand prints.
+func Dump(root string, w io.Writer, opts DumpOptions) error {
+	err := ScanRecords(root, opts.From, func(rec Record) error {
+		if opts.To != nil && !rec.Pos.less(*opts.To) {
+			return errStopDump
+		}
+
+		if opts.Type != 0 && rec.Type != opts.Type {
+			return nil
+		}
+
+		if opts.Format == DumpJSON {
+			return dumpJSON(w, rec, opts)
+		}
+
+		return dumpText(w, rec, opts)
+	})
+
+	if err == errStopDump {
+		return nil
+	}
+
+	return err
+}
+
+func dumpText(w io.Writer, rec Record, opts DumpOptions) error {
+	_, err := fmt.Fprintf(w, "%s\t%s\t%d\tcrc=%08x", rec.Pos, rec.Type, len(rec.Value), rec.CRC)
+	if err != nil {
+		return err
+	}
+
+	switch opts.Decode {
+	case DecodeJSON:
+		var buf bytes.Buffer
+
+		if err := json.Compact(&buf, rec.Value); err != nil {
+			fmt.Fprintf(w, "\t(invalid json: %s)", err)
+		} else {
+			fmt.Fprintf(w, "\t%s", buf.Bytes())
+		}
+	case DecodeText:
+		fmt.Fprintf(w, "\t%q", rec.Value)
+	}
+
+	_, err = fmt.Fprintln(w)
+	if err != nil {
+		return err
+	}
+
+	if opts.Hex {
+		_, err = io.WriteString(w, hex.Dump(rec.Value))
+	}
+
+	return err
+}
+
+func dumpJSON(w io.Writer, rec Record, opts DumpOptions) error {
+	out := dumpRecord{
+		Pos:   rec.Pos.String(),
+		Type:  rec.Type.String(),
+		Size:  len(rec.Value),
+		CRC:   rec.CRC,
+		Value: rec.Value,
+	}
+
+	switch opts.Decode {
+	case DecodeJSON:
+		var buf bytes.Buffer
+
+		if json.Compact(&buf, rec.Value) == nil {
+			out.JSON = buf.Bytes()
+		}
+	case DecodeText:
+		out.Text = string(rec.Value)
+	}
+
+	data, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(data, '\n'))
+
+	return err
+}
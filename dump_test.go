@@ -0,0 +1,96 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestDump(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	var mid Position
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte(`{"id": 1}`)))
+		require.NoError(t, wal.WriteTag([]byte("checkpoint")))
+
+		mid, err = wal.Pos()
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("plain")))
+		require.NoError(t, wal.Close())
+	})
+
+	dump := func(opts DumpOptions) []string {
+		var out bytes.Buffer
+
+		require.NoError(t, Dump(path, &out, opts))
+
+		return strings.Split(strings.TrimSpace(out.String()), "\n")
+	}
+
+	n.It("lists every record", func() {
+		out := dump(DumpOptions{Decode: DecodeText})
+
+		require.Equal(t, 3, len(out))
+
+		assert.True(t, strings.HasPrefix(out[0], "0:0\tdata\t9\tcrc="))
+		assert.True(t, strings.HasSuffix(out[1], "\t\"checkpoint\""))
+		assert.True(t, strings.HasSuffix(out[2], "\t\"plain\""))
+	})
+
+	n.It("limits the records by position and type", func() {
+		out := dump(DumpOptions{To: &mid})
+		assert.Equal(t, 2, len(out))
+
+		out = dump(DumpOptions{From: mid})
+		require.Equal(t, 1, len(out))
+		assert.True(t, strings.HasPrefix(out[0], mid.String()+"\tdata"))
+
+		out = dump(DumpOptions{Type: RecordTag})
+		require.Equal(t, 1, len(out))
+		assert.Contains(t, out[0], "\ttag\t")
+	})
+
+	n.It("writes JSON lines", func() {
+		out := dump(DumpOptions{Format: DumpJSON, Decode: DecodeJSON})
+
+		require.Equal(t, 3, len(out))
+
+		var rec dumpRecord
+
+		require.NoError(t, json.Unmarshal([]byte(out[0]), &rec))
+
+		assert.Equal(t, "0:0", rec.Pos)
+		assert.Equal(t, "data", rec.Type)
+		assert.Equal(t, `{"id": 1}`, string(rec.Value))
+		assert.Equal(t, `{"id":1}`, string(rec.JSON))
+
+		require.NoError(t, json.Unmarshal([]byte(out[2]), &rec))
+
+		assert.Equal(t, "plain", string(rec.Value))
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,118 @@
+package wal
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/golang/snappy"
+)
+
+// encodedRecord is a record that has been compressed and checksummed
+// and is ready to be written to a segment.
+type encodedRecord struct {
+	header []byte
+	body   []byte
+
+	// The size of the data compressed into body, or 0 if it's stored
+	// uncompressed.
+	raw int
+}
+
+func (e encodedRecord) size() int64 {
+	return int64(len(e.header) + len(e.body))
+}
+
+// encodeRecord compresses data, unless t has rawFlag set, and builds
+// the record header for it. buf and hdr are used as scratch space if
+// they are large enough, and allocated otherwise.
+func encodeRecord(cs hash.Hash32, t byte, data, buf, hdr []byte) encodedRecord {
+	if len(hdr) < 5+binary.MaxVarintLen64 {
+		hdr = make([]byte, 5+binary.MaxVarintLen64)
+	}
+
+	out, raw := data, 0
+	if t&rawFlag == 0 {
+		out, raw = snappy.Encode(buf, data), len(data)
+	}
+
+	n := binary.PutUvarint(hdr[5:], uint64(len(out)))
+
+	cs.Reset()
+	cs.Write(hdr[5 : 5+n])
+	cs.Write(out)
+
+	binary.BigEndian.PutUint32(hdr[:4], cs.Sum32())
+
+	hdr[4] = t
+
+	return encodedRecord{header: hdr[:5+n], body: out, raw: raw}
+}
+
+// encodeEntry splits data into blocks of blockSize and encodes them
+// concurrently on up to workers goroutines. If workers is 0, GOMAXPROCS
+// is used.
+func encodeEntry(t byte, data []byte, blockSize, workers int) []encodedRecord {
+	var chunks [][]byte
+
+	if blockSize > 0 {
+		for len(data) > blockSize {
+			chunks = append(chunks, data[:blockSize])
+			data = data[blockSize:]
+		}
+	}
+
+	chunks = append(chunks, data)
+
+	recs := make([]encodedRecord, len(chunks))
+
+	// Not worth a goroutine.
+	if len(chunks) == 1 {
+		recs[0] = encodeRecord(crc32.NewIEEE(), t, data, nil, nil)
+		return recs
+	}
+
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	if workers > len(chunks) {
+		workers = len(chunks)
+	}
+
+	var (
+		wg   sync.WaitGroup
+		next int64 = -1
+	)
+
+	wg.Add(workers)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+
+			cs := crc32.NewIEEE()
+
+			for {
+				j := int(atomic.AddInt64(&next, 1))
+				if j >= len(chunks) {
+					return
+				}
+
+				var typ byte = blockType | t&rawFlag
+				if j == len(chunks)-1 {
+					typ = t
+				}
+
+				recs[j] = encodeRecord(cs, typ, chunks[j], nil, nil)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return recs
+}
@@ -0,0 +1,108 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+var (
+	// Matches any CorruptError.
+	ErrCorrupt = errors.New("corrupt data")
+
+	// Returned when a write fails because the disk is full.
+	ErrWALFull = errors.New("no space left for the WAL")
+
+	// Returned by writes to a closed WAL.
+	ErrClosed = errors.New("WAL is closed")
+
+	// Returned when opening a WAL that another writer has open.
+	ErrLocked = errors.New("WAL is locked by another writer")
+
+	// Returned when opening a WAL whose newest segment wasn't closed
+	// cleanly, if the UncleanPolicy says to abort or the segment fails
+	// verification.
+	ErrUncleanShutdown = errors.New("WAL was not closed cleanly")
+
+	// Returned when opening a WAL with options that can't work.
+	ErrInvalidOptions = errors.New("invalid write options")
+
+	// Returned when looking up a tag that isn't in the WAL.
+	ErrTagNotFound = errors.New("tag not found")
+
+	// Returned for a position that isn't the start of an entry, or the
+	// end of a segment.
+	ErrInvalidPosition = errors.New("position is not at an entry")
+
+	// Returned, wrapped in a CorruptError, when a sealed segment has
+	// been changed since it was sealed.
+	ErrSealBroken = errors.New("sealed segment was modified")
+
+	// Reported by Verify for a segment whose records could be read but
+	// that doesn't match its block checksums.
+	ErrBlockChecksum = errors.New("segment does not match its block checksums")
+
+	// Matches any PrunedError.
+	ErrPositionPruned = errors.New("position has been pruned")
+
+	// Matches any ConflictError.
+	ErrReplicationConflict = errors.New("replicated entry conflicts with the local WAL")
+)
+
+// PrunedError is returned when seeking to a position whose segment has
+// been pruned, along with the oldest position still in the WAL, so a
+// consumer that has fallen that far behind can catch up from a
+// snapshot instead. It matches ErrPositionPruned, and ErrSegmentMissing
+// as a missing segment did before, with errors.Is.
+type PrunedError struct {
+	Pos    Position
+	Oldest Position
+
+	Err error
+}
+
+func (e *PrunedError) Error() string {
+	return fmt.Sprintf("position %s has been pruned, oldest is %s", e.Pos, e.Oldest)
+}
+
+func (e *PrunedError) Unwrap() error {
+	return e.Err
+}
+
+func (e *PrunedError) Is(target error) bool {
+	return target == ErrPositionPruned || target == ErrSegmentMissing
+}
+
+// CorruptError reports an entry that couldn't be read because it's
+// damaged, and where it is. It matches ErrCorrupt with errors.Is, and
+// unwraps to the specific problem, such as ErrCorruptCRC.
+type CorruptError struct {
+	// The segment file.
+	Path string
+
+	// The offset of the damaged entry in the segment.
+	Offset int64
+
+	Err error
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("%s: corrupt entry at offset %d: %s", e.Path, e.Offset, e.Err)
+}
+
+func (e *CorruptError) Unwrap() error {
+	return e.Err
+}
+
+func (e *CorruptError) Is(target error) bool {
+	return target == ErrCorrupt
+}
+
+// writeError adds ErrWALFull to err if it's due to the disk being full.
+func writeError(err error) error {
+	if errors.Is(err, syscall.ENOSPC) {
+		return fmt.Errorf("%w: %w", ErrWALFull, err)
+	}
+
+	return err
+}
@@ -0,0 +1,186 @@
+package wal
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestErrors(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	n.It("rejects writes after closing", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Close())
+
+		assert.Equal(t, ErrClosed, wal.Write([]byte("data")))
+		assert.Equal(t, ErrClosed, wal.WriteTag([]byte("tag")))
+	})
+
+	n.It("allows only one writer at a time", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		_, err = New(path)
+		assert.Equal(t, ErrLocked, err)
+
+		require.NoError(t, wal.Close())
+
+		wal, err = New(path)
+		require.NoError(t, err)
+
+		wal.Close()
+	})
+
+	n.It("keeps the lock where LockPath says", func() {
+		lockPath := filepath.Join(dir, "wal.lock")
+		defer os.Remove(lockPath)
+
+		wal, err := New(path, WithLockPath(lockPath))
+		require.NoError(t, err)
+
+		_, err = os.Stat(lockPath)
+		require.NoError(t, err)
+
+		_, err = os.Stat(filepath.Join(path, "lock"))
+		assert.True(t, os.IsNotExist(err))
+
+		_, err = New(path, WithLockPath(lockPath))
+		assert.Equal(t, ErrLocked, err)
+
+		_, err = Compact(path, func(Position, []byte) bool { return true }, WithLockPath(lockPath))
+		assert.Equal(t, ErrLocked, err)
+
+		require.NoError(t, wal.Close())
+
+		_, err = Compact(path, func(Position, []byte) bool { return true }, WithLockPath(lockPath))
+		require.NoError(t, err)
+	})
+
+	n.It("reports where corruption was found", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("data1")))
+
+		pos, err := wal.Pos()
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("data2")))
+		require.NoError(t, wal.Close())
+
+		f, err := os.OpenFile(filepath.Join(path, "0"), os.O_RDWR, 0644)
+		require.NoError(t, err)
+
+		_, err = f.WriteAt([]byte{0, 0, 0, 0}, pos.Offset)
+		require.NoError(t, err)
+
+		f.Close()
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.True(t, r.Next())
+		require.False(t, r.Next())
+
+		err = r.Error()
+		assert.True(t, errors.Is(err, ErrCorrupt))
+		assert.True(t, errors.Is(err, ErrCorruptCRC))
+
+		var cerr *CorruptError
+		require.True(t, errors.As(err, &cerr))
+
+		assert.Equal(t, filepath.Join(path, "0"), cerr.Path)
+		assert.Equal(t, pos.Offset, cerr.Offset)
+	})
+
+	n.It("reports seeking to a missing segment", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Close())
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		err = r.Seek(Position{Segment: 5})
+		assert.True(t, errors.Is(err, ErrSegmentMissing))
+		assert.True(t, errors.Is(err, os.ErrNotExist))
+	})
+
+	n.It("reports seeking to a pruned position", func() {
+		opts := DefaultWriteOptions
+		opts.SegmentSize = 100
+		opts.MaxSegments = 3
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		for i := 0; i < 4; i++ {
+			require.NoError(t, wal.Write([]byte("this is data that fills a segment on its own, more or less")))
+		}
+
+		require.NoError(t, wal.Close())
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		pruned := Position{Segment: 0, Offset: 10}
+
+		for _, err := range []error{r.Seek(pruned), r.Validate(pruned)} {
+			assert.True(t, errors.Is(err, ErrPositionPruned))
+			assert.True(t, errors.Is(err, ErrSegmentMissing))
+			assert.True(t, errors.Is(err, os.ErrNotExist))
+
+			var perr *PrunedError
+			require.True(t, errors.As(err, &perr))
+
+			assert.Equal(t, pruned, perr.Pos)
+			assert.Equal(t, Position{Segment: 1}, perr.Oldest)
+		}
+
+		// A segment missing from the middle wasn't pruned.
+		require.NoError(t, os.Remove(filepath.Join(path, "2")))
+
+		err = r.Seek(Position{Segment: 2})
+		assert.True(t, errors.Is(err, ErrSegmentMissing))
+		assert.False(t, errors.Is(err, ErrPositionPruned))
+	})
+
+	n.It("identifies a full disk", func() {
+		err := writeError(&os.PathError{Op: "write", Path: "0", Err: syscall.ENOSPC})
+
+		assert.True(t, errors.Is(err, ErrWALFull))
+		assert.True(t, errors.Is(err, syscall.ENOSPC))
+
+		assert.Equal(t, ErrClosed, writeError(ErrClosed))
+	})
+
+	n.Meow()
+}
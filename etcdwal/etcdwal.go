@@ -0,0 +1,454 @@
+// Package etcdwal converts between etcd's write-ahead log format and
+// this package's segments, so history can be carried across when
+// migrating from one to the other.
+package etcdwal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/evanphx/wal"
+)
+
+// The record types used by etcd.
+const (
+	MetadataType int64 = iota + 1
+	EntryType
+	StateType
+	CRCType
+	SnapshotType
+)
+
+// Record is a single record from an etcd WAL. For EntryType records,
+// Data is a marshaled raftpb.Entry.
+type Record struct {
+	Type int64
+	CRC  uint32
+	Data []byte
+}
+
+var (
+	ErrCRCMismatch = errors.New("etcd wal crc mismatch")
+	ErrBadRecord   = errors.New("malformed etcd wal record")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// marshal encodes the record as etcd's walpb.Record protobuf message.
+func (rec *Record) marshal() []byte {
+	buf := make([]byte, 0, 1+binary.MaxVarintLen64+1+binary.MaxVarintLen32+1+binary.MaxVarintLen64+len(rec.Data))
+
+	buf = append(buf, 0x08)
+	buf = binary.AppendUvarint(buf, uint64(rec.Type))
+
+	buf = append(buf, 0x10)
+	buf = binary.AppendUvarint(buf, uint64(rec.CRC))
+
+	if rec.Data != nil {
+		buf = append(buf, 0x1a)
+		buf = binary.AppendUvarint(buf, uint64(len(rec.Data)))
+		buf = append(buf, rec.Data...)
+	}
+
+	return buf
+}
+
+// unmarshal decodes a walpb.Record protobuf message.
+func (rec *Record) unmarshal(data []byte) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return ErrBadRecord
+		}
+
+		data = data[n:]
+
+		switch key {
+		case 0x08, 0x10:
+			v, n := binary.Uvarint(data)
+			if n <= 0 {
+				return ErrBadRecord
+			}
+
+			data = data[n:]
+
+			if key == 0x08 {
+				rec.Type = int64(v)
+			} else {
+				rec.CRC = uint32(v)
+			}
+		case 0x1a:
+			l, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < l {
+				return ErrBadRecord
+			}
+
+			rec.Data = data[n : n+int(l)]
+			data = data[n+int(l):]
+		default:
+			return ErrBadRecord
+		}
+	}
+
+	return nil
+}
+
+// walFiles returns the etcd WAL files in dir in sequence order.
+func walFiles(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+
+	for _, name := range names {
+		if strings.HasSuffix(name, ".wal") {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+
+	// The names are fixed width hex, so they sort lexically.
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// ReadAll calls fn with each record in the etcd WAL in dir, verifying
+// the rolling CRC as it goes.
+func ReadAll(dir string, fn func(Record) error) error {
+	files, err := walFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	var crc uint32
+
+	for _, path := range files {
+		err = readFile(path, &crc, fn)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readFile(path string, crc *uint32, fn func(Record) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	var (
+		lenBuf [8]byte
+		buf    []byte
+	)
+
+	for {
+		_, err = io.ReadFull(r, lenBuf[:])
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+
+			return err
+		}
+
+		lenField := binary.LittleEndian.Uint64(lenBuf[:])
+
+		// etcd preallocates its files, so a zero length marks the end.
+		if lenField == 0 {
+			return nil
+		}
+
+		size := int64(lenField & ^(uint64(0xff) << 56))
+
+		var pad int64
+		if lenField&(uint64(0x80)<<56) != 0 {
+			pad = int64((lenField >> 56) & 0x7)
+		}
+
+		if int64(cap(buf)) < size+pad {
+			buf = make([]byte, size+pad)
+		}
+
+		buf = buf[:size+pad]
+
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return err
+		}
+
+		var rec Record
+
+		err = rec.unmarshal(buf[:size])
+		if err != nil {
+			return err
+		}
+
+		if rec.Type == CRCType {
+			if *crc != 0 && rec.CRC != *crc {
+				return ErrCRCMismatch
+			}
+
+			*crc = rec.CRC
+			continue
+		}
+
+		*crc = crc32.Update(*crc, crcTable, rec.Data)
+
+		if rec.CRC != *crc {
+			return ErrCRCMismatch
+		}
+
+		err = fn(rec)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Import writes the data of every entry record in the etcd WAL in dir
+// to w, returning the number of entries written. Metadata, hard state
+// and snapshot records are specific to etcd and are not imported; use
+// ReadAll to access them.
+//
+// When a new raft leader replaces entries that weren't committed, etcd
+// appends the replacements rather than rewriting its WAL, so an entry
+// supersedes every one before it at the same or a later index. As with
+// etcd's own ReadAll, superseded entries are dropped, so only the log
+// raft ended up with is imported.
+func Import(dir string, w *wal.WALWriter) (int, error) {
+	// Find which entries survive from their indexes first, so that the
+	// entries themselves needn't be held in memory.
+	var indexes []uint64
+
+	err := ReadAll(dir, func(rec Record) error {
+		if rec.Type != EntryType {
+			return nil
+		}
+
+		index, err := entryIndex(rec.Data)
+		if err != nil {
+			return err
+		}
+
+		indexes = append(indexes, index)
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	keep := surviving(indexes)
+
+	var i, count int
+
+	err = ReadAll(dir, func(rec Record) error {
+		if rec.Type != EntryType {
+			return nil
+		}
+
+		i++
+
+		if !keep[i-1] {
+			return nil
+		}
+
+		count++
+
+		return w.Write(rec.Data)
+	})
+
+	return count, err
+}
+
+// surviving reports which of the entries with the given raft indexes,
+// in the order they were written, aren't superseded by a later one.
+func surviving(indexes []uint64) []bool {
+	var live []int
+
+	for i, index := range indexes {
+		for len(live) > 0 && indexes[live[len(live)-1]] >= index {
+			live = live[:len(live)-1]
+		}
+
+		live = append(live, i)
+	}
+
+	keep := make([]bool, len(indexes))
+
+	for _, i := range live {
+		keep[i] = true
+	}
+
+	return keep
+}
+
+// entryIndex returns the raft index of a marshaled raftpb.Entry, which
+// is its field 3.
+func entryIndex(data []byte) (uint64, error) {
+	var index uint64
+
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return 0, ErrBadRecord
+		}
+
+		data = data[n:]
+
+		switch key & 0x7 {
+		case 0:
+			v, n := binary.Uvarint(data)
+			if n <= 0 {
+				return 0, ErrBadRecord
+			}
+
+			data = data[n:]
+
+			if key>>3 == 3 {
+				index = v
+			}
+		case 1:
+			if len(data) < 8 {
+				return 0, ErrBadRecord
+			}
+
+			data = data[8:]
+		case 2:
+			l, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < l {
+				return 0, ErrBadRecord
+			}
+
+			data = data[n+int(l):]
+		case 5:
+			if len(data) < 4 {
+				return 0, ErrBadRecord
+			}
+
+			data = data[4:]
+		default:
+			return 0, ErrBadRecord
+		}
+	}
+
+	return index, nil
+}
+
+// Export writes every remaining entry in r to a new etcd WAL in dir as
+// an entry record, returning the number of entries written. Each value
+// must be a marshaled raftpb.Entry. The WAL begins with the given
+// metadata and an empty snapshot marker, as etcd's own WAL does.
+func Export(r *wal.WALReader, dir string, metadata []byte) (int, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return 0, err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%016x-%016x.wal", 0, 0))
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+
+	defer f.Close()
+
+	e := &encoder{w: bufio.NewWriter(f)}
+
+	err = e.encode(&Record{Type: CRCType})
+	if err != nil {
+		return 0, err
+	}
+
+	err = e.encode(&Record{Type: MetadataType, Data: metadata})
+	if err != nil {
+		return 0, err
+	}
+
+	// An empty walpb.Snapshot marshals to no bytes at all.
+	err = e.encode(&Record{Type: SnapshotType, Data: []byte{}})
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+
+	for r.Next() {
+		err = e.encode(&Record{Type: EntryType, Data: r.Value()})
+		if err != nil {
+			return count, err
+		}
+
+		count++
+	}
+
+	if err := r.Error(); err != nil {
+		return count, err
+	}
+
+	err = e.w.Flush()
+	if err != nil {
+		return count, err
+	}
+
+	return count, f.Sync()
+}
+
+type encoder struct {
+	w   *bufio.Writer
+	crc uint32
+}
+
+func (e *encoder) encode(rec *Record) error {
+	e.crc = crc32.Update(e.crc, crcTable, rec.Data)
+	rec.CRC = e.crc
+
+	data := rec.marshal()
+
+	lenField := uint64(len(data))
+
+	pad := (8 - len(data)%8) % 8
+	if pad != 0 {
+		lenField |= uint64(0x80|pad) << 56
+	}
+
+	var lenBuf [8]byte
+	binary.LittleEndian.PutUint64(lenBuf[:], lenField)
+
+	_, err := e.w.Write(lenBuf[:])
+	if err != nil {
+		return err
+	}
+
+	_, err = e.w.Write(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.w.Write(make([]byte, pad))
+	return err
+}
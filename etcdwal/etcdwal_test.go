@@ -0,0 +1,182 @@
+package etcdwal
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/evanphx/wal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestEtcdWAL(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+	etcd := filepath.Join(dir, "etcd")
+	imported := filepath.Join(dir, "imported")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+		os.RemoveAll(etcd)
+		os.RemoveAll(imported)
+	})
+
+	n.It("round trips entries through the etcd format", func() {
+		w, err := wal.New(path)
+		require.NoError(t, err)
+
+		err = w.Write(raftEntry(1, 1, "first entry"))
+		require.NoError(t, err)
+
+		err = w.Write(raftEntry(1, 2, "second entry!"))
+		require.NoError(t, err)
+
+		err = w.Close()
+		require.NoError(t, err)
+
+		r, err := wal.NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		count, err := Export(r, etcd, []byte("meta"))
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, count)
+
+		var types []int64
+
+		err = ReadAll(etcd, func(rec Record) error {
+			types = append(types, rec.Type)
+			return nil
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []int64{MetadataType, SnapshotType, EntryType, EntryType}, types)
+
+		w, err = wal.New(imported)
+		require.NoError(t, err)
+
+		count, err = Import(etcd, w)
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, count)
+
+		err = w.Close()
+		require.NoError(t, err)
+
+		r2, err := wal.NewReader(imported)
+		require.NoError(t, err)
+
+		defer r2.Close()
+
+		require.True(t, r2.Next())
+		assert.Equal(t, raftEntry(1, 1, "first entry"), r2.Value())
+
+		require.True(t, r2.Next())
+		assert.Equal(t, raftEntry(1, 2, "second entry!"), r2.Value())
+	})
+
+	n.It("drops entries superseded by a new leader's", func() {
+		// Written by etcd: entries 1 to 5 in term 1, then 3 and 4 again
+		// in term 2, replacing 3 to 5.
+		w, err := wal.New(imported)
+		require.NoError(t, err)
+
+		count, err := Import(filepath.Join("testdata", "superseded"), w)
+		require.NoError(t, err)
+
+		assert.Equal(t, 4, count)
+
+		require.NoError(t, w.Close())
+
+		r, err := wal.NewReader(imported)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		var (
+			indexes []uint64
+			values  []string
+		)
+
+		for r.Next() {
+			index, err := entryIndex(r.Value())
+			require.NoError(t, err)
+
+			indexes = append(indexes, index)
+			values = append(values, string(r.Value()))
+		}
+
+		require.NoError(t, r.Error())
+
+		assert.Equal(t, []uint64{1, 2, 3, 4}, indexes)
+
+		require.Len(t, values, 4)
+		assert.Contains(t, values[1], "term 1 entry 2")
+		assert.Contains(t, values[2], "term 2 entry 3")
+		assert.Contains(t, values[3], "term 2 entry 4")
+	})
+
+	n.It("detects corruption", func() {
+		w, err := wal.New(path)
+		require.NoError(t, err)
+
+		err = w.Write(raftEntry(1, 1, "first entry"))
+		require.NoError(t, err)
+
+		err = w.Close()
+		require.NoError(t, err)
+
+		r, err := wal.NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		_, err = Export(r, etcd, nil)
+		require.NoError(t, err)
+
+		files, err := walFiles(etcd)
+		require.NoError(t, err)
+
+		data, err := ioutil.ReadFile(files[0])
+		require.NoError(t, err)
+
+		// Flip a byte in the last entry's data.
+		data[len(data)-6] ^= 0xff
+
+		err = ioutil.WriteFile(files[0], data, 0644)
+		require.NoError(t, err)
+
+		err = ReadAll(etcd, func(rec Record) error { return nil })
+		assert.Equal(t, ErrCRCMismatch, err)
+	})
+
+	n.Meow()
+}
+
+// raftEntry marshals a normal raftpb.Entry.
+func raftEntry(term, index uint64, data string) []byte {
+	buf := []byte{0x08, 0}
+
+	buf = append(buf, 0x10)
+	buf = binary.AppendUvarint(buf, term)
+
+	buf = append(buf, 0x18)
+	buf = binary.AppendUvarint(buf, index)
+
+	buf = append(buf, 0x22)
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+
+	return append(buf, data...)
+}
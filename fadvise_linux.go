@@ -0,0 +1,26 @@
+//go:build linux
+// +build linux
+
+package wal
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// adviseSequential tells the kernel that f will be read front to back so
+// it can read ahead aggressively.
+func adviseSequential(f File) {
+	if of, ok := f.(*os.File); ok {
+		unix.Fadvise(int(of.Fd()), 0, 0, unix.FADV_SEQUENTIAL)
+	}
+}
+
+// adviseDontNeed tells the kernel that the cached pages of f won't be
+// used again and can be evicted.
+func adviseDontNeed(f File) {
+	if of, ok := f.(*os.File); ok {
+		unix.Fadvise(int(of.Fd()), 0, 0, unix.FADV_DONTNEED)
+	}
+}
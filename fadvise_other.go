@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package wal
+
+func adviseSequential(f File) {}
+
+func adviseDontNeed(f File) {}
@@ -0,0 +1,158 @@
+package wal
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+var (
+	ErrSegmentNotSealed  = errors.New("segment was not sealed")
+	ErrSegmentOutOfOrder = errors.New("segment does not follow the last applied segment")
+)
+
+// Follower builds a warm-standby copy of a primary's WAL by applying
+// the sealed segments it ships, such as those uploaded by an Archiver.
+// Each segment is validated before it's placed in the directory, so the
+// directory can be read with NewReader, or opened with New on failover,
+// at any time. Records streamed from a primary can instead be written
+// to a WALWriter, as a Replicator does over any Transport, or through
+// an Applier, which also detects a primary whose history has forked.
+type Follower struct {
+	root string
+
+	lock sync.Mutex
+	last int
+}
+
+// NewFollower opens, or creates, the follower directory root.
+func NewFollower(root string) (*Follower, error) {
+	err := os.Mkdir(root, 0755)
+	if err != nil {
+		if !os.IsExist(err) {
+			return nil, err
+		}
+	}
+
+	_, last, err := rangeSegments(OSFS, root)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Follower{root: root, last: last}, nil
+}
+
+// Next returns the index of the segment expected next, or -1 if no
+// segment has been applied yet and any index is accepted.
+func (f *Follower) Next() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.last == -1 {
+		return -1
+	}
+
+	return f.last + 1
+}
+
+// ApplySegment reads the segment with the given index from r and adds
+// it to the directory. Segments must be applied in order, and each must
+// be completely intact and cleanly closed; otherwise nothing is added
+// and an error is returned.
+func (f *Follower) ApplySegment(index int, r io.Reader) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if index < 0 || (f.last != -1 && index != f.last+1) {
+		return ErrSegmentOutOfOrder
+	}
+
+	path := filepath.Join(f.root, strconv.Itoa(index))
+	tmp := filepath.Join(f.root, "incoming."+strconv.Itoa(index))
+
+	err := copyToFile(tmp, r)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	err = verifySealed(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	err = os.Rename(tmp, path)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	err = syncDir(f.root)
+	if err != nil {
+		return err
+	}
+
+	f.last = index
+
+	return nil
+}
+
+// ApplySegmentFile is like ApplySegment but reads the segment from path.
+func (f *Follower) ApplySegmentFile(index int, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer src.Close()
+
+	return f.ApplySegment(index, src)
+}
+
+func copyToFile(path string, r io.Reader) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// verifySealed checks that every entry in the segment at path is intact
+// and that the segment ends with the closing magic.
+func verifySealed(path string) error {
+	rep := verifySegment(path, 0)
+
+	switch {
+	case rep.err != nil:
+		return rep.err
+	case !rep.Sealed:
+		return ErrSegmentNotSealed
+	default:
+		return nil
+	}
+}
+
+func syncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -0,0 +1,107 @@
+package wal
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestFollower(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	primary := filepath.Join(dir, "primary")
+	path := filepath.Join(dir, "follower")
+
+	n.Setup(func() {
+		os.RemoveAll(primary)
+		os.RemoveAll(path)
+
+		opts := DefaultWriteOptions
+		opts.SegmentSize = 20
+
+		w, err := NewWithOptions(primary, opts)
+		require.NoError(t, err)
+
+		defer w.Close()
+
+		require.NoError(t, w.Write([]byte("this is the first segment")))
+		require.NoError(t, w.Write([]byte("this is the second segment")))
+		require.NoError(t, w.Write([]byte("this is the third segment")))
+	})
+
+	n.It("applies sealed segments in order", func() {
+		f, err := NewFollower(path)
+		require.NoError(t, err)
+
+		assert.Equal(t, -1, f.Next())
+
+		require.NoError(t, f.ApplySegmentFile(0, filepath.Join(primary, "0")))
+		require.NoError(t, f.ApplySegmentFile(1, filepath.Join(primary, "1")))
+		require.NoError(t, f.ApplySegmentFile(2, filepath.Join(primary, "2")))
+
+		assert.Equal(t, 3, f.Next())
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.True(t, r.Next())
+		assert.Equal(t, "this is the first segment", string(r.Value()))
+
+		require.True(t, r.Next())
+		assert.Equal(t, "this is the second segment", string(r.Value()))
+
+		assert.False(t, r.Next())
+
+		f, err = NewFollower(path)
+		require.NoError(t, err)
+
+		assert.Equal(t, 3, f.Next())
+	})
+
+	n.It("rejects segments out of order", func() {
+		f, err := NewFollower(path)
+		require.NoError(t, err)
+
+		require.NoError(t, f.ApplySegmentFile(0, filepath.Join(primary, "0")))
+
+		err = f.ApplySegmentFile(2, filepath.Join(primary, "2"))
+		assert.Equal(t, ErrSegmentOutOfOrder, err)
+
+		err = f.ApplySegmentFile(0, filepath.Join(primary, "0"))
+		assert.Equal(t, ErrSegmentOutOfOrder, err)
+	})
+
+	n.It("rejects segments that aren't sealed", func() {
+		f, err := NewFollower(path)
+		require.NoError(t, err)
+
+		data, err := ioutil.ReadFile(filepath.Join(primary, "1"))
+		require.NoError(t, err)
+
+		err = f.ApplySegment(1, bytes.NewReader(data[:len(data)-len(closingMagic)]))
+		assert.Equal(t, ErrSegmentNotSealed, err)
+
+		err = f.ApplySegment(1, bytes.NewReader(data[:len(data)-len(closingMagic)-3]))
+		assert.Error(t, err)
+
+		_, err = os.Stat(filepath.Join(path, "1"))
+		assert.True(t, os.IsNotExist(err))
+
+		assert.Equal(t, -1, f.Next())
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,276 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ConsumerGroup hands the entries of a WAL out to a pool of named
+// consumers so that each entry is owned by exactly one of them until
+// it's acknowledged. The group's committed position, before which every
+// entry has been acknowledged, is stored in the WAL directory, so a
+// reopened group resumes with the first unacknowledged entry. This lets
+// a WAL serve as a simple durable work queue.
+//
+// Entries claimed but not acknowledged when the process exits are
+// delivered again after the group is reopened.
+type ConsumerGroup struct {
+	name string
+	path string
+
+	lock sync.Mutex
+	r    *WALReader
+
+	committed Position
+
+	// Claimed entries in WAL order, and the ones released by their
+	// consumer that are waiting to be claimed again.
+	inflight []*claim
+	released []*claim
+}
+
+// Claim is an entry owned by a consumer in a group.
+type Claim struct {
+	Consumer string
+
+	// The position just past the entry, which identifies it when
+	// acknowledging.
+	Pos Position
+
+	Value []byte
+}
+
+type claim struct {
+	owner string
+	pos   Position
+	value []byte
+	acked bool
+}
+
+type groupState struct {
+	Committed Position `json:"committed"`
+}
+
+var (
+	ErrInvalidGroupName = errors.New("invalid consumer group name")
+	ErrNotClaimed       = errors.New("entry is not claimed by consumer")
+)
+
+const groupPrefix = "group."
+
+// OpenConsumerGroup opens, or creates, the named consumer group for the
+// WAL in root.
+func OpenConsumerGroup(root, name string) (*ConsumerGroup, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return nil, ErrInvalidGroupName
+	}
+
+	r, err := NewReader(root)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &ConsumerGroup{
+		name: name,
+		path: filepath.Join(root, groupPrefix+name),
+		r:    r,
+	}
+
+	data, err := ioutil.ReadFile(g.path)
+	switch {
+	case err == nil:
+		var st groupState
+
+		err = json.Unmarshal(data, &st)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+
+		err = r.Seek(st.Committed)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+
+		g.committed = st.Committed
+	case os.IsNotExist(err):
+		g.committed, err = r.Pos()
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+	default:
+		r.Close()
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// Name returns the name of the group.
+func (g *ConsumerGroup) Name() string {
+	return g.name
+}
+
+// Committed returns the position before which every entry has been
+// acknowledged.
+func (g *ConsumerGroup) Committed() Position {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	return g.committed
+}
+
+// Claim assigns the next available entry to consumer. Released entries
+// are handed out again before new ones are read. If there is no entry
+// available, it returns ErrNoData.
+func (g *ConsumerGroup) Claim(consumer string) (Claim, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	var c *claim
+
+	if len(g.released) > 0 {
+		c = g.released[0]
+		g.released = g.released[1:]
+	} else {
+		if !g.r.Next() {
+			if err := g.r.Error(); err != nil {
+				return Claim{}, err
+			}
+
+			return Claim{}, ErrNoData
+		}
+
+		pos, err := g.r.Pos()
+		if err != nil {
+			return Claim{}, err
+		}
+
+		c = &claim{
+			pos:   pos,
+			value: append([]byte(nil), g.r.Value()...),
+		}
+
+		g.inflight = append(g.inflight, c)
+	}
+
+	c.owner = consumer
+
+	return Claim{Consumer: consumer, Pos: c.pos, Value: c.value}, nil
+}
+
+func (g *ConsumerGroup) find(consumer string, pos Position) *claim {
+	for _, c := range g.inflight {
+		if c.pos == pos && c.owner == consumer && !c.acked {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// Ack marks the entry at pos, claimed by consumer, as processed. Once
+// every entry before it has also been acknowledged, the group's
+// committed position moves past it and is saved.
+func (g *ConsumerGroup) Ack(consumer string, pos Position) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	c := g.find(consumer, pos)
+	if c == nil {
+		return ErrNotClaimed
+	}
+
+	c.acked = true
+
+	committed := g.committed
+
+	for len(g.inflight) > 0 && g.inflight[0].acked {
+		committed = g.inflight[0].pos
+		g.inflight = g.inflight[1:]
+	}
+
+	if committed == g.committed {
+		return nil
+	}
+
+	err := g.save(committed)
+	if err != nil {
+		return err
+	}
+
+	g.committed = committed
+
+	return nil
+}
+
+// Release gives up consumer's claim on the entry at pos so it can be
+// claimed by another consumer.
+func (g *ConsumerGroup) Release(consumer string, pos Position) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	c := g.find(consumer, pos)
+	if c == nil {
+		return ErrNotClaimed
+	}
+
+	c.owner = ""
+	g.released = append(g.released, c)
+
+	return nil
+}
+
+// ReleaseAll gives up every claim held by consumer, such as when it has
+// stopped running. It returns the number of entries released.
+func (g *ConsumerGroup) ReleaseAll(consumer string) int {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	var n int
+
+	for _, c := range g.inflight {
+		if c.owner == consumer && !c.acked {
+			c.owner = ""
+			g.released = append(g.released, c)
+			n++
+		}
+	}
+
+	return n
+}
+
+// save writes the committed position to a temporary file, syncs it and
+// renames it into place so a crash never leaves a partial or empty state
+// file.
+func (g *ConsumerGroup) save(pos Position) error {
+	data, err := json.Marshal(groupState{Committed: pos})
+	if err != nil {
+		return err
+	}
+
+	tmp := g.path + ".tmp"
+
+	err = copyToFile(tmp, bytes.NewReader(data))
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, g.path)
+}
+
+func (g *ConsumerGroup) Close() error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	return g.r.Close()
+}
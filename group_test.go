@@ -0,0 +1,182 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestConsumerGroup(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	write := func(values ...string) {
+		w, err := New(path)
+		require.NoError(t, err)
+
+		defer w.Close()
+
+		for _, v := range values {
+			require.NoError(t, w.Write([]byte(v)))
+		}
+	}
+
+	n.It("hands each entry to one consumer", func() {
+		write("a", "b", "c")
+
+		g, err := OpenConsumerGroup(path, "workers")
+		require.NoError(t, err)
+
+		defer g.Close()
+
+		c1, err := g.Claim("w1")
+		require.NoError(t, err)
+
+		c2, err := g.Claim("w2")
+		require.NoError(t, err)
+
+		c3, err := g.Claim("w1")
+		require.NoError(t, err)
+
+		assert.Equal(t, "a", string(c1.Value))
+		assert.Equal(t, "b", string(c2.Value))
+		assert.Equal(t, "c", string(c3.Value))
+
+		_, err = g.Claim("w2")
+		assert.Equal(t, ErrNoData, err)
+	})
+
+	n.It("only commits acknowledged entries in order", func() {
+		write("a", "b")
+
+		g, err := OpenConsumerGroup(path, "workers")
+		require.NoError(t, err)
+
+		start := g.Committed()
+
+		c1, err := g.Claim("w1")
+		require.NoError(t, err)
+
+		c2, err := g.Claim("w2")
+		require.NoError(t, err)
+
+		assert.Equal(t, ErrNotClaimed, g.Ack("w1", c2.Pos))
+
+		require.NoError(t, g.Ack("w2", c2.Pos))
+		assert.Equal(t, start, g.Committed())
+
+		require.NoError(t, g.Ack("w1", c1.Pos))
+		assert.Equal(t, c2.Pos, g.Committed())
+
+		require.NoError(t, g.Close())
+
+		g, err = OpenConsumerGroup(path, "workers")
+		require.NoError(t, err)
+
+		defer g.Close()
+
+		assert.Equal(t, c2.Pos, g.Committed())
+
+		_, err = g.Claim("w1")
+		assert.Equal(t, ErrNoData, err)
+	})
+
+	n.It("redelivers unacknowledged entries after reopening", func() {
+		write("a", "b")
+
+		g, err := OpenConsumerGroup(path, "workers")
+		require.NoError(t, err)
+
+		c1, err := g.Claim("w1")
+		require.NoError(t, err)
+
+		_, err = g.Claim("w1")
+		require.NoError(t, err)
+
+		require.NoError(t, g.Ack("w1", c1.Pos))
+		require.NoError(t, g.Close())
+
+		g, err = OpenConsumerGroup(path, "workers")
+		require.NoError(t, err)
+
+		defer g.Close()
+
+		c, err := g.Claim("w2")
+		require.NoError(t, err)
+
+		assert.Equal(t, "b", string(c.Value))
+	})
+
+	n.It("hands released entries to other consumers", func() {
+		write("a", "b")
+
+		g, err := OpenConsumerGroup(path, "workers")
+		require.NoError(t, err)
+
+		defer g.Close()
+
+		c1, err := g.Claim("w1")
+		require.NoError(t, err)
+
+		_, err = g.Claim("w1")
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, g.ReleaseAll("w1"))
+
+		c, err := g.Claim("w2")
+		require.NoError(t, err)
+
+		assert.Equal(t, "a", string(c.Value))
+		assert.Equal(t, ErrNotClaimed, g.Ack("w1", c1.Pos))
+		require.NoError(t, g.Ack("w2", c.Pos))
+
+		assert.Equal(t, ErrNotClaimed, g.Release("w2", c.Pos))
+	})
+
+	n.It("keeps offsets per group", func() {
+		write("a")
+
+		g1, err := OpenConsumerGroup(path, "one")
+		require.NoError(t, err)
+
+		defer g1.Close()
+
+		g2, err := OpenConsumerGroup(path, "two")
+		require.NoError(t, err)
+
+		defer g2.Close()
+
+		c, err := g1.Claim("w")
+		require.NoError(t, err)
+		require.NoError(t, g1.Ack("w", c.Pos))
+
+		c, err = g2.Claim("w")
+		require.NoError(t, err)
+
+		assert.Equal(t, "a", string(c.Value))
+	})
+
+	n.It("rejects names that aren't a single path element", func() {
+		write("a")
+
+		_, err := OpenConsumerGroup(path, "../x")
+		assert.Equal(t, ErrInvalidGroupName, err)
+	})
+
+	n.Meow()
+}
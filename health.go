@@ -0,0 +1,132 @@
+package wal
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// HealthState summarizes whether a WALWriter can accept writes.
+type HealthState int
+
+const (
+	HealthOK HealthState = iota
+
+	// The last sync failed, so recent writes may not be durable.
+	HealthSyncFailed
+
+	// The last write failed because the disk is full.
+	HealthDiskFull
+
+	// A write has held the writer's lock for longer than
+	// HealthStallTime, so every other write is waiting on it. This
+	// usually means the disk has stopped responding.
+	HealthLocked
+
+	// The writer has been closed.
+	HealthClosed
+)
+
+func (s HealthState) String() string {
+	switch s {
+	case HealthOK:
+		return "ok"
+	case HealthSyncFailed:
+		return "sync-failed"
+	case HealthDiskFull:
+		return "disk-full"
+	case HealthLocked:
+		return "locked"
+	case HealthClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// How long a write may hold the writer's lock before Health reports
+// HealthLocked.
+var HealthStallTime = 5 * time.Second
+
+// Health describes the state of a WALWriter, for readiness probes.
+type Health struct {
+	State HealthState
+
+	// The error behind HealthSyncFailed or HealthDiskFull.
+	Err error
+
+	// When the last successful sync finished, and how long ago that
+	// was. If the WAL hasn't been synced, SinceSync is the time since it
+	// was opened.
+	LastSync  time.Time
+	SinceSync time.Duration
+}
+
+// Health reports whether the WAL is able to accept writes. It never
+// waits on the writer's lock, so it answers promptly even when writes
+// are stuck.
+func (wal *WALWriter) Health() Health {
+	last, _, syncErr := wal.syncs.get()
+
+	h := Health{LastSync: last}
+
+	if last.IsZero() {
+		h.SinceSync = time.Since(wal.opened)
+	} else {
+		h.SinceSync = time.Since(last)
+	}
+
+	if wal.lock.TryLock() {
+		closed := wal.closed
+		wal.lock.Unlock()
+
+		if closed {
+			h.State = HealthClosed
+			h.Err = ErrClosed
+			return h
+		}
+	} else if since := atomic.LoadInt64(&wal.busySince); since != 0 {
+		if time.Since(time.Unix(0, since)) > HealthStallTime {
+			h.State = HealthLocked
+			return h
+		}
+	}
+
+	switch {
+	case atomic.LoadInt32(&wal.full) != 0:
+		h.State = HealthDiskFull
+		h.Err = ErrWALFull
+	case syncErr != nil:
+		h.State = HealthSyncFailed
+		h.Err = syncErr
+	}
+
+	return h
+}
+
+// writeResult adds ErrWALFull to the error from a write if the disk is
+// full, and records whether it was for Health.
+func (wal *WALWriter) writeResult(err error) error {
+	err = writeError(err)
+
+	var full int32
+	if errors.Is(err, ErrWALFull) {
+		full = 1
+	}
+
+	atomic.StoreInt32(&wal.full, full)
+
+	return err
+}
+
+// lockIO takes the writer's lock for an operation that writes to disk,
+// noting when so Health can spot one that's stuck.
+func (wal *WALWriter) lockIO() {
+	wal.lock.Lock()
+	atomic.StoreInt64(&wal.busySince, time.Now().UnixNano())
+}
+
+func (wal *WALWriter) unlockIO() {
+	atomic.StoreInt64(&wal.busySince, 0)
+	wal.lock.Unlock()
+}
@@ -0,0 +1,171 @@
+package wal
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+// faultyFS fails writes or syncs of the files opened through it while
+// the matching flag is set.
+type faultyFS struct {
+	FS
+
+	failWrite int32
+	failSync  int32
+}
+
+func (f *faultyFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	file, err := f.FS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &faultyFile{File: file, fs: f}, nil
+}
+
+type faultyFile struct {
+	File
+
+	fs *faultyFS
+}
+
+func (f *faultyFile) Write(b []byte) (int, error) {
+	if atomic.LoadInt32(&f.fs.failWrite) != 0 {
+		return 0, &os.PathError{Op: "write", Path: f.Name(), Err: syscall.ENOSPC}
+	}
+
+	return f.File.Write(b)
+}
+
+func (f *faultyFile) Sync() error {
+	if atomic.LoadInt32(&f.fs.failSync) != 0 {
+		return &os.PathError{Op: "sync", Path: f.Name(), Err: syscall.EIO}
+	}
+
+	return f.File.Sync()
+}
+
+func TestHealth(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	n.It("reports ok and the time since the last sync", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		h := wal.Health()
+		assert.Equal(t, HealthOK, h.State)
+		assert.True(t, h.LastSync.IsZero())
+
+		require.NoError(t, wal.Write([]byte("hello")))
+
+		h = wal.Health()
+		assert.Equal(t, HealthOK, h.State)
+		assert.NoError(t, h.Err)
+		assert.False(t, h.LastSync.IsZero())
+		assert.True(t, h.SinceSync < time.Minute)
+		assert.Equal(t, "ok", h.State.String())
+	})
+
+	n.It("reports a failed sync until one succeeds", func() {
+		fs := &faultyFS{FS: OSFS}
+
+		wal, err := New(path, WithFS(fs))
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		require.NoError(t, wal.Write([]byte("hello")))
+
+		last := wal.Health().LastSync
+
+		atomic.StoreInt32(&fs.failSync, 1)
+
+		require.Error(t, wal.Write([]byte("hello")))
+
+		h := wal.Health()
+		assert.Equal(t, HealthSyncFailed, h.State)
+		assert.True(t, errors.Is(h.Err, syscall.EIO))
+		assert.Equal(t, last, h.LastSync)
+
+		atomic.StoreInt32(&fs.failSync, 0)
+
+		require.NoError(t, wal.Write([]byte("hello")))
+
+		assert.Equal(t, HealthOK, wal.Health().State)
+	})
+
+	n.It("reports a full disk until a write succeeds", func() {
+		fs := &faultyFS{FS: OSFS}
+
+		wal, err := New(path, WithFS(fs))
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		atomic.StoreInt32(&fs.failWrite, 1)
+
+		require.Error(t, wal.Write([]byte("hello")))
+
+		h := wal.Health()
+		assert.Equal(t, HealthDiskFull, h.State)
+		assert.True(t, errors.Is(h.Err, ErrWALFull))
+
+		atomic.StoreInt32(&fs.failWrite, 0)
+
+		require.NoError(t, wal.Write([]byte("hello")))
+
+		assert.Equal(t, HealthOK, wal.Health().State)
+	})
+
+	n.It("reports a write that has held the lock too long", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		wal.lockIO()
+		assert.Equal(t, HealthOK, wal.Health().State)
+
+		atomic.StoreInt64(&wal.busySince, time.Now().Add(-2*HealthStallTime).UnixNano())
+		assert.Equal(t, HealthLocked, wal.Health().State)
+
+		wal.unlockIO()
+		assert.Equal(t, HealthOK, wal.Health().State)
+	})
+
+	n.It("reports a closed writer", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Close())
+
+		h := wal.Health()
+		assert.Equal(t, HealthClosed, h.State)
+		assert.Equal(t, ErrClosed, h.Err)
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,60 @@
+package wal
+
+import "iter"
+
+// All returns an iterator over the entries from the reader's current
+// position on, along with the position each one starts at, for use in a
+// range loop. The value is only valid until the next iteration. The
+// iterator stops at the end of the WAL or on an error, so check Error
+// once the loop is done.
+func (r *WALReader) All() iter.Seq2[Position, []byte] {
+	return func(yield func(Position, []byte) bool) {
+		for r.Next() {
+			if !yield(r.entryPos(), r.Value()) {
+				return
+			}
+		}
+	}
+}
+
+// AllRecords is like All, but also yields the tags between the entries,
+// as Records whose Type tells them apart. A stream reader yields the
+// stream's tags.
+func (r *WALReader) AllRecords() iter.Seq[Record] {
+	return func(yield func(Record) bool) {
+		r.setTags(true)
+		defer r.setTags(false)
+
+		for r.Next() {
+			hdr := r.Header()
+
+			rec := Record{
+				Pos:   r.entryPos(),
+				Type:  hdr.Type,
+				CRC:   hdr.CRC,
+				Value: r.Value(),
+			}
+
+			if !yield(rec) {
+				return
+			}
+		}
+	}
+}
+
+// entryPos returns where the current entry starts.
+func (r *WALReader) entryPos() Position {
+	return Position{r.index, r.Header().Offset}
+}
+
+// setTags sets whether tags are read along with entries. Any entries
+// already read ahead are dropped so they're read again with the new
+// setting.
+func (r *WALReader) setTags(on bool) {
+	r.tags = on
+
+	if r.seg != nil {
+		r.seg.stopPrefetch()
+		r.seg.tags = on
+	}
+}
@@ -0,0 +1,183 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestIterators(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	var tagPos Position
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		a, err := wal.Stream("a")
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("data1")))
+		require.NoError(t, a.Write([]byte("a1")))
+		require.NoError(t, a.WriteTag([]byte("atag")))
+
+		tagPos, err = wal.Pos()
+		require.NoError(t, err)
+
+		require.NoError(t, wal.WriteTag([]byte("tag")))
+		require.NoError(t, wal.Write([]byte("data2")))
+
+		require.NoError(t, wal.Close())
+	})
+
+	n.It("ranges over the entries and their positions", func() {
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		var (
+			values []string
+			starts []Position
+		)
+
+		for pos, val := range r.All() {
+			values = append(values, string(val))
+			starts = append(starts, pos)
+		}
+
+		require.NoError(t, r.Error())
+
+		assert.Equal(t, []string{"data1", "data2"}, values)
+
+		assert.Equal(t, Position{0, 0}, starts[0])
+
+		// Seeking to a yielded position reads that entry again.
+		require.NoError(t, r.Seek(starts[1]))
+		require.True(t, r.Next())
+		assert.Equal(t, "data2", string(r.Value()))
+	})
+
+	n.It("can stop part way and carry on", func() {
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		for _, val := range r.All() {
+			assert.Equal(t, "data1", string(val))
+			break
+		}
+
+		var values []string
+
+		for _, val := range r.All() {
+			values = append(values, string(val))
+		}
+
+		assert.Equal(t, []string{"data2"}, values)
+	})
+
+	n.It("includes tags with AllRecords", func() {
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		var recs []Record
+
+		for rec := range r.AllRecords() {
+			rec.Value = append([]byte(nil), rec.Value...)
+			recs = append(recs, rec)
+		}
+
+		require.NoError(t, r.Error())
+		require.Equal(t, 3, len(recs))
+
+		assert.Equal(t, RecordData, recs[0].Type)
+		assert.Equal(t, RecordTag, recs[1].Type)
+		assert.Equal(t, "tag", string(recs[1].Value))
+		assert.Equal(t, tagPos, recs[1].Pos)
+		assert.Equal(t, "data2", string(recs[2].Value))
+
+		// Tags are only included while ranging over AllRecords.
+		require.NoError(t, r.Seek(tagPos))
+
+		var values []string
+
+		for _, val := range r.All() {
+			values = append(values, string(val))
+		}
+
+		assert.Equal(t, []string{"data2"}, values)
+	})
+
+	n.It("includes a stream's own tags", func() {
+		r, err := NewStreamReader(path, "a", DefaultReadOptions)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		var values []string
+
+		for rec := range r.AllRecords() {
+			values = append(values, rec.Type.String()+":"+string(rec.Value))
+		}
+
+		require.NoError(t, r.Error())
+
+		assert.Equal(t, []string{"stream:a1", "stream-tag:atag"}, values)
+	})
+
+	n.It("works with prefetching", func() {
+		opts := DefaultReadOptions
+		opts.Prefetch = 4
+
+		r, err := NewReaderWithOptions(path, opts)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.True(t, r.Next())
+
+		var types []RecordType
+
+		for rec := range r.AllRecords() {
+			types = append(types, rec.Type)
+		}
+
+		require.NoError(t, r.Error())
+
+		assert.Equal(t, []RecordType{RecordTag, RecordData}, types)
+	})
+
+	n.It("surfaces errors once the loop is done", func() {
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		r.Close()
+
+		for range r.All() {
+			t.Fatal("no entries should be yielded")
+		}
+
+		assert.Equal(t, ErrClosed, r.Error())
+	})
+
+	n.Meow()
+}
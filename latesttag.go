@@ -0,0 +1,97 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// TagInfo describes a tag written to the WAL.
+type TagInfo struct {
+	Tag []byte
+	Pos Position
+
+	// Nil unless the tag was written with WriteTagPayload.
+	Payload []byte
+}
+
+// LatestTag returns the most recently written tag, whatever its name,
+// and positions the reader just after it, as SeekTag does. A stream
+// reader returns the stream's latest tag. It returns ErrTagNotFound if
+// there are no tags.
+//
+// Segments are read from the newest back, stopping at the first with a
+// tag in it, so recovery can tell what kind of checkpoint it's resuming
+// from without seeking to each kind in turn.
+func (wal *WALReader) LatestTag() (TagInfo, error) {
+	none := TagInfo{Pos: Position{-1, -1}}
+
+	if wal.closed {
+		return none, ErrClosed
+	}
+
+	first, last, err := rangeSegments(fsOrOS(wal.opts.FS), wal.root)
+	if err != nil {
+		return none, err
+	}
+
+	// With no segments, first and last are both -1.
+	for index := last; index >= 0 && index >= first; index-- {
+		seg, err := wal.openSegment(index)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return none, err
+		}
+
+		pos, err := seg.seekTag(func([]byte) bool { return true })
+		if err != nil || pos < 0 {
+			seg.Close()
+
+			if err != nil {
+				return none, err
+			}
+
+			continue
+		}
+
+		if wal.seg != nil {
+			wal.seg.Close()
+		}
+
+		wal.seg = seg
+		wal.index = index
+		wal.current = filepath.Join(wal.root, fmt.Sprintf("%d", index))
+
+		if last > wal.last {
+			wal.last = last
+		}
+
+		return TagInfo{Tag: seg.tagName, Pos: Position{index, pos}, Payload: seg.tagPayload}, nil
+	}
+
+	return none, ErrTagNotFound
+}
+
+// LatestTag returns the most recently written tag, whatever its name.
+// See WALReader.LatestTag.
+func (wal *WALWriter) LatestTag() (TagInfo, error) {
+	wal.lock.Lock()
+	closed := wal.closed
+	wal.lock.Unlock()
+
+	if closed {
+		return TagInfo{Pos: Position{-1, -1}}, ErrClosed
+	}
+
+	r, err := newReader(wal.root, nil, ReadOptions{FS: wal.opts.FS})
+	if err != nil {
+		return TagInfo{Pos: Position{-1, -1}}, err
+	}
+
+	defer r.Close()
+
+	return r.LatestTag()
+}
@@ -0,0 +1,130 @@
+package wal
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestLatestTag(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	n.It("returns the most recent tag of any name", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		require.NoError(t, wal.WriteTag([]byte("full")))
+		require.NoError(t, wal.Write([]byte("hello")))
+
+		pos, err := wal.Pos()
+		require.NoError(t, err)
+
+		require.NoError(t, wal.WriteTagPayload([]byte("incremental"), []byte("meta")))
+		require.NoError(t, wal.Write([]byte("world")))
+
+		info, err := wal.LatestTag()
+		require.NoError(t, err)
+
+		assert.Equal(t, "incremental", string(info.Tag))
+		assert.Equal(t, pos, info.Pos)
+		assert.Equal(t, "meta", string(info.Payload))
+	})
+
+	n.It("finds a tag in an earlier segment and reads on from it", func() {
+		opts := DefaultWriteOptions
+		opts.SegmentSize = 200
+		opts.MaxSegments = 100
+		opts.NoCompression = true
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.WriteTag([]byte("checkpoint")))
+		require.NoError(t, wal.Write([]byte("after")))
+
+		for i := 0; i < 5; i++ {
+			require.NoError(t, wal.Write(bytes.Repeat([]byte("x"), 100)))
+		}
+
+		require.NoError(t, wal.Close())
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		info, err := r.LatestTag()
+		require.NoError(t, err)
+
+		assert.Equal(t, "checkpoint", string(info.Tag))
+		assert.Equal(t, Position{0, 0}, info.Pos)
+		assert.Nil(t, info.Payload)
+
+		require.True(t, r.Next())
+		assert.Equal(t, "after", string(r.Value()))
+
+		count := 0
+		for r.Next() {
+			count++
+		}
+
+		require.NoError(t, r.Error())
+		assert.Equal(t, 5, count)
+	})
+
+	n.It("returns ErrTagNotFound without tags", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		require.NoError(t, wal.Write([]byte("hello")))
+
+		_, err = wal.LatestTag()
+		assert.Equal(t, ErrTagNotFound, err)
+	})
+
+	n.It("returns a stream's latest tag", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		a, err := wal.Stream("a")
+		require.NoError(t, err)
+
+		require.NoError(t, a.WriteTag([]byte("stream")))
+		require.NoError(t, wal.WriteTag([]byte("wal")))
+
+		r, err := NewStreamReader(path, "a", DefaultReadOptions)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		info, err := r.LatestTag()
+		require.NoError(t, err)
+
+		assert.Equal(t, "stream", string(info.Tag))
+	})
+
+	n.Meow()
+}
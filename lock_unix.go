@@ -0,0 +1,31 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package wal
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockFile takes an exclusive lock on f, returning ErrLocked if it's
+// already held. The lock is released when f is closed. Files that aren't
+// from the OS filesystem aren't locked.
+func lockFile(f File) error {
+	of, ok := f.(*os.File)
+	if !ok {
+		return nil
+	}
+
+	err := unix.Flock(int(of.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if err == unix.EWOULDBLOCK {
+		return ErrLocked
+	}
+
+	if err != nil {
+		return &os.PathError{Op: "flock", Path: of.Name(), Err: err}
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package wal
+
+import (
+	"context"
+	"log/slog"
+)
+
+// discardHandler drops every record. It's used when no logger is
+// configured.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool { return false }
+
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h discardHandler) WithGroup(string) slog.Handler { return h }
+
+var discardLogger = slog.New(discardHandler{})
+
+func loggerOrDiscard(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return discardLogger
+	}
+
+	return l
+}
@@ -0,0 +1,81 @@
+package wal
+
+import "time"
+
+// How often maintenance that's waiting for WriteOptions.MaintenanceGate
+// to open checks it again.
+var maintenancePollInterval = time.Minute
+
+// MaintenanceWindow is a window of time each day, such as the small
+// hours, in which to run the WAL's heavy maintenance IO. Its Open method
+// can be used as WriteOptions.MaintenanceGate.
+type MaintenanceWindow struct {
+	// When the window opens and closes, as times of day measured from
+	// midnight. If End is before Start, the window spans midnight.
+	Start, End time.Duration
+
+	// The days the window opens on. If empty, it opens every day. A
+	// window that spans midnight belongs to the day it opens on.
+	Days []time.Weekday
+
+	// The time zone the window is in. If nil, the local one is used.
+	Location *time.Location
+}
+
+// Open reports whether t is within the window.
+func (w MaintenanceWindow) Open(t time.Time) bool {
+	if w.Location != nil {
+		t = t.In(w.Location)
+	}
+
+	// Measured by the clock rather than the time elapsed since midnight,
+	// which is an hour off on the days daylight saving starts or ends.
+	h, m, s := t.Clock()
+	since := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute +
+		time.Duration(s)*time.Second + time.Duration(t.Nanosecond())
+	day := t.Weekday()
+
+	switch {
+	case w.Start <= w.End:
+		return since >= w.Start && since < w.End && w.onDay(day)
+	case since >= w.Start:
+		return w.onDay(day)
+	case since < w.End:
+		return w.onDay((day + 6) % 7)
+	default:
+		return false
+	}
+}
+
+func (w MaintenanceWindow) onDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maintenanceOpen reports whether maintenance may run now.
+func (wal *WALWriter) maintenanceOpen() bool {
+	return wal.opts.MaintenanceGate == nil || wal.opts.MaintenanceGate(time.Now())
+}
+
+// awaitMaintenance blocks until maintenance may run, returning false if
+// done is closed first.
+func (wal *WALWriter) awaitMaintenance(done <-chan struct{}) bool {
+	for !wal.maintenanceOpen() {
+		select {
+		case <-time.After(maintenancePollInterval):
+		case <-done:
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,135 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestMaintenance(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	at := func(day, hour, min int) time.Time {
+		// 2024-01-01 was a Monday.
+		return time.Date(2024, 1, day, hour, min, 0, 0, time.UTC)
+	}
+
+	n.It("opens within the window's hours", func() {
+		w := MaintenanceWindow{Start: 1 * time.Hour, End: 5 * time.Hour, Location: time.UTC}
+
+		assert.False(t, w.Open(at(1, 0, 59)))
+		assert.True(t, w.Open(at(1, 1, 0)))
+		assert.True(t, w.Open(at(1, 4, 59)))
+		assert.False(t, w.Open(at(1, 5, 0)))
+	})
+
+	n.It("spans midnight, belonging to the day it opens", func() {
+		w := MaintenanceWindow{
+			Start:    22 * time.Hour,
+			End:      2 * time.Hour,
+			Days:     []time.Weekday{time.Saturday},
+			Location: time.UTC,
+		}
+
+		assert.False(t, w.Open(at(6, 21, 0)))
+		assert.True(t, w.Open(at(6, 23, 0)))
+		assert.True(t, w.Open(at(7, 1, 0)))
+		assert.False(t, w.Open(at(7, 23, 0)))
+		assert.False(t, w.Open(at(6, 1, 0)))
+	})
+
+	n.It("goes by the clock on the days daylight saving changes", func() {
+		loc, err := time.LoadLocation("America/New_York")
+		require.NoError(t, err)
+
+		w := MaintenanceWindow{Start: 4 * time.Hour, End: 6 * time.Hour, Location: loc}
+
+		// Clocks went forward at 2am on 2024-03-10 and back at 2am on
+		// 2024-11-03.
+		for _, day := range []time.Time{
+			time.Date(2024, 3, 10, 0, 0, 0, 0, loc),
+			time.Date(2024, 11, 3, 0, 0, 0, 0, loc),
+		} {
+			y, m, d := day.Date()
+
+			assert.False(t, w.Open(time.Date(y, m, d, 3, 59, 0, 0, loc)), "%s", day)
+			assert.True(t, w.Open(time.Date(y, m, d, 4, 30, 0, 0, loc)), "%s", day)
+			assert.False(t, w.Open(time.Date(y, m, d, 6, 0, 0, 0, loc)), "%s", day)
+		}
+	})
+
+	n.It("leaves pruned segments on disk until the gate opens", func() {
+		old := maintenancePollInterval
+		maintenancePollInterval = 10 * time.Millisecond
+
+		defer func() { maintenancePollInterval = old }()
+
+		var open int32
+
+		wal, err := New(path, WithSegmentSize(100), WithMaxSegments(2), WithMaintenanceGate(func(time.Time) bool {
+			return atomic.LoadInt32(&open) == 1
+		}))
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		for i := 0; i < 5; i++ {
+			require.NoError(t, wal.Write([]byte("this is data that fills a segment on its own, more or less")))
+		}
+
+		first := wal.first
+		require.True(t, first > 0)
+
+		time.Sleep(50 * time.Millisecond)
+
+		_, err = os.Stat(filepath.Join(path, "0"))
+		assert.NoError(t, err)
+
+		st, err := wal.Stats()
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(0), st.Prunes)
+
+		atomic.StoreInt32(&open, 1)
+
+		start := time.Now()
+
+		for {
+			st, err = wal.Stats()
+			require.NoError(t, err)
+
+			if st.Prunes == int64(first) {
+				break
+			}
+
+			require.True(t, time.Since(start) < 5*time.Second, "segments not removed")
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		for i := 0; i < first; i++ {
+			_, err = os.Stat(filepath.Join(path, strconv.Itoa(i)))
+			assert.True(t, os.IsNotExist(err))
+		}
+	})
+
+	n.Meow()
+}
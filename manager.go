@@ -0,0 +1,229 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tomb "gopkg.in/tomb.v2"
+)
+
+var ErrInvalidWALName = errors.New("invalid WAL name")
+
+// Manager keeps many WALs open under one directory, such as one per
+// tenant or shard of a service. Each WAL is a subdirectory of the
+// manager's root, named when it's opened. The WALs share the buffers
+// used to compress entries and, if SyncRate is set, a single goroutine
+// that syncs them all, rather than each having its own.
+type Manager struct {
+	root string
+	opts WriteOptions
+
+	// Compression buffers, lent to the WALs' segments for each record.
+	pool sync.Pool
+
+	lock   sync.Mutex
+	wals   map[string]*WALWriter
+	closed bool
+
+	t tomb.Tomb
+}
+
+// OpenManager opens, or creates, root as a directory of WALs, each of
+// which is opened with opts.
+func OpenManager(root string, opts WriteOptions) (*Manager, error) {
+	err := opts.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	err = fsOrOS(opts.FS).Mkdir(root, 0755)
+	if err != nil {
+		if !os.IsExist(err) {
+			return nil, err
+		}
+	}
+
+	m := &Manager{
+		root: root,
+		opts: opts,
+		wals: make(map[string]*WALWriter),
+	}
+
+	policy := opts.BufferPolicy
+
+	m.pool.New = func() interface{} {
+		buf := make([]byte, policy.initialSize())
+		return &buf
+	}
+
+	m.opts.manager = m
+
+	if opts.SyncRate > 0 {
+		m.t.Go(m.syncEvery)
+	}
+
+	return m, nil
+}
+
+// Open returns the WAL called name, opening or creating it if it isn't
+// already open. Names are used as directory names, so may not contain
+// path separators.
+func (m *Manager) Open(name string) (*WALWriter, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return nil, ErrInvalidWALName
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	if wal, ok := m.wals[name]; ok && !wal.isClosed() {
+		return wal, nil
+	}
+
+	wal, err := NewWithOptions(filepath.Join(m.root, name), m.opts)
+	if err != nil {
+		return nil, err
+	}
+
+	m.wals[name] = wal
+
+	return wal, nil
+}
+
+// Get returns the WAL called name if it's open.
+func (m *Manager) Get(name string) (*WALWriter, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	wal, ok := m.wals[name]
+	if !ok || wal.isClosed() {
+		return nil, false
+	}
+
+	return wal, true
+}
+
+// Names returns the names of the open WALs, in order.
+func (m *Manager) Names() []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var names []string
+
+	for name, wal := range m.wals {
+		if !wal.isClosed() {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// CloseWAL closes the WAL called name, if it's open.
+func (m *Manager) CloseWAL(name string) error {
+	m.lock.Lock()
+	wal, ok := m.wals[name]
+	delete(m.wals, name)
+	m.lock.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	return wal.Close()
+}
+
+// Close stops the sync goroutine and closes every open WAL, returning
+// the first error from closing them.
+func (m *Manager) Close() error {
+	m.lock.Lock()
+
+	if m.closed {
+		m.lock.Unlock()
+		return nil
+	}
+
+	m.closed = true
+
+	wals := m.wals
+	m.wals = nil
+
+	m.lock.Unlock()
+
+	if m.opts.SyncRate > 0 {
+		m.t.Kill(nil)
+		m.t.Wait()
+	}
+
+	var err error
+
+	for _, wal := range wals {
+		if cerr := wal.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// syncEvery syncs each WAL that's been written to once every SyncRate.
+func (m *Manager) syncEvery() error {
+	tick := time.NewTicker(m.opts.SyncRate)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			m.lock.Lock()
+
+			wals := make([]*WALWriter, 0, len(m.wals))
+			for _, wal := range m.wals {
+				wals = append(wals, wal)
+			}
+
+			m.lock.Unlock()
+
+			for _, wal := range wals {
+				err := wal.syncChanged()
+				if err != nil {
+					wal.logger.Error("background sync failed", "path", wal.root, "error", err)
+				}
+			}
+		case <-m.t.Dying():
+			return nil
+		}
+	}
+}
+
+// syncChanged syncs the active segment if it's been written to since
+// it was last synced. It holds the lock so the segment isn't rotated
+// part way through.
+func (wal *WALWriter) syncChanged() error {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	if wal.closed {
+		return nil
+	}
+
+	return wal.segment.syncChanged(context.Background())
+}
+
+func (wal *WALWriter) isClosed() bool {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	return wal.closed
+}
@@ -0,0 +1,147 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestManager(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wals")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	readAll := func(root string) []string {
+		r, err := NewReader(root)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		var values []string
+
+		for r.Next() {
+			values = append(values, string(r.Value()))
+		}
+
+		require.NoError(t, r.Error())
+
+		return values
+	}
+
+	n.It("opens WALs by name", func() {
+		m, err := OpenManager(path, DefaultWriteOptions)
+		require.NoError(t, err)
+
+		defer m.Close()
+
+		a, err := m.Open("a")
+		require.NoError(t, err)
+
+		b, err := m.Open("b")
+		require.NoError(t, err)
+
+		again, err := m.Open("a")
+		require.NoError(t, err)
+		assert.True(t, a == again)
+
+		got, ok := m.Get("b")
+		assert.True(t, ok)
+		assert.True(t, b == got)
+
+		_, ok = m.Get("c")
+		assert.False(t, ok)
+
+		assert.Equal(t, []string{"a", "b"}, m.Names())
+
+		require.NoError(t, a.Write([]byte("to a")))
+		require.NoError(t, b.Write([]byte("to b")))
+
+		require.NoError(t, m.CloseWAL("a"))
+		assert.Equal(t, []string{"b"}, m.Names())
+
+		assert.Equal(t, []string{"to a"}, readAll(filepath.Join(path, "a")))
+
+		_, err = m.Open("../escape")
+		assert.Equal(t, ErrInvalidWALName, err)
+	})
+
+	n.It("syncs every WAL from one goroutine", func() {
+		opts := DefaultWriteOptions
+		opts.SyncRate = 10 * time.Millisecond
+
+		m, err := OpenManager(path, opts)
+		require.NoError(t, err)
+
+		a, err := m.Open("a")
+		require.NoError(t, err)
+
+		b, err := m.Open("b")
+		require.NoError(t, err)
+
+		require.NoError(t, a.Write([]byte("to a")))
+		require.NoError(t, b.Write([]byte("to b")))
+
+		assert.True(t, a.segment.bgSync)
+		assert.Equal(t, time.Duration(0), a.segment.syncRate)
+
+		deadline := time.Now().Add(5 * time.Second)
+
+		for time.Now().Before(deadline) {
+			if !a.Health().LastSync.IsZero() && !b.Health().LastSync.IsZero() {
+				break
+			}
+
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		assert.False(t, a.Health().LastSync.IsZero())
+		assert.False(t, b.Health().LastSync.IsZero())
+
+		require.NoError(t, m.Close())
+
+		_, err = m.Open("a")
+		assert.Equal(t, ErrClosed, err)
+
+		assert.Equal(t, []string{"to a"}, readAll(filepath.Join(path, "a")))
+		assert.Equal(t, []string{"to b"}, readAll(filepath.Join(path, "b")))
+	})
+
+	n.It("shares compression buffers", func() {
+		m, err := OpenManager(path, DefaultWriteOptions)
+		require.NoError(t, err)
+
+		defer m.Close()
+
+		a, err := m.Open("a")
+		require.NoError(t, err)
+
+		assert.Nil(t, a.segment.buf)
+		assert.True(t, a.segment.pool == &m.pool)
+
+		require.NoError(t, a.Write([]byte("to a")))
+		require.NoError(t, a.Close())
+
+		assert.Equal(t, []string{"to a"}, readAll(filepath.Join(path, "a")))
+
+		reopened, err := m.Open("a")
+		require.NoError(t, err)
+		assert.False(t, a == reopened)
+	})
+
+	n.Meow()
+}
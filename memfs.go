@@ -0,0 +1,388 @@
+package wal
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemFS is an FS that keeps everything in memory. It's meant for tests
+// of code that embeds a WAL, which can then run in parallel without
+// temporary directories. Unlike an OS filesystem, Mkdir creates any
+// missing parent directories, so any path can be used as a WAL root.
+type MemFS struct {
+	lock  sync.Mutex
+	dirs  map[string]bool
+	files map[string]*memNode
+}
+
+func NewMemFS() *MemFS {
+	return &MemFS{
+		dirs:  map[string]bool{".": true, "/": true},
+		files: map[string]*memNode{},
+	}
+}
+
+type memNode struct {
+	lock    sync.Mutex
+	data    []byte
+	modTime time.Time
+}
+
+func pathErr(op, name string, err error) error {
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+func (m *MemFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	name = filepath.Clean(name)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.dirs[name] {
+		return nil, pathErr("open", name, os.ErrInvalid)
+	}
+
+	node, ok := m.files[name]
+	switch {
+	case ok && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, pathErr("open", name, os.ErrExist)
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, pathErr("open", name, os.ErrNotExist)
+	case !ok:
+		if !m.dirs[filepath.Dir(name)] {
+			return nil, pathErr("open", name, os.ErrNotExist)
+		}
+
+		node = &memNode{modTime: time.Now()}
+		m.files[name] = node
+	}
+
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+
+	if writable && flag&os.O_TRUNC != 0 {
+		node.lock.Lock()
+		node.data = nil
+		node.modTime = time.Now()
+		node.lock.Unlock()
+	}
+
+	return &memFile{
+		name:     name,
+		node:     node,
+		readable: flag&os.O_WRONLY == 0,
+		writable: writable,
+		append:   flag&os.O_APPEND != 0,
+	}, nil
+}
+
+func (m *MemFS) Mkdir(name string, perm os.FileMode) error {
+	name = filepath.Clean(name)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.dirs[name] || m.files[name] != nil {
+		return pathErr("mkdir", name, os.ErrExist)
+	}
+
+	for dir := name; !m.dirs[dir]; dir = filepath.Dir(dir) {
+		if m.files[dir] != nil {
+			return pathErr("mkdir", name, os.ErrInvalid)
+		}
+
+		m.dirs[dir] = true
+	}
+
+	return nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	name = filepath.Clean(name)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.files[name]; ok {
+		delete(m.files, name)
+		return nil
+	}
+
+	if !m.dirs[name] {
+		return pathErr("remove", name, os.ErrNotExist)
+	}
+
+	if len(m.children(name)) > 0 {
+		return pathErr("remove", name, os.ErrExist)
+	}
+
+	delete(m.dirs, name)
+
+	return nil
+}
+
+func (m *MemFS) Rename(oldname, newname string) error {
+	oldname = filepath.Clean(oldname)
+	newname = filepath.Clean(newname)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	node, ok := m.files[oldname]
+	if !ok {
+		return pathErr("rename", oldname, os.ErrNotExist)
+	}
+
+	if !m.dirs[filepath.Dir(newname)] || m.dirs[newname] {
+		return pathErr("rename", newname, os.ErrInvalid)
+	}
+
+	delete(m.files, oldname)
+	m.files[newname] = node
+
+	return nil
+}
+
+func (m *MemFS) Stat(name string) (os.FileInfo, error) {
+	name = filepath.Clean(name)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.dirs[name] {
+		return memInfo{name: filepath.Base(name), dir: true}, nil
+	}
+
+	node, ok := m.files[name]
+	if !ok {
+		return nil, pathErr("stat", name, os.ErrNotExist)
+	}
+
+	return node.info(name), nil
+}
+
+func (m *MemFS) ReadDirNames(name string) ([]string, error) {
+	name = filepath.Clean(name)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if !m.dirs[name] {
+		return nil, pathErr("open", name, os.ErrNotExist)
+	}
+
+	return m.children(name), nil
+}
+
+// children returns the names of the files and directories in dir, in
+// order.
+func (m *MemFS) children(dir string) []string {
+	var names []string
+
+	for path := range m.files {
+		if filepath.Dir(path) == dir {
+			names = append(names, filepath.Base(path))
+		}
+	}
+
+	for path := range m.dirs {
+		if path != dir && filepath.Dir(path) == dir {
+			names = append(names, filepath.Base(path))
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func (n *memNode) info(name string) memInfo {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	return memInfo{
+		name:    filepath.Base(name),
+		size:    int64(len(n.data)),
+		modTime: n.modTime,
+	}
+}
+
+// memFile is an open handle on a memNode. Like an OS file, it remains
+// usable after the file is removed.
+type memFile struct {
+	name string
+	node *memNode
+	off  int64
+
+	readable bool
+	writable bool
+	append   bool
+	closed   bool
+}
+
+func (f *memFile) check(op string, ok bool) error {
+	if f.closed {
+		return pathErr(op, f.name, os.ErrClosed)
+	}
+
+	if !ok {
+		return pathErr(op, f.name, os.ErrPermission)
+	}
+
+	return nil
+}
+
+func (f *memFile) Read(b []byte) (int, error) {
+	if err := f.check("read", f.readable); err != nil {
+		return 0, err
+	}
+
+	f.node.lock.Lock()
+	defer f.node.lock.Unlock()
+
+	if f.off >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(b, f.node.data[f.off:])
+	f.off += int64(n)
+
+	return n, nil
+}
+
+func (f *memFile) Write(b []byte) (int, error) {
+	if err := f.check("write", f.writable); err != nil {
+		return 0, err
+	}
+
+	f.node.lock.Lock()
+	defer f.node.lock.Unlock()
+
+	if f.append {
+		f.off = int64(len(f.node.data))
+	}
+
+	size := int64(len(f.node.data))
+	end := f.off + int64(len(b))
+
+	if end > size {
+		if end > int64(cap(f.node.data)) {
+			data := make([]byte, end, end*2)
+			copy(data, f.node.data)
+			f.node.data = data
+		} else {
+			f.node.data = f.node.data[:end]
+
+			// Writing past the end leaves a hole, which reads as zeros.
+			for i := size; i < f.off; i++ {
+				f.node.data[i] = 0
+			}
+		}
+	}
+
+	copy(f.node.data[f.off:], b)
+	f.off = end
+	f.node.modTime = time.Now()
+
+	return len(b), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	if err := f.check("seek", true); err != nil {
+		return 0, err
+	}
+
+	f.node.lock.Lock()
+	size := int64(len(f.node.data))
+	f.node.lock.Unlock()
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += size
+	}
+
+	if offset < 0 {
+		return 0, pathErr("seek", f.name, os.ErrInvalid)
+	}
+
+	f.off = offset
+
+	return offset, nil
+}
+
+func (f *memFile) Close() error {
+	if f.closed {
+		return pathErr("close", f.name, os.ErrClosed)
+	}
+
+	f.closed = true
+
+	return nil
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	if err := f.check("stat", true); err != nil {
+		return nil, err
+	}
+
+	return f.node.info(f.name), nil
+}
+
+func (f *memFile) Sync() error {
+	return f.check("sync", true)
+}
+
+func (f *memFile) Truncate(size int64) error {
+	if err := f.check("truncate", f.writable); err != nil {
+		return err
+	}
+
+	if size < 0 {
+		return pathErr("truncate", f.name, os.ErrInvalid)
+	}
+
+	f.node.lock.Lock()
+	defer f.node.lock.Unlock()
+
+	if size <= int64(len(f.node.data)) {
+		f.node.data = f.node.data[:size]
+	} else {
+		data := make([]byte, size)
+		copy(data, f.node.data)
+		f.node.data = data
+	}
+
+	f.node.modTime = time.Now()
+
+	return nil
+}
+
+type memInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) ModTime() time.Time { return i.modTime }
+func (i memInfo) IsDir() bool        { return i.dir }
+func (i memInfo) Sys() interface{}   { return nil }
+
+func (i memInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0755
+	}
+
+	return 0644
+}
@@ -0,0 +1,146 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestMemFS(t *testing.T) {
+	n := neko.Start(t)
+
+	n.It("stores a WAL without touching the disk", func() {
+		fs := NewMemFS()
+
+		opts := DefaultWriteOptions
+		opts.FS = fs
+		opts.SegmentSize = 20
+		opts.MaxSegments = 2
+
+		w, err := NewWithOptions("/nonexistent/wal", opts)
+		require.NoError(t, err)
+
+		require.NoError(t, w.Write([]byte("this is the first value")))
+		require.NoError(t, w.Write([]byte("this is the second value")))
+		require.NoError(t, w.WriteTag([]byte("checkpoint")))
+		require.NoError(t, w.Write([]byte("this is the third value")))
+		require.NoError(t, w.Close())
+
+		_, err = os.Stat("/nonexistent")
+		assert.True(t, os.IsNotExist(err))
+
+		names, err := fs.ReadDirNames("/nonexistent/wal")
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"2", "3", "tags"}, names)
+
+		ro := DefaultReadOptions
+		ro.FS = fs
+
+		r, err := NewReaderWithOptions("/nonexistent/wal", ro)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.True(t, r.Next())
+		assert.Equal(t, "this is the second value", string(r.Value()))
+
+		require.True(t, r.Next())
+		assert.Equal(t, "this is the third value", string(r.Value()))
+
+		assert.False(t, r.Next())
+		require.NoError(t, r.Error())
+
+		pos, err := r.SeekTag([]byte("checkpoint"))
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, pos.Segment)
+	})
+
+	n.It("reopens a WAL written to it", func() {
+		fs := NewMemFS()
+
+		opts := DefaultWriteOptions
+		opts.FS = fs
+
+		w, err := NewWithOptions("wal", opts)
+		require.NoError(t, err)
+
+		require.NoError(t, w.Write([]byte("before")))
+		require.NoError(t, w.Close())
+
+		w, err = NewWithOptions("wal", opts)
+		require.NoError(t, err)
+
+		require.NoError(t, w.Write([]byte("after")))
+		require.NoError(t, w.Close())
+
+		ro := DefaultReadOptions
+		ro.FS = fs
+
+		r, err := NewReaderWithOptions("wal", ro)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		var vals []string
+
+		for r.Next() {
+			vals = append(vals, string(r.Value()))
+		}
+
+		assert.Equal(t, []string{"before", "after"}, vals)
+	})
+
+	n.It("behaves like a filesystem", func() {
+		fs := NewMemFS()
+
+		_, err := fs.OpenFile("dir/a", os.O_RDONLY, 0)
+		assert.True(t, os.IsNotExist(err))
+
+		require.NoError(t, fs.Mkdir("dir", 0755))
+		assert.True(t, os.IsExist(fs.Mkdir("dir", 0755)))
+
+		f, err := fs.OpenFile("dir/a", os.O_CREATE|os.O_RDWR, 0644)
+		require.NoError(t, err)
+
+		_, err = f.Write([]byte("hello world"))
+		require.NoError(t, err)
+
+		require.NoError(t, f.Truncate(5))
+
+		_, err = f.Seek(0, os.SEEK_SET)
+		require.NoError(t, err)
+
+		data, err := ioutil.ReadAll(f)
+		require.NoError(t, err)
+
+		assert.Equal(t, "hello", string(data))
+
+		require.NoError(t, fs.Rename("dir/a", "dir/b"))
+
+		fi, err := fs.Stat("dir/b")
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(5), fi.Size())
+
+		assert.Error(t, fs.Remove("dir"))
+		require.NoError(t, fs.Remove("dir/b"))
+
+		// Open handles outlive removal.
+		_, err = f.Write([]byte("!"))
+		require.NoError(t, err)
+
+		require.NoError(t, f.Close())
+		require.NoError(t, fs.Remove("dir"))
+
+		_, err = fs.Stat("dir")
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	n.Meow()
+}
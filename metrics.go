@@ -0,0 +1,63 @@
+package wal
+
+import "time"
+
+// MetricsSink receives metrics about the operation of a WAL. It lets
+// any metrics system be plugged in without this package depending on
+// it. Implementations must be safe for concurrent use.
+type MetricsSink interface {
+	// IncrCounter adds delta to the named counter.
+	IncrCounter(name string, delta int64)
+
+	// SetGauge sets the named gauge to value.
+	SetGauge(name string, value float64)
+
+	// Timing records how long the named operation took.
+	Timing(name string, d time.Duration)
+}
+
+// The names of the metrics reported to a MetricsSink.
+const (
+	MetricWrites            = "wal.writes"
+	MetricWriteBytes        = "wal.write.bytes"
+	MetricWriteErrors       = "wal.write.errors"
+	MetricWriteLatency      = "wal.write.latency"
+	MetricTags              = "wal.tags"
+	MetricSyncLatency       = "wal.sync.latency"
+	MetricSlowSyncs         = "wal.sync.slow"
+	MetricEscalatedSyncs    = "wal.sync.escalated"
+	MetricUncompressedBytes = "wal.compression.uncompressed.bytes"
+	MetricCompressedBytes   = "wal.compression.compressed.bytes"
+	MetricRotations         = "wal.rotations"
+	MetricPrunedSegments    = "wal.segments.pruned"
+	MetricSegments          = "wal.segments"
+	MetricArchivedSegments  = "wal.segments.archived"
+	MetricArchiveErrors     = "wal.archive.errors"
+	MetricReads             = "wal.reads"
+	MetricReadBytes         = "wal.read.bytes"
+	MetricReadErrors        = "wal.read.errors"
+	MetricFetchedSegments   = "wal.segments.fetched"
+	MetricScrubbedSegments  = "wal.scrub.segments"
+	MetricScrubbedBytes     = "wal.scrub.bytes"
+	MetricScrubDamage       = "wal.scrub.damage"
+	MetricScrubRepairs      = "wal.scrub.repairs"
+	MetricScrubRepairErrors = "wal.scrub.repair.errors"
+)
+
+// NopMetrics is a MetricsSink that discards everything. It's used when
+// no sink is configured.
+type NopMetrics struct{}
+
+func (NopMetrics) IncrCounter(name string, delta int64) {}
+
+func (NopMetrics) SetGauge(name string, value float64) {}
+
+func (NopMetrics) Timing(name string, d time.Duration) {}
+
+func metricsOrNop(m MetricsSink) MetricsSink {
+	if m == nil {
+		return NopMetrics{}
+	}
+
+	return m
+}
@@ -0,0 +1,265 @@
+package wal
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Option adjusts the WriteOptions used by New. Options start from
+// DefaultWriteOptions, so any setting not given keeps its default.
+type Option func(*WriteOptions)
+
+// WithWriteOptions replaces all the options with opts. Options given
+// after it adjust opts.
+func WithWriteOptions(opts WriteOptions) Option {
+	return func(wo *WriteOptions) {
+		*wo = opts
+	}
+}
+
+// WithSegmentSize sets the size at which segments are rotated.
+func WithSegmentSize(n int64) Option {
+	return func(wo *WriteOptions) {
+		wo.SegmentSize = n
+	}
+}
+
+// WithMaxSegments sets how many segments are kept on disk, or keeps
+// them all if n is 0. See WriteOptions.MaxSegments.
+func WithMaxSegments(n int) Option {
+	return func(wo *WriteOptions) {
+		wo.MaxSegments = n
+	}
+}
+
+// WithUnlimitedRetention never prunes segments as the WAL rotates,
+// leaving it to PruneBefore.
+func WithUnlimitedRetention() Option {
+	return func(wo *WriteOptions) {
+		wo.MaxSegments = 0
+	}
+}
+
+// WithRetainForReaders keeps the segments that registered readers
+// haven't finished with. See WriteOptions.RetainForReaders.
+func WithRetainForReaders() Option {
+	return func(wo *WriteOptions) {
+		wo.RetainForReaders = true
+	}
+}
+
+// WithPruneDir moves pruned segments into dir rather than removing
+// them. See WriteOptions.PruneDir.
+func WithPruneDir(dir string) Option {
+	return func(wo *WriteOptions) {
+		wo.PruneDir = dir
+	}
+}
+
+// WithPruneRate removes pruned segments in the background at no more
+// than bytesPerSecond. See WriteOptions.PruneBytesPerSecond.
+func WithPruneRate(bytesPerSecond int64) Option {
+	return func(wo *WriteOptions) {
+		wo.PruneBytesPerSecond = bytesPerSecond
+	}
+}
+
+// WithMaintenanceWindow only runs maintenance IO within w. See
+// WriteOptions.MaintenanceGate.
+func WithMaintenanceWindow(w MaintenanceWindow) Option {
+	return func(wo *WriteOptions) {
+		wo.MaintenanceGate = w.Open
+	}
+}
+
+// WithMaintenanceGate only runs maintenance IO while gate returns true.
+// See WriteOptions.MaintenanceGate.
+func WithMaintenanceGate(gate func(now time.Time) bool) Option {
+	return func(wo *WriteOptions) {
+		wo.MaintenanceGate = gate
+	}
+}
+
+// WithTagCachePath writes the tag cache to path. See
+// WriteOptions.TagCachePath.
+func WithTagCachePath(path string) Option {
+	return func(wo *WriteOptions) {
+		wo.TagCachePath = path
+	}
+}
+
+// WithLockPath keeps the WAL's lock file at path. See
+// WriteOptions.LockPath.
+func WithLockPath(path string) Option {
+	return func(wo *WriteOptions) {
+		wo.LockPath = path
+	}
+}
+
+// WithoutTagCache disables the tag cache.
+func WithoutTagCache() Option {
+	return func(wo *WriteOptions) {
+		wo.NoTagCache = true
+	}
+}
+
+// WithTimeIndex keeps a time index beside each segment, adding a point
+// every interval. See WriteOptions.TimeIndexInterval.
+func WithTimeIndex(interval time.Duration) Option {
+	return func(wo *WriteOptions) {
+		wo.TimeIndexInterval = interval
+	}
+}
+
+// WithBlockChecksums checksums segments in blocks of size bytes as
+// they're rotated out. See WriteOptions.ChecksumBlockSize.
+func WithBlockChecksums(size int64) Option {
+	return func(wo *WriteOptions) {
+		wo.ChecksumBlockSize = size
+	}
+}
+
+// WithScrubbing runs a scrubber that re-reads the sealed segments every
+// interval, reading at most bytesPerSecond. See ScrubOptions.
+func WithScrubbing(interval time.Duration, bytesPerSecond int64) Option {
+	return func(wo *WriteOptions) {
+		wo.Scrub.Interval = interval
+		wo.Scrub.BytesPerSecond = bytesPerSecond
+	}
+}
+
+// WithAutoTag writes tag automatically once as many bytes or entries as
+// opts allows have been written, or as long has passed. See
+// AutoTagOptions.
+func WithAutoTag(tag []byte, opts AutoTagOptions) Option {
+	return func(wo *WriteOptions) {
+		wo.AutoTag = opts
+		wo.AutoTag.Tag = tag
+	}
+}
+
+// WithSealing seals segments as they're rotated out, making them
+// read-only too if readOnly is set. See WriteOptions.SealSegments.
+func WithSealing(readOnly bool) Option {
+	return func(wo *WriteOptions) {
+		wo.SealSegments = true
+		wo.SealReadOnly = readOnly
+	}
+}
+
+// WithoutClosingMagic stops segments being marked as cleanly closed.
+// See WriteOptions.NoClosingMagic.
+func WithoutClosingMagic() Option {
+	return func(wo *WriteOptions) {
+		wo.NoClosingMagic = true
+	}
+}
+
+// WithTotalSize sizes the segments so the WAL uses about total bytes of
+// disk. See WriteOptions.CalculateFromTotal.
+func WithTotalSize(total int64) Option {
+	return func(wo *WriteOptions) {
+		wo.MaxSegments = 0
+		wo.CalculateFromTotal(total)
+	}
+}
+
+// WithSyncRate syncs the WAL in the background at most once every d,
+// rather than after every write.
+func WithSyncRate(d time.Duration) Option {
+	return func(wo *WriteOptions) {
+		wo.SyncRate = d
+	}
+}
+
+// WithMaxUnsyncedBytes syncs on write once n bytes are waiting for a
+// background sync. See WriteOptions.MaxUnsyncedBytes.
+func WithMaxUnsyncedBytes(n int64) Option {
+	return func(wo *WriteOptions) {
+		wo.MaxUnsyncedBytes = n
+	}
+}
+
+// WithNodeID stamps the entries written with Write with id. See
+// WriteOptions.NodeID.
+func WithNodeID(id string) Option {
+	return func(wo *WriteOptions) {
+		wo.NodeID = id
+	}
+}
+
+// WithSlowSync calls fn with each sync that takes at least threshold.
+// See WriteOptions.SlowSyncThreshold.
+func WithSlowSync(threshold time.Duration, fn func(SlowSync)) Option {
+	return func(wo *WriteOptions) {
+		wo.SlowSyncThreshold = threshold
+		wo.OnSlowSync = fn
+	}
+}
+
+// WithCompression controls whether entries are compressed.
+func WithCompression(enabled bool) Option {
+	return func(wo *WriteOptions) {
+		wo.NoCompression = !enabled
+	}
+}
+
+// WithBufferPolicy sets how the buffer used to compress entries grows
+// and shrinks.
+func WithBufferPolicy(p BufferPolicy) Option {
+	return func(wo *WriteOptions) {
+		wo.BufferPolicy = p
+	}
+}
+
+// WithBlockSize sets the size of the blocks large entries are written
+// in.
+func WithBlockSize(n int) Option {
+	return func(wo *WriteOptions) {
+		wo.BlockSize = n
+	}
+}
+
+// WithParallelEncode encodes entries of at least threshold bytes on
+// workers goroutines before taking the writer's lock.
+func WithParallelEncode(threshold, workers int) Option {
+	return func(wo *WriteOptions) {
+		wo.ParallelEncodeThreshold = threshold
+		wo.EncodeWorkers = workers
+	}
+}
+
+// WithMetrics reports metrics to sink.
+func WithMetrics(sink MetricsSink) Option {
+	return func(wo *WriteOptions) {
+		wo.Metrics = sink
+	}
+}
+
+// WithTracer creates spans with t.
+func WithTracer(t Tracer) Option {
+	return func(wo *WriteOptions) {
+		wo.Tracer = t
+	}
+}
+
+// WithLogger logs segment management to l.
+func WithLogger(l *slog.Logger) Option {
+	return func(wo *WriteOptions) {
+		wo.Logger = l
+	}
+}
+
+// WithArchiver gives each sealed segment to a.
+func WithArchiver(a Archiver) Option {
+	return func(wo *WriteOptions) {
+		wo.Archiver = a
+	}
+}
+
+// WithFS stores the WAL in fs.
+func WithFS(fs FS) Option {
+	return func(wo *WriteOptions) {
+		wo.FS = fs
+	}
+}
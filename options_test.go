@@ -0,0 +1,110 @@
+package wal
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestOptions(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	n.It("uses the defaults without options", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		assert.Equal(t, DefaultWriteOptions, wal.opts)
+	})
+
+	n.It("applies options over the defaults", func() {
+		fs := NewMemFS()
+
+		wal, err := New(path,
+			WithSegmentSize(1024),
+			WithMaxSegments(3),
+			WithSyncRate(time.Second),
+			WithCompression(false),
+			WithFS(fs),
+		)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		assert.Equal(t, int64(1024), wal.opts.SegmentSize)
+		assert.Equal(t, 3, wal.opts.MaxSegments)
+		assert.Equal(t, time.Second, wal.opts.SyncRate)
+		assert.True(t, wal.opts.NoCompression)
+		assert.Equal(t, DefaultWriteOptions.BlockSize, wal.opts.BlockSize)
+
+		_, err = fs.Stat(filepath.Join(path, "0"))
+		assert.NoError(t, err)
+	})
+
+	n.It("starts from a base set of options", func() {
+		wal, err := New(path, WithWriteOptions(EmbeddedWriteOptions), WithMaxSegments(8))
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		want := EmbeddedWriteOptions
+		want.MaxSegments = 8
+
+		assert.Equal(t, want, wal.opts)
+	})
+
+	n.It("sizes segments from a total", func() {
+		wal, err := New(path, WithTotalSize(64*1024*1024))
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		assert.Equal(t, int64(MaxSegmentSize), wal.opts.SegmentSize)
+		assert.Equal(t, 4, wal.opts.MaxSegments)
+	})
+
+	n.It("rejects invalid options", func() {
+		bad := []Option{
+			WithSegmentSize(0),
+			WithMaxSegments(-1),
+			WithSyncRate(-time.Second),
+			WithBlockSize(-1),
+			WithParallelEncode(-1, 0),
+			WithBufferPolicy(BufferPolicy{MaxSize: -1}),
+			WithBufferPolicy(BufferPolicy{InitialSize: 4096, MaxSize: 1024}),
+			WithBufferPolicy(BufferPolicy{MaxSize: 1024}),
+		}
+
+		for _, opt := range bad {
+			_, err := New(path, opt)
+			assert.True(t, errors.Is(err, ErrInvalidOptions), "%v", err)
+		}
+
+		_, err := os.Stat(path)
+		assert.True(t, os.IsNotExist(err))
+
+		assert.NoError(t, DefaultWriteOptions.Validate())
+		assert.NoError(t, EmbeddedWriteOptions.Validate())
+	})
+
+	n.Meow()
+}
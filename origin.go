@@ -0,0 +1,138 @@
+package wal
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+	"strconv"
+)
+
+var errBadOriginEntry = errors.New("malformed origin entry")
+
+// Origin identifies where an entry was first written, so pipelines that
+// replicate entries between the WALs of several nodes can tell the
+// entries produced locally from those applied from elsewhere, and
+// avoid sending them back where they came from.
+type Origin struct {
+	// The ID of the node that first wrote the entry. Empty if the entry
+	// has no origin.
+	Node string
+
+	// Where the entry was written in that node's WAL.
+	Pos Position
+}
+
+// IsZero reports whether o is empty, as for entries written without an
+// origin.
+func (o Origin) IsZero() bool {
+	return o.Node == ""
+}
+
+// WriteOrigin is like Write, but records that data was first written
+// at origin, such as by a replication applier copying an entry from
+// another node's WAL. Readers return origin in Header().Origin.
+func (wal *WALWriter) WriteOrigin(data []byte, origin Origin) error {
+	return wal.WriteOriginContext(context.Background(), data, origin)
+}
+
+// WriteOriginContext is like WriteOrigin, but any span created for the
+// write is a child of the one in ctx.
+func (wal *WALWriter) WriteOriginContext(ctx context.Context, data []byte, origin Origin) error {
+	if origin.IsZero() {
+		return wal.writeEntry(ctx, dataType, data)
+	}
+
+	return wal.writeEntry(ctx, originType, frameOrigin(origin, data))
+}
+
+// writeData writes a data entry of type t, stamped with the WAL's
+// NodeID if it has one.
+func (wal *WALWriter) writeData(ctx context.Context, t byte, data []byte) error {
+	if wal.opts.NodeID == "" {
+		return wal.writeEntry(ctx, t, data)
+	}
+
+	// The entry's own position isn't known until the lock is held and
+	// any rotation done, so it's stamped then.
+	_, err := wal.writeEntryAt(ctx, originType|t&rawFlag, data, func(pos Position) []byte {
+		return originPrefix(Origin{Node: wal.opts.NodeID, Pos: pos})
+	})
+
+	return err
+}
+
+// frameOrigin prefixes data with origin, as given by originPrefix.
+func frameOrigin(origin Origin, data []byte) []byte {
+	body := make([]byte, 0, 3*binary.MaxVarintLen64+len(origin.Node)+len(data))
+
+	return append(appendOrigin(body, origin), data...)
+}
+
+// originPrefix returns how origin starts the body of an entry: the
+// node's ID framed like a stream's name, then the segment and offset as
+// varints.
+func originPrefix(origin Origin) []byte {
+	return appendOrigin(make([]byte, 0, 3*binary.MaxVarintLen64+len(origin.Node)), origin)
+}
+
+func appendOrigin(body []byte, origin Origin) []byte {
+	body = binary.AppendUvarint(body, uint64(len(origin.Node)))
+	body = append(body, origin.Node...)
+	body = binary.AppendVarint(body, int64(origin.Pos.Segment))
+
+	return binary.AppendVarint(body, origin.Pos.Offset)
+}
+
+// splitOrigin splits the body of an origin entry into its origin and
+// its value. An entry stamped with the ID of the node that wrote it by
+// an older version of this package has a position of -1:-1, for the
+// caller to fill in.
+func splitOrigin(body []byte) (Origin, []byte, error) {
+	node, rest, ok := splitStreamEntry(body)
+	if !ok {
+		return Origin{}, nil, errBadOriginEntry
+	}
+
+	seg, n := binary.Varint(rest)
+	if n <= 0 {
+		return Origin{}, nil, errBadOriginEntry
+	}
+
+	rest = rest[n:]
+
+	off, n := binary.Varint(rest)
+	if n <= 0 {
+		return Origin{}, nil, errBadOriginEntry
+	}
+
+	origin := Origin{Node: string(node), Pos: Position{int(seg), off}}
+
+	return origin, rest[n:], nil
+}
+
+// entryOrigin splits the body of an origin entry that starts at start
+// in the segment index, filling in the position of a local one.
+func entryOrigin(body []byte, index int, start int64) (Origin, []byte, error) {
+	origin, value, err := splitOrigin(body)
+	if err != nil {
+		return origin, nil, err
+	}
+
+	if origin.Pos.Segment < 0 {
+		origin.Pos = Position{index, start}
+	}
+
+	return origin, value, nil
+}
+
+// segmentIndexOf returns the index of the segment at path, or -1 if its
+// name isn't one.
+func segmentIndexOf(path string) int {
+	index, err := strconv.Atoi(filepath.Base(path))
+	if err != nil {
+		return -1
+	}
+
+	return index
+}
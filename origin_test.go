@@ -0,0 +1,190 @@
+package wal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestOrigin(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+		os.RemoveAll(filepath.Join(dir, "copy"))
+	})
+
+	type entry struct {
+		value  string
+		origin Origin
+	}
+
+	read := func(path string, opts ReadOptions) []entry {
+		r, err := NewReaderWithOptions(path, opts)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		var entries []entry
+
+		for r.Next() {
+			entries = append(entries, entry{string(r.Value()), r.Header().Origin})
+		}
+
+		require.NoError(t, r.Error())
+
+		return entries
+	}
+
+	n.It("stamps local entries with the node and their position", func() {
+		wal, err := New(path, WithNodeID("node-a"))
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("first")))
+
+		pos, err := wal.Pos()
+		require.NoError(t, err)
+
+		require.NoError(t, wal.WriteRaw([]byte("second")))
+		require.NoError(t, wal.Close())
+
+		want := []entry{
+			{"first", Origin{Node: "node-a", Pos: Position{0, 0}}},
+			{"second", Origin{Node: "node-a", Pos: pos}},
+		}
+
+		assert.Equal(t, want, read(path, DefaultReadOptions))
+
+		opts := DefaultReadOptions
+		opts.Prefetch = 4
+
+		assert.Equal(t, want, read(path, opts))
+	})
+
+	n.It("stores the position it stamps, which compaction leaves alone", func() {
+		wal, err := New(path, WithNodeID("node-a"), WithSegmentSize(256), WithMaxSegments(100), WithParallelEncode(64, 2))
+		require.NoError(t, err)
+
+		for i := 0; i < 10; i++ {
+			require.NoError(t, wal.Write([]byte(fmt.Sprintf("entry%d %s", i, strings.Repeat("x", 100)))))
+		}
+
+		require.NoError(t, wal.Close())
+
+		before := read(path, DefaultReadOptions)
+		require.Len(t, before, 10)
+
+		assert.Equal(t, Position{0, 0}, before[0].origin.Pos)
+		assert.True(t, before[9].origin.Pos.Segment > 0)
+
+		drop := func(value string) bool {
+			return value[5] == '0' || value[5] == '5'
+		}
+
+		_, err = Compact(path, func(_ Position, value []byte) bool {
+			return !drop(string(value))
+		})
+		require.NoError(t, err)
+
+		var kept []entry
+
+		for _, e := range before {
+			if !drop(e.value) {
+				kept = append(kept, e)
+			}
+		}
+
+		assert.Equal(t, kept, read(path, DefaultReadOptions))
+	})
+
+	n.It("writes the stamp ahead of the entry so only it's encoded under the lock", func() {
+		wal, err := New(path, WithNodeID("node-a"), WithBlockSize(64))
+		require.NoError(t, err)
+
+		value := strings.Repeat("abcdefgh", 40)
+
+		require.NoError(t, wal.Write([]byte(value)))
+		require.NoError(t, wal.Close())
+
+		sr, err := NewSegmentReader(filepath.Join(path, "0"))
+		require.NoError(t, err)
+
+		defer sr.Close()
+
+		e, err := sr.readRecord()
+		require.NoError(t, err)
+
+		assert.Equal(t, byte(blockType), e.entryType)
+		assert.True(t, e.raw)
+		assert.Equal(t, originPrefix(Origin{Node: "node-a", Pos: Position{0, 0}}), e.value)
+
+		want := []entry{{value, Origin{Node: "node-a", Pos: Position{0, 0}}}}
+
+		assert.Equal(t, want, read(path, DefaultReadOptions))
+
+		opts := DefaultReadOptions
+		opts.StreamValues = true
+
+		assert.Equal(t, want, read(path, opts))
+	})
+
+	n.It("keeps the origin of replicated entries", func() {
+		wal, err := New(path, WithNodeID("node-a"))
+		require.NoError(t, err)
+
+		remote := Origin{Node: "node-b", Pos: Position{3, 120}}
+
+		require.NoError(t, wal.WriteOrigin([]byte("applied"), remote))
+		require.NoError(t, wal.Close())
+
+		assert.Equal(t, []entry{{"applied", remote}}, read(path, DefaultReadOptions))
+	})
+
+	n.It("leaves entries without an origin alone", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("plain")))
+		require.NoError(t, wal.WriteOrigin([]byte("also plain"), Origin{}))
+		require.NoError(t, wal.Close())
+
+		entries := read(path, DefaultReadOptions)
+
+		require.Equal(t, 2, len(entries))
+		assert.True(t, entries[0].origin.IsZero())
+		assert.True(t, entries[1].origin.IsZero())
+	})
+
+	n.It("keeps where local entries were first written when cloned", func() {
+		wal, err := New(path, WithNodeID("node-a"))
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("first")))
+		require.NoError(t, wal.Write([]byte("second")))
+		require.NoError(t, wal.Close())
+
+		want := read(path, DefaultReadOptions)
+
+		dst := filepath.Join(dir, "copy")
+
+		require.NoError(t, CloneWithOptions(path, dst, CloneOptions{From: &Position{0, want[1].origin.Pos.Offset}}))
+
+		assert.Equal(t, want[1:], read(dst, DefaultReadOptions))
+	})
+
+	n.Meow()
+}
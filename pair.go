@@ -1,16 +1,26 @@
 package wal
 
 import (
+	"context"
 	"errors"
+	"path/filepath"
 	"sync"
+	"time"
 )
 
+// PairedWriter is a WALWriter that coordinates with the PairedReaders
+// reading from it, so they can block waiting for new entries and never
+// read part of one that's still being written.
 type PairedWriter struct {
 	*WALWriter
 
 	lock sync.Mutex
 	cond *sync.Cond
 	gen  uint64
+
+	// The end of the data that has been completely written, which
+	// is as far as a paired reader may read.
+	committed Position
 }
 
 type PairedReader struct {
@@ -26,27 +36,96 @@ func NewPair(path string, opts WriteOptions) (*PairedReader, *PairedWriter, erro
 		return nil, nil, err
 	}
 
-	r, err := NewReader(path)
+	pw, err := pairWriter(w)
 	if err != nil {
 		w.Close()
 		return nil, nil, err
 	}
 
-	pw := &PairedWriter{WALWriter: w}
-	pw.cond = sync.NewCond(&pw.lock)
+	r, err := pw.Reader()
+	if err != nil {
+		w.Close()
+		return nil, nil, err
+	}
+
+	return r, pw, nil
+}
+
+var ErrPairMismatch = errors.New("reader and writer are not for the same WAL")
+
+// PairFrom pairs an already open writer and reader of the same WAL, for
+// when the writer needs options NewPair can't give it or is opened long
+// before anything reads it. Only writes made through the returned
+// PairedWriter are made visible to the reader, so the writer shouldn't
+// be used directly afterwards.
+func PairFrom(w *WALWriter, r *WALReader) (*PairedReader, *PairedWriter, error) {
+	if filepath.Clean(w.root) != filepath.Clean(r.root) {
+		return nil, nil, ErrPairMismatch
+	}
+
+	pw, err := pairWriter(w)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	return &PairedReader{WALReader: r, pw: pw}, pw, nil
 }
 
+func pairWriter(w *WALWriter) (*PairedWriter, error) {
+	committed, err := w.Pos()
+	if err != nil {
+		return nil, err
+	}
+
+	pw := &PairedWriter{WALWriter: w, committed: committed}
+	pw.cond = sync.NewCond(&pw.lock)
+
+	return pw, nil
+}
+
+// Reader opens another reader paired with the writer, starting at the
+// beginning of the WAL. A writer can have any number of paired readers,
+// each with its own position, and every write wakes all of them, so one
+// WAL can feed several consumers in the same process.
+func (w *PairedWriter) Reader() (*PairedReader, error) {
+	opts := DefaultReadOptions
+	opts.FS = w.opts.FS
+
+	r, err := NewReaderWithOptions(w.root, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PairedReader{WALReader: r, pw: w}, nil
+}
+
+// ReaderAt is like Reader, but starts at pos, such as where a consumer
+// left off.
+func (w *PairedWriter) ReaderAt(pos Position) (*PairedReader, error) {
+	r, err := w.Reader()
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.Seek(pos)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
 var ErrNoData = errors.New("no data available")
 
-// Next wraps the underlying WALReader's Next() method with a
-// mutex which is shared by the writer. Since we are dealing
-// with file IO, a simple mutex makes it safe for a paired
-// reader and writer to run concurrently.
+// Next wraps the underlying WALReader's Next() method, limiting
+// it to the data the writer has finished writing. This makes it
+// safe for a paired reader and writer to run concurrently without
+// either one waiting on the other's IO.
 func (r *PairedReader) Next() bool {
-	r.pw.lock.Lock()
-	defer r.pw.lock.Unlock()
+	tail := r.pw.committedPos()
+	r.tail = &tail
+
 	return r.WALReader.Next()
 }
 
@@ -69,18 +148,271 @@ func (r *PairedReader) BlockingNext() error {
 	return nil
 }
 
+// Take returns the next entry, waiting for one to be written if the
+// reader has caught up with the writer, along with the position just
+// past it, from which reading can later resume. The value is the
+// caller's to keep. It returns ctx's error if ctx is done first, or the
+// reader's error if reading fails.
+func (r *PairedReader) Take(ctx context.Context) ([]byte, Position, error) {
+	stop := context.AfterFunc(ctx, func() {
+		r.pw.lock.Lock()
+		r.pw.cond.Broadcast()
+		r.pw.lock.Unlock()
+	})
+
+	defer stop()
+
+	for {
+		r.pw.lock.Lock()
+		gen := r.pw.gen
+		r.pw.lock.Unlock()
+
+		if r.Next() {
+			pos, err := r.Pos()
+			if err != nil {
+				return nil, Position{}, err
+			}
+
+			return append([]byte(nil), r.Value()...), pos, nil
+		}
+
+		if err := r.Error(); err != nil {
+			return nil, Position{}, err
+		}
+
+		r.pw.lock.Lock()
+
+		for r.pw.gen == gen && ctx.Err() == nil {
+			r.pw.cond.Wait()
+		}
+
+		r.pw.lock.Unlock()
+
+		if err := ctx.Err(); err != nil {
+			return nil, Position{}, err
+		}
+	}
+}
+
+// TakeBatch is like Take, but returns entries in batches, for sinks
+// such as databases that work better with chunks than with one entry at
+// a time. It waits for an entry as Take does, then keeps taking entries
+// until it has max of them or delay has passed since the first, and
+// returns them along with the position just past the last. If ctx is
+// done once it has some entries, it returns those rather than ctx's
+// error. If reading fails part way through a batch, the entries already
+// taken are returned along with the error.
+func (r *PairedReader) TakeBatch(ctx context.Context, max int, delay time.Duration) ([][]byte, Position, error) {
+	value, pos, err := r.Take(ctx)
+	if err != nil {
+		return nil, Position{}, err
+	}
+
+	batch := [][]byte{value}
+
+	if len(batch) >= max {
+		return batch, pos, nil
+	}
+
+	bctx, cancel := context.WithTimeout(ctx, delay)
+	defer cancel()
+
+	for len(batch) < max {
+		value, next, err := r.Take(bctx)
+		if err != nil {
+			if bctx.Err() != nil {
+				break
+			}
+
+			return batch, pos, err
+		}
+
+		batch = append(batch, value)
+		pos = next
+	}
+
+	return batch, pos, nil
+}
+
 func (r *PairedWriter) Write(d []byte) error {
+	return r.WriteContext(context.Background(), d)
+}
+
+// WriteContext is like Write, but any span created for the write is a
+// child of the one in ctx.
+func (r *PairedWriter) WriteContext(ctx context.Context, d []byte) error {
+	err := r.WALWriter.WriteContext(ctx, d)
+	if err != nil {
+		return err
+	}
+
+	return r.commit()
+}
+
+// WriteTag writes a tag and wakes any reader blocked in BlockingNext,
+// so readers waiting on commit markers see them promptly.
+func (r *PairedWriter) WriteTag(tag []byte) error {
+	return r.WriteTagContext(context.Background(), tag)
+}
+
+// WriteTagContext is like WriteTag, but any span created for the write
+// is a child of the one in ctx.
+func (r *PairedWriter) WriteTagContext(ctx context.Context, tag []byte) error {
+	err := r.WALWriter.WriteTagContext(ctx, tag)
+	if err != nil {
+		return err
+	}
+
+	return r.commit()
+}
+
+// WriteRaw is like Write, but stores d uncompressed. See
+// WALWriter.WriteRaw.
+func (r *PairedWriter) WriteRaw(d []byte) error {
+	return r.WriteRawContext(context.Background(), d)
+}
+
+// WriteRawContext is like WriteRaw, but any span created for the write
+// is a child of the one in ctx.
+func (r *PairedWriter) WriteRawContext(ctx context.Context, d []byte) error {
+	err := r.WALWriter.WriteRawContext(ctx, d)
+	if err != nil {
+		return err
+	}
+
+	return r.commit()
+}
+
+// WriteTTL is like Write, but d expires after ttl. See
+// WALWriter.WriteTTL.
+func (r *PairedWriter) WriteTTL(d []byte, ttl time.Duration) error {
+	return r.WriteTTLContext(context.Background(), d, ttl)
+}
+
+// WriteTTLContext is like WriteTTL, but any span created for the write
+// is a child of the one in ctx.
+func (r *PairedWriter) WriteTTLContext(ctx context.Context, d []byte, ttl time.Duration) error {
+	err := r.WALWriter.WriteTTLContext(ctx, d, ttl)
+	if err != nil {
+		return err
+	}
+
+	return r.commit()
+}
+
+// WriteOrigin is like Write, but records where d was first written. See
+// WALWriter.WriteOrigin.
+func (r *PairedWriter) WriteOrigin(d []byte, origin Origin) error {
+	return r.WriteOriginContext(context.Background(), d, origin)
+}
+
+// WriteOriginContext is like WriteOrigin, but any span created for the
+// write is a child of the one in ctx.
+func (r *PairedWriter) WriteOriginContext(ctx context.Context, d []byte, origin Origin) error {
+	err := r.WALWriter.WriteOriginContext(ctx, d, origin)
+	if err != nil {
+		return err
+	}
+
+	return r.commit()
+}
+
+// WriteTagPayload is like WriteTag, but writes payload along with the
+// tag. See WALWriter.WriteTagPayload.
+func (r *PairedWriter) WriteTagPayload(tag, payload []byte) error {
+	return r.WriteTagPayloadContext(context.Background(), tag, payload)
+}
+
+// WriteTagPayloadContext is like WriteTagPayload, but any span created
+// for the write is a child of the one in ctx.
+func (r *PairedWriter) WriteTagPayloadContext(ctx context.Context, tag, payload []byte) error {
+	err := r.WALWriter.WriteTagPayloadContext(ctx, tag, payload)
+	if err != nil {
+		return err
+	}
+
+	return r.commit()
+}
+
+// WriteTags is like WriteTag, but writes several tags as one record. See
+// WALWriter.WriteTags.
+func (r *PairedWriter) WriteTags(tags ...[]byte) error {
+	return r.WriteTagsContext(context.Background(), tags...)
+}
+
+// WriteTagsContext is like WriteTags, but any span created for the write
+// is a child of the one in ctx.
+func (r *PairedWriter) WriteTagsContext(ctx context.Context, tags ...[]byte) error {
+	err := r.WALWriter.WriteTagsContext(ctx, tags...)
+	if err != nil {
+		return err
+	}
+
+	return r.commit()
+}
+
+// Stream is like WALWriter.Stream, but writes to the stream are
+// committed like the writer's own, waking the paired readers.
+func (r *PairedWriter) Stream(name string) (*StreamWriter, error) {
+	s, err := r.WALWriter.Stream(name)
+	if err != nil {
+		return nil, err
+	}
+
+	s.written = r.commit
+
+	return s, nil
+}
+
+// DiscardAfter is like WALWriter.DiscardAfter, but also pulls back how
+// far the paired readers may read, so they don't read what's written
+// in place of the discarded entries before it's committed.
+func (r *PairedWriter) DiscardAfter(pos Position) error {
+	err := r.WALWriter.DiscardAfter(pos)
+	if err != nil {
+		return err
+	}
+
+	end, err := r.WALWriter.Pos()
+	if err != nil {
+		return err
+	}
+
 	r.lock.Lock()
 	defer r.lock.Unlock()
 
-	err := r.WALWriter.Write(d)
+	r.committed = end
+
+	return nil
+}
+
+// commit makes everything written so far visible to the paired readers
+// and wakes them.
+func (r *PairedWriter) commit() error {
+	pos, err := r.WALWriter.Pos()
 	if err != nil {
 		return err
 	}
 
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// Concurrent writes can finish out of order, so only ever move
+	// the committed position forward.
+	if r.committed.less(pos) {
+		r.committed = pos
+	}
+
 	r.gen++
 
 	r.cond.Broadcast()
 
 	return nil
 }
+
+func (r *PairedWriter) committedPos() Position {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.committed
+}
@@ -2,11 +2,14 @@ package wal
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"sync"
 	"testing"
 	"time"
@@ -142,5 +145,322 @@ func TestPair(t *testing.T) {
 		wg.Wait()
 	})
 
+	n.It("only reads data the writer has committed", func() {
+		r, w, err := NewPair(path, DefaultWriteOptions)
+		require.NoError(t, err)
+
+		// Bypass the paired writer so the data is on disk but not
+		// yet committed.
+		err = w.WALWriter.Write([]byte("data1"))
+		require.NoError(t, err)
+
+		require.False(t, r.Next())
+		require.NoError(t, r.Error())
+
+		err = w.Write([]byte("data2"))
+		require.NoError(t, err)
+
+		require.True(t, r.Next())
+		assert.Equal(t, []byte("data1"), r.Value())
+
+		require.True(t, r.Next())
+		assert.Equal(t, []byte("data2"), r.Value())
+	})
+
+	n.It("commits every kind of write", func() {
+		r, w, err := NewPair(path, DefaultWriteOptions)
+		require.NoError(t, err)
+
+		defer w.Close()
+
+		writes := map[string]func() error{
+			"WriteRaw":        func() error { return w.WriteRaw([]byte("raw")) },
+			"WriteTTL":        func() error { return w.WriteTTL([]byte("ttl"), time.Hour) },
+			"WriteOrigin":     func() error { return w.WriteOrigin([]byte("origin"), Origin{Node: "a"}) },
+			"WriteTagPayload": func() error { return w.WriteTagPayload([]byte("tag"), []byte("payload")) },
+			"WriteTags":       func() error { return w.WriteTags([]byte("t1"), []byte("t2")) },
+		}
+
+		for name, write := range writes {
+			before := w.committedPos()
+
+			require.NoError(t, write(), name)
+
+			end, err := w.WALWriter.Pos()
+			require.NoError(t, err)
+
+			assert.Equal(t, end, w.committedPos(), name)
+			assert.True(t, before.less(end), name)
+		}
+
+		var values []string
+
+		for r.Next() {
+			values = append(values, string(r.Value()))
+		}
+
+		require.NoError(t, r.Error())
+
+		sort.Strings(values)
+
+		assert.Equal(t, []string{"origin", "raw", "ttl"}, values)
+	})
+
+	n.It("commits writes to streams", func() {
+		_, w, err := NewPair(path, DefaultWriteOptions)
+		require.NoError(t, err)
+
+		defer w.Close()
+
+		s, err := w.Stream("events")
+		require.NoError(t, err)
+
+		writes := map[string]func() error{
+			"Write":    func() error { return s.Write([]byte("event")) },
+			"WriteRaw": func() error { return s.WriteRaw([]byte("raw")) },
+			"WriteTag": func() error { return s.WriteTag([]byte("tag")) },
+		}
+
+		for name, write := range writes {
+			before := w.committedPos()
+
+			require.NoError(t, write(), name)
+
+			end, err := w.WALWriter.Pos()
+			require.NoError(t, err)
+
+			assert.Equal(t, end, w.committedPos(), name)
+			assert.True(t, before.less(end), name)
+		}
+	})
+
+	n.It("pulls back what readers may read when entries are discarded", func() {
+		r, w, err := NewPair(path, DefaultWriteOptions)
+		require.NoError(t, err)
+
+		defer w.Close()
+
+		require.NoError(t, w.Write([]byte("data1")))
+
+		pos, err := w.Pos()
+		require.NoError(t, err)
+
+		require.NoError(t, w.Write([]byte("data2")))
+		require.NoError(t, w.Write([]byte("data3")))
+
+		require.NoError(t, w.DiscardAfter(pos))
+
+		assert.Equal(t, pos, w.committedPos())
+
+		// Written in place of the discarded entries, but not committed.
+		require.NoError(t, w.WALWriter.Write([]byte("uncommitted")))
+
+		require.True(t, r.Next())
+		assert.Equal(t, []byte("data1"), r.Value())
+
+		assert.False(t, r.Next())
+		require.NoError(t, r.Error())
+	})
+
+	n.It("pairs an open writer and reader", func() {
+		wal, err := New(path, WithSegmentSize(1024))
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		require.NoError(t, wal.Write([]byte("before")))
+
+		rd, err := NewReader(path)
+		require.NoError(t, err)
+
+		r, w, err := PairFrom(wal, rd)
+		require.NoError(t, err)
+
+		require.True(t, r.Next())
+		assert.Equal(t, "before", string(r.Value()))
+
+		require.NoError(t, w.Write([]byte("after")))
+
+		require.True(t, r.Next())
+		assert.Equal(t, "after", string(r.Value()))
+
+		r2, err := w.Reader()
+		require.NoError(t, err)
+
+		require.True(t, r2.Next())
+		assert.Equal(t, "before", string(r2.Value()))
+
+		other, err := NewReader(path)
+		require.NoError(t, err)
+
+		other.root = filepath.Join(dir, "other")
+
+		_, _, err = PairFrom(wal, other)
+		assert.Equal(t, ErrPairMismatch, err)
+	})
+
+	n.It("wakes blocked readers when a tag is written", func() {
+		r, w, err := NewPair(path, DefaultWriteOptions)
+		require.NoError(t, err)
+
+		defer w.Close()
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			w.WriteTag([]byte("commit"))
+		}()
+
+		done := make(chan error, 1)
+
+		go func() {
+			done <- r.BlockingNext()
+		}()
+
+		select {
+		case err := <-done:
+			assert.Equal(t, ErrNoData, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("reader was not woken by the tag")
+		}
+
+		pos, err := w.Pos()
+		require.NoError(t, err)
+
+		assert.Equal(t, pos, r.pw.committedPos())
+	})
+
+	n.It("takes entries, waiting for them as needed", func() {
+		r, w, err := NewPair(path, DefaultWriteOptions)
+		require.NoError(t, err)
+
+		defer w.Close()
+
+		require.NoError(t, w.Write([]byte("data1")))
+
+		val, pos, err := r.Take(context.Background())
+		require.NoError(t, err)
+
+		assert.Equal(t, "data1", string(val))
+
+		wpos, err := w.Pos()
+		require.NoError(t, err)
+		assert.Equal(t, wpos, pos)
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			w.Write([]byte("data2"))
+		}()
+
+		val, _, err = r.Take(context.Background())
+		require.NoError(t, err)
+
+		assert.Equal(t, "data2", string(val))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, _, err = r.Take(ctx)
+		assert.Equal(t, context.DeadlineExceeded, err)
+	})
+
+	n.It("takes entries in batches", func() {
+		r, w, err := NewPair(path, DefaultWriteOptions)
+		require.NoError(t, err)
+
+		defer w.Close()
+
+		for i := 0; i < 5; i++ {
+			require.NoError(t, w.Write([]byte(fmt.Sprintf("data%d", i))))
+		}
+
+		batch, _, err := r.TakeBatch(context.Background(), 3, time.Second)
+		require.NoError(t, err)
+
+		require.Equal(t, 3, len(batch))
+		assert.Equal(t, "data0", string(batch[0]))
+		assert.Equal(t, "data2", string(batch[2]))
+
+		// Only 2 are left, so the batch is cut short by the delay.
+		start := time.Now()
+
+		batch, pos, err := r.TakeBatch(context.Background(), 3, 50*time.Millisecond)
+		require.NoError(t, err)
+
+		assert.True(t, time.Since(start) >= 50*time.Millisecond)
+
+		require.Equal(t, 2, len(batch))
+		assert.Equal(t, "data4", string(batch[1]))
+
+		wpos, err := w.Pos()
+		require.NoError(t, err)
+		assert.Equal(t, wpos, pos)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, _, err = r.TakeBatch(ctx, 3, time.Second)
+		assert.Equal(t, context.DeadlineExceeded, err)
+	})
+
+	n.It("feeds many readers, each at its own position", func() {
+		r, w, err := NewPair(path, DefaultWriteOptions)
+		require.NoError(t, err)
+
+		defer w.Close()
+
+		require.NoError(t, w.Write([]byte("data0")))
+
+		pos, err := w.Pos()
+		require.NoError(t, err)
+
+		late, err := w.ReaderAt(pos)
+		require.NoError(t, err)
+
+		other, err := w.Reader()
+		require.NoError(t, err)
+
+		const count = 50
+
+		var wg sync.WaitGroup
+
+		results := make([][]string, 3)
+
+		for i, rd := range []*PairedReader{r, other, late} {
+			wg.Add(1)
+
+			go func(i int, rd *PairedReader) {
+				defer wg.Done()
+
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+
+				for {
+					val, _, err := rd.Take(ctx)
+					if err != nil {
+						return
+					}
+
+					results[i] = append(results[i], string(val))
+
+					if string(val) == "done" {
+						return
+					}
+				}
+			}(i, rd)
+		}
+
+		for i := 1; i < count; i++ {
+			require.NoError(t, w.Write([]byte(fmt.Sprintf("data%d", i))))
+		}
+
+		require.NoError(t, w.Write([]byte("done")))
+
+		wg.Wait()
+
+		assert.Equal(t, count+1, len(results[0]))
+		assert.Equal(t, results[0], results[1])
+		assert.Equal(t, results[0][1:], results[2])
+	})
+
 	n.Meow()
 }
@@ -0,0 +1,183 @@
+package wal
+
+import (
+	"io"
+	"time"
+)
+
+type prefetched struct {
+	value []byte
+	crc   uint32
+	hdr   RecordHeader
+	pos   int64
+	err   error
+}
+
+type prefetcher struct {
+	ch   chan prefetched
+	stop chan struct{}
+}
+
+// Prefetch sets how many entries are read and decoded ahead of the
+// caller on a background goroutine. 0 disables prefetching.
+func (r *SegmentReader) Prefetch(n int) {
+	r.stopPrefetch()
+	r.prefetch = n
+}
+
+func (r *SegmentReader) startPrefetch() {
+	pf := &prefetcher{
+		ch:   make(chan prefetched, r.prefetch),
+		stop: make(chan struct{}),
+	}
+
+	r.pf = pf
+
+	go r.runPrefetch(pf)
+}
+
+// runPrefetch reads entries until it hits an error (including io.EOF)
+// or is stopped. Only it touches the reading state of r while running.
+func (r *SegmentReader) runPrefetch(pf *prefetcher) {
+	defer close(pf.ch)
+
+	for {
+		var item prefetched
+
+		ent, err := r.readEntry()
+		if err == nil {
+			if !r.wanted(ent.entryType) {
+				continue
+			}
+
+			var value []byte
+
+			value, err = r.decodeEntry(ent)
+			if err == nil && r.stream != nil {
+				var ok bool
+
+				value, ok, err = r.streamValue(value)
+				if err != nil {
+					err = r.corrupt(ent.offset, err)
+				} else if !ok {
+					continue
+				}
+			}
+
+			var (
+				expires time.Time
+				origin  Origin
+			)
+
+			if err == nil && ent.entryType == expiringType {
+				expires, value, err = splitExpiry(value)
+				if err != nil {
+					err = r.corrupt(ent.offset, err)
+				}
+			}
+
+			if err == nil && ent.entryType == originType {
+				origin, value, err = entryOrigin(value, r.index, ent.start)
+				if err != nil {
+					err = r.corrupt(ent.offset, err)
+				}
+			}
+
+			if err == nil {
+				// Copy it out because the buffers are reused by the next entry.
+				item.value = append([]byte(nil), value...)
+				r.budget.adjust(int64(len(item.value)))
+				item.crc = ent.crc
+				item.hdr = ent.header()
+				item.hdr.Expires = expires
+				item.hdr.Origin = origin
+			}
+		}
+
+		item.pos = r.readPos
+		item.err = err
+
+		select {
+		case pf.ch <- item:
+		case <-pf.stop:
+			r.budget.adjust(-int64(len(item.value)))
+			return
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// stopPrefetch halts the background goroutine and rewinds the file to
+// just after the last entry returned to the caller, so that entries that
+// were read ahead aren't lost.
+func (r *SegmentReader) stopPrefetch() {
+	r.dropPrefetched()
+
+	if r.pf == nil {
+		return
+	}
+
+	close(r.pf.stop)
+
+	r.drainPrefetched()
+
+	r.pf = nil
+
+	if r.readPos != r.pos {
+		r.Seek(r.pos)
+	}
+}
+
+func (r *SegmentReader) nextPrefetched() bool {
+	if r.pf == nil {
+		r.startPrefetch()
+	}
+
+	r.dropPrefetched()
+
+	item := <-r.pf.ch
+
+	r.pos = item.pos
+
+	if item.err != nil {
+		// The goroutine exits after an error, so wait for it and start
+		// a new one next time, which lets a tailing reader pick up new data.
+		r.drainPrefetched()
+
+		r.pf = nil
+
+		r.releaseBuffers()
+
+		if item.err != io.EOF {
+			r.err = item.err
+		}
+
+		return false
+	}
+
+	r.value = item.value
+	r.valueCRC = item.crc
+	r.header = item.hdr
+
+	r.prefetched = int64(len(item.value))
+
+	return true
+}
+
+// drainPrefetched waits for the prefetcher to exit, giving back the
+// budget held by the entries it read ahead.
+func (r *SegmentReader) drainPrefetched() {
+	for item := range r.pf.ch {
+		r.budget.adjust(-int64(len(item.value)))
+	}
+}
+
+// dropPrefetched gives back the budget held by the current value, if it
+// was read ahead.
+func (r *SegmentReader) dropPrefetched() {
+	r.budget.adjust(-r.prefetched)
+	r.prefetched = 0
+}
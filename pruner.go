@@ -0,0 +1,135 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	tomb "gopkg.in/tomb.v2"
+)
+
+// pruner removes pruned segments in the background, no faster than
+// WriteOptions.PruneBytesPerSecond and only while
+// WriteOptions.MaintenanceGate is open, so that pruning a lot at once,
+// such as after a retention change, doesn't starve writes of the disk.
+type pruner struct {
+	wal   *WALWriter
+	limit *rateLimiter
+
+	lock    sync.Mutex
+	pending []int
+	wake    chan struct{}
+
+	t tomb.Tomb
+}
+
+// startPruner starts the pruner, if the WAL is set to throttle or
+// schedule pruning.
+func (wal *WALWriter) startPruner() {
+	if wal.opts.PruneBytesPerSecond <= 0 && wal.opts.MaintenanceGate == nil {
+		return
+	}
+
+	wal.pruner = &pruner{
+		wal:   wal,
+		limit: newRateLimiter(wal.opts.PruneBytesPerSecond),
+		wake:  make(chan struct{}, 1),
+	}
+
+	wal.pruner.t.Go(wal.pruner.run)
+}
+
+// stopPruner stops the pruner. Segments it hasn't got to yet are left
+// on disk, to be pruned again once the WAL is next opened.
+func (wal *WALWriter) stopPruner() {
+	if wal.pruner == nil {
+		return
+	}
+
+	wal.pruner.t.Kill(nil)
+	wal.pruner.t.Wait()
+}
+
+// add queues segments to be removed, oldest first.
+func (p *pruner) add(indexes []int) {
+	p.lock.Lock()
+	p.pending = append(p.pending, indexes...)
+	p.lock.Unlock()
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the oldest segment waiting to be removed.
+func (p *pruner) next() (int, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if len(p.pending) == 0 {
+		return 0, false
+	}
+
+	i := p.pending[0]
+	p.pending = p.pending[1:]
+
+	return i, true
+}
+
+func (p *pruner) run() error {
+	for {
+		select {
+		case <-p.wake:
+		case <-p.t.Dying():
+			return nil
+		}
+
+		for {
+			i, ok := p.next()
+			if !ok {
+				break
+			}
+
+			if !p.remove(i) {
+				return nil
+			}
+		}
+	}
+}
+
+// remove removes segment i once maintenance may run and the limit
+// allows for its size, returning false if the pruner was stopped first.
+func (p *pruner) remove(i int) bool {
+	wal := p.wal
+
+	if !wal.awaitMaintenance(p.t.Dying()) {
+		return false
+	}
+
+	var size int64
+
+	fi, err := wal.fs.Stat(filepath.Join(wal.root, strconv.Itoa(i)))
+	if err == nil {
+		size = fi.Size()
+	}
+
+	if !p.limit.wait(size, p.t.Dying()) {
+		return false
+	}
+
+	err = wal.removeSegment(i)
+
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	switch {
+	case err == nil:
+		wal.prunedSegment(i)
+	case !os.IsNotExist(err):
+		wal.logger.Error("failed to prune segment", "segment", i, "error", err)
+	}
+
+	return true
+}
@@ -0,0 +1,234 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ErrQuotaExceeded is matched by the QuotaError returned for a write
+// that would take a stream over its quota.
+var ErrQuotaExceeded = errors.New("stream quota exceeded")
+
+// StreamQuota limits how much of a WAL one stream may hold, so that one
+// busy stream can't take over the space shared by all of them. A limit
+// of 0 is no limit.
+type StreamQuota struct {
+	// The most bytes of entries, not counting their framing.
+	MaxBytes int64
+
+	// The most entries.
+	MaxEntries int64
+}
+
+func (q StreamQuota) limited() bool {
+	return q.MaxBytes > 0 || q.MaxEntries > 0
+}
+
+func (q StreamQuota) valid() bool {
+	return q.MaxBytes >= 0 && q.MaxEntries >= 0
+}
+
+func (wo *WriteOptions) streamQuotasValid() bool {
+	for _, q := range wo.StreamQuotas {
+		if !q.valid() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// StreamUsage is how much of a WAL a stream holds: the entries it's
+// written that are in segments that haven't been pruned.
+type StreamUsage struct {
+	Bytes   int64
+	Entries int64
+}
+
+// QuotaError is returned for a write that would take a stream over its
+// quota. It matches ErrQuotaExceeded with errors.Is. The stream can be
+// written to again once enough of the segments holding its entries
+// have been pruned.
+type QuotaError struct {
+	Stream string
+	Quota  StreamQuota
+
+	// What the stream held before the write.
+	Usage StreamUsage
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("stream %q holds %d entries and %d bytes, quota is %d entries and %d bytes",
+		e.Stream, e.Usage.Entries, e.Usage.Bytes, e.Quota.MaxEntries, e.Quota.MaxBytes)
+}
+
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// streamQuotas tracks how much of the WAL each stream holds, by the
+// segment its entries are in, so that pruning a segment gives back what
+// its entries used.
+type streamQuotas struct {
+	usage    map[string]StreamUsage
+	segments map[int]map[string]StreamUsage
+}
+
+// quotaFor returns the quota of the stream called name.
+func (wal *WALWriter) quotaFor(name string) StreamQuota {
+	if q, ok := wal.opts.StreamQuotas[name]; ok {
+		return q
+	}
+
+	return wal.opts.StreamQuota
+}
+
+// hasQuotas reports whether any stream has a quota.
+func (wo *WriteOptions) hasQuotas() bool {
+	if wo.StreamQuota.limited() {
+		return true
+	}
+
+	for _, q := range wo.StreamQuotas {
+		if q.limited() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadQuotas counts what each stream holds in the segments already in
+// the WAL, if any stream has a quota. Every segment is read to do so.
+func (wal *WALWriter) loadQuotas() error {
+	if !wal.opts.hasQuotas() {
+		return nil
+	}
+
+	wal.quotas = &streamQuotas{
+		usage:    map[string]StreamUsage{},
+		segments: map[int]map[string]StreamUsage{},
+	}
+
+	opts := DefaultReadOptions
+	opts.FS = wal.fs
+
+	for i := wal.first; i <= wal.index; i++ {
+		sr, err := NewSegmentReaderWithOptions(filepath.Join(wal.root, strconv.Itoa(i)), opts)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return err
+		}
+
+		for {
+			t, ok := sr.nextRecord()
+			if !ok {
+				break
+			}
+
+			if t != streamType {
+				continue
+			}
+
+			name, value, ok := splitStreamEntry(sr.Value())
+			if ok {
+				wal.quotas.charge(i, string(name), int64(len(value)))
+			}
+		}
+
+		err = sr.Error()
+		sr.Close()
+
+		// What's unreadable can't be counted, but it's recovery's job to
+		// deal with it rather than ours.
+		if err != nil {
+			wal.logger.Warn("failed to count stream usage", "segment", i, "error", err)
+		}
+	}
+
+	return nil
+}
+
+// checkQuota returns a QuotaError if writing a stream entry with body
+// would take the stream over its quota, along with the stream's name
+// and the size of the entry to charge once it's written. The lock must
+// be held.
+func (wal *WALWriter) checkQuota(body []byte) (string, int64, error) {
+	if wal.quotas == nil {
+		return "", 0, nil
+	}
+
+	name, value, ok := splitStreamEntry(body)
+	if !ok {
+		return "", 0, nil
+	}
+
+	q := wal.quotaFor(string(name))
+	usage := wal.quotas.usage[string(name)]
+	size := int64(len(value))
+
+	if (q.MaxBytes > 0 && usage.Bytes+size > q.MaxBytes) ||
+		(q.MaxEntries > 0 && usage.Entries+1 > q.MaxEntries) {
+		return "", 0, &QuotaError{Stream: string(name), Quota: q, Usage: usage}
+	}
+
+	return string(name), size, nil
+}
+
+// charge adds an entry of size bytes to what the stream called name
+// holds in segment index.
+func (sq *streamQuotas) charge(index int, name string, size int64) {
+	add := func(u StreamUsage) StreamUsage {
+		return StreamUsage{Bytes: u.Bytes + size, Entries: u.Entries + 1}
+	}
+
+	sq.usage[name] = add(sq.usage[name])
+
+	seg := sq.segments[index]
+	if seg == nil {
+		seg = map[string]StreamUsage{}
+		sq.segments[index] = seg
+	}
+
+	seg[name] = add(seg[name])
+}
+
+// release gives back what the streams held in segment index, once it's
+// been pruned.
+func (sq *streamQuotas) release(index int) {
+	for name, u := range sq.segments[index] {
+		total := sq.usage[name]
+
+		total.Bytes -= u.Bytes
+		total.Entries -= u.Entries
+
+		if total.Entries <= 0 {
+			delete(sq.usage, name)
+		} else {
+			sq.usage[name] = total
+		}
+	}
+
+	delete(sq.segments, index)
+}
+
+// Usage returns how much of the WAL the stream holds. It's only tracked
+// while some stream has a quota, and is zero otherwise.
+func (s *StreamWriter) Usage() StreamUsage {
+	wal := s.wal
+
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	if wal.quotas == nil {
+		return StreamUsage{}
+	}
+
+	return wal.quotas.usage[s.name]
+}
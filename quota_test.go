@@ -0,0 +1,151 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestStreamQuotas(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	open := func(opts ...Option) *WALWriter {
+		wal, err := New(path, opts...)
+		require.NoError(t, err)
+
+		return wal
+	}
+
+	stream := func(wal *WALWriter, name string) *StreamWriter {
+		s, err := wal.Stream(name)
+		require.NoError(t, err)
+
+		return s
+	}
+
+	quota := func(q StreamQuota) Option {
+		return func(wo *WriteOptions) {
+			wo.StreamQuota = q
+		}
+	}
+
+	n.It("rejects writes that would exceed a stream's quota", func() {
+		wal := open(quota(StreamQuota{MaxEntries: 3}))
+		defer wal.Close()
+
+		a, b := stream(wal, "a"), stream(wal, "b")
+
+		for i := 0; i < 3; i++ {
+			require.NoError(t, a.Write([]byte("hello")))
+		}
+
+		err := a.Write([]byte("hello"))
+		require.Error(t, err)
+
+		assert.True(t, errors.Is(err, ErrQuotaExceeded))
+
+		var qe *QuotaError
+		require.True(t, errors.As(err, &qe))
+
+		assert.Equal(t, "a", qe.Stream)
+		assert.Equal(t, StreamUsage{Bytes: 15, Entries: 3}, qe.Usage)
+
+		// Other streams, and the WAL itself, aren't held back.
+		require.NoError(t, b.Write([]byte("hello")))
+		require.NoError(t, wal.Write([]byte("hello")))
+
+		assert.Equal(t, StreamUsage{Bytes: 15, Entries: 3}, a.Usage())
+		assert.Equal(t, StreamUsage{Bytes: 5, Entries: 1}, b.Usage())
+	})
+
+	n.It("limits bytes, with quotas for particular streams", func() {
+		wal := open(quota(StreamQuota{MaxBytes: 10}), func(wo *WriteOptions) {
+			wo.StreamQuotas = map[string]StreamQuota{"big": {}}
+		})
+		defer wal.Close()
+
+		small, big := stream(wal, "small"), stream(wal, "big")
+
+		require.NoError(t, small.Write([]byte("12345")))
+		require.NoError(t, small.Write([]byte("12345")))
+
+		assert.True(t, errors.Is(small.Write([]byte("1")), ErrQuotaExceeded))
+
+		require.NoError(t, big.Write(bytes.Repeat([]byte("x"), 100)))
+	})
+
+	n.It("counts what streams hold when the WAL is reopened", func() {
+		wal := open(quota(StreamQuota{MaxEntries: 2}))
+
+		require.NoError(t, stream(wal, "a").Write([]byte("hello")))
+		require.NoError(t, wal.Close())
+
+		wal = open(quota(StreamQuota{MaxEntries: 2}))
+		defer wal.Close()
+
+		a := stream(wal, "a")
+
+		assert.Equal(t, StreamUsage{Bytes: 5, Entries: 1}, a.Usage())
+
+		require.NoError(t, a.Write([]byte("hello")))
+		assert.True(t, errors.Is(a.Write([]byte("hello")), ErrQuotaExceeded))
+	})
+
+	n.It("gives back what pruned segments held", func() {
+		wal := open(quota(StreamQuota{MaxEntries: 2}), WithSegmentSize(1024), WithMaxSegments(2), WithCompression(false))
+		defer wal.Close()
+
+		a := stream(wal, "a")
+
+		require.NoError(t, a.Write([]byte("hello")))
+		require.NoError(t, a.Write([]byte("hello")))
+
+		assert.True(t, errors.Is(a.Write([]byte("hello")), ErrQuotaExceeded))
+
+		for i := 0; i < 50; i++ {
+			require.NoError(t, wal.Write(bytes.Repeat([]byte("x"), 100)))
+		}
+
+		assert.Equal(t, StreamUsage{}, a.Usage())
+
+		require.NoError(t, a.Write([]byte("hello")))
+	})
+
+	n.It("doesn't track usage without quotas", func() {
+		wal := open()
+		defer wal.Close()
+
+		a := stream(wal, "a")
+
+		require.NoError(t, a.Write([]byte("hello")))
+
+		assert.Equal(t, StreamUsage{}, a.Usage())
+	})
+
+	n.It("rejects negative limits", func() {
+		wo := DefaultWriteOptions
+		wo.StreamQuotas = map[string]StreamQuota{"a": {MaxBytes: -1}}
+
+		assert.True(t, errors.Is(wo.Validate(), ErrInvalidOptions))
+	})
+
+	n.Meow()
+}
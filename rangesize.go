@@ -0,0 +1,165 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// How much of a range EstimateRange reads to count entries in.
+const rangeSampleBytes = 1 << 20
+
+// RangeSize describes how much of the WAL lies between two positions.
+type RangeSize struct {
+	// The bytes of the segments between the positions, including the
+	// framing of entries and any tags. Always exact.
+	Bytes int64
+
+	// The entries between the positions. A stream reader only counts the
+	// stream's entries.
+	Entries int64
+
+	// Whether Entries was counted rather than estimated.
+	Exact bool
+}
+
+// EstimateRange reports how much of the WAL lies from from up to to,
+// such as for a replication progress bar, a lag dashboard, or sizing a
+// catch-up transfer ahead of time. Bytes comes from the sizes of the
+// segments, so it's exact and cheap. Entries are counted in the first
+// megabyte or so of the range and extrapolated from there, so they're
+// exact for short ranges and estimated for long ones; Exact says which.
+// CountRange always counts them.
+//
+// Both positions should be ones Seek accepts. The reader's position is
+// left as it was.
+func (wal *WALReader) EstimateRange(from, to Position) (RangeSize, error) {
+	return wal.sizeRange(from, to, rangeSampleBytes)
+}
+
+// CountRange is like EstimateRange, but reads the whole range so that
+// Entries is exact.
+func (wal *WALReader) CountRange(from, to Position) (RangeSize, error) {
+	return wal.sizeRange(from, to, -1)
+}
+
+// sizeRange measures the range from from to to, counting entries in at
+// least sample bytes of it, or all of it if sample is negative.
+func (wal *WALReader) sizeRange(from, to Position, sample int64) (RangeSize, error) {
+	var rs RangeSize
+
+	if wal.closed {
+		return rs, ErrClosed
+	}
+
+	if !from.Valid() || !to.Valid() || to.less(from) {
+		return rs, fmt.Errorf("%w: %s to %s", ErrInvalidPosition, from, to)
+	}
+
+	fs := fsOrOS(wal.opts.FS)
+
+	// The bytes of each segment in the range.
+	spans := make([][2]int64, 0, to.Segment-from.Segment+1)
+
+	for i := from.Segment; i <= to.Segment; i++ {
+		fi, err := fs.Stat(filepath.Join(wal.root, strconv.Itoa(i)))
+		if err != nil {
+			if os.IsNotExist(err) {
+				err = wal.missingSegment(Position{i, 0}, err)
+			}
+
+			return RangeSize{}, err
+		}
+
+		start, end := int64(0), fi.Size()
+
+		if i == from.Segment {
+			start = from.Offset
+		}
+
+		if i == to.Segment && to.Offset < end {
+			end = to.Offset
+		}
+
+		if end < start {
+			end = start
+		}
+
+		spans = append(spans, [2]int64{start, end})
+		rs.Bytes += end - start
+	}
+
+	var counted int64
+
+	rs.Exact = true
+
+	for j, span := range spans {
+		budget := int64(-1)
+
+		if sample >= 0 {
+			budget = sample - counted
+			if budget <= 0 {
+				rs.Exact = false
+				break
+			}
+		}
+
+		n, covered, err := wal.countEntries(from.Segment+j, span[0], span[1], budget)
+		if err != nil {
+			return RangeSize{}, err
+		}
+
+		rs.Entries += n
+		counted += covered
+
+		if covered < span[1]-span[0] {
+			rs.Exact = false
+			break
+		}
+	}
+
+	if !rs.Exact && counted > 0 {
+		rs.Entries = rs.Entries * rs.Bytes / counted
+	}
+
+	return rs, nil
+}
+
+// countEntries counts the entries of segment index that start at or
+// after start and end by end, reading at least budget bytes of them if
+// budget isn't negative. It returns the count and how many bytes it
+// covered, which is end-start if it reached the end.
+func (wal *WALReader) countEntries(index int, start, end, budget int64) (int64, int64, error) {
+	seg, err := wal.openSegment(index)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer seg.Close()
+
+	err = seg.Seek(start)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var n int64
+
+	for seg.Next() {
+		if seg.Pos() > end {
+			break
+		}
+
+		n++
+
+		if budget >= 0 && seg.Pos()-start >= budget {
+			return n, seg.Pos() - start, nil
+		}
+	}
+
+	if err := seg.Error(); err != nil {
+		return 0, 0, err
+	}
+
+	return n, end - start, nil
+}
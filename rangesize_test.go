@@ -0,0 +1,123 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestRangeSize(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	// Writes count entries of the same size across several segments,
+	// returning the positions before the first and after the last.
+	write := func(count int) (Position, Position) {
+		opts := DefaultWriteOptions
+		opts.SegmentSize = 10000
+		opts.MaxSegments = 1000
+		opts.NoCompression = true
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		from, err := wal.Pos()
+		require.NoError(t, err)
+
+		for i := 0; i < count; i++ {
+			require.NoError(t, wal.Write(bytes.Repeat([]byte("x"), 90)))
+		}
+
+		to, err := wal.Pos()
+		require.NoError(t, err)
+
+		return from, to
+	}
+
+	n.It("counts the entries and bytes of a short range exactly", func() {
+		from, to := write(300)
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		rs, err := r.EstimateRange(from, to)
+		require.NoError(t, err)
+
+		assert.True(t, rs.Exact)
+		assert.Equal(t, int64(300), rs.Entries)
+		assert.True(t, to.Segment > from.Segment)
+
+		var size int64
+
+		for i := from.Segment; i < to.Segment; i++ {
+			fi, err := os.Stat(filepath.Join(path, strconv.Itoa(i)))
+			require.NoError(t, err)
+
+			size += fi.Size()
+		}
+
+		assert.Equal(t, size+to.Offset, rs.Bytes)
+
+		rs, err = r.EstimateRange(to, to)
+		require.NoError(t, err)
+
+		assert.Equal(t, RangeSize{Exact: true}, rs)
+	})
+
+	n.It("estimates the entries of a long range", func() {
+		from, to := write(20000)
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		rs, err := r.EstimateRange(from, to)
+		require.NoError(t, err)
+
+		assert.False(t, rs.Exact)
+		assert.True(t, rs.Entries > 19000 && rs.Entries < 21000, "estimated %d entries", rs.Entries)
+
+		rs, err = r.CountRange(from, to)
+		require.NoError(t, err)
+
+		assert.True(t, rs.Exact)
+		assert.Equal(t, int64(20000), rs.Entries)
+	})
+
+	n.It("rejects a range that ends before it starts", func() {
+		from, to := write(5)
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		_, err = r.EstimateRange(to, from)
+		assert.True(t, errors.Is(err, ErrInvalidPosition))
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,76 @@
+package wal
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter paces IO to a number of bytes a second, shared by
+// everything that waits on it.
+type rateLimiter struct {
+	rate int64
+
+	lock sync.Mutex
+	next time.Time
+}
+
+// newRateLimiter returns a limiter to rate bytes a second, or nil, which
+// never waits, if rate isn't positive.
+func newRateLimiter(rate int64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+
+	return &rateLimiter{rate: rate}
+}
+
+// wait blocks until the IO of those before it would have taken at the
+// rate, then takes n bytes of it. It returns false without waiting out
+// the rest if done is closed first.
+func (l *rateLimiter) wait(n int64, done <-chan struct{}) bool {
+	if l == nil {
+		return true
+	}
+
+	l.lock.Lock()
+
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+
+	due := l.next
+	l.next = l.next.Add(time.Duration(float64(n) / float64(l.rate) * float64(time.Second)))
+
+	l.lock.Unlock()
+
+	wait := time.Until(due)
+	if wait <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// limitedReader reads from r no faster than limit allows.
+type limitedReader struct {
+	r     io.Reader
+	limit *rateLimiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+
+	lr.limit.wait(int64(n), nil)
+
+	return n, err
+}
@@ -0,0 +1,130 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+)
+
+// The directory readers keep segments fetched from ReadOptions.Source in
+// if ReadOptions.CacheDir isn't set.
+const defaultFetchCacheDir = "fetched"
+
+// Tells apart the temporary files of fetches running at the same time.
+var fetchSeq int64
+
+// cacheDir returns the directory the reader keeps fetched segments in.
+func (wal *WALReader) cacheDir() string {
+	dir := wal.opts.CacheDir
+	if dir == "" {
+		dir = defaultFetchCacheDir
+	}
+
+	return pruneDir(wal.root, dir)
+}
+
+// openFetched opens a copy of segment index from the reader's source in
+// place of the local one, which couldn't be opened because of err. If
+// there's no copy to be had, err is returned.
+func (wal *WALReader) openFetched(index int, err error) (*SegmentReader, error) {
+	path, ferr := wal.fetchSegment(index)
+	if ferr != nil {
+		loggerOrDiscard(wal.opts.Logger).Warn("failed to fetch segment", "segment", index, "error", ferr)
+		return nil, err
+	}
+
+	return NewSegmentReaderWithOptions(path, wal.opts)
+}
+
+// repairSegment replaces the reader's current segment, which failed to
+// read with err, with a copy from the reader's source, positioned at the
+// damaged entry so that reading carries on from there. It reports
+// whether it did.
+func (wal *WALReader) repairSegment(err error) bool {
+	var ce *CorruptError
+
+	if wal.opts.Source == nil || !errors.As(err, &ce) {
+		return false
+	}
+
+	// A fetched copy that's damaged too isn't fetched again.
+	if filepath.Dir(ce.Path) == wal.cacheDir() {
+		return false
+	}
+
+	seg, err := wal.openFetched(wal.index, err)
+	if err != nil {
+		return false
+	}
+
+	seg.stream = wal.stream
+	seg.tags = wal.tags
+
+	wal.limitSegment(seg, wal.index)
+
+	err = seg.Seek(ce.Offset)
+	if err != nil {
+		seg.Close()
+		return false
+	}
+
+	wal.seg.Close()
+	wal.seg = seg
+
+	return true
+}
+
+// fetchSegment makes sure there's a copy of segment index in the
+// reader's cache directory, fetching it from the reader's source if
+// there isn't one yet, and returns its path. Copies are verified before
+// they're used, and are kept for later readers to use as well.
+func (wal *WALReader) fetchSegment(index int) (string, error) {
+	fs := fsOrOS(wal.opts.FS)
+	dir := wal.cacheDir()
+	path := filepath.Join(dir, strconv.Itoa(index))
+
+	if _, err := fs.Stat(path); err == nil {
+		return path, nil
+	}
+
+	err := fs.Mkdir(dir, 0755)
+	if err != nil && !os.IsExist(err) {
+		return "", err
+	}
+
+	tmp := fmt.Sprintf("%s.fetch%d-%d", path, os.Getpid(), atomic.AddInt64(&fetchSeq, 1))
+
+	f, err := fs.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	err = wal.opts.Source.FetchSegment(context.Background(), index, f)
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		if rep := verifySegmentFS(fs, tmp, index); rep.err != nil {
+			err = fmt.Errorf("fetched copy: %w", rep.err)
+		}
+	}
+
+	if err == nil {
+		err = fs.Rename(tmp, path)
+	}
+
+	if err != nil {
+		fs.Remove(tmp)
+		return "", err
+	}
+
+	metricsOrNop(wal.opts.Metrics).IncrCounter(MetricFetchedSegments, 1)
+
+	return path, nil
+}
@@ -0,0 +1,174 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestReadRepair(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+	segment := filepath.Join(path, "1")
+
+	var (
+		archiver *BlobArchiver
+		metrics  *testMetrics
+	)
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+
+		archiver = NewBlobArchiver(NewMemoryBlobStore(), BlobArchiveOptions{})
+		metrics = &testMetrics{}
+
+		opts := DefaultWriteOptions
+		opts.SegmentSize = 1024
+		opts.MaxSegments = 100
+		opts.NoCompression = true
+		opts.Archiver = archiver
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		for i := 0; i < 20; i++ {
+			require.NoError(t, wal.Write(bytes.Repeat([]byte(fmt.Sprintf("entry%d ", i)), 20)))
+		}
+
+		require.NoError(t, wal.Close())
+		require.NoError(t, archiver.Wait())
+	})
+
+	readAll := func(opts ReadOptions) ([]string, error) {
+		opts.Metrics = metrics
+
+		r, err := NewReaderWithOptions(path, opts)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		var values []string
+
+		for r.Next() {
+			values = append(values, string(bytes.Fields(r.Value())[0]))
+		}
+
+		return values, r.Error()
+	}
+
+	all := func() []string {
+		var values []string
+
+		for i := 0; i < 20; i++ {
+			values = append(values, fmt.Sprintf("entry%d", i))
+		}
+
+		return values
+	}
+
+	n.It("reads a missing segment from the source", func() {
+		require.NoError(t, os.Remove(segment))
+
+		opts := DefaultReadOptions
+		opts.Source = archiver
+
+		values, err := readAll(opts)
+		require.NoError(t, err)
+
+		assert.Equal(t, all(), values)
+		assert.Equal(t, int64(1), metrics.counter(MetricFetchedSegments))
+
+		_, err = os.Stat(filepath.Join(path, "fetched", "1"))
+		assert.NoError(t, err)
+
+		// Later readers use the copy already fetched.
+		values, err = readAll(opts)
+		require.NoError(t, err)
+
+		assert.Equal(t, all(), values)
+		assert.Equal(t, int64(1), metrics.counter(MetricFetchedSegments))
+	})
+
+	n.It("seeks into a pruned segment using the source", func() {
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		require.True(t, r.Next())
+
+		pos, err := r.Pos()
+		require.NoError(t, err)
+
+		require.NoError(t, r.Close())
+
+		first, _, err := rangeSegments(OSFS, path)
+		require.NoError(t, err)
+
+		require.NoError(t, os.Remove(filepath.Join(path, fmt.Sprint(first))))
+
+		opts := DefaultReadOptions
+		opts.Source = archiver
+		opts.CacheDir = filepath.Join(dir, "cache")
+
+		r, err = NewReaderWithOptions(path, opts)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.NoError(t, r.Seek(pos))
+		require.True(t, r.Next())
+
+		assert.Equal(t, "entry1", string(bytes.Fields(r.Value())[0]))
+	})
+
+	n.It("reads past damage using a copy from the source", func() {
+		data, err := ioutil.ReadFile(segment)
+		require.NoError(t, err)
+
+		data[500] ^= 0xff
+
+		require.NoError(t, ioutil.WriteFile(segment, data, 0644))
+
+		values, err := readAll(DefaultReadOptions)
+		assert.True(t, errors.Is(err, ErrCorrupt))
+		assert.True(t, len(values) < 20)
+
+		opts := DefaultReadOptions
+		opts.Source = archiver
+
+		values, err = readAll(opts)
+		require.NoError(t, err)
+
+		assert.Equal(t, all(), values)
+	})
+
+	n.It("reports the segment missing if the source can't supply it", func() {
+		require.NoError(t, os.Remove(segment))
+
+		opts := DefaultReadOptions
+		opts.Source = NewBlobArchiver(NewMemoryBlobStore(), BlobArchiveOptions{})
+
+		_, err := readAll(opts)
+		assert.True(t, errors.Is(err, ErrSegmentMissing))
+
+		names, err := ioutil.ReadDir(filepath.Join(path, "fetched"))
+		require.NoError(t, err)
+
+		assert.Equal(t, 0, len(names))
+	})
+
+	n.Meow()
+}
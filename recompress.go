@@ -0,0 +1,102 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// RecompressStats describes what Recompress rewrote.
+type RecompressStats struct {
+	// The segments that were rewritten.
+	Segments int
+
+	// The records that were stored uncompressed and now aren't, and the
+	// bytes on disk that freed.
+	Records int
+	Bytes   int64
+}
+
+// Recompress rewrites the sealed segments of the WAL in root that were
+// last modified before the given time, compressing the records that
+// were stored uncompressed, such as those written with NoCompression to
+// spare the hot write path the work. That reclaims space in WALs kept
+// for a long time. A segment is only replaced if that makes it smaller.
+//
+// Records are only ever compressed with snappy, since that's the one
+// codec the segment format has a flag for, so records that are already
+// compressed are copied as they are rather than recompressed with a
+// stronger codec, and a segment holding nothing but compressed records
+// is left alone.
+//
+// Entries stay in the same segments and order, and time indexes are
+// updated, but the offsets of entries within a rewritten segment change,
+// as with Compact. The WAL must not be open for writing; ErrLocked is
+// returned if it is. opts are those the WAL is opened with, as with
+// Compact.
+func Recompress(root string, before time.Time, opts ...Option) (RecompressStats, error) {
+	lockf, err := lockRoot(root, opts)
+	if err != nil {
+		return RecompressStats{}, err
+	}
+
+	defer lockf.Close()
+
+	indexes, err := listSegments(root)
+	if err != nil {
+		return RecompressStats{}, err
+	}
+
+	var stats RecompressStats
+
+	for _, index := range indexes {
+		err = recompressSegment(root, index, before, &stats)
+		if err != nil {
+			return stats, fmt.Errorf("segment %d: %w", index, err)
+		}
+	}
+
+	if stats.Segments > 0 {
+		err = syncDir(root)
+	}
+
+	return stats, err
+}
+
+func recompressSegment(root string, index int, before time.Time, stats *RecompressStats) error {
+	fi, err := os.Stat(filepath.Join(root, strconv.Itoa(index)))
+	if err != nil {
+		return err
+	}
+
+	if !fi.ModTime().Before(before) {
+		return nil
+	}
+
+	// Only the records stored raw get any smaller; the rest are copied
+	// with the same snappy encoding they had.
+	rw, err := rewriteSegment(root, index, func(byte, Position, []byte) bool {
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	if !rw.sealed || rw.raw == 0 || rw.newSize() >= rw.size {
+		rw.discard()
+		return nil
+	}
+
+	freed, err := rw.commit()
+	if err != nil {
+		return err
+	}
+
+	stats.Segments++
+	stats.Records += rw.raw
+	stats.Bytes += freed
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package wal
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestRecompress(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	entry := func(i int) []byte {
+		return bytes.Repeat([]byte(fmt.Sprintf("entry%d ", i)), 20)
+	}
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+
+		wal, err := New(path, WithSegmentSize(1024), WithMaxSegments(100), WithCompression(false), WithTimeIndex(time.Nanosecond))
+		require.NoError(t, err)
+
+		for i := 0; i < 20; i++ {
+			require.NoError(t, wal.Write(entry(i)))
+		}
+
+		require.NoError(t, wal.WriteTag([]byte("tag")))
+		require.NoError(t, wal.Close())
+
+		// The newest segment is left unsealed, as though still being
+		// written.
+		wal, err = New(path, WithCompression(false), WithoutClosingMagic())
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write(entry(20)))
+		require.NoError(t, wal.Close())
+	})
+
+	read := func() [][]byte {
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		var values [][]byte
+
+		for r.Next() {
+			values = append(values, append([]byte(nil), r.Value()...))
+		}
+
+		require.NoError(t, r.Error())
+
+		return values
+	}
+
+	n.It("compresses the records of old sealed segments", func() {
+		_, last, err := rangeSegments(OSFS, path)
+		require.NoError(t, err)
+
+		before := read()
+
+		lastInfo, err := os.Stat(filepath.Join(path, fmt.Sprint(last)))
+		require.NoError(t, err)
+
+		stats, err := Recompress(path, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+
+		assert.Equal(t, last, stats.Segments)
+		unsealed := verifySegment(filepath.Join(path, fmt.Sprint(last)), last)
+		assert.Equal(t, 21-unsealed.Entries, stats.Records)
+		assert.True(t, stats.Bytes > 0)
+
+		assert.Equal(t, before, read())
+
+		lastAfter, err := os.Stat(filepath.Join(path, fmt.Sprint(last)))
+		require.NoError(t, err)
+
+		assert.Equal(t, lastInfo.Size(), lastAfter.Size())
+
+		report, err := Verify(path)
+		require.NoError(t, err)
+
+		assert.True(t, report.OK)
+		assert.Equal(t, 1, report.Tags)
+
+		// The time indexes follow the records.
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		points, err := readTimeIndex(OSFS, path, 0)
+		require.NoError(t, err)
+
+		require.NoError(t, r.Seek(Position{0, points[1].Offset}))
+		require.True(t, r.Next())
+		assert.Equal(t, entry(2), r.Value())
+
+		stats, err = Recompress(path, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+
+		assert.Equal(t, 0, stats.Segments)
+	})
+
+	n.It("leaves recently modified segments alone", func() {
+		stats, err := Recompress(path, time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+
+		assert.Equal(t, RecompressStats{}, stats)
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,192 @@
+package wal
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RecordType identifies what a record in a segment holds.
+type RecordType byte
+
+const (
+	RecordData      RecordType = dataType
+	RecordTag       RecordType = tagType
+	RecordStream    RecordType = streamType
+	RecordStreamTag RecordType = streamTagType
+	RecordExpiring  RecordType = expiringType
+
+	// A tag written with WriteTagPayload. Record.Tag splits its value.
+	RecordPayloadTag RecordType = payloadTagType
+
+	// A data entry written with an origin. Its value starts with the
+	// origin, framed as written.
+	RecordOrigin RecordType = originType
+
+	// Several tags written at once with WriteTags. Record.Tags splits its
+	// value.
+	RecordBarrier RecordType = barrierType
+)
+
+func (t RecordType) String() string {
+	switch t {
+	case RecordData:
+		return "data"
+	case RecordTag:
+		return "tag"
+	case RecordStream:
+		return "stream"
+	case RecordStreamTag:
+		return "stream-tag"
+	case RecordExpiring:
+		return "expiring"
+	case RecordPayloadTag:
+		return "payload-tag"
+	case RecordBarrier:
+		return "barrier"
+	case RecordOrigin:
+		return "origin"
+	default:
+		return fmt.Sprintf("unknown(%q)", byte(t))
+	}
+}
+
+// Record is an entry or tag as stored in a segment.
+type Record struct {
+	// Where the record starts.
+	Pos Position
+
+	Type RecordType
+
+	// The CRC stored with the record.
+	CRC uint32
+
+	// The decompressed value. Only valid until the scan callback returns.
+	Value []byte
+}
+
+// RecordHeader describes how an entry is framed in its segment.
+type RecordHeader struct {
+	Type RecordType
+
+	// Where the entry starts in its segment. For an entry written in
+	// blocks, that's the start of its first block.
+	Offset int64
+
+	// The size of the entry's body as stored, compressed or not, summed
+	// over its blocks. The framing of each record adds a few bytes.
+	Length int64
+
+	// The CRC stored with the entry's final record.
+	CRC uint32
+
+	// Whether the entry's final record is compressed. Entries written
+	// with compression off are stored raw.
+	Compressed bool
+
+	// How many records the entry was written as: 1, unless it was
+	// written in blocks.
+	Blocks int
+
+	// When the entry expires, if it was written with WriteTTL.
+	Expires time.Time
+
+	// Where the entry was first written, if it was written with
+	// WriteOrigin or by a WAL with a NodeID.
+	Origin Origin
+}
+
+// String returns the position as "segment:offset".
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Segment, p.Offset)
+}
+
+// ParsePosition parses a position in the "segment:offset" form
+// returned by Position.String.
+func ParsePosition(s string) (Position, error) {
+	idx := strings.IndexByte(s, ':')
+	if idx == -1 {
+		return Position{}, fmt.Errorf("malformed position: %s", s)
+	}
+
+	seg, err := strconv.Atoi(s[:idx])
+	if err != nil {
+		return Position{}, fmt.Errorf("malformed position: %s", s)
+	}
+
+	off, err := strconv.ParseInt(s[idx+1:], 10, 64)
+	if err != nil {
+		return Position{}, fmt.Errorf("malformed position: %s", s)
+	}
+
+	return Position{Segment: seg, Offset: off}, nil
+}
+
+// ScanRecords calls fn with every record in the WAL in root from the
+// position from on, including tags, stopping at the first error from
+// reading or from fn.
+func ScanRecords(root string, from Position, fn func(Record) error) error {
+	indexes, err := listSegments(root)
+	if err != nil {
+		return err
+	}
+
+	for _, index := range indexes {
+		if index < from.Segment {
+			continue
+		}
+
+		var offset int64
+		if index == from.Segment {
+			offset = from.Offset
+		}
+
+		err = scanSegment(filepath.Join(root, strconv.Itoa(index)), index, offset, fn)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func scanSegment(path string, index int, offset int64, fn func(Record) error) error {
+	sr, err := NewSegmentReader(path)
+	if err != nil {
+		return err
+	}
+
+	defer sr.Close()
+
+	if offset > 0 {
+		err = sr.Seek(offset)
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		start := sr.Pos()
+
+		t, ok := sr.nextRecord()
+		if !ok {
+			if err := sr.Error(); err != nil {
+				return fmt.Errorf("segment %d offset %d: %w", index, start, err)
+			}
+
+			return nil
+		}
+
+		err = fn(Record{
+			Pos:   Position{index, start},
+			Type:  RecordType(t),
+			CRC:   sr.CRC(),
+			Value: sr.Value(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestRecords(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+
+		w, err := New(path)
+		require.NoError(t, err)
+
+		require.NoError(t, w.Write([]byte("first")))
+		require.NoError(t, w.WriteTag([]byte("checkpoint")))
+		require.NoError(t, w.Write([]byte("second")))
+		require.NoError(t, w.Close())
+	})
+
+	n.It("scans records including tags", func() {
+		var recs []Record
+
+		err := ScanRecords(path, Position{}, func(rec Record) error {
+			rec.Value = append([]byte(nil), rec.Value...)
+			recs = append(recs, rec)
+			return nil
+		})
+		require.NoError(t, err)
+
+		require.Equal(t, 3, len(recs))
+
+		assert.Equal(t, RecordData, recs[0].Type)
+		assert.Equal(t, "first", string(recs[0].Value))
+		assert.Equal(t, Position{0, 0}, recs[0].Pos)
+
+		assert.Equal(t, RecordTag, recs[1].Type)
+		assert.Equal(t, "checkpoint", string(recs[1].Value))
+
+		assert.Equal(t, RecordData, recs[2].Type)
+		assert.Equal(t, "second", string(recs[2].Value))
+
+		var from []string
+
+		err = ScanRecords(path, recs[1].Pos, func(rec Record) error {
+			from = append(from, string(rec.Value))
+			return nil
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"checkpoint", "second"}, from)
+	})
+
+	n.It("formats and parses positions", func() {
+		assert.Equal(t, "2:45", Position{2, 45}.String())
+
+		pos, err := ParsePosition("2:45")
+		require.NoError(t, err)
+
+		assert.Equal(t, Position{2, 45}, pos)
+
+		_, err = ParsePosition("245")
+		assert.Error(t, err)
+	})
+
+	n.Meow()
+}
@@ -1,24 +1,88 @@
 package wal
 
+import (
+	"context"
+	"errors"
+)
+
 func BeginRecovery(path string, tag []byte) (*WALReader, error) {
-	r, err := NewReader(path)
+	return BeginRecoveryWithOptions(path, tag, DefaultReadOptions)
+}
+
+func BeginRecoveryWithOptions(path string, tag []byte, opts ReadOptions) (*WALReader, error) {
+	return beginRecovery(path, nil, tag, opts)
+}
+
+func beginRecovery(path string, stream, tag []byte, opts ReadOptions) (r *WALReader, err error) {
+	ctx, span := tracerOrNop(opts.Tracer).Start(context.Background(), SpanRecover)
+	defer func() { endSpan(span, err) }()
+
+	r, err = newReader(path, stream, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	pos, err := r.SeekTag(tag)
+	_, err = r.SeekTagContext(ctx, tag)
+	if errors.Is(err, ErrTagNotFound) {
+		// Without the tag, recovery starts from the beginning.
+		err = r.Reset()
+	}
+
 	if err != nil {
 		r.Close()
 		return nil, err
 	}
 
-	if pos.None() {
+	return r, nil
+}
+
+// BeginRecoveryUncommitted is like BeginRecoveryWithOptions, but also
+// returns where the entries written after the last commit tag begin.
+// Those entries were never committed, so rather than only skipping them
+// on this read, crash recovery can pass the position to
+// WALWriter.DiscardAfter to remove them. Without the tag nothing was
+// committed, and the position is the start of the WAL.
+func BeginRecoveryUncommitted(path string, tag []byte, opts ReadOptions) (*WALReader, Position, error) {
+	r, err := newReader(path, nil, opts)
+	if err != nil {
+		return nil, Position{-1, -1}, err
+	}
+
+	uncommitted, err := r.seekUncommitted(tag)
+	if err != nil {
+		r.Close()
+		return nil, Position{-1, -1}, err
+	}
+
+	return r, uncommitted, nil
+}
+
+// seekUncommitted positions the reader just after the last time tag was
+// written, or at the start without it, and returns that position.
+func (r *WALReader) seekUncommitted(tag []byte) (pos Position, err error) {
+	_, span := tracerOrNop(r.opts.Tracer).Start(context.Background(), SpanRecover)
+	defer func() { endSpan(span, err) }()
+
+	_, _, err = r.seekTag(tag)
+	if errors.Is(err, ErrTagNotFound) {
 		err = r.Reset()
 		if err != nil {
-			r.Close()
-			return nil, err
+			return Position{-1, -1}, err
 		}
+
+		return Position{r.first, 0}, nil
 	}
 
-	return r, nil
+	if err != nil {
+		return Position{-1, -1}, err
+	}
+
+	// Step over the tag itself, which is committed.
+	if _, ok := r.seg.nextRecord(); !ok {
+		if err := r.seg.Error(); err != nil {
+			return Position{-1, -1}, err
+		}
+	}
+
+	return Position{r.index, r.seg.Pos()}, nil
 }
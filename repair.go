@@ -0,0 +1,396 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ScrubRepair is what the scrubber does with a damaged segment it finds,
+// beyond reporting it.
+type ScrubRepair int
+
+const (
+	// RepairNone leaves the segment as it is.
+	RepairNone ScrubRepair = iota
+
+	// RepairQuarantine moves the segment, along with its time index and
+	// block checksums, into ScrubOptions.QuarantineDir, where it can be
+	// examined. Readers stop at the gap it leaves with
+	// ErrSegmentMissing rather than reading damaged entries.
+	RepairQuarantine
+
+	// RepairTruncate cuts the segment off after the last intact record
+	// before the damage and marks it closed cleanly. The entries after
+	// the damage are lost. A segment whose seal doesn't match its records
+	// is left as it is: its records may all read back intact, and cutting
+	// the seal off would only hide that they were changed.
+	RepairTruncate
+
+	// RepairRefetch replaces the segment with a copy from
+	// ScrubOptions.Source, such as an archive or a replica. The copy is
+	// verified first, and the segment left as it is if it can't be
+	// fetched or is damaged too.
+	RepairRefetch
+)
+
+func (r ScrubRepair) String() string {
+	switch r {
+	case RepairNone:
+		return "none"
+	case RepairQuarantine:
+		return "quarantine"
+	case RepairTruncate:
+		return "truncate"
+	case RepairRefetch:
+		return "refetch"
+	default:
+		return fmt.Sprintf("ScrubRepair(%d)", int(r))
+	}
+}
+
+// SegmentSource supplies intact copies of segments, such as from an
+// archive or a replica, to repair damaged ones with. BlobArchiver is
+// one.
+type SegmentSource interface {
+	// FetchSegment writes the contents of segment index to w.
+	FetchSegment(ctx context.Context, index int, w io.Writer) error
+}
+
+// The directory damaged segments are quarantined in if
+// ScrubOptions.QuarantineDir isn't set.
+const defaultQuarantineDir = "quarantine"
+
+// repairSegment applies the scrubber's repair policy to a damaged
+// segment.
+func (s *scrubber) repairSegment(index int, rep SegmentReport) error {
+	wal := s.wal
+	path := filepath.Join(wal.root, strconv.Itoa(index))
+
+	switch s.opts.Repair {
+	case RepairQuarantine:
+		return wal.quarantineSegment(index, s.opts.QuarantineDir)
+	case RepairTruncate:
+		return wal.truncateSegment(index, path, rep)
+	case RepairRefetch:
+		return wal.refetchSegment(s.t.Context(nil), index, path, s.opts.Source)
+	default:
+		return nil
+	}
+}
+
+// quarantineSegment moves a sealed segment and the files kept beside it
+// into dir.
+func (wal *WALWriter) quarantineSegment(index int, dir string) error {
+	if dir == "" {
+		dir = defaultQuarantineDir
+	}
+
+	dir = pruneDir(wal.root, dir)
+
+	err := wal.fs.Mkdir(dir, 0755)
+	if err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	err = wal.checkSealed(index)
+	if err != nil {
+		return err
+	}
+
+	err = wal.fs.Rename(filepath.Join(wal.root, strconv.Itoa(index)), filepath.Join(dir, strconv.Itoa(index)))
+	if err != nil {
+		return err
+	}
+
+	wal.fs.Rename(timeIndexPath(wal.root, index), timeIndexPath(dir, index))
+	wal.fs.Rename(blockSumPath(wal.root, index), blockSumPath(dir, index))
+
+	wal.sealedBytes -= wal.segSizes[index]
+	delete(wal.segSizes, index)
+
+	if wal.quotas != nil {
+		wal.quotas.release(index)
+	}
+
+	return nil
+}
+
+// checkSealed returns an error if segment index is no longer one of the
+// WAL's sealed segments, such as because it's been pruned or
+// quarantined since it was found to be damaged. The lock must be held.
+func (wal *WALWriter) checkSealed(index int) error {
+	if index < wal.first || index >= wal.index {
+		return fmt.Errorf("%w: segment %d is no longer sealed in the WAL", ErrSegmentMissing, index)
+	}
+
+	return nil
+}
+
+// truncateSegment cuts a sealed segment off after the last record that
+// ends by the damage rep found, and seals it again. The segment is
+// copied without the lock held, so writes carry on meanwhile, and only
+// swapped for the copy with it held.
+func (wal *WALWriter) truncateSegment(index int, path string, rep SegmentReport) error {
+	if errors.Is(rep.err, ErrSealBroken) {
+		return fmt.Errorf("segment %d can't be truncated: %w", index, rep.err)
+	}
+
+	before, err := wal.fs.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	end, err := intactEnd(wal.fs, path, rep.ErrorOffset)
+	if err != nil {
+		return err
+	}
+
+	// Redo the block checksums, if it has them, as it's copied.
+	var sums *blockSummer
+
+	sumSize, _, err := readBlockSums(wal.fs, path)
+	if err != nil {
+		return err
+	}
+
+	if sumSize > 0 {
+		sums = newBlockSummer(sumSize)
+	}
+
+	tmp := path + ".cut"
+
+	size, err := copyPrefix(wal.fs, path, tmp, end, !wal.opts.NoClosingMagic, sums)
+	if err != nil {
+		return err
+	}
+
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	err = wal.checkSealed(index)
+	if err == nil {
+		err = checkUnchanged(wal.fs, path, before)
+	}
+
+	if err == nil {
+		err = wal.fs.Rename(tmp, path)
+	}
+
+	if err != nil {
+		wal.fs.Remove(tmp)
+		return err
+	}
+
+	wal.protectSegment(path)
+
+	// Points past the end would send SeekTime beyond it.
+	wal.fs.Remove(timeIndexPath(wal.root, index))
+
+	if sums != nil {
+		err = saveBlockSums(wal.fs, path, sums.finish())
+		if err != nil {
+			return err
+		}
+	}
+
+	wal.sealedBytes += size - wal.segSizes[index]
+	wal.segSizes[index] = size
+
+	return nil
+}
+
+// checkUnchanged returns an error if the file at path is no longer the
+// one described by before, such as because it was replaced while a
+// copy of it was being made.
+func checkUnchanged(fs FS, path string, before os.FileInfo) error {
+	fi, err := fs.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if fi.Size() != before.Size() || !fi.ModTime().Equal(before.ModTime()) {
+		return fmt.Errorf("%s changed while it was being repaired", path)
+	}
+
+	return nil
+}
+
+// cutSegment replaces the segment at path with a copy of its first end
+// bytes, followed by the closing magic if magic is set, returning the
+// copy's size. The copy is synced and renamed over the segment rather
+// than the segment being truncated in place, so a crash leaves one or
+// the other and the hard links of snapshots keep the original.
+func cutSegment(fs FS, path string, end int64, magic bool) (int64, error) {
+	tmp := path + ".cut"
+
+	size, err := copyPrefix(fs, path, tmp, end, magic, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	err = fs.Rename(tmp, path)
+	if err != nil {
+		fs.Remove(tmp)
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// copyPrefix writes the first end bytes of the segment at path to tmp,
+// followed by the closing magic if magic is set, and syncs it, returning
+// its size. What's written is also added to sums, if set.
+func copyPrefix(fs FS, path, tmp string, end int64, magic bool, sums *blockSummer) (int64, error) {
+	src, err := fs.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	defer src.Close()
+
+	f, err := fs.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, err
+	}
+
+	var w io.Writer = f
+	if sums != nil {
+		w = io.MultiWriter(f, sums)
+	}
+
+	size := end
+
+	_, err = io.Copy(w, io.LimitReader(src, end))
+	if err == nil && magic {
+		_, err = w.Write(closingMagic)
+		size += int64(len(closingMagic))
+	}
+
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		fs.Remove(tmp)
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// intactEnd returns the end of the last record in the segment at path
+// that ends by offset and can be read.
+func intactEnd(fs FS, path string, offset int64) (int64, error) {
+	opts := DefaultReadOptions
+	opts.FS = fs
+
+	sr, err := NewSegmentReaderWithOptions(path, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	defer sr.Close()
+
+	var end int64
+
+	for {
+		_, ok := sr.nextRecord()
+		if !ok || sr.Pos() > offset {
+			return end, nil
+		}
+
+		end = sr.Pos()
+	}
+}
+
+// refetchSegment replaces a sealed segment with the copy from src, once
+// it's been verified. As with truncateSegment, the lock is only held to
+// swap the copy in.
+func (wal *WALWriter) refetchSegment(ctx context.Context, index int, path string, src SegmentSource) error {
+	sumSize, _, err := readBlockSums(wal.fs, path)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".fetch"
+
+	f, err := wal.fs.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = src.FetchSegment(ctx, index, f)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		rep := verifySegmentFS(wal.fs, tmp, index)
+
+		switch {
+		case rep.err != nil:
+			err = fmt.Errorf("fetched copy: %w", rep.err)
+		case !rep.Sealed && !wal.opts.NoClosingMagic:
+			err = fmt.Errorf("fetched copy: %w", ErrNotSealed)
+		}
+	}
+
+	var sums []byte
+
+	if err == nil && sumSize > 0 {
+		sums, err = sumFile(wal.fs, tmp, sumSize)
+	}
+
+	if err != nil {
+		wal.fs.Remove(tmp)
+		return err
+	}
+
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	err = wal.checkSealed(index)
+	if err == nil {
+		err = wal.fs.Rename(tmp, path)
+	}
+
+	if err != nil {
+		wal.fs.Remove(tmp)
+		return err
+	}
+
+	wal.protectSegment(path)
+
+	if sums != nil {
+		err = saveBlockSums(wal.fs, path, sums)
+		if err != nil {
+			return err
+		}
+	}
+
+	fi, err := wal.fs.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	wal.sealedBytes += fi.Size() - wal.segSizes[index]
+	wal.segSizes[index] = fi.Size()
+
+	return nil
+}
@@ -0,0 +1,254 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestScrubRepair(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+	segment := filepath.Join(path, "1")
+
+	var (
+		archiver *BlobArchiver
+		original []byte
+	)
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+
+		archiver = NewBlobArchiver(NewMemoryBlobStore(), BlobArchiveOptions{Compress: true})
+
+		opts := DefaultWriteOptions
+		opts.SegmentSize = 1024
+		opts.MaxSegments = 100
+		opts.NoCompression = true
+		opts.Archiver = archiver
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		for i := 0; i < 20; i++ {
+			require.NoError(t, wal.Write(bytes.Repeat([]byte(fmt.Sprintf("entry%d ", i)), 20)))
+		}
+
+		require.NoError(t, wal.Close())
+		require.NoError(t, archiver.Wait())
+
+		original, err = ioutil.ReadFile(segment)
+		require.NoError(t, err)
+
+		// Damage a record part way through segment 1.
+		data := append([]byte(nil), original...)
+		data[500] ^= 0xff
+
+		require.NoError(t, ioutil.WriteFile(segment, data, 0644))
+	})
+
+	scrub := func(opts ScrubOptions) ScrubDamage {
+		damage := make(chan ScrubDamage, 10)
+
+		opts.Interval = 10 * time.Millisecond
+		opts.OnDamage = func(d ScrubDamage) {
+			select {
+			case damage <- d:
+			default:
+			}
+		}
+
+		wal, err := New(path, func(wo *WriteOptions) { wo.Scrub = opts })
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		select {
+		case d := <-damage:
+			require.Equal(t, 1, d.Segment)
+			return d
+		case <-time.After(5 * time.Second):
+			t.Fatal("no damage reported")
+			return ScrubDamage{}
+		}
+	}
+
+	n.It("only reports damage by default", func() {
+		d := scrub(ScrubOptions{})
+
+		assert.Equal(t, RepairNone, d.Repaired)
+		assert.NoError(t, d.RepairErr)
+
+		report, err := Verify(path)
+		require.NoError(t, err)
+
+		assert.False(t, report.OK)
+	})
+
+	n.It("quarantines a damaged segment", func() {
+		d := scrub(ScrubOptions{Repair: RepairQuarantine})
+
+		require.NoError(t, d.RepairErr)
+		assert.Equal(t, RepairQuarantine, d.Repaired)
+
+		_, err := os.Stat(segment)
+		assert.True(t, os.IsNotExist(err))
+
+		_, err = os.Stat(filepath.Join(path, "quarantine", "1"))
+		assert.NoError(t, err)
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		for r.Next() {
+		}
+
+		assert.True(t, errors.Is(r.Error(), ErrSegmentMissing))
+	})
+
+	n.It("truncates a damaged segment before the damage", func() {
+		d := scrub(ScrubOptions{Repair: RepairTruncate})
+
+		require.NoError(t, d.RepairErr)
+		assert.Equal(t, RepairTruncate, d.Repaired)
+
+		report, err := Verify(path)
+		require.NoError(t, err)
+
+		assert.True(t, report.OK)
+
+		seg := report.Segments[1]
+
+		assert.True(t, seg.Sealed)
+		assert.True(t, seg.Size < int64(len(original)))
+		assert.True(t, seg.Entries > 0)
+	})
+
+	n.It("leaves the hard links of a truncated segment alone", func() {
+		damaged, err := ioutil.ReadFile(segment)
+		require.NoError(t, err)
+
+		link := filepath.Join(dir, "link")
+		defer os.Remove(link)
+
+		require.NoError(t, os.Link(segment, link))
+
+		d := scrub(ScrubOptions{Repair: RepairTruncate})
+
+		require.NoError(t, d.RepairErr)
+
+		data, err := ioutil.ReadFile(link)
+		require.NoError(t, err)
+
+		assert.Equal(t, damaged, data)
+
+		_, err = os.Stat(segment + ".cut")
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	n.It("won't truncate a segment whose seal is broken", func() {
+		damaged, err := ioutil.ReadFile(segment)
+		require.NoError(t, err)
+
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		rep := SegmentReport{err: fmt.Errorf("segment 1: %w", ErrSealBroken)}
+
+		err = wal.truncateSegment(1, segment, rep)
+		assert.True(t, errors.Is(err, ErrSealBroken))
+
+		data, err := ioutil.ReadFile(segment)
+		require.NoError(t, err)
+
+		assert.Equal(t, damaged, data)
+
+		_, err = os.Stat(segment + ".cut")
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	n.It("won't repair a segment that's no longer sealed in the WAL", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		active := wal.current
+
+		err = wal.quarantineSegment(wal.index, "")
+		assert.True(t, errors.Is(err, ErrSegmentMissing))
+
+		err = wal.truncateSegment(wal.index, active, SegmentReport{})
+		assert.True(t, errors.Is(err, ErrSegmentMissing))
+
+		err = wal.quarantineSegment(wal.first-1, "")
+		assert.True(t, errors.Is(err, ErrSegmentMissing))
+
+		_, err = os.Stat(active)
+		assert.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("still writable")))
+	})
+
+	n.It("replaces a damaged segment with a copy from the source", func() {
+		d := scrub(ScrubOptions{Repair: RepairRefetch, Source: archiver})
+
+		require.NoError(t, d.RepairErr)
+		assert.Equal(t, RepairRefetch, d.Repaired)
+
+		data, err := ioutil.ReadFile(segment)
+		require.NoError(t, err)
+
+		assert.Equal(t, original, data)
+
+		report, err := Verify(path)
+		require.NoError(t, err)
+
+		assert.True(t, report.OK)
+	})
+
+	n.It("leaves the segment if the source can't supply it", func() {
+		empty := NewBlobArchiver(NewMemoryBlobStore(), BlobArchiveOptions{})
+
+		d := scrub(ScrubOptions{Repair: RepairRefetch, Source: empty})
+
+		assert.Error(t, d.RepairErr)
+		assert.Equal(t, RepairNone, d.Repaired)
+
+		_, err := os.Stat(segment + ".fetch")
+		assert.True(t, os.IsNotExist(err))
+
+		data, err := ioutil.ReadFile(segment)
+		require.NoError(t, err)
+
+		assert.NotEqual(t, original, data)
+	})
+
+	n.It("requires a source to refetch from", func() {
+		wo := DefaultWriteOptions
+		wo.Scrub.Repair = RepairRefetch
+
+		assert.True(t, errors.Is(wo.Validate(), ErrInvalidOptions))
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,131 @@
+package wal
+
+import (
+	"path/filepath"
+	"strconv"
+)
+
+// RegisterReader records that the reader called name has processed
+// every entry before pos, registering it if it's new. Call it again as
+// the reader acknowledges more entries. With RetainForReaders set,
+// segments at or after the oldest registered position aren't pruned, so
+// retention follows the slowest reader rather than a fixed count.
+//
+// Registrations aren't persisted; readers must register again after the
+// WAL is reopened.
+func (wal *WALWriter) RegisterReader(name string, pos Position) error {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	if wal.closed {
+		return ErrClosed
+	}
+
+	if wal.readers == nil {
+		wal.readers = make(map[string]Position)
+	}
+
+	wal.readers[name] = pos
+
+	return nil
+}
+
+// UnregisterReader forgets the reader called name, so it no longer
+// holds back pruning.
+func (wal *WALWriter) UnregisterReader(name string) {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	delete(wal.readers, name)
+}
+
+// ReaderPositions returns the positions of the registered readers, by
+// name.
+func (wal *WALWriter) ReaderPositions() map[string]Position {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	positions := make(map[string]Position, len(wal.readers))
+
+	for name, pos := range wal.readers {
+		positions[name] = pos
+	}
+
+	return positions
+}
+
+// minReaderPos returns the oldest position of any registered reader.
+// The lock must be held.
+func (wal *WALWriter) minReaderPos() (Position, bool) {
+	var (
+		min Position
+		ok  bool
+	)
+
+	for _, pos := range wal.readers {
+		if !ok || pos.less(min) {
+			min = pos
+			ok = true
+		}
+	}
+
+	return min, ok
+}
+
+// PruneBefore removes the segments that only hold entries before pos,
+// as MaxSegments would, moving them to PruneDir if that's set. It's how
+// a WAL with MaxSegments of 0 is trimmed, such as once a checkpoint
+// covering everything before pos has been taken, but works whatever
+// MaxSegments is. The active segment is never removed, and with
+// RetainForReaders set, neither are those registered readers still
+// need.
+func (wal *WALWriter) PruneBefore(pos Position) error {
+	wal.lockIO()
+	defer wal.unlockIO()
+
+	if wal.closed {
+		return ErrClosed
+	}
+
+	last := pos.Segment - 1
+	if last >= wal.index {
+		last = wal.index - 1
+	}
+
+	return wal.pruneThrough(last)
+}
+
+// removeSegment prunes segment i, moving it to PruneDir if that's set.
+// Its time index and block checksums, if any, go with it.
+func (wal *WALWriter) removeSegment(i int) error {
+	path := filepath.Join(wal.root, strconv.Itoa(i))
+
+	if wal.opts.PruneDir == "" {
+		err := wal.fs.Remove(path)
+		if err == nil {
+			wal.fs.Remove(timeIndexPath(wal.root, i))
+			wal.fs.Remove(blockSumPath(wal.root, i))
+		}
+
+		return err
+	}
+
+	dir := pruneDir(wal.root, wal.opts.PruneDir)
+
+	err := wal.fs.Rename(path, filepath.Join(dir, strconv.Itoa(i)))
+	if err == nil {
+		wal.fs.Rename(timeIndexPath(wal.root, i), timeIndexPath(dir, i))
+		wal.fs.Rename(blockSumPath(wal.root, i), blockSumPath(dir, i))
+	}
+
+	return err
+}
+
+// pruneDir returns where the WAL in root moves pruned segments to.
+func pruneDir(root, dir string) string {
+	if filepath.IsAbs(dir) {
+		return dir
+	}
+
+	return filepath.Join(root, dir)
+}
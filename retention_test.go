@@ -0,0 +1,259 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestRetention(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	fill := func(wal *WALWriter, n int) {
+		for i := 0; i < n; i++ {
+			require.NoError(t, wal.Write([]byte("this is data that fills a segment on its own, more or less")))
+		}
+	}
+
+	n.It("keeps the segments a registered reader hasn't acknowledged", func() {
+		wal, err := New(path, WithSegmentSize(100), WithMaxSegments(2), WithRetainForReaders())
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		fill(wal, 1)
+
+		start, err := wal.Pos()
+		require.NoError(t, err)
+
+		require.NoError(t, wal.RegisterReader("slow", start))
+
+		fill(wal, 5)
+
+		assert.Equal(t, start.Segment, wal.first)
+
+		pos, err := wal.Pos()
+		require.NoError(t, err)
+
+		require.NoError(t, wal.RegisterReader("slow", pos))
+		assert.Equal(t, map[string]Position{"slow": pos}, wal.ReaderPositions())
+
+		fill(wal, 1)
+
+		assert.Equal(t, wal.index-1, wal.first)
+	})
+
+	n.It("follows the slowest reader", func() {
+		wal, err := New(path, WithSegmentSize(100), WithMaxSegments(2), WithRetainForReaders())
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		fill(wal, 1)
+
+		start, err := wal.Pos()
+		require.NoError(t, err)
+
+		require.NoError(t, wal.RegisterReader("slow", start))
+
+		fill(wal, 3)
+
+		pos, err := wal.Pos()
+		require.NoError(t, err)
+
+		require.NoError(t, wal.RegisterReader("fast", pos))
+
+		fill(wal, 2)
+
+		assert.Equal(t, start.Segment, wal.first)
+
+		wal.UnregisterReader("slow")
+
+		fill(wal, 1)
+
+		assert.Equal(t, pos.Segment, wal.first)
+	})
+
+	n.It("prunes by count when the mode is off", func() {
+		wal, err := New(path, WithSegmentSize(100), WithMaxSegments(2))
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		require.NoError(t, wal.RegisterReader("slow", Position{0, 0}))
+
+		fill(wal, 6)
+
+		assert.Equal(t, wal.index-1, wal.first)
+	})
+
+	n.It("moves pruned segments into the prune directory", func() {
+		wal, err := New(path, WithSegmentSize(100), WithMaxSegments(2), WithPruneDir("archive"))
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		fill(wal, 4)
+
+		first := wal.first
+		require.True(t, first > 1)
+
+		for i := 0; i < first; i++ {
+			_, err = os.Stat(filepath.Join(path, strconv.Itoa(i)))
+			assert.True(t, os.IsNotExist(err))
+
+			_, err = os.Stat(filepath.Join(path, "archive", strconv.Itoa(i)))
+			assert.NoError(t, err)
+		}
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		assert.Equal(t, first, r.first)
+	})
+
+	n.It("keeps every segment with unlimited retention", func() {
+		wal, err := New(path, WithSegmentSize(100), WithUnlimitedRetention())
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		fill(wal, 6)
+
+		assert.Equal(t, 0, wal.first)
+		assert.Equal(t, 5, wal.index)
+
+		for i := 0; i <= wal.index; i++ {
+			_, err = os.Stat(filepath.Join(path, strconv.Itoa(i)))
+			assert.NoError(t, err)
+		}
+	})
+
+	n.It("prunes before a position on request", func() {
+		wal, err := New(path, WithSegmentSize(100), WithUnlimitedRetention())
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		fill(wal, 3)
+
+		pos, err := wal.Pos()
+		require.NoError(t, err)
+
+		fill(wal, 2)
+
+		require.NoError(t, wal.PruneBefore(pos))
+
+		assert.Equal(t, pos.Segment, wal.first)
+
+		for i := 0; i < pos.Segment; i++ {
+			_, err = os.Stat(filepath.Join(path, strconv.Itoa(i)))
+			assert.True(t, os.IsNotExist(err))
+		}
+
+		st, err := wal.Stats()
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(pos.Segment), st.Prunes)
+
+		// The active segment stays, however far the position is.
+		require.NoError(t, wal.PruneBefore(Position{wal.index + 10, 0}))
+
+		assert.Equal(t, wal.index, wal.first)
+
+		_, err = os.Stat(filepath.Join(path, strconv.Itoa(wal.index)))
+		assert.NoError(t, err)
+	})
+
+	n.It("won't prune what registered readers need", func() {
+		wal, err := New(path, WithSegmentSize(100), WithUnlimitedRetention(), WithRetainForReaders())
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		fill(wal, 2)
+
+		start, err := wal.Pos()
+		require.NoError(t, err)
+
+		require.NoError(t, wal.RegisterReader("slow", start))
+
+		fill(wal, 3)
+
+		pos, err := wal.Pos()
+		require.NoError(t, err)
+
+		require.NoError(t, wal.PruneBefore(pos))
+
+		assert.Equal(t, start.Segment, wal.first)
+	})
+
+	n.It("removes pruned segments no faster than the prune rate", func() {
+		wal, err := New(path, WithSegmentSize(100), WithUnlimitedRetention(), WithPruneRate(500))
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		fill(wal, 6)
+
+		pos, err := wal.Pos()
+		require.NoError(t, err)
+
+		fi, err := os.Stat(filepath.Join(path, "0"))
+		require.NoError(t, err)
+
+		start := time.Now()
+
+		require.NoError(t, wal.PruneBefore(pos))
+
+		// The segments are pruned right away, but not all removed yet.
+		assert.Equal(t, pos.Segment, wal.first)
+
+		_, err = os.Stat(filepath.Join(path, strconv.Itoa(pos.Segment-1)))
+		assert.NoError(t, err)
+
+		for {
+			st, err := wal.Stats()
+			require.NoError(t, err)
+
+			if st.Prunes == int64(pos.Segment) {
+				break
+			}
+
+			require.True(t, time.Since(start) < 10*time.Second, "segments not removed")
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		// The first is removed at once, and each after it once the
+		// previous one's bytes are paid for.
+		min := time.Duration(int64(pos.Segment-1) * fi.Size() * int64(time.Second) / 500)
+		assert.True(t, time.Since(start) >= min*9/10, "removed in %s, expected at least %s", time.Since(start), min)
+
+		for i := 0; i < pos.Segment; i++ {
+			_, err = os.Stat(filepath.Join(path, strconv.Itoa(i)))
+			assert.True(t, os.IsNotExist(err))
+		}
+	})
+
+	n.Meow()
+}
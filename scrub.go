@@ -0,0 +1,244 @@
+package wal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	tomb "gopkg.in/tomb.v2"
+)
+
+// ScrubOptions configures the scrubber, which re-reads the sealed
+// segments of a WAL in the background checking their CRCs, seals and
+// block checksums, so that data that's rotted on disk is found long
+// before a recovery depends on it.
+type ScrubOptions struct {
+	// How long to wait between passes over the sealed segments. If 0,
+	// the scrubber doesn't run.
+	Interval time.Duration
+
+	// The most bytes a second the scrubber reads, so that it doesn't
+	// compete with writes and readers for the disk. If 0, it reads as
+	// fast as it can.
+	BytesPerSecond int64
+
+	// What to do with a damaged segment once it's been found. If
+	// RepairNone, it's only reported.
+	Repair ScrubRepair
+
+	// Where RepairQuarantine moves damaged segments to. A relative
+	// path is within the WAL's directory. If empty, "quarantine" is
+	// used.
+	QuarantineDir string
+
+	// Where RepairRefetch gets intact copies of segments from, which it
+	// requires.
+	Source SegmentSource
+
+	// Called, from the scrubber's goroutine, with each damaged segment
+	// found, once any repair has been attempted. Damage is also logged
+	// and counted in MetricScrubDamage.
+	OnDamage func(ScrubDamage)
+}
+
+// ScrubDamage describes a damaged segment found by the scrubber.
+type ScrubDamage struct {
+	Segment int
+
+	// What's wrong with the segment, and the offset of the record where
+	// it was found.
+	Err    error
+	Offset int64
+
+	// The blocks that don't match their checksums, for a segment
+	// checksummed with WriteOptions.ChecksumBlockSize.
+	BadBlocks []BlockRange
+
+	// The repair made to the segment, which is RepairNone if none was
+	// attempted or it failed, and why it failed.
+	Repaired  ScrubRepair
+	RepairErr error
+}
+
+var errScrubStopped = errors.New("scrubber stopped")
+
+// scrubber runs passes over the sealed segments of a WAL until the WAL
+// is closed.
+type scrubber struct {
+	wal  *WALWriter
+	opts ScrubOptions
+
+	t tomb.Tomb
+}
+
+// startScrubber starts the scrubber, if the WAL is set to run one.
+func (wal *WALWriter) startScrubber() {
+	if wal.opts.Scrub.Interval <= 0 {
+		return
+	}
+
+	wal.scrub = &scrubber{wal: wal, opts: wal.opts.Scrub}
+	wal.scrub.t.Go(wal.scrub.run)
+}
+
+// stopScrubber stops the scrubber, waiting for the segment it's reading
+// to be let go.
+func (wal *WALWriter) stopScrubber() {
+	if wal.scrub == nil {
+		return
+	}
+
+	wal.scrub.t.Kill(nil)
+	wal.scrub.t.Wait()
+}
+
+func (s *scrubber) run() error {
+	tick := time.NewTicker(s.opts.Interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			s.pass()
+		case <-s.t.Dying():
+			return nil
+		}
+	}
+}
+
+// pass scrubs each sealed segment once.
+func (s *scrubber) pass() {
+	wal := s.wal
+
+	wal.lock.Lock()
+	first, active := wal.first, wal.index
+	wal.lock.Unlock()
+
+	for i := first; i < active; i++ {
+		// Outside the maintenance window, the pass is left for the
+		// next one to start again.
+		if !wal.maintenanceOpen() || !s.segment(i) {
+			return
+		}
+	}
+}
+
+// segment scrubs one segment, returning false if the scrubber was
+// stopped part way through it.
+func (s *scrubber) segment(index int) bool {
+	wal := s.wal
+
+	fs := &throttledFS{FS: wal.fs, rate: s.opts.BytesPerSecond, dying: s.t.Dying(), start: time.Now()}
+
+	rep := verifySegmentFS(fs, filepath.Join(wal.root, strconv.Itoa(index)), index)
+
+	switch {
+	case errors.Is(rep.err, errScrubStopped):
+		return false
+	case errors.Is(rep.err, os.ErrNotExist):
+		// Pruned since the pass started.
+		return true
+	case rep.err == nil && !rep.Sealed && !wal.opts.NoClosingMagic:
+		rep.err, rep.ErrorOffset = ErrNotSealed, rep.Size
+	}
+
+	wal.metrics.IncrCounter(MetricScrubbedSegments, 1)
+	wal.metrics.IncrCounter(MetricScrubbedBytes, fs.read)
+
+	if rep.err == nil {
+		return true
+	}
+
+	wal.logger.Error("scrubber found a damaged segment", "segment", index, "offset", rep.ErrorOffset, "error", rep.err)
+	wal.metrics.IncrCounter(MetricScrubDamage, 1)
+
+	damage := ScrubDamage{
+		Segment:   index,
+		Err:       rep.err,
+		Offset:    rep.ErrorOffset,
+		BadBlocks: rep.BadBlocks,
+	}
+
+	if s.opts.Repair != RepairNone {
+		err := s.repairSegment(index, rep)
+		if err != nil {
+			wal.logger.Error("failed to repair segment", "segment", index, "repair", s.opts.Repair, "error", err)
+			wal.metrics.IncrCounter(MetricScrubRepairErrors, 1)
+
+			damage.RepairErr = err
+		} else {
+			wal.logger.Warn("repaired segment", "segment", index, "repair", s.opts.Repair)
+			wal.metrics.IncrCounter(MetricScrubRepairs, 1)
+
+			damage.Repaired = s.opts.Repair
+		}
+	}
+
+	if s.opts.OnDamage != nil {
+		s.opts.OnDamage(damage)
+	}
+
+	return true
+}
+
+// throttledFS limits how fast the files opened through it are read, and
+// fails reads once dying is closed.
+type throttledFS struct {
+	FS
+
+	rate  int64
+	dying <-chan struct{}
+
+	start time.Time
+	read  int64
+}
+
+func (t *throttledFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := t.FS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &throttledFile{File: f, fs: t}, nil
+}
+
+type throttledFile struct {
+	File
+	fs *throttledFS
+}
+
+func (f *throttledFile) Read(p []byte) (int, error) {
+	t := f.fs
+
+	select {
+	case <-t.dying:
+		return 0, errScrubStopped
+	default:
+	}
+
+	n, err := f.File.Read(p)
+
+	t.read += int64(n)
+
+	if t.rate <= 0 {
+		return n, err
+	}
+
+	// Wait until reading this much at the rate would have taken.
+	due := t.start.Add(time.Duration(float64(t.read) / float64(t.rate) * float64(time.Second)))
+
+	if wait := time.Until(due); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-t.dying:
+			return n, errScrubStopped
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,160 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestScrubber(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+
+		wal, err := New(path, WithSegmentSize(1024), WithMaxSegments(100), WithCompression(false))
+		require.NoError(t, err)
+
+		for i := 0; i < 20; i++ {
+			require.NoError(t, wal.Write(bytes.Repeat([]byte(fmt.Sprintf("entry%d ", i)), 20)))
+		}
+
+		require.NoError(t, wal.Close())
+	})
+
+	open := func(scrub ScrubOptions) (*WALWriter, *testMetrics, chan ScrubDamage) {
+		var metrics testMetrics
+
+		damage := make(chan ScrubDamage, 10)
+
+		scrub.OnDamage = func(d ScrubDamage) {
+			select {
+			case damage <- d:
+			default:
+			}
+		}
+
+		opts := DefaultWriteOptions
+		opts.SegmentSize = 1024
+		opts.MaxSegments = 100
+		opts.Metrics = &metrics
+		opts.Scrub = scrub
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		return wal, &metrics, damage
+	}
+
+	counter := func(m *testMetrics, name string) int64 {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		return m.counters[name]
+	}
+
+	n.It("re-reads the sealed segments", func() {
+		_, last, err := rangeSegments(OSFS, path)
+		require.NoError(t, err)
+
+		wal, metrics, damage := open(ScrubOptions{Interval: 10 * time.Millisecond})
+		defer wal.Close()
+
+		deadline := time.Now().Add(5 * time.Second)
+
+		for counter(metrics, MetricScrubbedSegments) < int64(last) {
+			require.True(t, time.Now().Before(deadline), "scrubber didn't finish a pass")
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		assert.True(t, counter(metrics, MetricScrubbedBytes) > 0)
+		assert.Equal(t, int64(0), counter(metrics, MetricScrubDamage))
+		assert.Len(t, damage, 0)
+	})
+
+	n.It("reports damaged segments", func() {
+		segment := filepath.Join(path, "1")
+
+		data, err := ioutil.ReadFile(segment)
+		require.NoError(t, err)
+
+		data[100] ^= 0xff
+
+		require.NoError(t, ioutil.WriteFile(segment, data, 0644))
+
+		wal, metrics, damage := open(ScrubOptions{Interval: 10 * time.Millisecond})
+		defer wal.Close()
+
+		select {
+		case d := <-damage:
+			assert.Equal(t, 1, d.Segment)
+			assert.True(t, errors.Is(d.Err, ErrCorrupt))
+			assert.True(t, d.Offset <= 100)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no damage reported")
+		}
+
+		assert.True(t, counter(metrics, MetricScrubDamage) > 0)
+	})
+
+	n.It("reports a sealed segment missing its closing magic", func() {
+		segment := filepath.Join(path, "0")
+
+		fi, err := os.Stat(segment)
+		require.NoError(t, err)
+
+		require.NoError(t, os.Truncate(segment, fi.Size()-int64(len(closingMagic))))
+
+		wal, _, damage := open(ScrubOptions{Interval: 10 * time.Millisecond})
+		defer wal.Close()
+
+		select {
+		case d := <-damage:
+			assert.Equal(t, 0, d.Segment)
+			assert.Equal(t, ErrNotSealed, d.Err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no damage reported")
+		}
+	})
+
+	n.It("limits how fast it reads", func() {
+		wal, metrics, _ := open(ScrubOptions{Interval: time.Millisecond, BytesPerSecond: 2048})
+
+		time.Sleep(200 * time.Millisecond)
+
+		start := time.Now()
+
+		require.NoError(t, wal.Close())
+
+		// Closing interrupts a throttled read rather than waiting it
+		// out.
+		assert.True(t, time.Since(start) < time.Second)
+
+		assert.True(t, counter(metrics, MetricScrubbedBytes) <= 2048)
+	})
+
+	n.It("rejects a negative interval", func() {
+		wo := DefaultWriteOptions
+		wo.Scrub.Interval = -1
+
+		assert.True(t, errors.Is(wo.Validate(), ErrInvalidOptions))
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,266 @@
+package wal
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/golang/snappy"
+)
+
+// The body of a seal record: the offset the seal is at, followed by the
+// checksum of the records before it, both big endian.
+const sealSize = 12
+
+// sumRecord adds a record to the checksum a seal is checked against.
+// It covers each record's CRC, type and length, and so, through the CRC,
+// its body too, which makes it cheap to keep as the segment is read.
+func (r *SegmentReader) sumRecord(e segmentEntry) {
+	if r.sealSum == nil {
+		return
+	}
+
+	t := e.entryType
+	if e.raw {
+		t |= rawFlag
+	}
+
+	var buf [5 + binary.MaxVarintLen64]byte
+
+	binary.BigEndian.PutUint32(buf[:4], e.crc)
+	buf[4] = t
+
+	n := binary.PutUvarint(buf[5:], uint64(len(e.value)))
+
+	r.sealSum.Write(buf[:5+n])
+}
+
+// checkSeal checks the segment's seal against the records read before
+// it, if they were all read.
+func (r *SegmentReader) checkSeal(e segmentEntry) error {
+	r.pastSeal = true
+
+	if r.sealSum == nil {
+		return nil
+	}
+
+	body, err := r.decode(e)
+	if err != nil {
+		return err
+	}
+
+	if len(body) != sealSize ||
+		int64(binary.BigEndian.Uint64(body[:8])) != e.offset ||
+		binary.BigEndian.Uint32(body[8:]) != r.sealSum.Sum32() {
+		return r.corrupt(e.offset, ErrSealBroken)
+	}
+
+	r.sealChecked = true
+
+	return nil
+}
+
+// sumRecord adds a record being written to the checksum the segment is
+// sealed with. The reader's checksum covers the same bytes as the
+// record's header, so that's all there is to add.
+func (s *SegmentWriter) sumRecord(rec encodedRecord) {
+	if s.sealSum != nil {
+		s.sealSum.Write(rec.header)
+	}
+}
+
+// resetSum drops the seal and block checksums of what's after pos when
+// the segment is truncated to it. Only truncating it away entirely
+// leaves known checksums.
+func (s *SegmentWriter) resetSum(pos int64) {
+	if pos != 0 {
+		s.sealSum = nil
+		s.blockSums = nil
+
+		return
+	}
+
+	s.sealSum = crc32.NewIEEE()
+
+	if s.blockSums != nil {
+		s.blockSums = newBlockSummer(s.blockSums.size)
+	}
+}
+
+// seal writes the segment's seal. The checksum is the one kept as the
+// records were written, so sealing doesn't read the segment back, unless
+// it's one this writer didn't write all of, such as one reopened after a
+// restart. Then it's read back, and isn't sealed if it's been damaged.
+func (s *SegmentWriter) seal(ctx context.Context, fs FS) error {
+	sum := s.sealSum
+
+	if sum == nil {
+		var err error
+
+		sum, err = s.readSum(fs)
+		if err != nil {
+			return err
+		}
+	}
+
+	body := make([]byte, sealSize)
+
+	binary.BigEndian.PutUint64(body[:8], uint64(s.Size()))
+	binary.BigEndian.PutUint32(body[8:], sum.Sum32())
+
+	err := s.writeRecord(sealType, body)
+	if err != nil {
+		return err
+	}
+
+	return s.syncWrite(ctx)
+}
+
+// readSum checksums the segment's records by reading them back.
+func (s *SegmentWriter) readSum(fs FS) (hash.Hash32, error) {
+	sr, err := NewSegmentReaderWithOptions(s.f.Name(), ReadOptions{FS: fs})
+	if err != nil {
+		return nil, err
+	}
+
+	defer sr.Close()
+
+	for {
+		_, ok := sr.nextRecord()
+		if !ok {
+			break
+		}
+	}
+
+	err = sr.Error()
+	if err != nil {
+		return nil, err
+	}
+
+	if sr.Pos() != s.Size() {
+		return nil, fmt.Errorf("%w: segment has %d bytes of records, expected %d", ErrSealBroken, sr.Pos(), s.Size())
+	}
+
+	return sr.sealSum, nil
+}
+
+// maxSealRecord is the most a seal record takes up: its header, the
+// length of its body, and the body as compressed at its worst.
+var maxSealRecord = 5 + binary.MaxVarintLen64 + snappy.MaxEncodedLen(sealSize)
+
+// lastSealed reports whether the segment at path ends with its seal,
+// such as when a crash stopped a rotation between sealing it and
+// starting the next one. Only the end of the segment is read: the seal
+// is looked for at each offset it could start at, and only taken as one
+// if its CRC matches and it records that offset as its own.
+func lastSealed(fs FS, path string) (bool, error) {
+	f, err := fs.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return false, err
+	}
+
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	start := fi.Size() - int64(maxSealRecord+len(closingMagic))
+	if start < 0 {
+		start = 0
+	}
+
+	_, err = f.Seek(start, io.SeekStart)
+	if err != nil {
+		return false, err
+	}
+
+	tail := make([]byte, fi.Size()-start)
+
+	_, err = io.ReadFull(f, tail)
+	if err != nil {
+		return false, err
+	}
+
+	tail = bytes.TrimSuffix(tail, closingMagic)
+
+	for i := 0; i+5 < len(tail); i++ {
+		rec := tail[i:]
+
+		if rec[4]&^rawFlag != sealType {
+			continue
+		}
+
+		cnt, n := binary.Uvarint(rec[5:])
+		if n <= 0 || uint64(len(rec)-5-n) != cnt {
+			continue
+		}
+
+		if crc32.ChecksumIEEE(rec[5:]) != binary.BigEndian.Uint32(rec[:4]) {
+			continue
+		}
+
+		body := rec[5+n:]
+		if rec[4]&rawFlag == 0 {
+			body, err = snappy.Decode(nil, body)
+			if err != nil {
+				continue
+			}
+		}
+
+		if len(body) == sealSize && int64(binary.BigEndian.Uint64(body[:8])) == start+int64(i) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// chmoder is implemented by filesystems that can change a file's
+// permissions.
+type chmoder interface {
+	Chmod(name string, mode os.FileMode) error
+}
+
+func (osFS) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+// sealSegment seals the active segment before it's rotated out, if the
+// WAL seals its segments. A segment that can't be sealed, such as one
+// that's been damaged, is left unsealed and the failure logged, rather
+// than failing every write from then on.
+func (wal *WALWriter) sealSegment() {
+	if !wal.opts.SealSegments {
+		return
+	}
+
+	err := wal.segment.seal(context.Background(), wal.fs)
+	if err != nil {
+		wal.logger.Error("failed to seal segment", "segment", wal.index, "error", err)
+	}
+}
+
+// protectSegment makes a sealed segment read-only, if the WAL is set to.
+// Failing to is logged rather than failing the rotation.
+func (wal *WALWriter) protectSegment(path string) {
+	if !wal.opts.SealSegments || !wal.opts.SealReadOnly {
+		return
+	}
+
+	c, ok := wal.fs.(chmoder)
+	if !ok {
+		return
+	}
+
+	err := c.Chmod(path, 0444)
+	if err != nil {
+		wal.logger.Warn("failed to make segment read-only", "path", path, "error", err)
+	}
+}
@@ -0,0 +1,336 @@
+package wal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestSealing(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	entry := func(i int) []byte {
+		return bytes.Repeat([]byte(fmt.Sprintf("entry%d ", i)), 20)
+	}
+
+	write := func(opts ...Option) {
+		opts = append([]Option{WithSegmentSize(1024), WithMaxSegments(100), WithCompression(false)}, opts...)
+
+		wal, err := New(path, opts...)
+		require.NoError(t, err)
+
+		for i := 0; i < 20; i++ {
+			require.NoError(t, wal.Write(entry(i)))
+		}
+
+		require.NoError(t, wal.Close())
+	}
+
+	read := func() ([][]byte, error) {
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		var values [][]byte
+
+		for r.Next() {
+			values = append(values, append([]byte(nil), r.Value()...))
+		}
+
+		return values, r.Error()
+	}
+
+	segment := filepath.Join(path, "0")
+
+	// record returns the bytes of the record for value, as written to a
+	// segment.
+	record := func(value []byte) []byte {
+		tmp := filepath.Join(dir, "record")
+		defer os.Remove(tmp)
+
+		sw, err := NewSegmentWriter(tmp)
+		require.NoError(t, err)
+
+		sw.noMagic = true
+		sw.noCompress = true
+
+		require.NoError(t, sw.writeRecord(dataType, value))
+		require.NoError(t, sw.Close())
+
+		data, err := ioutil.ReadFile(tmp)
+		require.NoError(t, err)
+
+		return data
+	}
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	n.It("seals rotated segments with a checksum readers check", func() {
+		write(WithSealing(false))
+
+		values, err := read()
+		require.NoError(t, err)
+
+		require.Len(t, values, 20)
+
+		for i, v := range values {
+			assert.Equal(t, entry(i), v)
+		}
+
+		report, err := Verify(path)
+		require.NoError(t, err)
+
+		require.True(t, report.OK)
+		assert.Equal(t, 20, report.Entries)
+
+		last := report.Segments[len(report.Segments)-1]
+
+		for _, seg := range report.Segments {
+			assert.True(t, seg.Sealed)
+			assert.Equal(t, seg.Index != last.Index, seg.Checksummed, "segment %d", seg.Index)
+		}
+	})
+
+	n.It("doesn't seal segments by default", func() {
+		write()
+
+		report, err := Verify(path)
+		require.NoError(t, err)
+
+		require.True(t, report.OK)
+
+		for _, seg := range report.Segments {
+			assert.False(t, seg.Checksummed)
+		}
+	})
+
+	n.It("detects a record replaced with a valid one", func() {
+		write(WithSealing(false))
+
+		data, err := ioutil.ReadFile(segment)
+		require.NoError(t, err)
+
+		orig, forged := record(entry(0)), record(entry(9))
+		require.Equal(t, len(orig), len(forged))
+		require.True(t, bytes.HasPrefix(data, orig))
+
+		copy(data, forged)
+
+		require.NoError(t, ioutil.WriteFile(segment, data, 0644))
+
+		_, err = read()
+		require.Error(t, err)
+
+		assert.True(t, errors.Is(err, ErrSealBroken))
+		assert.True(t, errors.Is(err, ErrCorrupt))
+
+		report, err := Verify(path)
+		require.NoError(t, err)
+
+		assert.False(t, report.OK)
+		assert.True(t, errors.Is(report.Segments[0].err, ErrSealBroken))
+	})
+
+	n.It("detects a record added to a sealed segment", func() {
+		write(WithSealing(false))
+
+		data, err := ioutil.ReadFile(segment)
+		require.NoError(t, err)
+
+		data = append(record(entry(20)), data...)
+
+		require.NoError(t, ioutil.WriteFile(segment, data, 0644))
+
+		_, err = read()
+		assert.True(t, errors.Is(err, ErrSealBroken))
+	})
+
+	n.It("detects a record added after the seal", func() {
+		write(WithSealing(false))
+
+		data, err := ioutil.ReadFile(segment)
+		require.NoError(t, err)
+
+		data = append(data[:len(data)-len(closingMagic)], record(entry(20))...)
+		data = append(data, closingMagic...)
+
+		require.NoError(t, ioutil.WriteFile(segment, data, 0644))
+
+		_, err = read()
+		assert.True(t, errors.Is(err, ErrSealBroken))
+	})
+
+	n.It("doesn't check the seal after seeking into a segment", func() {
+		write(WithSealing(false))
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.True(t, r.Next())
+
+		pos, err := r.Pos()
+		require.NoError(t, err)
+
+		require.NoError(t, r.Seek(pos))
+
+		count := 1
+
+		for r.Next() {
+			count++
+		}
+
+		require.NoError(t, r.Error())
+		assert.Equal(t, 20, count)
+	})
+
+	n.It("keeps the checksum as records are written", func() {
+		require.NoError(t, os.MkdirAll(path, 0755))
+
+		sw, err := NewSegmentWriter(segment)
+		require.NoError(t, err)
+
+		sw.SetBlockSize(40)
+
+		var first int64
+
+		for i := 0; i < 5; i++ {
+			_, err = sw.Write(entry(i))
+			require.NoError(t, err)
+
+			if i == 0 {
+				first = sw.Pos()
+			}
+		}
+
+		require.NoError(t, sw.WriteTag([]byte("tag")))
+		require.NotNil(t, sw.sealSum)
+
+		sum, err := sw.readSum(OSFS)
+		require.NoError(t, err)
+
+		assert.Equal(t, sum.Sum32(), sw.sealSum.Sum32())
+
+		// Truncating within the records loses the checksum, so sealing
+		// reads them back.
+		require.NoError(t, sw.truncateTo(first))
+		assert.Nil(t, sw.sealSum)
+
+		_, err = sw.Write(entry(5))
+		require.NoError(t, err)
+
+		require.NoError(t, sw.seal(context.Background(), OSFS))
+		require.NoError(t, sw.Close())
+
+		rep := verifySegment(segment, 0)
+		require.NoError(t, rep.err)
+
+		assert.True(t, rep.Checksummed)
+		assert.Equal(t, 2, rep.Entries)
+	})
+
+	n.It("makes sealed segments read-only", func() {
+		write(WithSealing(true))
+
+		fi, err := os.Stat(segment)
+		require.NoError(t, err)
+
+		assert.Equal(t, os.FileMode(0444), fi.Mode().Perm())
+	})
+
+	n.It("starts a new segment when the newest one is already sealed", func() {
+		wal, err := New(path, WithSealing(true))
+		require.NoError(t, err)
+
+		for i := 0; i < 3; i++ {
+			require.NoError(t, wal.Write(entry(i)))
+		}
+
+		// Crash part way through rotating, once the segment is sealed
+		// but before the next one is created.
+		wal.sealSegment()
+		require.NoError(t, wal.segment.Close())
+		wal.protectSegment(wal.current)
+		wal.lockf.Close()
+
+		wal, err = New(path, WithSealing(true))
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, wal.index)
+
+		for i := 3; i < 5; i++ {
+			require.NoError(t, wal.Write(entry(i)))
+		}
+
+		require.NoError(t, wal.Close())
+
+		values, err := read()
+		require.NoError(t, err)
+
+		require.Len(t, values, 5)
+
+		for i, v := range values {
+			assert.Equal(t, entry(i), v)
+		}
+
+		rep := verifySegment(segment, 0)
+		require.NoError(t, rep.err)
+
+		assert.True(t, rep.Checksummed)
+	})
+
+	n.It("keeps compacted and split segments readable", func() {
+		write(WithSealing(false))
+
+		stats, err := Compact(path, func(_ Position, value []byte) bool {
+			return !bytes.Equal(value, entry(0))
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, stats.Entries)
+
+		report, err := Verify(path)
+		require.NoError(t, err)
+
+		require.True(t, report.OK)
+		assert.True(t, report.Segments[0].Checksummed)
+
+		split, err := SplitSegment(path, 0, 30)
+		require.NoError(t, err)
+
+		require.True(t, len(split.Starts) > 1)
+
+		values, err := read()
+		require.NoError(t, err)
+
+		require.Len(t, values, 19)
+		assert.Equal(t, entry(1), values[0])
+
+		report, err = Verify(path)
+		require.NoError(t, err)
+
+		assert.True(t, report.OK)
+	})
+
+	n.Meow()
+}
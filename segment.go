@@ -3,11 +3,14 @@ package wal
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"hash"
 	"hash/crc32"
 	"io"
+	"log/slog"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -19,7 +22,7 @@ import (
 )
 
 type SegmentWriter struct {
-	f     *os.File
+	f     File
 	buf   []byte
 	sbuf  []byte
 	clean bool
@@ -28,23 +31,109 @@ type SegmentWriter struct {
 
 	cs hash.Hash32
 
+	// The checksum of the records written so far, which the segment is
+	// sealed with, or nil if it isn't known because the segment already
+	// had records when it was opened or has been truncated.
+	sealSum hash.Hash32
+
+	// The block checksums of everything written, if they're being kept
+	// and are known, as with sealSum.
+	blockSums *blockSummer
+
 	t        tomb.Tomb
 	syncRate time.Duration
 	bgSync   bool
+
+	policy     BufferPolicy
+	blockSize  int
+	noCompress bool
+	noMagic    bool
+
+	// If set, the buffer used to compress a record is taken from pool
+	// rather than kept by the segment, so many WALs can share buffers.
+	pool *sync.Pool
+
+	// The size of the segment when a Manager last synced it.
+	lastSynced int64
+
+	// The size of the segment when it was last synced by anything, and
+	// how far past that writes may get before they sync it themselves
+	// rather than leave it to the background. Accessed atomically.
+	synced      int64
+	maxUnsynced int64
+
+	metrics MetricsSink
+	tracer  Tracer
+	logger  *slog.Logger
+
+	syncs *syncStats
+
+	// How well the segment's records compressed, and the same for the
+	// WAL as a whole, which is shared by its segments.
+	compression      CompressionStats
+	totalCompression *CompressionStats
+}
+
+// syncStats records the last successful sync, whether the most recent
+// one failed, and how long they all took. It's shared by the segments
+// of a WAL so it survives rotation, and locked since syncs can happen
+// in the background.
+type syncStats struct {
+	lock     sync.Mutex
+	last     time.Time
+	duration time.Duration
+	err      error
+
+	// The latency histogram, and how many syncs took at least
+	// slowThreshold, which onSlow is called with.
+	counts        []int64
+	slow          int64
+	slowThreshold time.Duration
+	onSlow        func(SlowSync)
+
+	// How many writes synced because too much was left unsynced.
+	escalated int64
+}
+
+// record adds a sync, reporting whether it was slow.
+func (s *syncStats) record(end time.Time, dur time.Duration, err error) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.err = err
+
+	if err == nil {
+		s.last = end
+		s.duration = dur
+	}
+
+	return s.observe(dur)
+}
+
+func (s *syncStats) get() (time.Time, time.Duration, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.last, s.duration, s.err
 }
 
 const bufferSize = 16 * 1024
 
-func createSegment(f *os.File) (*SegmentWriter, error) {
+func createSegment(f File) (*SegmentWriter, error) {
 	buf := make([]byte, bufferSize)
 	sbuf := make([]byte, 32)
 
 	seg := &SegmentWriter{
-		f:    f,
-		buf:  buf,
-		sbuf: sbuf,
-		cs:   crc32.NewIEEE(),
-		size: new(int64),
+		f:       f,
+		buf:     buf,
+		sbuf:    sbuf,
+		cs:      crc32.NewIEEE(),
+		size:    new(int64),
+		policy:  DefaultBufferPolicy,
+		metrics: NopMetrics{},
+		tracer:  nopTracer{},
+		logger:  discardLogger,
+		syncs:   &syncStats{},
 	}
 
 	err := seg.calculateClean()
@@ -53,12 +142,21 @@ func createSegment(f *os.File) (*SegmentWriter, error) {
 	}
 
 	*seg.size = seg.diskPos()
+	seg.synced = *seg.size
+
+	if *seg.size == 0 {
+		seg.sealSum = crc32.NewIEEE()
+	}
 
 	return seg, nil
 }
 
 func NewSegmentWriter(path string) (*SegmentWriter, error) {
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	return newSegmentWriter(OSFS, path)
+}
+
+func newSegmentWriter(fs FS, path string) (*SegmentWriter, error) {
+	f, err := fs.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
 	if err != nil {
 		return nil, err
 	}
@@ -72,6 +170,13 @@ func (s *SegmentWriter) SetSyncRate(dur time.Duration) {
 	s.t.Go(s.syncEvery)
 }
 
+// SetBufferPolicy controls how the buffer used to compress entries
+// grows and shrinks.
+func (s *SegmentWriter) SetBufferPolicy(p BufferPolicy) {
+	s.policy = p
+	s.buf = make([]byte, p.initialSize())
+}
+
 func (s *SegmentWriter) syncEvery() error {
 	tick := time.NewTicker(s.syncRate)
 	defer tick.Stop()
@@ -84,12 +189,18 @@ func (s *SegmentWriter) syncEvery() error {
 			cur := atomic.LoadInt64(s.size)
 
 			if cur != before {
-				s.f.Sync()
+				err := s.sync(context.Background())
+				if err != nil {
+					s.logger.Error("background sync failed", "path", s.f.Name(), "error", err)
+				}
 			}
 
 			before = cur
 		case <-s.t.Dying():
-			s.f.Sync()
+			err := s.sync(context.Background())
+			if err != nil {
+				s.logger.Error("background sync failed", "path", s.f.Name(), "error", err)
+			}
 			return nil
 		}
 	}
@@ -97,17 +208,39 @@ func (s *SegmentWriter) syncEvery() error {
 	return nil
 }
 
+// syncChanged syncs the segment if it has been written to since it was
+// last synced this way.
+func (s *SegmentWriter) syncChanged(ctx context.Context) error {
+	cur := atomic.LoadInt64(s.size)
+	if cur == s.lastSynced {
+		return nil
+	}
+
+	s.lastSynced = cur
+
+	return s.sync(ctx)
+}
+
 var closingMagic = []byte("\x00this segment was closed properly\x42")
 
 func (s *SegmentWriter) Close() error {
-	if s.bgSync {
+	switch {
+	case s.syncRate > 0:
 		s.t.Kill(nil)
 		s.t.Wait()
+	case s.bgSync:
+		// Synced by a Manager, so sync what it hasn't got to yet.
+		err := s.syncChanged(context.Background())
+		if err != nil {
+			s.logger.Error("background sync failed", "path", s.f.Name(), "error", err)
+		}
 	}
 
-	_, err := s.f.Write(closingMagic)
-	if err != nil {
-		return err
+	if !s.noMagic {
+		err := s.write(closingMagic)
+		if err != nil {
+			return err
+		}
 	}
 
 	return s.f.Close()
@@ -130,8 +263,11 @@ func (s *SegmentWriter) calculateClean() error {
 	offset := int64(-len(closingMagic))
 	_, err = s.f.Seek(offset, os.SEEK_END)
 	if err != nil {
-		s.f.Seek(0, os.SEEK_SET)
-		return nil
+		// The file is too short to hold the magic, so it wasn't closed
+		// cleanly. Continue writing after what's there rather than over
+		// it.
+		_, err = s.f.Seek(0, os.SEEK_END)
+		return err
 	}
 
 	_, err = io.ReadFull(s.f, s.buf[:len(closingMagic)])
@@ -144,8 +280,16 @@ func (s *SegmentWriter) calculateClean() error {
 	s.clean = bytes.Equal(s.buf[:len(closingMagic)], closingMagic)
 
 	if s.clean {
-		// Ok, we're clean. Seek to just before the magic so we overwrite it
-		_, err := s.f.Seek(offset, os.SEEK_END)
+		// Ok, we're clean. Drop the magic so that new entries replace it
+		// rather than leaving the rest of it after them.
+		end := fi.Size() + offset
+
+		err := s.f.Truncate(end)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.f.Seek(end, os.SEEK_SET)
 		return err
 	} else {
 		// Leave seeked to the end so we continue writing
@@ -157,51 +301,221 @@ func (s *SegmentWriter) calculateClean() error {
 const (
 	dataType = 'd'
 	tagType  = 't'
+
+	// An entry of a named stream, whose body starts with the stream's
+	// name. See WALWriter.Stream.
+	streamType = 's'
+
+	// A tag of a named stream, framed like a stream entry.
+	streamTagType = 'T'
+
+	// A tag carrying a payload, framed like a stream entry with the tag
+	// in place of the stream's name. See WALWriter.WriteTagPayload.
+	payloadTagType = 'p'
+
+	// Several tags written as one record, each framed by its length as a
+	// uvarint. See WALWriter.WriteTags.
+	barrierType = 'B'
+
+	// A data entry whose body starts with where it was first written.
+	// See WALWriter.WriteOrigin.
+	originType = 'o'
+
+	// A data entry whose body starts with when it expires, in Unix
+	// nanoseconds, big endian. See WALWriter.WriteTTL.
+	expiringType = 'e'
+
+	// The last record of a sealed segment, holding a checksum of the
+	// records before it. See WriteOptions.SealSegments.
+	sealType = 'S'
+
+	// A block of a larger entry. The entry's final block is written with
+	// the entry's own type.
+	blockType = 'b'
+
+	// Set in the type of a record whose body is stored uncompressed.
+	rawFlag = 0x80
 )
 
-func (s *SegmentWriter) writeType(t byte, data []byte) (int, error) {
-	out := snappy.Encode(s.buf, data)
+// SetBlockSize causes entries larger than n bytes to be compressed and
+// written in blocks of n bytes, so the memory needed to encode an entry
+// is bounded by n rather than the size of the entry. If n is 0, entries
+// are always written whole.
+func (s *SegmentWriter) SetBlockSize(n int) {
+	s.blockSize = n
+}
 
-	n := binary.PutUvarint(s.sbuf[5:], uint64(len(out)))
+// SetCompression controls whether entries are compressed. Segments can
+// mix compressed and uncompressed entries. Turning compression off saves
+// CPU and the buffer used to compress, at the cost of disk space.
+func (s *SegmentWriter) SetCompression(enabled bool) {
+	s.noCompress = !enabled
+}
 
-	s.cs.Reset()
-	s.cs.Write(s.sbuf[5 : 5+n])
-	s.cs.Write(out)
+func (s *SegmentWriter) writeType(ctx context.Context, t byte, data []byte) (int, error) {
+	total := len(data)
 
-	binary.BigEndian.PutUint32(s.sbuf[:4], s.cs.Sum32())
+	if s.blockSize > 0 {
+		for len(data) > s.blockSize {
+			err := s.writeRecord(blockType|t&rawFlag, data[:s.blockSize])
+			if err != nil {
+				return 0, err
+			}
 
-	s.sbuf[4] = t
+			data = data[s.blockSize:]
+		}
+	}
 
-	_, err := s.f.Write(s.sbuf[:5+n])
+	err := s.writeRecord(t, data)
 	if err != nil {
 		return 0, err
 	}
 
-	_, err = s.f.Write(out)
+	err = s.syncWrite(ctx)
 	if err != nil {
 		return 0, err
 	}
 
-	if !s.bgSync {
-		err = s.f.Sync()
+	return total, nil
+}
+
+// writeEncoded writes an entry that has already been encoded into
+// records.
+func (s *SegmentWriter) writeEncoded(ctx context.Context, recs []encodedRecord) error {
+	for _, rec := range recs {
+		err := s.writeEncodedRecord(rec)
 		if err != nil {
-			return 0, err
+			return err
 		}
 	}
 
-	entry := int64(5 + n + len(out))
+	return s.syncWrite(ctx)
+}
 
-	atomic.AddInt64(s.size, entry)
+func (s *SegmentWriter) syncWrite(ctx context.Context) error {
+	if s.bgSync && !s.overUnsynced() {
+		return nil
+	}
 
-	return len(data), nil
+	return s.sync(ctx)
+}
+
+func (s *SegmentWriter) sync(ctx context.Context) error {
+	_, span := s.tracer.Start(ctx, SpanSync)
+
+	size := atomic.LoadInt64(s.size)
+
+	start := time.Now()
+	err := s.f.Sync()
+	dur := time.Since(start)
+	s.metrics.Timing(MetricSyncLatency, dur)
+
+	if err == nil {
+		s.markSynced(size)
+	}
+
+	if s.syncs.record(start.Add(dur), dur, err) {
+		s.reportSlow(dur, err)
+	}
+
+	endSpan(span, err)
+
+	return err
+}
+
+// writeRecord writes data as a record of type t, compressing it unless
+// compression is off or t has rawFlag set.
+func (s *SegmentWriter) writeRecord(t byte, data []byte) error {
+	if s.noCompress || t&rawFlag != 0 {
+		return s.writeEncodedRecord(encodeRecord(s.cs, t|rawFlag, data, nil, s.sbuf))
+	}
+
+	if s.pool != nil {
+		bp := s.pool.Get().(*[]byte)
+		*bp = s.policy.ensure(*bp, snappy.MaxEncodedLen(len(data)))
+
+		err := s.writeEncodedRecord(encodeRecord(s.cs, t, data, *bp, s.sbuf))
+
+		*bp = s.policy.shrink(*bp)
+		s.pool.Put(bp)
+
+		return err
+	}
+
+	s.buf = s.policy.ensure(s.buf, snappy.MaxEncodedLen(len(data)))
+
+	// The record points into buf, so only release it once we're done
+	// writing.
+	defer func() {
+		s.buf = s.policy.shrink(s.buf)
+	}()
+
+	return s.writeEncodedRecord(encodeRecord(s.cs, t, data, s.buf, s.sbuf))
+}
+
+// write writes data to the segment, adding it to the block checksums.
+// Once a write fails, what's in the segment isn't known, so neither are
+// its checksums.
+func (s *SegmentWriter) write(data []byte) error {
+	_, err := s.f.Write(data)
+	if err != nil {
+		s.sealSum = nil
+		s.blockSums = nil
+
+		return err
+	}
+
+	if s.blockSums != nil {
+		s.blockSums.Write(data)
+	}
+
+	return nil
+}
+
+// keepBlockSums keeps the checksums of each block of size bytes as the
+// segment is written, if it's empty so far.
+func (s *SegmentWriter) keepBlockSums(size int64) {
+	if s.Size() == 0 {
+		s.blockSums = newBlockSummer(size)
+	}
+}
+
+func (s *SegmentWriter) writeEncodedRecord(rec encodedRecord) error {
+	err := s.write(rec.header)
+	if err != nil {
+		return err
+	}
+
+	err = s.write(rec.body)
+	if err != nil {
+		return err
+	}
+
+	atomic.AddInt64(s.size, rec.size())
+
+	s.sumRecord(rec)
+
+	if rec.raw > 0 {
+		raw, stored := int64(rec.raw), int64(len(rec.body))
+
+		s.compression.add(raw, stored)
+		if s.totalCompression != nil {
+			s.totalCompression.add(raw, stored)
+		}
+
+		s.metrics.IncrCounter(MetricUncompressedBytes, raw)
+		s.metrics.IncrCounter(MetricCompressedBytes, stored)
+	}
+
+	return nil
 }
 
 func (s *SegmentWriter) Write(data []byte) (int, error) {
-	return s.writeType(dataType, data)
+	return s.writeType(context.Background(), dataType, data)
 }
 
 func (s *SegmentWriter) WriteTag(data []byte) error {
-	_, err := s.writeType(tagType, data)
+	_, err := s.writeType(context.Background(), tagType, data)
 	return err
 }
 
@@ -219,9 +533,58 @@ func (s *SegmentWriter) Pos() int64 {
 }
 
 func (s *SegmentWriter) Truncate(pos int64) error {
+	s.resetSum(pos)
+
 	return s.f.Truncate(pos)
 }
 
+// SetClosingMagic controls whether Close marks the segment as cleanly
+// closed by writing the closing magic after its last entry.
+func (s *SegmentWriter) SetClosingMagic(enabled bool) {
+	s.noMagic = !enabled
+}
+
+// writeClosingMagic writes the closing magic now instead of on Close, so
+// that it can be synced along with the records before the segment is
+// renamed into place.
+func (s *SegmentWriter) writeClosingMagic() error {
+	if s.noMagic {
+		return nil
+	}
+
+	err := s.write(closingMagic)
+	if err != nil {
+		return err
+	}
+
+	s.noMagic = true
+
+	return nil
+}
+
+// truncateTo drops everything after pos and continues writing there.
+func (s *SegmentWriter) truncateTo(pos int64) error {
+	err := s.f.Truncate(pos)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.f.Seek(pos, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	atomic.StoreInt64(s.size, pos)
+
+	s.resetSum(pos)
+
+	if atomic.LoadInt64(&s.synced) > pos {
+		atomic.StoreInt64(&s.synced, pos)
+	}
+
+	return nil
+}
+
 func (s *SegmentWriter) Clean() bool {
 	return s.clean
 }
@@ -246,7 +609,9 @@ func (hr *hashReader) ReadByte() (byte, error) {
 
 	hr.counter++
 
-	hr.h.Write([]byte{b})
+	if hr.h != nil {
+		hr.h.Write([]byte{b})
+	}
 
 	return b, nil
 }
@@ -259,119 +624,502 @@ func (hr *hashReader) Read(b []byte) (int, error) {
 
 	hr.counter += int64(n)
 
-	hr.h.Write(b[:n])
+	if hr.h != nil {
+		hr.h.Write(b[:n])
+	}
 
 	return n, nil
 }
 
+// boundedFile reads from f but reports io.EOF once limit is reached, so
+// that a reader never observes an entry that is still being written.
+// A negative limit means the whole file is readable.
+type boundedFile struct {
+	f     File
+	off   int64
+	limit int64
+}
+
+func (bf *boundedFile) Read(b []byte) (int, error) {
+	if limit := atomic.LoadInt64(&bf.limit); limit >= 0 {
+		max := limit - bf.off
+		if max <= 0 {
+			return 0, io.EOF
+		}
+
+		if int64(len(b)) > max {
+			b = b[:max]
+		}
+	}
+
+	n, err := bf.f.Read(b)
+	bf.off += int64(n)
+
+	return n, err
+}
+
 type SegmentReader struct {
-	f    *os.File
+	f    File
+	bf   boundedFile
 	r    *bufio.Reader
 	buf  []byte
 	buf2 []byte
 
 	value    []byte
 	valueCRC uint32
+	header   RecordHeader
+
+	// The decompressed leading blocks of the current entry.
+	blocks []byte
+
+	// If set, Next leaves the blocks of a data entry written in blocks
+	// for ValueReader to decode as they're read. deferred is the entry it
+	// left, and vr the reader ValueReader last returned, which is closed
+	// when the reader moves on. See ReadOptions.StreamValues.
+	streamValues bool
+	fs           FS
+	deferred     *segmentEntry
+	vr           *blockValueReader
+
+	// pos is the position after the last entry returned to the caller
+	// and readPos is the position after the last entry read from the
+	// file. They differ only while prefetching.
+	pos     int64
+	readPos int64
 
-	pos int64
 	err error
 	cs  hash.Hash32
 	hr  hashReader
+
+	policy    BufferPolicy
+	dropCache bool
+
+	budget *MemoryBudget
+	held   int64
+
+	// The bytes of the current value that were read ahead, which count
+	// against the budget until the next entry replaces it.
+	prefetched int64
+
+	skipCRC bool
+
+	prefetch int
+	pf       *prefetcher
+
+	// The index of the segment, from its name, or -1.
+	index int
+
+	// If set, only the entries of this stream are returned.
+	stream []byte
+
+	// If set, tags are returned along with entries.
+	tags bool
+
+	// The name and payload of the tag SeekTag last found.
+	tagName    []byte
+	tagPayload []byte
+
+	// A checksum of the records read since the start of the segment, to
+	// check its seal against. Nil if the reader has seeked past the
+	// start, since the records before aren't known.
+	sealSum hash.Hash32
+
+	// Whether the segment's seal has been read, and whether it was
+	// checked and matched.
+	pastSeal    bool
+	sealChecked bool
 }
 
 func NewSegmentReader(path string) (*SegmentReader, error) {
-	f, err := os.Open(path)
+	return NewSegmentReaderWithOptions(path, DefaultReadOptions)
+}
+
+func NewSegmentReaderWithOptions(path string, opts ReadOptions) (*SegmentReader, error) {
+	f, err := fsOrOS(opts.FS).OpenFile(path, os.O_RDONLY, 0)
 	if err != nil {
 		return nil, err
 	}
 
-	r := bufio.NewReader(f)
-	buf := make([]byte, bufferSize)
-	buf2 := make([]byte, bufferSize)
+	policy := opts.bufferPolicy()
+
+	buf := make([]byte, policy.initialSize())
+	buf2 := make([]byte, policy.initialSize())
 	sr := &SegmentReader{
-		f:    f,
-		r:    r,
-		buf:  buf,
-		buf2: buf2,
-		cs:   crc32.NewIEEE(),
+		f:         f,
+		bf:        boundedFile{f: f, limit: -1},
+		buf:       buf,
+		buf2:      buf2,
+		cs:        crc32.NewIEEE(),
+		policy:    policy,
+		dropCache: opts.DropPageCache,
+		budget:    opts.Budget,
+		prefetch:  opts.Prefetch,
+
+		streamValues: opts.StreamValues,
+		fs:           fsOrOS(opts.FS),
+
+		index: segmentIndexOf(path),
 	}
 
-	sr.hr.h = sr.cs
-	sr.hr.r = r
+	sr.skipCRC, err = opts.skipChecksums(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if sr.dropCache {
+		adviseSequential(f)
+	}
+
+	sr.r = bufio.NewReaderSize(&sr.bf, opts.bufferSize())
+
+	if !sr.skipCRC {
+		sr.hr.h = sr.cs
+		sr.sealSum = crc32.NewIEEE()
+	}
+
+	sr.hr.r = sr.r
+
+	sr.account()
 
 	return sr, nil
 }
 
+// SetBufferPolicy controls how the buffers used to read and decompress
+// entries grow and shrink.
+func (r *SegmentReader) SetBufferPolicy(p BufferPolicy) {
+	r.stopPrefetch()
+
+	r.policy = p
+	r.buf = make([]byte, p.initialSize())
+	r.buf2 = make([]byte, p.initialSize())
+	r.account()
+}
+
+// account updates the budget with the current size of the reader's
+// buffers.
+func (r *SegmentReader) account() {
+	held := int64(r.r.Size() + len(r.buf) + len(r.buf2) + cap(r.blocks))
+	r.budget.adjust(held - r.held)
+	r.held = held
+}
+
+// releaseBuffers shrinks any buffers that grew past the policy for the
+// previous entry, or past their initial size if the budget is exhausted.
+func (r *SegmentReader) releaseBuffers() {
+	policy := r.policy
+	if r.budget.exceeded() {
+		policy.MaxSize = policy.initialSize()
+	}
+
+	r.buf = policy.shrink(r.buf)
+	r.buf2 = policy.shrink(r.buf2)
+
+	if policy.MaxSize > 0 && cap(r.blocks) > policy.MaxSize {
+		r.blocks = nil
+	} else {
+		r.blocks = r.blocks[:0]
+	}
+
+	r.account()
+}
+
 func (r *SegmentReader) Close() error {
+	r.stopPrefetch()
+	r.releaseValue()
+
+	r.budget.adjust(-r.held)
+	r.held = 0
+
+	if r.dropCache {
+		adviseDontNeed(r.f)
+	}
+
 	return r.f.Close()
 }
 
 func (r *SegmentReader) Seek(pos int64) error {
+	r.stopPrefetch()
+
 	_, err := r.f.Seek(pos, os.SEEK_SET)
 	if err != nil {
 		return err
 	}
 
 	r.pos = pos
+	r.readPos = pos
+	r.bf.off = pos
 
-	r.r.Reset(r.f)
+	r.sealSum = nil
+	r.pastSeal = false
+	r.sealChecked = false
+
+	if pos == 0 && !r.skipCRC {
+		r.sealSum = crc32.NewIEEE()
+	}
+
+	r.r.Reset(&r.bf)
 
 	return nil
 }
 
+// setLimit prevents the reader from reading past offset n. A negative
+// n removes the limit.
+func (r *SegmentReader) setLimit(n int64) {
+	atomic.StoreInt64(&r.bf.limit, n)
+}
+
 func (s *SegmentReader) Pos() int64 {
 	return s.pos
 }
 
 func (r *SegmentReader) SeekTag(tag []byte) (int64, error) {
-	r.err = nil
+	return r.seekTag(func(t []byte) bool {
+		return bytes.Equal(t, tag)
+	})
+}
 
+// seekTag seeks to the last tag in the segment that match accepts,
+// returning its offset, or -1 if there's none.
+func (r *SegmentReader) seekTag(match func(tag []byte) bool) (int64, error) {
 	var lastPos int64 = -1
 
+	err := r.eachTag(func(pos int64, tag, payload []byte) {
+		if match(tag) {
+			lastPos = pos
+			r.tagName = append([]byte(nil), tag...)
+			r.tagPayload = append([]byte(nil), payload...)
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if lastPos != -1 {
+		err := r.Seek(lastPos)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return lastPos, nil
+}
+
+// eachTag reads the rest of the segment, calling fn with where each tag
+// SeekTag looks for starts, its name, and its payload, if any. The
+// slices are only valid until fn returns.
+func (r *SegmentReader) eachTag(fn func(pos int64, tag, payload []byte)) error {
+	r.stopPrefetch()
+
+	r.err = nil
+
 	for {
-		pos := r.pos
-		ent, err := r.readNext()
+		pos := r.readPos
+		ent, err := r.readEntry()
+		r.pos = r.readPos
 		if err != nil {
 			if err == io.EOF || err == io.ErrUnexpectedEOF {
-				break
+				return nil
 			}
 
 			r.err = err
 
-			return 0, err
+			return err
 		}
 
-		if ent.entryType == tagType {
-			plain, err := snappy.Decode(r.buf2, ent.value)
+		if !r.seeksTag(ent.entryType) {
+			continue
+		}
+
+		plain, err := r.decodeEntry(ent)
+		if err != nil {
+			return err
+		}
+
+		if ent.entryType == barrierType {
+			tags, ok := splitBarrier(plain)
+			if !ok {
+				return r.corrupt(ent.offset, errMalformedBarrier)
+			}
+
+			for _, tag := range tags {
+				fn(pos, tag, nil)
+			}
+
+			continue
+		}
+
+		var payload []byte
+
+		if ent.entryType == payloadTagType {
+			var ok bool
+
+			plain, payload, ok = splitStreamEntry(plain)
+			if !ok {
+				return r.corrupt(ent.offset, errMalformedTagPayload)
+			}
+		}
+
+		if r.stream != nil {
+			var ok bool
+
+			plain, ok, err = r.streamValue(plain)
 			if err != nil {
-				return 0, err
+				return r.corrupt(ent.offset, err)
 			}
 
-			if bytes.Equal(plain, tag) {
-				lastPos = pos
+			if !ok {
+				continue
 			}
 		}
+
+		fn(pos, plain, payload)
 	}
+}
 
-	if lastPos != -1 {
-		err := r.Seek(lastPos)
+// entryBoundary returns the offset of the first entry in the segment f
+// that starts at or after pos, which may be the end of the segment's
+// entries, or -1 if there's none. Only the records' framing is read; their
+// CRCs aren't checked.
+func entryBoundary(f File, pos int64) (int64, error) {
+	_, err := f.Seek(0, io.SeekStart)
+	if err != nil {
+		return -1, err
+	}
+
+	br := bufio.NewReader(f)
+
+	var (
+		off   int64
+		start = true
+		hdr   [5]byte
+	)
+
+	for {
+		if start && off >= pos {
+			return off, nil
+		}
+
+		if magic, _ := br.Peek(len(closingMagic)); bytes.Equal(magic, closingMagic) {
+			return -1, nil
+		}
+
+		_, err = io.ReadFull(br, hdr[:])
 		if err != nil {
-			return 0, err
+			break
 		}
+
+		cr := &countingByteReader{r: br}
+
+		cnt, err := binary.ReadUvarint(cr)
+		if err != nil {
+			break
+		}
+
+		n, err := br.Discard(int(cnt))
+		if err != nil || uint64(n) != cnt {
+			break
+		}
+
+		// An entry written in blocks starts at its first block.
+		start = hdr[4]&^rawFlag != blockType
+		off += int64(len(hdr)) + cr.n + int64(cnt)
 	}
 
-	return lastPos, nil
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return -1, nil
+	}
+
+	return -1, err
+}
+
+// countingByteReader counts the bytes read through it.
+type countingByteReader struct {
+	r io.ByteReader
+	n int64
+}
+
+func (c *countingByteReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+
+	return b, err
 }
 
 var ErrCorruptCRC = errors.New("corrupt data detected")
 
 type segmentEntry struct {
 	entryType byte
+	raw       bool
+	offset    int64
 	value     []byte
 	crc       uint32
+
+	// Where the entry starts, the stored size of its body and how many
+	// records it was written as, counting any leading blocks.
+	start  int64
+	length int64
+	blocks int
 }
 
+func (e *segmentEntry) header() RecordHeader {
+	return RecordHeader{
+		Type:       RecordType(e.entryType),
+		Offset:     e.start,
+		Length:     e.length,
+		CRC:        e.crc,
+		Compressed: !e.raw,
+		Blocks:     e.blocks,
+	}
+}
+
+// readNext reads the next record, checking and skipping over the
+// segment's seal.
 func (r *SegmentReader) readNext() (e segmentEntry, err error) {
+	for {
+		e, err = r.readRecord()
+		if err != nil {
+			return
+		}
+
+		if r.pastSeal {
+			// Nothing but the closing magic may follow the seal.
+			err = r.corrupt(e.offset, ErrSealBroken)
+			return
+		}
+
+		if e.entryType != sealType {
+			r.sumRecord(e)
+			return
+		}
+
+		err = r.checkSeal(e)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (r *SegmentReader) readRecord() (e segmentEntry, err error) {
+	// A cleanly closed segment ends with the closing magic rather than
+	// another entry. Peek so that it's never consumed.
+	if magic, _ := r.r.Peek(len(closingMagic)); bytes.Equal(magic, closingMagic) {
+		// Drop what's buffered so the next read goes back to the file, in
+		// case the segment is reopened and the magic replaced by entries.
+		_, err = r.f.Seek(r.readPos, os.SEEK_SET)
+		if err != nil {
+			return
+		}
+
+		r.bf.off = r.readPos
+		r.r.Reset(&r.bf)
+
+		err = io.EOF
+		return
+	}
+
 	_, err = io.ReadFull(r.r, r.buf[:5])
 	if err != nil {
 		return
@@ -379,36 +1127,50 @@ func (r *SegmentReader) readNext() (e segmentEntry, err error) {
 
 	crc := binary.BigEndian.Uint32(r.buf[:4])
 
+	e.offset = r.readPos
+
 	e.entryType = r.buf[4]
 
+	if e.entryType&rawFlag != 0 {
+		e.entryType &^= rawFlag
+		e.raw = true
+	}
+
 	r.cs.Reset()
 
 	r.hr.counter = 0
 
+	// The header has been read, so running out of segment from here on
+	// leaves a torn record rather than a clean end.
 	cnt, err := binary.ReadUvarint(&r.hr)
 	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
 		return
 	}
 
-	if int(cnt) > len(r.buf) {
-		r.buf = make([]byte, cnt*2)
-	}
+	r.buf = r.policy.ensure(r.buf, int(cnt))
 
 	comp := r.buf[:cnt]
 
 	_, err = io.ReadFull(&r.hr, comp)
 	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
 		return
 	}
 
-	if r.cs.Sum32() != crc {
-		err = ErrCorruptCRC
+	if !r.skipCRC && r.cs.Sum32() != crc {
+		err = r.corrupt(e.offset, ErrCorruptCRC)
 		return
 	}
 
-	r.pos += (5 + r.hr.counter)
+	r.readPos += (5 + r.hr.counter)
 	e.crc = crc
 	e.value = comp
+	e.length = int64(cnt)
 
 	return
 }
@@ -416,39 +1178,236 @@ func (r *SegmentReader) readNext() (e segmentEntry, err error) {
 func (r *SegmentReader) Next() bool {
 	r.err = nil
 
+	if r.prefetch > 0 {
+		return r.nextPrefetched()
+	}
+
+	r.releaseValue()
+
 top:
-	ent, err := r.readNext()
+	ent, err := r.readEntryDeferring(r.streamValues)
+	r.pos = r.readPos
 	if err != nil {
 		if err != io.EOF {
 			r.err = err
 		}
 
+		// Nothing refers to the buffers now, so give back what they grew
+		// by rather than holding it while idle, such as when tailing.
+		r.releaseBuffers()
+
 		return false
 	}
 
-	if ent.entryType == tagType {
+	if !r.wanted(ent.entryType) {
 		goto top
 	}
 
-	r.value, err = snappy.Decode(r.buf2, ent.value)
+	switch {
+	case ent.blocks > 1 && r.streamValues:
+		r.deferValue(ent)
+
+		if ent.entryType == dataType {
+			return true
+		}
+
+		// The values of other entries are needed to finish reading
+		// them.
+		if r.Value(); r.err != nil {
+			return false
+		}
+	case !r.setValue(ent):
+		return false
+	}
+
+	if r.stream != nil {
+		value, ok, err := r.streamValue(r.value)
+		if err != nil {
+			r.err = r.corrupt(ent.offset, err)
+			return false
+		}
+
+		if !ok {
+			goto top
+		}
+
+		r.value = value
+	}
+
+	if ent.entryType == expiringType {
+		expires, value, err := splitExpiry(r.value)
+		if err != nil {
+			r.err = r.corrupt(ent.offset, err)
+			return false
+		}
+
+		r.value = value
+		r.header.Expires = expires
+	}
+
+	if ent.entryType == originType {
+		origin, value, err := entryOrigin(r.value, r.index, ent.start)
+		if err != nil {
+			r.err = r.corrupt(ent.offset, err)
+			return false
+		}
+
+		r.value = value
+		r.header.Origin = origin
+	}
+
+	return true
+}
+
+// nextRecord is like Next but also returns tags, along with the type of
+// the entry read.
+func (r *SegmentReader) nextRecord() (byte, bool) {
+	r.err = nil
+
+	ent, err := r.readEntry()
+	r.pos = r.readPos
+	if err != nil {
+		if err != io.EOF {
+			r.err = err
+		}
+
+		return 0, false
+	}
+
+	return ent.entryType, r.setValue(ent)
+}
+
+func (r *SegmentReader) setValue(ent segmentEntry) bool {
+	var err error
+
+	r.value, err = r.decodeEntry(ent)
 	if err != nil {
 		r.err = err
 		return false
 	}
 
 	r.valueCRC = ent.crc
+	r.header = ent.header()
 
 	return true
 }
 
+// readEntry reads the next entry, collecting any leading blocks it was
+// written in.
+func (r *SegmentReader) readEntry() (e segmentEntry, err error) {
+	return r.readEntryDeferring(false)
+}
+
+// readEntryDeferring is like readEntry, but if deferBlocks is set, the
+// leading blocks are only checked, not decoded.
+func (r *SegmentReader) readEntryDeferring(deferBlocks bool) (e segmentEntry, err error) {
+	r.releaseBuffers()
+
+	var (
+		start  int64 = -1
+		length int64
+		blocks int
+	)
+
+	for {
+		e, err = r.readNext()
+		if err != nil {
+			// The writer always finishes an entry, so running out of data
+			// part way through one means it's been cut short.
+			if err == io.EOF && len(r.blocks) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+
+			return
+		}
+
+		if start == -1 {
+			start = e.offset
+		}
+
+		length += e.length
+		blocks++
+
+		if e.entryType != blockType {
+			e.start, e.length, e.blocks = start, length, blocks
+			return
+		}
+
+		if deferBlocks {
+			continue
+		}
+
+		plain, err := r.decode(e)
+		if err != nil {
+			return e, err
+		}
+
+		r.blocks = append(r.blocks, plain...)
+	}
+}
+
+// decodeEntry returns the decompressed value of e, including any blocks
+// that preceded it.
+func (r *SegmentReader) decodeEntry(e segmentEntry) ([]byte, error) {
+	defer r.account()
+
+	plain, err := r.decode(e)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(r.blocks) == 0 {
+		return plain, nil
+	}
+
+	r.blocks = append(r.blocks, plain...)
+
+	return r.blocks, nil
+}
+
+func (r *SegmentReader) decode(e segmentEntry) ([]byte, error) {
+	if e.raw {
+		return e.value, nil
+	}
+
+	src := e.value
+
+	n, err := snappy.DecodedLen(src)
+	if err != nil {
+		return nil, r.corrupt(e.offset, err)
+	}
+
+	r.buf2 = r.policy.ensure(r.buf2, n)
+
+	plain, err := snappy.Decode(r.buf2, src)
+	if err != nil {
+		return nil, r.corrupt(e.offset, err)
+	}
+
+	return plain, nil
+}
+
+func (r *SegmentReader) corrupt(offset int64, err error) error {
+	return &CorruptError{Path: r.f.Name(), Offset: offset, Err: err}
+}
+
 func (r *SegmentReader) Error() error {
 	return r.err
 }
 
 func (r *SegmentReader) Value() []byte {
+	if r.deferred != nil && r.value == nil {
+		r.value, r.err = r.assembleValue()
+	}
+
 	return r.value
 }
 
 func (r *SegmentReader) CRC() uint32 {
 	return r.valueCRC
 }
+
+// Header describes how the current entry is stored in the segment.
+func (r *SegmentReader) Header() RecordHeader {
+	return r.header
+}
@@ -1,6 +1,7 @@
 package wal
 
 import (
+	"crypto/rand"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -217,5 +218,458 @@ func TestSegment(t *testing.T) {
 		assert.NotEqual(t, 0, r.CRC())
 	})
 
+	n.It("releases buffers that grow past the policy", func() {
+		segment, err := NewSegmentWriter(path)
+		require.NoError(t, err)
+
+		segment.SetBufferPolicy(BufferPolicy{InitialSize: 1024, MaxSize: 4096})
+
+		big := make([]byte, 32*1024)
+		_, err = rand.Read(big)
+		require.NoError(t, err)
+
+		_, err = segment.Write(big)
+		require.NoError(t, err)
+
+		assert.Equal(t, 1024, len(segment.buf))
+
+		_, err = segment.Write([]byte("small"))
+		require.NoError(t, err)
+
+		err = segment.Close()
+		require.NoError(t, err)
+
+		r, err := NewSegmentReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		r.SetBufferPolicy(BufferPolicy{InitialSize: 1024, MaxSize: 4096})
+
+		require.True(t, r.Next())
+
+		assert.Equal(t, big, r.Value())
+
+		require.True(t, r.Next())
+
+		assert.Equal(t, "small", string(r.Value()))
+
+		assert.Equal(t, 1024, len(r.buf))
+		assert.Equal(t, 1024, len(r.buf2))
+	})
+
+	n.It("can drop the page cache after reading", func() {
+		segment, err := NewSegmentWriter(path)
+		require.NoError(t, err)
+
+		_, err = segment.Write([]byte("test data"))
+		require.NoError(t, err)
+
+		err = segment.Close()
+		require.NoError(t, err)
+
+		ro := DefaultReadOptions
+		ro.DropPageCache = true
+
+		r, err := NewSegmentReaderWithOptions(path, ro)
+		require.NoError(t, err)
+
+		require.True(t, r.Next())
+
+		assert.Equal(t, "test data", string(r.Value()))
+
+		assert.False(t, r.Next())
+
+		require.NoError(t, r.Close())
+	})
+
+	n.It("writes large entries in blocks", func() {
+		segment, err := NewSegmentWriter(path)
+		require.NoError(t, err)
+
+		segment.SetBlockSize(1024)
+
+		big := make([]byte, 10*1024+10)
+		_, err = rand.Read(big)
+		require.NoError(t, err)
+
+		_, err = segment.Write(big)
+		require.NoError(t, err)
+
+		pos := segment.Pos()
+
+		err = segment.WriteTag(big)
+		require.NoError(t, err)
+
+		_, err = segment.Write([]byte("small"))
+		require.NoError(t, err)
+
+		err = segment.Close()
+		require.NoError(t, err)
+
+		r, err := NewSegmentReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.True(t, r.Next())
+
+		assert.Equal(t, big, r.Value())
+
+		tagPos, err := r.SeekTag(big)
+		require.NoError(t, err)
+
+		assert.Equal(t, pos, tagPos)
+
+		require.True(t, r.Next())
+
+		assert.Equal(t, "small", string(r.Value()))
+
+		assert.False(t, r.Next())
+		require.NoError(t, r.Error())
+	})
+
+	n.It("releases buffers when the memory budget is exceeded", func() {
+		segment, err := NewSegmentWriter(path)
+		require.NoError(t, err)
+
+		big := make([]byte, 128*1024)
+		_, err = rand.Read(big)
+		require.NoError(t, err)
+
+		_, err = segment.Write(big)
+		require.NoError(t, err)
+
+		_, err = segment.Write([]byte("small"))
+		require.NoError(t, err)
+
+		err = segment.Close()
+		require.NoError(t, err)
+
+		budget := NewMemoryBudget(64 * 1024)
+
+		ro := DefaultReadOptions
+		ro.BufferPolicy = BufferPolicy{InitialSize: 1024}
+		ro.Budget = budget
+
+		r, err := NewSegmentReaderWithOptions(path, ro)
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(bufferSize+2048), budget.Used())
+
+		require.True(t, r.Next())
+
+		assert.Equal(t, big, r.Value())
+
+		assert.True(t, budget.Used() > budget.Limit())
+
+		require.True(t, r.Next())
+
+		assert.Equal(t, "small", string(r.Value()))
+
+		assert.Equal(t, int64(bufferSize+2048), budget.Used())
+
+		require.NoError(t, r.Close())
+
+		assert.Equal(t, int64(0), budget.Used())
+	})
+
+	n.It("releases buffers at the end of the segment when the budget is exceeded", func() {
+		segment, err := NewSegmentWriter(path)
+		require.NoError(t, err)
+
+		big := make([]byte, 128*1024)
+		_, err = rand.Read(big)
+		require.NoError(t, err)
+
+		_, err = segment.Write(big)
+		require.NoError(t, err)
+
+		err = segment.Close()
+		require.NoError(t, err)
+
+		budget := NewMemoryBudget(64 * 1024)
+
+		ro := DefaultReadOptions
+		ro.BufferPolicy = BufferPolicy{InitialSize: 1024}
+		ro.Budget = budget
+
+		r, err := NewSegmentReaderWithOptions(path, ro)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.True(t, r.Next())
+
+		assert.True(t, budget.Used() > budget.Limit())
+
+		assert.False(t, r.Next())
+		require.NoError(t, r.Error())
+
+		assert.Equal(t, int64(bufferSize+2048), budget.Used())
+	})
+
+	n.It("counts entries read ahead against the memory budget", func() {
+		segment, err := NewSegmentWriter(path)
+		require.NoError(t, err)
+
+		big := make([]byte, 128*1024)
+		_, err = rand.Read(big)
+		require.NoError(t, err)
+
+		for i := 0; i < 3; i++ {
+			_, err = segment.Write(big)
+			require.NoError(t, err)
+		}
+
+		err = segment.Close()
+		require.NoError(t, err)
+
+		budget := NewMemoryBudget(64 * 1024)
+
+		ro := DefaultReadOptions
+		ro.BufferPolicy = BufferPolicy{InitialSize: 1024}
+		ro.Budget = budget
+		ro.Prefetch = 2
+
+		r, err := NewSegmentReaderWithOptions(path, ro)
+		require.NoError(t, err)
+
+		require.True(t, r.Next())
+		assert.Equal(t, big, r.Value())
+
+		assert.True(t, budget.Used() >= int64(len(big)))
+
+		require.True(t, r.Next())
+		require.True(t, r.Next())
+
+		assert.False(t, r.Next())
+		require.NoError(t, r.Error())
+
+		assert.Equal(t, int64(bufferSize+2048), budget.Used())
+
+		require.NoError(t, r.Close())
+
+		assert.Equal(t, int64(0), budget.Used())
+	})
+
+	n.It("can skip checksum verification", func() {
+		segment, err := NewSegmentWriter(path)
+		require.NoError(t, err)
+
+		_, err = segment.Write([]byte("test data"))
+		require.NoError(t, err)
+
+		err = segment.Close()
+		require.NoError(t, err)
+
+		f, err := os.OpenFile(path, os.O_RDWR, 0644)
+		require.NoError(t, err)
+
+		// Clobber the CRC
+		_, err = f.WriteAt([]byte{0, 0, 0, 0}, 0)
+		require.NoError(t, err)
+
+		f.Close()
+
+		r, err := NewSegmentReader(path)
+		require.NoError(t, err)
+
+		assert.False(t, r.Next())
+		assert.ErrorIs(t, r.Error(), ErrCorruptCRC)
+
+		r.Close()
+
+		ro := DefaultReadOptions
+		ro.SkipChecksums = true
+
+		r, err = NewSegmentReaderWithOptions(path, ro)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.True(t, r.Next())
+
+		assert.Equal(t, "test data", string(r.Value()))
+	})
+
+	n.It("can check only the checksums of closed segments", func() {
+		segment, err := NewSegmentWriter(path)
+		require.NoError(t, err)
+
+		_, err = segment.Write([]byte("test data"))
+		require.NoError(t, err)
+
+		err = segment.Close()
+		require.NoError(t, err)
+
+		f, err := os.OpenFile(path, os.O_RDWR, 0644)
+		require.NoError(t, err)
+
+		// Clobber the CRC
+		_, err = f.WriteAt([]byte{0, 0, 0, 0}, 0)
+		require.NoError(t, err)
+
+		ro := DefaultReadOptions
+		ro.Checksums = ChecksumSealed
+
+		r, err := NewSegmentReaderWithOptions(path, ro)
+		require.NoError(t, err)
+
+		assert.False(t, r.Next())
+		assert.ErrorIs(t, r.Error(), ErrCorruptCRC)
+
+		r.Close()
+
+		// Without the closing magic, the segment could still be being
+		// written, so it isn't checked.
+		fi, err := f.Stat()
+		require.NoError(t, err)
+
+		require.NoError(t, f.Truncate(fi.Size()-int64(len(closingMagic))))
+
+		f.Close()
+
+		r, err = NewSegmentReaderWithOptions(path, ro)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.True(t, r.Next())
+
+		assert.Equal(t, "test data", string(r.Value()))
+
+		ro.Checksums = ChecksumAlways
+
+		r2, err := NewSegmentReaderWithOptions(path, ro)
+		require.NoError(t, err)
+
+		defer r2.Close()
+
+		assert.False(t, r2.Next())
+		assert.ErrorIs(t, r2.Error(), ErrCorruptCRC)
+	})
+
+	n.It("can prefetch entries in the background", func() {
+		segment, err := NewSegmentWriter(path)
+		require.NoError(t, err)
+
+		_, err = segment.Write([]byte("first data"))
+		require.NoError(t, err)
+
+		_, err = segment.Write([]byte("second data"))
+		require.NoError(t, err)
+
+		pos := segment.Pos()
+
+		_, err = segment.Write([]byte("third data"))
+		require.NoError(t, err)
+
+		r, err := NewSegmentReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		r.Prefetch(2)
+
+		require.True(t, r.Next())
+
+		assert.Equal(t, "first data", string(r.Value()))
+
+		require.True(t, r.Next())
+
+		assert.Equal(t, "second data", string(r.Value()))
+
+		assert.Equal(t, pos, r.Pos())
+
+		// Turning prefetch off rewinds to the last returned entry.
+		r.Prefetch(0)
+
+		require.True(t, r.Next())
+
+		assert.Equal(t, "third data", string(r.Value()))
+
+		assert.False(t, r.Next())
+
+		r.Prefetch(2)
+
+		_, err = segment.Write([]byte("fourth data"))
+		require.NoError(t, err)
+
+		require.True(t, r.Next())
+
+		assert.Equal(t, "fourth data", string(r.Value()))
+
+		require.NoError(t, segment.Close())
+	})
+
+	n.It("appends to a segment too short to hold the closing magic", func() {
+		segment, err := NewSegmentWriter(path)
+		require.NoError(t, err)
+
+		_, err = segment.Write([]byte("x"))
+		require.NoError(t, err)
+
+		// Drop it without closing, as though the process crashed.
+		segment.f.Close()
+
+		seg2, err := NewSegmentWriter(path)
+		require.NoError(t, err)
+
+		_, err = seg2.Write([]byte("y"))
+		require.NoError(t, err)
+
+		require.NoError(t, seg2.Close())
+
+		r, err := NewSegmentReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.True(t, r.Next())
+		assert.Equal(t, "x", string(r.Value()))
+
+		require.True(t, r.Next())
+		assert.Equal(t, "y", string(r.Value()))
+
+		assert.False(t, r.Next())
+		require.NoError(t, r.Error())
+	})
+
+	n.It("replaces the closing magic when reopened", func() {
+		segment, err := NewSegmentWriter(path)
+		require.NoError(t, err)
+
+		_, err = segment.Write([]byte("first"))
+		require.NoError(t, err)
+
+		require.NoError(t, segment.Close())
+
+		r, err := NewSegmentReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.True(t, r.Next())
+		require.False(t, r.Next())
+		require.NoError(t, r.Error())
+
+		// Reopen and write an entry shorter than the magic without
+		// closing, as though the process then crashed.
+		seg2, err := NewSegmentWriter(path)
+		require.NoError(t, err)
+
+		_, err = seg2.Write([]byte("x"))
+		require.NoError(t, err)
+
+		require.True(t, r.Next())
+		assert.Equal(t, "x", string(r.Value()))
+
+		assert.False(t, r.Next())
+		require.NoError(t, r.Error())
+
+		seg2.f.Close()
+	})
+
 	n.Meow()
 }
@@ -0,0 +1,122 @@
+package wal
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// SegmentInfo describes a segment of a WAL.
+type SegmentInfo struct {
+	Index int    `json:"index"`
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+
+	Entries int `json:"entries"`
+	Tags    int `json:"tags"`
+
+	// How well the segment's compressed entries compressed.
+	Compression CompressionStats `json:"compression"`
+
+	// The positions of the first and last entries in the segment, or
+	// Position{-1, -1} if it has none.
+	First Position `json:"first"`
+	Last  Position `json:"last"`
+
+	// When the segment was last written to. Sealed segments are not
+	// written again, so for them this is when they were rotated out.
+	ModTime time.Time `json:"mod_time"`
+
+	// True if the segment was closed cleanly.
+	Sealed bool `json:"sealed"`
+
+	// True for the newest segment if it's still open for writing, or
+	// was left open by a writer that didn't close it.
+	Active bool `json:"active"`
+}
+
+// Segments describes every segment of the WAL in root, oldest first.
+// Every record is read to count the entries, so this takes time in
+// proportion to the size of the WAL.
+func Segments(root string) ([]SegmentInfo, error) {
+	indexes, err := listSegments(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []SegmentInfo
+
+	for i, index := range indexes {
+		info, err := segmentInfo(filepath.Join(root, strconv.Itoa(index)), index)
+		if err != nil {
+			return nil, fmt.Errorf("segment %d: %w", index, err)
+		}
+
+		info.Active = i == len(indexes)-1 && !info.Sealed
+
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+func segmentInfo(path string, index int) (SegmentInfo, error) {
+	info := SegmentInfo{
+		Index: index,
+		Path:  path,
+		First: Position{-1, -1},
+		Last:  Position{-1, -1},
+	}
+
+	sr, err := NewSegmentReader(path)
+	if err != nil {
+		return info, err
+	}
+
+	defer sr.Close()
+
+	fi, err := sr.f.Stat()
+	if err != nil {
+		return info, err
+	}
+
+	info.Size = fi.Size()
+	info.ModTime = fi.ModTime()
+
+	for {
+		start := sr.Pos()
+
+		t, ok := sr.nextRecord()
+		if !ok {
+			if err := sr.Error(); err != nil {
+				return info, err
+			}
+
+			break
+		}
+
+		if isTag(t) {
+			info.Tags++
+			continue
+		}
+
+		if info.Entries == 0 {
+			info.First = Position{index, start}
+		}
+
+		info.Last = Position{index, start}
+		info.Entries++
+
+		if hdr := sr.Header(); hdr.Compressed {
+			info.Compression.add(int64(len(sr.Value())), hdr.Length)
+		}
+	}
+
+	info.Sealed, err = hasClosingMagic(sr.f, sr.Pos(), info.Size)
+	if err != nil {
+		return info, err
+	}
+
+	return info, nil
+}
@@ -0,0 +1,95 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestSegments(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	n.It("describes each segment", func() {
+		opts := DefaultWriteOptions
+		opts.SegmentSize = 100
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		require.NoError(t, wal.Write([]byte("first entry")))
+
+		last, err := wal.Pos()
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("second entry")))
+		require.NoError(t, wal.WriteTag([]byte("tag")))
+		require.NoError(t, wal.Write([]byte("this is data that needs another segment, being long enough")))
+
+		infos, err := Segments(path)
+		require.NoError(t, err)
+
+		require.Equal(t, 2, len(infos))
+
+		seg := infos[0]
+
+		assert.Equal(t, 0, seg.Index)
+		assert.Equal(t, filepath.Join(path, "0"), seg.Path)
+		assert.Equal(t, 2, seg.Entries)
+		assert.Equal(t, 1, seg.Tags)
+		assert.Equal(t, Position{0, 0}, seg.First)
+		assert.Equal(t, last, seg.Last)
+		assert.True(t, seg.Sealed)
+		assert.False(t, seg.Active)
+		assert.False(t, seg.ModTime.IsZero())
+
+		fi, err := os.Stat(seg.Path)
+		require.NoError(t, err)
+
+		assert.Equal(t, fi.Size(), seg.Size)
+
+		seg = infos[1]
+
+		assert.Equal(t, 1, seg.Index)
+		assert.Equal(t, 1, seg.Entries)
+		assert.Equal(t, Position{1, 0}, seg.First)
+		assert.False(t, seg.Sealed)
+		assert.True(t, seg.Active)
+	})
+
+	n.It("describes an empty segment", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Close())
+
+		infos, err := Segments(path)
+		require.NoError(t, err)
+
+		require.Equal(t, 1, len(infos))
+
+		assert.Equal(t, 0, infos[0].Entries)
+		assert.Equal(t, Position{-1, -1}, infos[0].First)
+		assert.True(t, infos[0].Sealed)
+		assert.False(t, infos[0].Active)
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,314 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoSnapshot  = errors.New("no snapshot found")
+	ErrBadSnapshot = errors.New("snapshot is corrupt")
+)
+
+// Identifies a snapshot file, followed by the length of its header.
+var snapshotMagic = []byte("WALSNAP1")
+
+const snapshotPrefix = "snapshot."
+
+// SnapshotInfo describes a snapshot in a SnapshotStore.
+type SnapshotInfo struct {
+	// Snapshots are numbered in the order they're saved.
+	ID uint64 `json:"id"`
+
+	// The position in the WAL that the snapshot reflects, so that
+	// recovery loads the snapshot and then replays the WAL from Pos.
+	Pos Position `json:"pos"`
+
+	// An optional tag in the WAL that the snapshot corresponds to.
+	Tag []byte `json:"tag,omitempty"`
+
+	Created time.Time `json:"created"`
+	Size    int64     `json:"size"`
+	CRC     uint32    `json:"crc"`
+}
+
+// SnapshotStore keeps checkpoints of the state built from a WAL, so
+// that recovery can start from the latest one rather than replaying the
+// whole WAL. Each snapshot is written to a temporary file, synced and
+// renamed into place, so a crash never leaves a partial snapshot, and
+// only the newest few are kept.
+type SnapshotStore struct {
+	dir    string
+	retain int
+
+	lock   sync.Mutex
+	nextID uint64
+}
+
+// OpenSnapshotStore opens, or creates, a snapshot store in dir. Usually
+// dir sits beside the WAL's root. Saving a snapshot removes all but the
+// newest retain of them; a retain of 0 keeps every snapshot.
+func OpenSnapshotStore(dir string, retain int) (*SnapshotStore, error) {
+	err := os.Mkdir(dir, 0755)
+	if err != nil {
+		if !os.IsExist(err) {
+			return nil, err
+		}
+	}
+
+	s := &SnapshotStore{dir: dir, retain: retain}
+
+	ids, err := s.ids()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ids) > 0 {
+		s.nextID = ids[len(ids)-1] + 1
+	}
+
+	return s, nil
+}
+
+func (s *SnapshotStore) path(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s%020d", snapshotPrefix, id))
+}
+
+// ids returns the IDs of the snapshots in the store, oldest first.
+func (s *SnapshotStore) ids() ([]uint64, error) {
+	f, err := os.Open(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint64
+
+	for _, name := range names {
+		if !strings.HasPrefix(name, snapshotPrefix) {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimPrefix(name, snapshotPrefix), 10, 64)
+		if err == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids, nil
+}
+
+// Save stores data as a snapshot of the state as of pos, and optionally
+// tag, then prunes old snapshots. It returns once the snapshot is
+// durable.
+func (s *SnapshotStore) Save(pos Position, tag []byte, data []byte) (SnapshotInfo, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	info := SnapshotInfo{
+		ID:      s.nextID,
+		Pos:     pos,
+		Tag:     tag,
+		Created: time.Now(),
+		Size:    int64(len(data)),
+		CRC:     crc32.ChecksumIEEE(data),
+	}
+
+	hdr, err := json.Marshal(info)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+
+	var buf bytes.Buffer
+
+	buf.Write(snapshotMagic)
+	buf.Write(binary.AppendUvarint(nil, uint64(len(hdr))))
+	buf.Write(hdr)
+
+	path := s.path(info.ID)
+	tmp := path + ".tmp"
+
+	err = copyToFile(tmp, io.MultiReader(&buf, bytes.NewReader(data)))
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return SnapshotInfo{}, err
+	}
+
+	err = syncDir(s.dir)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+
+	s.nextID++
+
+	return info, s.prune()
+}
+
+// prune removes all but the newest retain snapshots.
+func (s *SnapshotStore) prune() error {
+	if s.retain <= 0 {
+		return nil
+	}
+
+	ids, err := s.ids()
+	if err != nil {
+		return err
+	}
+
+	for len(ids) > s.retain {
+		err = os.Remove(s.path(ids[0]))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		ids = ids[1:]
+	}
+
+	return nil
+}
+
+// List returns the snapshots in the store, oldest first. Like Latest,
+// it skips any whose header is damaged.
+func (s *SnapshotStore) List() ([]SnapshotInfo, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ids, err := s.ids()
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []SnapshotInfo
+
+	for _, id := range ids {
+		info, _, err := s.read(id, false)
+		if errors.Is(err, ErrBadSnapshot) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+// Load returns the snapshot with the given ID and its data, verifying
+// the data's checksum.
+func (s *SnapshotStore) Load(id uint64) (SnapshotInfo, []byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.read(id, true)
+}
+
+// Latest returns the newest intact snapshot and its data, skipping any
+// that fail their checksum. It returns ErrNoSnapshot if there are none.
+func (s *SnapshotStore) Latest() (SnapshotInfo, []byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ids, err := s.ids()
+	if err != nil {
+		return SnapshotInfo{}, nil, err
+	}
+
+	for i := len(ids) - 1; i >= 0; i-- {
+		info, data, err := s.read(ids[i], true)
+		if err == nil {
+			return info, data, nil
+		}
+
+		if !errors.Is(err, ErrBadSnapshot) {
+			return SnapshotInfo{}, nil, err
+		}
+	}
+
+	return SnapshotInfo{}, nil, ErrNoSnapshot
+}
+
+// read reads the header of a snapshot, and its data if withData is set.
+func (s *SnapshotStore) read(id uint64, withData bool) (SnapshotInfo, []byte, error) {
+	f, err := os.Open(s.path(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return SnapshotInfo{}, nil, ErrNoSnapshot
+		}
+
+		return SnapshotInfo{}, nil, err
+	}
+
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+
+	magic := make([]byte, len(snapshotMagic))
+
+	_, err = io.ReadFull(br, magic)
+	if err != nil || !bytes.Equal(magic, snapshotMagic) {
+		return SnapshotInfo{}, nil, fmt.Errorf("%w: snapshot %d has no header", ErrBadSnapshot, id)
+	}
+
+	sz, err := binary.ReadUvarint(br)
+	if err != nil || sz > maxFrameSize {
+		return SnapshotInfo{}, nil, fmt.Errorf("%w: snapshot %d has an invalid header", ErrBadSnapshot, id)
+	}
+
+	hdr := make([]byte, sz)
+
+	_, err = io.ReadFull(br, hdr)
+	if err != nil {
+		return SnapshotInfo{}, nil, fmt.Errorf("%w: snapshot %d has a short header", ErrBadSnapshot, id)
+	}
+
+	var info SnapshotInfo
+
+	err = json.Unmarshal(hdr, &info)
+	if err != nil {
+		return SnapshotInfo{}, nil, fmt.Errorf("%w: snapshot %d: %s", ErrBadSnapshot, id, err)
+	}
+
+	if !withData {
+		return info, nil, nil
+	}
+
+	data, err := ioutil.ReadAll(br)
+	if err != nil {
+		return SnapshotInfo{}, nil, err
+	}
+
+	if int64(len(data)) != info.Size || crc32.ChecksumIEEE(data) != info.CRC {
+		return SnapshotInfo{}, nil, fmt.Errorf("%w: snapshot %d fails its checksum", ErrBadSnapshot, id)
+	}
+
+	return info, data, nil
+}
@@ -0,0 +1,152 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestSnapshotStore(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "snapshots")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	n.It("reports no snapshot when empty", func() {
+		s, err := OpenSnapshotStore(path, 0)
+		require.NoError(t, err)
+
+		_, _, err = s.Latest()
+		assert.Equal(t, ErrNoSnapshot, err)
+	})
+
+	n.It("saves and loads snapshots", func() {
+		s, err := OpenSnapshotStore(path, 0)
+		require.NoError(t, err)
+
+		pos := Position{Segment: 2, Offset: 100}
+
+		info, err := s.Save(pos, []byte("ckpt"), []byte("state1"))
+		require.NoError(t, err)
+
+		_, err = s.Save(Position{Segment: 3, Offset: 10}, nil, []byte("state2"))
+		require.NoError(t, err)
+
+		got, data, err := s.Load(info.ID)
+		require.NoError(t, err)
+
+		assert.Equal(t, pos, got.Pos)
+		assert.Equal(t, []byte("ckpt"), got.Tag)
+		assert.Equal(t, []byte("state1"), data)
+
+		latest, data, err := s.Latest()
+		require.NoError(t, err)
+
+		assert.Equal(t, Position{Segment: 3, Offset: 10}, latest.Pos)
+		assert.Equal(t, []byte("state2"), data)
+	})
+
+	n.It("keeps only the newest snapshots", func() {
+		s, err := OpenSnapshotStore(path, 2)
+		require.NoError(t, err)
+
+		for i := 0; i < 4; i++ {
+			_, err = s.Save(Position{Offset: int64(i)}, nil, []byte("state"))
+			require.NoError(t, err)
+		}
+
+		infos, err := s.List()
+		require.NoError(t, err)
+
+		require.Equal(t, 2, len(infos))
+		assert.Equal(t, uint64(2), infos[0].ID)
+		assert.Equal(t, uint64(3), infos[1].ID)
+	})
+
+	n.It("continues numbering after reopening", func() {
+		s, err := OpenSnapshotStore(path, 0)
+		require.NoError(t, err)
+
+		_, err = s.Save(Position{}, nil, []byte("state"))
+		require.NoError(t, err)
+
+		s, err = OpenSnapshotStore(path, 0)
+		require.NoError(t, err)
+
+		info, err := s.Save(Position{}, nil, []byte("state"))
+		require.NoError(t, err)
+
+		assert.Equal(t, uint64(1), info.ID)
+	})
+
+	n.It("skips corrupt snapshots", func() {
+		s, err := OpenSnapshotStore(path, 0)
+		require.NoError(t, err)
+
+		_, err = s.Save(Position{Offset: 1}, nil, []byte("good"))
+		require.NoError(t, err)
+
+		info, err := s.Save(Position{Offset: 2}, nil, []byte("bad!"))
+		require.NoError(t, err)
+
+		f, err := os.OpenFile(s.path(info.ID), os.O_RDWR, 0)
+		require.NoError(t, err)
+
+		fi, err := f.Stat()
+		require.NoError(t, err)
+
+		_, err = f.WriteAt([]byte("x"), fi.Size()-1)
+		require.NoError(t, err)
+
+		f.Close()
+
+		_, _, err = s.Load(info.ID)
+		assert.ErrorIs(t, err, ErrBadSnapshot)
+
+		latest, data, err := s.Latest()
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(1), latest.Pos.Offset)
+		assert.Equal(t, []byte("good"), data)
+	})
+
+	n.It("lists around snapshots with corrupt headers", func() {
+		s, err := OpenSnapshotStore(path, 0)
+		require.NoError(t, err)
+
+		_, err = s.Save(Position{Offset: 1}, nil, []byte("good"))
+		require.NoError(t, err)
+
+		info, err := s.Save(Position{Offset: 2}, nil, []byte("bad!"))
+		require.NoError(t, err)
+
+		err = ioutil.WriteFile(s.path(info.ID), []byte("garbage"), 0644)
+		require.NoError(t, err)
+
+		infos, err := s.List()
+		require.NoError(t, err)
+
+		require.Len(t, infos, 1)
+		assert.Equal(t, int64(1), infos[0].Pos.Offset)
+
+		latest, _, err := s.Latest()
+		require.NoError(t, err)
+
+		assert.Equal(t, infos[0], latest)
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,254 @@
+package wal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// SegmentSplit describes how SplitSegment renumbered a WAL, so positions
+// saved from before the split can be carried over.
+type SegmentSplit struct {
+	// The segment that was split.
+	Index int
+
+	// Where each piece started in the original segment. Piece i is now
+	// segment Index+i. A segment that didn't need splitting has one
+	// piece.
+	Starts []int64
+}
+
+// Remap returns where the entry that was at p before the split is now.
+func (s *SegmentSplit) Remap(p Position) Position {
+	switch {
+	case p.Segment < s.Index:
+		return p
+	case p.Segment > s.Index:
+		return Position{p.Segment + len(s.Starts) - 1, p.Offset}
+	}
+
+	i := sort.Search(len(s.Starts), func(i int) bool {
+		return s.Starts[i] > p.Offset
+	}) - 1
+
+	return Position{s.Index + i, p.Offset - s.Starts[i]}
+}
+
+// SplitSegment splits segment index of the WAL in root, such as one
+// that grew from a large write or under an older SegmentSize, into
+// pieces holding at most size bytes of records each, so that retention
+// and archiving work in predictably sized chunks. An entry larger than
+// size gets a piece of its own. The records are copied as they are and
+// the segments after index are renumbered to make room; use the
+// returned SegmentSplit to remap saved positions. The pieces of a
+// segment sealed with SealSegments aren't sealed with its checksum.
+//
+// The pieces are written before anything is renamed, but a crash while
+// the later segments are being renumbered leaves a gap in the sequence,
+// with the pieces in files named "<index>.split.<piece>".
+//
+// The WAL must not be open for writing; ErrLocked is returned if it is.
+// opts are those the WAL is opened with, as with Compact.
+func SplitSegment(root string, index int, size int64, opts ...Option) (*SegmentSplit, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("%w: size must be positive, got %d", ErrInvalidOptions, size)
+	}
+
+	lockf, err := lockRoot(root, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	defer lockf.Close()
+
+	path := filepath.Join(root, strconv.Itoa(index))
+
+	sumSize, _, err := readBlockSums(OSFS, path)
+	if err != nil {
+		return nil, err
+	}
+
+	split, end, sealed, err := planSplit(path, index, size)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(split.Starts) == 1 {
+		return split, nil
+	}
+
+	pieces, err := writePieces(path, split, end, sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	_, last, err := rangeSegments(OSFS, root)
+	if err != nil {
+		return nil, err
+	}
+
+	extra := len(split.Starts) - 1
+
+	for i := last; i > index; i-- {
+		err = renameSegment(root, i, i+extra)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The first piece replaces the original segment last, so until then
+	// it's intact.
+	for i := len(pieces) - 1; i >= 0; i-- {
+		err = os.Rename(pieces[i], filepath.Join(root, strconv.Itoa(index+i)))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The offsets in its time index no longer match the pieces.
+	os.Remove(timeIndexPath(root, index))
+	os.Remove(blockSumPath(root, index))
+
+	if sumSize > 0 {
+		for i := range pieces {
+			err = writeBlockSums(OSFS, filepath.Join(root, strconv.Itoa(index+i)), sumSize)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return split, syncDir(root)
+}
+
+// planSplit reads the segment at path, returning where each piece
+// should start, where the records end, and whether the segment is
+// sealed.
+func planSplit(path string, index int, size int64) (*SegmentSplit, int64, bool, error) {
+	sr, err := NewSegmentReader(path)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	defer sr.Close()
+
+	fi, err := sr.f.Stat()
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	split := &SegmentSplit{Index: index, Starts: []int64{0}}
+
+	var end int64
+
+	for {
+		start := sr.Pos()
+
+		_, ok := sr.nextRecord()
+		if !ok {
+			if err := sr.Error(); err != nil {
+				return nil, 0, false, fmt.Errorf("segment %d offset %d: %w", index, start, err)
+			}
+
+			// Any seal isn't copied, since it covers the whole segment.
+			end = start
+
+			break
+		}
+
+		pieceStart := split.Starts[len(split.Starts)-1]
+
+		if start > pieceStart && sr.Pos()-pieceStart > size {
+			split.Starts = append(split.Starts, start)
+		}
+	}
+
+	sealed, err := hasClosingMagic(sr.f, sr.Pos(), fi.Size())
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	if !sealed && sr.Pos() != fi.Size() {
+		return nil, 0, false, fmt.Errorf("segment %d offset %d: %w", index, sr.Pos(), errTrailingData)
+	}
+
+	return split, end, sealed, nil
+}
+
+// writePieces copies each piece of the segment at path to a temporary
+// file beside it. Every piece but the last is sealed, and the last is
+// sealed if the segment was.
+func writePieces(path string, split *SegmentSplit, end int64, sealed bool) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	bounds := append(append([]int64(nil), split.Starts...), end)
+
+	var pieces []string
+
+	for i := range split.Starts {
+		tmp := fmt.Sprintf("%s.split.%d", path, i)
+
+		r := io.NewSectionReader(f, bounds[i], bounds[i+1]-bounds[i])
+
+		err = copySegmentPiece(tmp, r, sealed || i < len(split.Starts)-1)
+		if err != nil {
+			for _, p := range append(pieces, tmp) {
+				os.Remove(p)
+			}
+
+			return nil, err
+		}
+
+		pieces = append(pieces, tmp)
+	}
+
+	return pieces, nil
+}
+
+func copySegmentPiece(path string, r io.Reader, seal bool) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if err == nil && seal {
+		_, err = f.Write(closingMagic)
+	}
+
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// renameSegment moves segment from to to, along with its time index and
+// block checksums.
+func renameSegment(root string, from, to int) error {
+	err := os.Rename(filepath.Join(root, strconv.Itoa(from)), filepath.Join(root, strconv.Itoa(to)))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range []func(string, int) string{timeIndexPath, blockSumPath} {
+		err = os.Rename(path(root, from), path(root, to))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
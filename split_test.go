@@ -0,0 +1,144 @@
+package wal
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestSplitSegment(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	// Positions of the entries before the split, by value.
+	var positions map[string]Position
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+
+		positions = map[string]Position{}
+
+		// The first segment is written large, as though under an older
+		// SegmentSize.
+		wal, err := New(path, WithSegmentSize(4096), WithCompression(false))
+		require.NoError(t, err)
+
+		for i := 0; i < 20; i++ {
+			require.NoError(t, wal.Write([]byte(fmt.Sprintf("big%02d", i))))
+		}
+
+		require.NoError(t, wal.Write(bytes.Repeat([]byte("x"), 200)))
+		require.NoError(t, wal.WriteTag([]byte("tag")))
+		require.NoError(t, wal.Close())
+
+		wal, err = New(path, WithSegmentSize(64), WithMaxSegments(100), WithCompression(false))
+		require.NoError(t, err)
+
+		for i := 0; i < 10; i++ {
+			require.NoError(t, wal.Write([]byte(fmt.Sprintf("small%d", i))))
+		}
+
+		require.NoError(t, wal.Close())
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		for pos, val := range r.All() {
+			positions[string(val)] = pos
+		}
+
+		require.NoError(t, r.Error())
+	})
+
+	n.It("splits a segment into pieces and remaps positions", func() {
+		_, lastBefore, err := rangeSegments(OSFS, path)
+		require.NoError(t, err)
+
+		split, err := SplitSegment(path, 0, 64)
+		require.NoError(t, err)
+
+		require.True(t, len(split.Starts) > 2)
+
+		first, last, err := rangeSegments(OSFS, path)
+		require.NoError(t, err)
+
+		assert.Equal(t, 0, first)
+		assert.Equal(t, lastBefore+len(split.Starts)-1, last)
+
+		report, err := Verify(path)
+		require.NoError(t, err)
+
+		assert.True(t, report.OK)
+		assert.Equal(t, len(positions), report.Entries)
+		assert.Equal(t, 1, report.Tags)
+
+		for _, seg := range report.Segments[:len(split.Starts)] {
+			// Only the large entry may exceed the size.
+			if seg.Entries > 1 {
+				assert.True(t, seg.Size <= 64+int64(len(closingMagic)))
+			}
+		}
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		for val, pos := range positions {
+			require.NoError(t, r.Seek(split.Remap(pos)))
+			require.True(t, r.Next())
+			assert.Equal(t, val, string(r.Value()))
+		}
+
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		assert.True(t, wal.WasCleanShutdown())
+
+		pos, err := wal.TagPos([]byte("tag"))
+		require.NoError(t, err)
+		assert.Equal(t, len(split.Starts)-1, pos.Segment)
+	})
+
+	n.It("leaves a segment that fits alone", func() {
+		split, err := SplitSegment(path, 1, 4096)
+		require.NoError(t, err)
+
+		assert.Equal(t, []int64{0}, split.Starts)
+
+		pos := Position{3, 12}
+		assert.Equal(t, pos, split.Remap(pos))
+
+		_, err = os.Stat(filepath.Join(path, "1.split.0"))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	n.It("refuses to split an open WAL", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		_, err = SplitSegment(path, 0, 64)
+		assert.Equal(t, ErrLocked, err)
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,141 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Stats describes the state of a WALWriter, for dashboards and capacity
+// planning. Counts are since the WAL was opened.
+type Stats struct {
+	// The active segment and how much has been written to it.
+	Segment     int
+	SegmentSize int64
+
+	// The oldest segment still on disk, and how many segments there are.
+	FirstSegment int
+	Segments     int
+
+	// The total size of the segments on disk.
+	DiskSize int64
+
+	Entries   int64
+	Tags      int64
+	Rotations int64
+
+	// The number of segments removed to stay under MaxSegments or by
+	// PruneBefore.
+	Prunes int64
+
+	// When the last successful sync finished and how long it took. Zero
+	// if the WAL hasn't been synced since it was opened.
+	LastSync         time.Time
+	LastSyncDuration time.Duration
+
+	// How long syncs have taken, and how many took at least
+	// SlowSyncThreshold.
+	SyncLatency SyncHistogram
+	SlowSyncs   int64
+
+	// The bytes of the active segment not yet synced, and how many
+	// writes synced because that reached MaxUnsyncedBytes.
+	Unsynced       int64
+	EscalatedSyncs int64
+
+	// How well records have compressed, in the active segment and since
+	// the WAL was opened. Call Ratio on either for the savings.
+	SegmentCompression CompressionStats
+	Compression        CompressionStats
+}
+
+// Stats returns the current state of the WAL. Nothing is read from
+// disk, so it's cheap to call often.
+func (wal *WALWriter) Stats() (Stats, error) {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	if wal.closed {
+		return Stats{}, ErrClosed
+	}
+
+	st := Stats{
+		Segment:      wal.index,
+		SegmentSize:  wal.segment.Size(),
+		FirstSegment: wal.first,
+		Segments:     wal.index - wal.first + 1,
+		Entries:      wal.entries,
+		Tags:         wal.tags,
+		Rotations:    wal.rotations,
+		Prunes:       wal.prunes,
+		DiskSize:     wal.sealedBytes + wal.segment.Size(),
+
+		SegmentCompression: wal.segment.compression,
+		Compression:        wal.compression,
+	}
+
+	st.LastSync, st.LastSyncDuration, _ = wal.syncs.get()
+	st.SyncLatency, st.SlowSyncs = wal.syncs.histogram()
+	st.Unsynced = wal.segment.unsynced()
+	st.EscalatedSyncs = wal.syncs.escalations()
+
+	return st, nil
+}
+
+// SizeOnDisk returns the number of bytes the WAL's directory uses: its
+// segments, the tag cache, and any other files that were in it when the
+// WAL was opened. It's maintained as the WAL is written rather than
+// read from the filesystem, so it's cheap enough to check before every
+// write when enforcing a disk budget.
+func (wal *WALWriter) SizeOnDisk() int64 {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	return wal.sealedBytes + wal.segment.Size() + wal.cacheBytes + wal.otherBytes
+}
+
+// diskUsage returns the sizes of the segments before the active one in
+// root, and the total size of the files that aren't segments. The tag
+// cache at cachePath isn't counted since it's rewritten on open.
+func diskUsage(fs FS, root string, active int, cachePath string) (map[int]int64, int64, error) {
+	names, err := fs.ReadDirNames(root)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		sizes = map[int]int64{}
+		other int64
+	)
+
+	for _, name := range names {
+		index, err := strconv.Atoi(name)
+		segment := err == nil
+
+		path := filepath.Join(root, name)
+
+		if (segment && index == active) || path == cachePath {
+			continue
+		}
+
+		fi, err := fs.Stat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, 0, err
+		}
+
+		switch {
+		case fi.IsDir():
+		case segment:
+			sizes[index] = fi.Size()
+		default:
+			other += fi.Size()
+		}
+	}
+
+	return sizes, other, nil
+}
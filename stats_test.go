@@ -0,0 +1,169 @@
+package wal
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestStats(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	n.It("reports the state of the writer", func() {
+		opts := DefaultWriteOptions
+		opts.SegmentSize = 100
+		opts.MaxSegments = 2
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		st, err := wal.Stats()
+		require.NoError(t, err)
+
+		assert.Equal(t, 0, st.Segment)
+		assert.Equal(t, 1, st.Segments)
+		assert.True(t, st.LastSync.IsZero())
+
+		for i := 0; i < 4; i++ {
+			require.NoError(t, wal.Write([]byte("this is data that fills a segment on its own, more or less")))
+		}
+
+		require.NoError(t, wal.WriteTag([]byte("tag")))
+
+		st, err = wal.Stats()
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(4), st.Entries)
+		assert.Equal(t, int64(1), st.Tags)
+		assert.Equal(t, 3, st.Segment)
+		assert.Equal(t, 2, st.FirstSegment)
+		assert.Equal(t, 2, st.Segments)
+		assert.Equal(t, int64(3), st.Rotations)
+		assert.Equal(t, int64(2), st.Prunes)
+		assert.False(t, st.LastSync.IsZero())
+
+		var size int64
+
+		for _, name := range []string{"2", "3"} {
+			fi, err := os.Stat(filepath.Join(path, name))
+			require.NoError(t, err)
+
+			size += fi.Size()
+		}
+
+		assert.Equal(t, size, st.DiskSize)
+
+		fi, err := os.Stat(filepath.Join(path, "3"))
+		require.NoError(t, err)
+
+		assert.Equal(t, fi.Size(), st.SegmentSize)
+	})
+
+	n.It("reports how well entries compress", func() {
+		metrics := &testMetrics{}
+
+		wal, err := New(path, WithMetrics(metrics))
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		data := bytes.Repeat([]byte("compressible "), 100)
+
+		require.NoError(t, wal.Write(data))
+		require.NoError(t, wal.Write(data))
+		require.NoError(t, wal.WriteRaw(data))
+
+		st, err := wal.Stats()
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(2*len(data)), st.Compression.Uncompressed)
+		assert.True(t, st.Compression.Compressed > 0)
+		assert.True(t, st.Compression.Ratio() > 1)
+		assert.Equal(t, st.Compression, st.SegmentCompression)
+
+		assert.Equal(t, st.Compression.Uncompressed, metrics.counter(MetricUncompressedBytes))
+		assert.Equal(t, st.Compression.Compressed, metrics.counter(MetricCompressedBytes))
+
+		infos, err := Segments(path)
+		require.NoError(t, err)
+
+		require.Equal(t, 1, len(infos))
+		assert.Equal(t, st.Compression, infos[0].Compression)
+
+		assert.Equal(t, 0.0, CompressionStats{}.Ratio())
+	})
+
+	n.It("fails once closed", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Close())
+
+		_, err = wal.Stats()
+		assert.Equal(t, ErrClosed, err)
+	})
+
+	n.It("tracks the size of the directory", func() {
+		// Leave a file behind from before the WAL is opened.
+		require.NoError(t, os.MkdirAll(path, 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(path, "group.test"), []byte("state"), 0644))
+
+		opts := DefaultWriteOptions
+		opts.SegmentSize = 100
+		opts.MaxSegments = 2
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		dirSize := func() int64 {
+			names, err := ioutil.ReadDir(path)
+			require.NoError(t, err)
+
+			var total int64
+			for _, fi := range names {
+				total += fi.Size()
+			}
+
+			return total
+		}
+
+		assert.Equal(t, dirSize(), wal.SizeOnDisk())
+
+		for i := 0; i < 5; i++ {
+			require.NoError(t, wal.Write([]byte("this is data that fills a segment on its own, more or less")))
+			require.NoError(t, wal.WriteTag([]byte("tag")))
+
+			assert.Equal(t, dirSize(), wal.SizeOnDisk())
+		}
+
+		require.NoError(t, wal.Close())
+
+		wal, err = NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		assert.Equal(t, dirSize(), wal.SizeOnDisk())
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,222 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Framing controls how a byte stream maps onto WAL entries.
+type Framing int
+
+const (
+	// Each Write call is one entry. When reading, entries are simply
+	// concatenated.
+	FrameRaw Framing = iota
+
+	// Each newline terminated line is one entry, without the newline.
+	FrameLines
+
+	// The stream is a sequence of uvarint lengths, each followed by that
+	// many bytes of entry.
+	FrameLengthPrefixed
+)
+
+var (
+	ErrShortFrame     = errors.New("stream ended part way through an entry")
+	ErrBadFrameLength = errors.New("invalid entry length in stream")
+)
+
+// The largest entry accepted from a length prefixed stream, to guard
+// against treating garbage as a huge length.
+const maxFrameSize = 1 << 30
+
+// RecordWriter is an io.Writer that writes to a WAL, so that code which
+// writes to files or pipes can write to a WAL unchanged.
+type RecordWriter struct {
+	w       *WALWriter
+	framing Framing
+
+	// Data that hasn't made up a whole entry yet.
+	buf []byte
+}
+
+// AsWriter returns an io.Writer that writes entries to the WAL, split
+// from the written bytes according to framing.
+func (wal *WALWriter) AsWriter(framing Framing) *RecordWriter {
+	return &RecordWriter{w: wal, framing: framing}
+}
+
+func (rw *RecordWriter) Write(p []byte) (int, error) {
+	if rw.framing == FrameRaw {
+		err := rw.w.Write(p)
+		if err != nil {
+			return 0, err
+		}
+
+		return len(p), nil
+	}
+
+	held := len(rw.buf)
+
+	rw.buf = append(rw.buf, p...)
+	total := len(rw.buf)
+
+	for {
+		ent, rest, ok, err := rw.split(rw.buf)
+		if err == nil && !ok {
+			break
+		}
+
+		if err == nil {
+			err = rw.w.Write(ent)
+		}
+
+		if err != nil {
+			return rw.unbuffer(held, total), err
+		}
+
+		rw.buf = rest
+	}
+
+	// Don't hang onto a large buffer once it's been consumed.
+	if len(rw.buf) == 0 {
+		rw.buf = nil
+	}
+
+	return len(p), nil
+}
+
+// unbuffer drops the bytes of p that weren't written as entries after
+// a Write of p fails, given how much was buffered before p was added and
+// in total after, and returns how many of p's bytes were written. What
+// was buffered before stays buffered if it wasn't written either.
+func (rw *RecordWriter) unbuffer(held, total int) int {
+	written := total - len(rw.buf)
+
+	if written < held {
+		rw.buf = rw.buf[:held-written]
+		return 0
+	}
+
+	rw.buf = nil
+
+	return written - held
+}
+
+// split returns the first whole entry in buf and what follows it.
+func (rw *RecordWriter) split(buf []byte) (ent, rest []byte, ok bool, err error) {
+	switch rw.framing {
+	case FrameLines:
+		idx := bytes.IndexByte(buf, '\n')
+		if idx == -1 {
+			return nil, buf, false, nil
+		}
+
+		return buf[:idx], buf[idx+1:], true, nil
+	case FrameLengthPrefixed:
+		sz, n := binary.Uvarint(buf)
+		if n == 0 {
+			return nil, buf, false, nil
+		}
+
+		if n < 0 || sz > maxFrameSize {
+			return nil, buf, false, ErrBadFrameLength
+		}
+
+		if uint64(len(buf)-n) < sz {
+			return nil, buf, false, nil
+		}
+
+		end := n + int(sz)
+
+		return buf[n:end], buf[end:], true, nil
+	default:
+		return buf, nil, true, nil
+	}
+}
+
+// Flush writes any trailing line that wasn't terminated by a newline as
+// an entry. For length prefixed framing, a partially written entry is
+// an error.
+func (rw *RecordWriter) Flush() error {
+	if len(rw.buf) == 0 {
+		return nil
+	}
+
+	if rw.framing == FrameLengthPrefixed {
+		return ErrShortFrame
+	}
+
+	err := rw.w.Write(rw.buf)
+	if err != nil {
+		return err
+	}
+
+	rw.buf = nil
+
+	return nil
+}
+
+// Close flushes any remaining data. It does not close the WAL.
+func (rw *RecordWriter) Close() error {
+	return rw.Flush()
+}
+
+// recordStream is an io.Reader over the entries of a WAL.
+type recordStream struct {
+	r       *WALReader
+	framing Framing
+
+	buf     []byte
+	pending []byte
+}
+
+// AsStream returns an io.Reader of the entries from the reader's
+// current position on, framed according to framing, so the WAL can be
+// piped into anything that consumes a stream. The stream returns io.EOF
+// once it reaches the end of the WAL, but can be read again after more
+// data has been written.
+func (r *WALReader) AsStream(framing Framing) io.Reader {
+	return &recordStream{r: r, framing: framing}
+}
+
+func (s *recordStream) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		if !s.r.Next() {
+			if err := s.r.Error(); err != nil {
+				return 0, err
+			}
+
+			return 0, io.EOF
+		}
+
+		s.frame(s.r.Value())
+	}
+
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+
+	return n, nil
+}
+
+// frame sets pending to the framed value, reusing the same buffer so
+// the stream allocates only when an entry outgrows it.
+func (s *recordStream) frame(val []byte) {
+	buf := s.buf[:0]
+
+	switch s.framing {
+	case FrameLines:
+		buf = append(buf, val...)
+		buf = append(buf, '\n')
+	case FrameLengthPrefixed:
+		buf = binary.AppendUvarint(buf, uint64(len(val)))
+		buf = append(buf, val...)
+	default:
+		buf = append(buf, val...)
+	}
+
+	s.buf = buf
+	s.pending = buf
+}
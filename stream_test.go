@@ -0,0 +1,202 @@
+package wal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestStream(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	values := func() []string {
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		var vals []string
+
+		for r.Next() {
+			vals = append(vals, string(r.Value()))
+		}
+
+		return vals
+	}
+
+	n.It("writes each Write as an entry", func() {
+		w, err := New(path)
+		require.NoError(t, err)
+
+		rw := w.AsWriter(FrameRaw)
+
+		fmt.Fprintf(rw, "hello %d", 1)
+		fmt.Fprintf(rw, "hello %d", 2)
+
+		require.NoError(t, rw.Close())
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, []string{"hello 1", "hello 2"}, values())
+	})
+
+	n.It("writes each line as an entry", func() {
+		w, err := New(path)
+		require.NoError(t, err)
+
+		rw := w.AsWriter(FrameLines)
+
+		_, err = rw.Write([]byte("line 1\nli"))
+		require.NoError(t, err)
+
+		_, err = rw.Write([]byte("ne 2\nline 3"))
+		require.NoError(t, err)
+
+		require.NoError(t, rw.Close())
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, []string{"line 1", "line 2", "line 3"}, values())
+	})
+
+	n.It("writes length prefixed entries", func() {
+		w, err := New(path)
+		require.NoError(t, err)
+
+		rw := w.AsWriter(FrameLengthPrefixed)
+
+		var buf []byte
+
+		for _, v := range []string{"first", "", "second"} {
+			buf = binary.AppendUvarint(buf, uint64(len(v)))
+			buf = append(buf, v...)
+		}
+
+		// Write a byte at a time to check entries are reassembled.
+		for i := range buf {
+			_, err = rw.Write(buf[i : i+1])
+			require.NoError(t, err)
+		}
+
+		require.NoError(t, rw.Flush())
+
+		_, err = rw.Write([]byte{10, 'x'})
+		require.NoError(t, err)
+
+		assert.Equal(t, ErrShortFrame, rw.Close())
+
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, []string{"first", "", "second"}, values())
+	})
+
+	n.It("reports only the bytes written as entries when a write fails", func() {
+		w, err := New(path)
+		require.NoError(t, err)
+
+		rw := w.AsWriter(FrameLengthPrefixed)
+
+		buf := binary.AppendUvarint(nil, uint64(len("first")))
+		buf = append(buf, "first"...)
+
+		_, err = rw.Write(buf[:2])
+		require.NoError(t, err)
+
+		// The rest of the first entry, then a length that's too large.
+		bad := append(buf[2:], binary.AppendUvarint(nil, maxFrameSize+1)...)
+
+		n, err := rw.Write(bad)
+		assert.Equal(t, ErrBadFrameLength, err)
+		assert.Equal(t, len(buf)-2, n)
+
+		assert.Empty(t, rw.buf)
+
+		_, err = rw.Write([]byte{1})
+		require.NoError(t, err)
+
+		require.NoError(t, w.Close())
+
+		// What was buffered before a failed write is kept.
+		n, err = rw.Write([]byte("x"))
+		assert.Equal(t, ErrClosed, err)
+		assert.Equal(t, 0, n)
+
+		assert.Equal(t, []byte{1}, rw.buf)
+
+		assert.Equal(t, []string{"first"}, values())
+	})
+
+	n.It("reads entries as a stream", func() {
+		w, err := New(path)
+		require.NoError(t, err)
+
+		require.NoError(t, w.Write([]byte("first")))
+		require.NoError(t, w.Write([]byte("")))
+		require.NoError(t, w.Write([]byte("second")))
+		require.NoError(t, w.Close())
+
+		for _, framing := range []Framing{FrameRaw, FrameLines, FrameLengthPrefixed} {
+			r, err := NewReader(path)
+			require.NoError(t, err)
+
+			data, err := ioutil.ReadAll(r.AsStream(framing))
+			require.NoError(t, err)
+
+			r.Close()
+
+			switch framing {
+			case FrameRaw:
+				assert.Equal(t, "firstsecond", string(data))
+			case FrameLines:
+				assert.Equal(t, "first\n\nsecond\n", string(data))
+			case FrameLengthPrefixed:
+				assert.Equal(t, "\x05first\x00\x06second", string(data))
+			}
+		}
+	})
+
+	n.It("round trips through the writer and stream adapters", func() {
+		w, err := New(path)
+		require.NoError(t, err)
+
+		var buf []byte
+
+		for _, v := range []string{"one", "two", "three"} {
+			buf = binary.AppendUvarint(buf, uint64(len(v)))
+			buf = append(buf, v...)
+		}
+
+		_, err = w.AsWriter(FrameLengthPrefixed).Write(buf)
+		require.NoError(t, err)
+
+		require.NoError(t, w.Close())
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		data, err := ioutil.ReadAll(r.AsStream(FrameLengthPrefixed))
+		require.NoError(t, err)
+
+		assert.Equal(t, buf, data)
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,222 @@
+package wal
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	ErrInvalidStreamName = errors.New("invalid stream name")
+
+	errBadStreamEntry = errors.New("malformed stream entry")
+)
+
+// StreamWriter writes the entries of one named stream of a WAL. Streams
+// let an application keep a handful of independent logs in one WAL:
+// their entries are interleaved in the same segments, so they share one
+// directory and one series of syncs, but each is read back on its own
+// with NewStreamReader. Readers of the WAL itself don't see stream
+// entries.
+//
+// Streams don't have positions or retention of their own. Because their
+// entries share segments, a position in a stream is a position in the
+// WAL, which moves with writes to every stream, and a stream's entries
+// are pruned along with the segments that hold them, by the WAL's
+// retention rather than one set for the stream. Some of that can be
+// approximated: registering a stream consumer's position with
+// RegisterReader, with RetainForReaders set, keeps the stream's entries
+// until they've been processed, though it holds back every stream's
+// pruning to do so, and StreamQuota keeps one stream from filling the
+// WAL at the expense of the others.
+type StreamWriter struct {
+	wal    *WALWriter
+	name   string
+	prefix []byte
+
+	// Called after each write, such as by a PairedWriter to commit it.
+	written func() error
+}
+
+// Stream returns a writer for the stream called name, which must not be
+// empty.
+func (wal *WALWriter) Stream(name string) (*StreamWriter, error) {
+	if name == "" {
+		return nil, ErrInvalidStreamName
+	}
+
+	return &StreamWriter{
+		wal:    wal,
+		name:   name,
+		prefix: binary.AppendUvarint(nil, uint64(len(name))),
+	}, nil
+}
+
+// Name returns the name of the stream.
+func (s *StreamWriter) Name() string {
+	return s.name
+}
+
+// Write writes data as an entry of the stream.
+func (s *StreamWriter) Write(data []byte) error {
+	return s.WriteContext(context.Background(), data)
+}
+
+// WriteContext is like Write, but any span created for the write is a
+// child of the one in ctx.
+func (s *StreamWriter) WriteContext(ctx context.Context, data []byte) error {
+	return s.done(s.wal.writeEntry(ctx, streamType, s.frame(data)))
+}
+
+// WriteRaw is like Write, but stores data uncompressed. See
+// WALWriter.WriteRaw.
+func (s *StreamWriter) WriteRaw(data []byte) error {
+	return s.done(s.wal.writeEntry(context.Background(), streamType|rawFlag, s.frame(data)))
+}
+
+// Pos returns the position in the WAL after the last entry written to
+// it, whichever stream, or the WAL itself, that entry belongs to. There
+// is no position of the stream's own.
+func (s *StreamWriter) Pos() (Position, error) {
+	return s.wal.Pos()
+}
+
+// NewStreamReader returns a reader of the entries of the stream called
+// name in the WAL in root. It's a WALReader in every other respect, and
+// its positions can be used with the WAL's other readers.
+func NewStreamReader(root, name string, opts ReadOptions) (*WALReader, error) {
+	if name == "" {
+		return nil, ErrInvalidStreamName
+	}
+
+	return newReader(root, []byte(name), opts)
+}
+
+// WriteTag writes a tag to the stream. Stream tags are separate from
+// the WAL's own tags and those of other streams, so each stream can
+// checkpoint independently. Find them with a stream reader's SeekTag, or
+// BeginStreamRecovery.
+func (s *StreamWriter) WriteTag(tag []byte) error {
+	return s.WriteTagContext(context.Background(), tag)
+}
+
+// WriteTagContext is like WriteTag, but any span created for the write
+// is a child of the one in ctx.
+func (s *StreamWriter) WriteTagContext(ctx context.Context, tag []byte) error {
+	return s.done(s.wal.writeTag(ctx, streamTagType, s.frame(tag), tagKey([]byte(s.name), tag)))
+}
+
+// TagPos returns the position of the last time tag was written to the
+// stream, or ErrTagNotFound if it isn't in the WAL.
+func (s *StreamWriter) TagPos(tag []byte) (Position, error) {
+	return s.wal.tagPos([]byte(s.name), tag)
+}
+
+// done finishes a write that returned err.
+func (s *StreamWriter) done(err error) error {
+	if err != nil || s.written == nil {
+		return err
+	}
+
+	return s.written()
+}
+
+// frame prefixes data with the stream's name.
+func (s *StreamWriter) frame(data []byte) []byte {
+	ent := make([]byte, 0, len(s.prefix)+len(s.name)+len(data))
+	ent = append(ent, s.prefix...)
+	ent = append(ent, s.name...)
+
+	return append(ent, data...)
+}
+
+// BeginStreamRecovery is like BeginRecoveryWithOptions, but returns a
+// reader of the stream called name, positioned at the stream's tag.
+func BeginStreamRecovery(path, name string, tag []byte, opts ReadOptions) (*WALReader, error) {
+	if name == "" {
+		return nil, ErrInvalidStreamName
+	}
+
+	return beginRecovery(path, []byte(name), tag, opts)
+}
+
+// tagKey returns the key of tag in the tag cache. Stream tags are
+// qualified by the stream's name, with separators that can't appear in
+// base64 so they never collide with the WAL's own tags.
+func tagKey(stream, tag []byte) string {
+	key := base64.URLEncoding.EncodeToString(tag)
+
+	if stream == nil {
+		return key
+	}
+
+	return "stream:" + base64.URLEncoding.EncodeToString(stream) + ":" + key
+}
+
+// streamTagKey returns the tag cache key of a stream tag record's body,
+// as copied by Clone.
+func streamTagKey(body []byte) string {
+	name, tag, ok := splitStreamEntry(body)
+	if !ok {
+		return "stream:" + base64.URLEncoding.EncodeToString(body)
+	}
+
+	return tagKey(name, tag)
+}
+
+// wanted reports whether Next returns entries of type t: data entries,
+// or the entries of the stream being read, and their tags if tags is set.
+func (r *SegmentReader) wanted(t byte) bool {
+	if r.tags && r.seeksTag(t) {
+		return true
+	}
+
+	if r.stream != nil {
+		return t == streamType
+	}
+
+	return t != streamType && !isTag(t)
+}
+
+// seeksTag reports whether t is the type of the tags SeekTag looks for.
+func (r *SegmentReader) seeksTag(t byte) bool {
+	if r.stream != nil {
+		return t == streamTagType
+	}
+
+	return t == tagType || t == payloadTagType || t == barrierType
+}
+
+// isTag reports whether t is the type of a tag, of the WAL or a stream.
+func isTag(t byte) bool {
+	return t == tagType || t == streamTagType || t == payloadTagType || t == barrierType
+}
+
+// streamValue splits the stream name from the value of a stream entry,
+// returning the rest if the entry belongs to the stream being read.
+func (r *SegmentReader) streamValue(value []byte) ([]byte, bool, error) {
+	name, value, ok := splitStreamEntry(value)
+	if !ok {
+		return nil, false, errBadStreamEntry
+	}
+
+	if string(name) != string(r.stream) {
+		return nil, false, nil
+	}
+
+	return value, true, nil
+}
+
+// splitStreamEntry splits the body of a stream entry or tag into the
+// stream's name and the rest.
+func splitStreamEntry(body []byte) ([]byte, []byte, bool) {
+	n, sz := binary.Uvarint(body)
+	if sz <= 0 || uint64(len(body)-sz) < n {
+		return nil, nil, false
+	}
+
+	end := sz + int(n)
+
+	return body[sz:end], body[end:], true
+}
@@ -0,0 +1,182 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestStreams(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+		os.RemoveAll(path + ".clone")
+
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		a, err := wal.Stream("a")
+		require.NoError(t, err)
+
+		b, err := wal.Stream("b")
+		require.NoError(t, err)
+
+		require.NoError(t, a.Write([]byte("a1")))
+		require.NoError(t, wal.Write([]byte("main")))
+		require.NoError(t, b.Write([]byte("b1")))
+		require.NoError(t, wal.WriteTag([]byte("tag")))
+		require.NoError(t, a.Write([]byte("a2")))
+
+		require.NoError(t, wal.Close())
+	})
+
+	read := func(r *WALReader) []string {
+		defer r.Close()
+
+		var values []string
+
+		for r.Next() {
+			values = append(values, string(r.Value()))
+		}
+
+		require.NoError(t, r.Error())
+
+		return values
+	}
+
+	stream := func(root, name string, opts ReadOptions) []string {
+		r, err := NewStreamReader(root, name, opts)
+		require.NoError(t, err)
+
+		return read(r)
+	}
+
+	n.It("reads each stream on its own", func() {
+		assert.Equal(t, []string{"a1", "a2"}, stream(path, "a", DefaultReadOptions))
+		assert.Equal(t, []string{"b1"}, stream(path, "b", DefaultReadOptions))
+		assert.Nil(t, stream(path, "c", DefaultReadOptions))
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"main"}, read(r))
+	})
+
+	n.It("reads streams while prefetching", func() {
+		opts := DefaultReadOptions
+		opts.Prefetch = 2
+
+		assert.Equal(t, []string{"a1", "a2"}, stream(path, "a", opts))
+	})
+
+	n.It("shares positions with the WAL", func() {
+		r, err := NewStreamReader(path, "a", DefaultReadOptions)
+		require.NoError(t, err)
+
+		require.True(t, r.Next())
+
+		pos, err := r.Pos()
+		require.NoError(t, err)
+
+		require.NoError(t, r.Close())
+
+		r, err = NewStreamReader(path, "a", DefaultReadOptions)
+		require.NoError(t, err)
+
+		require.NoError(t, r.Seek(pos))
+
+		assert.Equal(t, []string{"a2"}, read(r))
+	})
+
+	n.It("keeps streams when cloned", func() {
+		require.NoError(t, Clone(path, path+".clone"))
+
+		assert.Equal(t, []string{"a1", "a2"}, stream(path+".clone", "a", DefaultReadOptions))
+	})
+
+	n.It("rejects an empty name", func() {
+		_, err := NewStreamReader(path, "", DefaultReadOptions)
+		assert.Equal(t, ErrInvalidStreamName, err)
+	})
+
+	n.It("keeps tags for each stream", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		a, err := wal.Stream("a")
+		require.NoError(t, err)
+
+		b, err := wal.Stream("b")
+		require.NoError(t, err)
+
+		require.NoError(t, a.WriteTag([]byte("checkpoint")))
+		require.NoError(t, a.Write([]byte("a3")))
+		require.NoError(t, b.Write([]byte("b2")))
+		require.NoError(t, b.WriteTag([]byte("checkpoint")))
+		require.NoError(t, b.Write([]byte("b3")))
+
+		apos, err := a.TagPos([]byte("checkpoint"))
+		require.NoError(t, err)
+
+		bpos, err := b.TagPos([]byte("checkpoint"))
+		require.NoError(t, err)
+
+		assert.True(t, apos.less(bpos))
+
+		_, err = wal.TagPos([]byte("checkpoint"))
+		assert.Equal(t, ErrTagNotFound, err)
+
+		require.NoError(t, wal.Close())
+
+		r, err := BeginStreamRecovery(path, "a", []byte("checkpoint"), DefaultReadOptions)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"a3"}, read(r))
+
+		r, err = BeginStreamRecovery(path, "b", []byte("checkpoint"), DefaultReadOptions)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"b3"}, read(r))
+
+		r, err = BeginRecovery(path, []byte("checkpoint"))
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"main"}, read(r))
+
+		r, err = NewStreamReader(path, "a", DefaultReadOptions)
+		require.NoError(t, err)
+
+		pos, err := r.SeekTag([]byte("checkpoint"))
+		require.NoError(t, err)
+		assert.Equal(t, apos, pos)
+
+		r.Close()
+
+		wal, err = New(path)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		b, err = wal.Stream("b")
+		require.NoError(t, err)
+
+		pos, err = b.TagPos([]byte("checkpoint"))
+		require.NoError(t, err)
+		assert.Equal(t, bpos, pos)
+	})
+
+	n.Meow()
+}
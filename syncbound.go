@@ -0,0 +1,53 @@
+package wal
+
+import "sync/atomic"
+
+// unsynced returns how many bytes have been written to the segment
+// since it was last synced.
+func (s *SegmentWriter) unsynced() int64 {
+	n := atomic.LoadInt64(s.size) - atomic.LoadInt64(&s.synced)
+	if n < 0 {
+		return 0
+	}
+
+	return n
+}
+
+// markSynced records that the segment has been synced up to size. Syncs
+// can race with each other, so it never moves backwards.
+func (s *SegmentWriter) markSynced(size int64) {
+	for {
+		cur := atomic.LoadInt64(&s.synced)
+		if size <= cur || atomic.CompareAndSwapInt64(&s.synced, cur, size) {
+			return
+		}
+	}
+}
+
+// overUnsynced reports whether a segment synced in the background has
+// fallen far enough behind that the next write must sync it, counting
+// the escalation if so.
+func (s *SegmentWriter) overUnsynced() bool {
+	if s.maxUnsynced <= 0 || s.unsynced() < s.maxUnsynced {
+		return false
+	}
+
+	s.metrics.IncrCounter(MetricEscalatedSyncs, 1)
+	s.syncs.escalate()
+
+	return true
+}
+
+func (s *syncStats) escalate() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.escalated++
+}
+
+func (s *syncStats) escalations() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.escalated
+}
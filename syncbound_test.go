@@ -0,0 +1,85 @@
+package wal
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestMaxUnsyncedBytes(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	n.It("syncs on write once too much is unsynced", func() {
+		metrics := &testMetrics{}
+
+		wal, err := New(path, WithSyncRate(time.Hour), WithMaxUnsyncedBytes(100), WithMetrics(metrics))
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		require.NoError(t, wal.Write([]byte("hello")))
+
+		st, err := wal.Stats()
+		require.NoError(t, err)
+
+		assert.True(t, st.Unsynced > 0)
+		assert.Equal(t, int64(0), st.EscalatedSyncs)
+		assert.True(t, st.LastSync.IsZero())
+
+		for st.EscalatedSyncs == 0 {
+			require.NoError(t, wal.Write([]byte("hello")))
+
+			st, err = wal.Stats()
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, int64(1), st.EscalatedSyncs)
+		assert.Equal(t, int64(0), st.Unsynced)
+		assert.False(t, st.LastSync.IsZero())
+		assert.Equal(t, int64(1), metrics.counter(MetricEscalatedSyncs))
+	})
+
+	n.It("leaves syncing to the background without a bound", func() {
+		wal, err := New(path, WithSyncRate(time.Hour))
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		for i := 0; i < 50; i++ {
+			require.NoError(t, wal.Write([]byte("hello")))
+		}
+
+		st, err := wal.Stats()
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(0), st.EscalatedSyncs)
+		assert.Equal(t, st.SegmentSize, st.Unsynced)
+	})
+
+	n.It("must not be negative", func() {
+		wo := DefaultWriteOptions
+		wo.MaxUnsyncedBytes = -1
+
+		assert.True(t, errors.Is(wo.Validate(), ErrInvalidOptions))
+	})
+
+	n.Meow()
+}
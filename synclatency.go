@@ -0,0 +1,88 @@
+package wal
+
+import "time"
+
+// The upper bounds of the buckets of SyncHistogram. Syncs slower than
+// the last go in a final, unbounded bucket.
+var syncBuckets = []time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+}
+
+// SyncHistogram counts syncs by how long they took. Counts[i] is the
+// number that took at most Bounds[i] and longer than Bounds[i-1]; the
+// last count, one more than there are bounds, is of those slower than
+// every bound.
+type SyncHistogram struct {
+	Bounds []time.Duration
+	Counts []int64
+}
+
+// SlowSync describes a sync that took longer than
+// WriteOptions.SlowSyncThreshold.
+type SlowSync struct {
+	// The segment that was synced.
+	Path string
+
+	Duration time.Duration
+
+	// Set if the sync failed.
+	Err error
+}
+
+// observe adds a sync that took dur to the histogram, reporting whether
+// it was slow. The lock must be held.
+func (s *syncStats) observe(dur time.Duration) bool {
+	if s.counts == nil {
+		s.counts = make([]int64, len(syncBuckets)+1)
+	}
+
+	i := 0
+	for i < len(syncBuckets) && dur > syncBuckets[i] {
+		i++
+	}
+
+	s.counts[i]++
+
+	if s.slowThreshold <= 0 || dur < s.slowThreshold {
+		return false
+	}
+
+	s.slow++
+
+	return true
+}
+
+// histogram returns a copy of the latency histogram.
+func (s *syncStats) histogram() (SyncHistogram, int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	h := SyncHistogram{
+		Bounds: append([]time.Duration(nil), syncBuckets...),
+		Counts: make([]int64, len(syncBuckets)+1),
+	}
+
+	copy(h.Counts, s.counts)
+
+	return h, s.slow
+}
+
+// reportSlow tells the WAL about a slow sync of the segment.
+func (s *SegmentWriter) reportSlow(dur time.Duration, err error) {
+	s.metrics.IncrCounter(MetricSlowSyncs, 1)
+	s.logger.Warn("slow sync", "path", s.f.Name(), "duration", dur, "error", err)
+
+	if fn := s.syncs.onSlow; fn != nil {
+		fn(SlowSync{Path: s.f.Name(), Duration: dur, Err: err})
+	}
+}
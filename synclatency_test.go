@@ -0,0 +1,118 @@
+package wal
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestSyncLatency(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	total := func(h SyncHistogram) int64 {
+		var n int64
+
+		for _, c := range h.Counts {
+			n += c
+		}
+
+		return n
+	}
+
+	n.It("counts syncs in the latency histogram", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		for i := 0; i < 3; i++ {
+			require.NoError(t, wal.Write([]byte("hello")))
+		}
+
+		st, err := wal.Stats()
+		require.NoError(t, err)
+
+		assert.Equal(t, len(st.SyncLatency.Bounds)+1, len(st.SyncLatency.Counts))
+		assert.True(t, total(st.SyncLatency) >= 3)
+		assert.Equal(t, int64(0), st.SlowSyncs)
+	})
+
+	n.It("reports syncs that take at least the threshold", func() {
+		var (
+			lock sync.Mutex
+			slow []SlowSync
+		)
+
+		metrics := &testMetrics{}
+
+		wal, err := New(path, WithMetrics(metrics), WithSlowSync(time.Nanosecond, func(s SlowSync) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			slow = append(slow, s)
+		}))
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		require.NoError(t, wal.Write([]byte("hello")))
+
+		st, err := wal.Stats()
+		require.NoError(t, err)
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		require.True(t, len(slow) > 0)
+
+		assert.Equal(t, filepath.Join(path, "0"), slow[0].Path)
+		assert.True(t, slow[0].Duration > 0)
+		assert.NoError(t, slow[0].Err)
+
+		assert.Equal(t, int64(len(slow)), st.SlowSyncs)
+		assert.Equal(t, st.SlowSyncs, metrics.counter(MetricSlowSyncs))
+	})
+
+	n.It("buckets syncs by their duration", func() {
+		var s syncStats
+
+		s.observe(500 * time.Microsecond)
+		s.observe(time.Millisecond)
+		s.observe(3 * time.Millisecond)
+		s.observe(time.Minute)
+
+		h, _ := s.histogram()
+
+		assert.Equal(t, int64(2), h.Counts[0])
+		assert.Equal(t, int64(1), h.Counts[2])
+		assert.Equal(t, int64(1), h.Counts[len(h.Counts)-1])
+	})
+
+	n.It("rejects a negative threshold", func() {
+		wo := DefaultWriteOptions
+		wo.SlowSyncThreshold = -time.Second
+
+		assert.True(t, errors.Is(wo.Validate(), ErrInvalidOptions))
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,88 @@
+package wal
+
+import "os"
+
+// TagHistory returns every position tag was written at that's still in
+// the WAL, newest first, along with the payloads it was written with.
+// Seeking to one of the positions reads on from that checkpoint, so an
+// application can roll back to an earlier one when the latest turns out
+// to be bad. A stream reader looks for the stream's tags. It returns
+// ErrTagNotFound if the tag isn't in any segment.
+//
+// Every segment is read. The reader's position is left as it was.
+func (wal *WALReader) TagHistory(tag []byte) ([]TagInfo, error) {
+	if wal.closed {
+		return nil, ErrClosed
+	}
+
+	first, last, err := rangeSegments(fsOrOS(wal.opts.FS), wal.root)
+	if err != nil {
+		return nil, err
+	}
+
+	var history []TagInfo
+
+	// With no segments, first and last are both -1.
+	for index := last; index >= 0 && index >= first; index-- {
+		seg, err := wal.openSegment(index)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		var found []TagInfo
+
+		err = seg.eachTag(func(pos int64, name, payload []byte) {
+			if string(name) != string(tag) {
+				return
+			}
+
+			info := TagInfo{Tag: tag, Pos: Position{index, pos}}
+			if payload != nil {
+				info.Payload = append([]byte(nil), payload...)
+			}
+
+			found = append(found, info)
+		})
+
+		seg.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		for i := len(found) - 1; i >= 0; i-- {
+			history = append(history, found[i])
+		}
+	}
+
+	if len(history) == 0 {
+		return nil, ErrTagNotFound
+	}
+
+	return history, nil
+}
+
+// TagHistory returns every position tag was written at that's still in
+// the WAL, newest first. See WALReader.TagHistory.
+func (wal *WALWriter) TagHistory(tag []byte) ([]TagInfo, error) {
+	wal.lock.Lock()
+	closed := wal.closed
+	wal.lock.Unlock()
+
+	if closed {
+		return nil, ErrClosed
+	}
+
+	r, err := newReader(wal.root, nil, ReadOptions{FS: wal.opts.FS})
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	return r.TagHistory(tag)
+}
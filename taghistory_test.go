@@ -0,0 +1,89 @@
+package wal
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestTagHistory(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	n.It("returns every position a tag was written at, newest first", func() {
+		opts := DefaultWriteOptions
+		opts.SegmentSize = 200
+		opts.MaxSegments = 100
+		opts.NoCompression = true
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		var positions []Position
+
+		for i := 0; i < 3; i++ {
+			pos, err := wal.Pos()
+			require.NoError(t, err)
+
+			positions = append([]Position{pos}, positions...)
+
+			require.NoError(t, wal.WriteTagPayload([]byte("checkpoint"), []byte{byte('a' + i)}))
+			require.NoError(t, wal.WriteTag([]byte("other")))
+			require.NoError(t, wal.Write(bytes.Repeat([]byte{byte('0' + i)}, 150)))
+		}
+
+		require.NoError(t, wal.Close())
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		history, err := r.TagHistory([]byte("checkpoint"))
+		require.NoError(t, err)
+
+		require.Equal(t, 3, len(history))
+
+		for i, info := range history {
+			assert.Equal(t, "checkpoint", string(info.Tag))
+			assert.Equal(t, positions[i], info.Pos)
+			assert.Equal(t, string([]byte{byte('c' - i)}), string(info.Payload))
+		}
+
+		// Roll back to the checkpoint before the latest.
+		require.NoError(t, r.Seek(history[1].Pos))
+
+		require.True(t, r.Next())
+		assert.Equal(t, bytes.Repeat([]byte("1"), 150), r.Value())
+	})
+
+	n.It("returns ErrTagNotFound for a tag that was never written", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		require.NoError(t, wal.WriteTag([]byte("other")))
+
+		_, err = wal.TagHistory([]byte("checkpoint"))
+		assert.Equal(t, ErrTagNotFound, err)
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,55 @@
+package wal
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+)
+
+var errMalformedTagPayload = errors.New("malformed tag payload")
+
+// WriteTagPayload is like WriteTag, but writes payload along with the
+// tag, such as the metadata recovery needs to resume from a checkpoint.
+// SeekTagPayload returns it.
+func (wal *WALWriter) WriteTagPayload(tag, payload []byte) error {
+	return wal.WriteTagPayloadContext(context.Background(), tag, payload)
+}
+
+// WriteTagPayloadContext is like WriteTagPayload, but any span created
+// for the write is a child of the one in ctx.
+func (wal *WALWriter) WriteTagPayloadContext(ctx context.Context, tag, payload []byte) error {
+	return wal.writeTag(ctx, payloadTagType, frameTagPayload(tag, payload), tagKey(nil, tag))
+}
+
+// frameTagPayload prefixes payload with tag, framed like a stream entry.
+func frameTagPayload(tag, payload []byte) []byte {
+	ent := make([]byte, 0, binary.MaxVarintLen64+len(tag)+len(payload))
+	ent = binary.AppendUvarint(ent, uint64(len(tag)))
+	ent = append(ent, tag...)
+
+	return append(ent, payload...)
+}
+
+// SeekTagPayload is like SeekTag, but also returns the payload the tag
+// was last written with, which is nil if it was written with WriteTag.
+func (wal *WALReader) SeekTagPayload(tag []byte) (Position, []byte, error) {
+	_, span := tracerOrNop(wal.opts.Tracer).Start(context.Background(), SpanSeekTag)
+
+	pos, payload, err := wal.seekTag(tag)
+
+	endSpan(span, err)
+
+	return pos, payload, err
+}
+
+// Tag returns the tag and payload of a tag record. The payload is nil
+// for tags written without one.
+func (r Record) Tag() ([]byte, []byte) {
+	if r.Type != RecordPayloadTag {
+		return r.Value, nil
+	}
+
+	tag, payload, _ := splitStreamEntry(r.Value)
+
+	return tag, payload
+}
@@ -0,0 +1,136 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestTagPayload(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	n.It("returns the payload the tag was last written with", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.WriteTagPayload([]byte("checkpoint"), []byte("first")))
+		require.NoError(t, wal.Write([]byte("hello")))
+
+		pos, err := wal.Pos()
+		require.NoError(t, err)
+
+		require.NoError(t, wal.WriteTagPayload([]byte("checkpoint"), []byte("second")))
+		require.NoError(t, wal.Write([]byte("world")))
+
+		tpos, err := wal.TagPos([]byte("checkpoint"))
+		require.NoError(t, err)
+
+		assert.Equal(t, pos, tpos)
+
+		require.NoError(t, wal.Close())
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		rpos, payload, err := r.SeekTagPayload([]byte("checkpoint"))
+		require.NoError(t, err)
+
+		assert.Equal(t, pos, rpos)
+		assert.Equal(t, "second", string(payload))
+
+		require.True(t, r.Next())
+		assert.Equal(t, "world", string(r.Value()))
+	})
+
+	n.It("returns no payload for a plain tag", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.WriteTagPayload([]byte("checkpoint"), []byte("old")))
+		require.NoError(t, wal.WriteTag([]byte("checkpoint")))
+		require.NoError(t, wal.Close())
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		_, payload, err := r.SeekTagPayload([]byte("checkpoint"))
+		require.NoError(t, err)
+
+		assert.Nil(t, payload)
+	})
+
+	n.It("keeps payload tags out of the entries", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("hello")))
+		require.NoError(t, wal.WriteTagPayload([]byte("checkpoint"), []byte("meta")))
+		require.NoError(t, wal.Close())
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		var values []string
+
+		for r.Next() {
+			values = append(values, string(r.Value()))
+		}
+
+		require.NoError(t, r.Error())
+		assert.Equal(t, []string{"hello"}, values)
+	})
+
+	n.It("splits the payload from a tag record", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.WriteTag([]byte("plain")))
+		require.NoError(t, wal.WriteTagPayload([]byte("checkpoint"), []byte("meta")))
+		require.NoError(t, wal.Close())
+
+		var recs []Record
+
+		err = ScanRecords(path, Position{}, func(rec Record) error {
+			rec.Value = append([]byte(nil), rec.Value...)
+			recs = append(recs, rec)
+			return nil
+		})
+		require.NoError(t, err)
+
+		require.Equal(t, 2, len(recs))
+
+		tag, payload := recs[0].Tag()
+		assert.Equal(t, "plain", string(tag))
+		assert.Nil(t, payload)
+
+		assert.Equal(t, RecordPayloadTag, recs[1].Type)
+
+		tag, payload = recs[1].Tag()
+		assert.Equal(t, "checkpoint", string(tag))
+		assert.Equal(t, "meta", string(payload))
+	})
+
+	n.Meow()
+}
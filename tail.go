@@ -0,0 +1,87 @@
+package wal
+
+import (
+	"context"
+	"time"
+)
+
+// How often Tail checks for new entries if TailOptions.PollInterval
+// isn't set.
+const DefaultTailInterval = 100 * time.Millisecond
+
+// How many times in a row Tail retries reading an entry, which may still
+// be being written, before giving up.
+const maxTailRetries = 10
+
+// TailOptions controls how Tail follows a WAL.
+type TailOptions struct {
+	// How often to check for new entries once everything written so far
+	// has been read. If 0, DefaultTailInterval is used.
+	PollInterval time.Duration
+
+	// If set, called each time everything written so far has been read,
+	// before waiting for more, such as to flush what fn has buffered.
+	CaughtUp func()
+}
+
+// Tail calls fn with each entry from the reader's position on, along
+// with the position just after it, and then with each entry written
+// after that, until ctx is done or fn returns an error. It returns nil
+// once ctx is done.
+//
+// An entry that can't be read may be one that's still being written, so
+// Tail goes back to the end of the last entry it read and tries again,
+// only giving up, and returning the error, after failing to read one
+// several times in a row.
+func (r *WALReader) Tail(ctx context.Context, opts TailOptions, fn func(Position, []byte) error) error {
+	interval := opts.PollInterval
+	if interval <= 0 {
+		interval = DefaultTailInterval
+	}
+
+	last, err := r.Pos()
+	if err != nil {
+		return err
+	}
+
+	var failures int
+
+	for {
+		for r.Next() {
+			pos, err := r.Pos()
+			if err != nil {
+				return err
+			}
+
+			err = fn(pos, r.Value())
+			if err != nil {
+				return err
+			}
+
+			last = pos
+			failures = 0
+		}
+
+		if opts.CaughtUp != nil {
+			opts.CaughtUp()
+		}
+
+		if err := r.Error(); err != nil {
+			failures++
+			if failures >= maxTailRetries {
+				return err
+			}
+
+			err = r.Seek(last)
+			if err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
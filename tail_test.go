@@ -0,0 +1,123 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestTail(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	n.It("follows entries as they're written until the context is done", func() {
+		w, err := New(path)
+		require.NoError(t, err)
+
+		defer w.Close()
+
+		require.NoError(t, w.Write([]byte("first")))
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		var (
+			lock     sync.Mutex
+			got      []string
+			last     Position
+			caughtUp int
+		)
+
+		values := func() []string {
+			lock.Lock()
+			defer lock.Unlock()
+
+			return append([]string(nil), got...)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		opts := TailOptions{
+			PollInterval: time.Millisecond,
+			CaughtUp: func() {
+				lock.Lock()
+				caughtUp++
+				lock.Unlock()
+			},
+		}
+
+		done := make(chan error, 1)
+
+		go func() {
+			done <- r.Tail(ctx, opts, func(pos Position, value []byte) error {
+				lock.Lock()
+				got = append(got, string(value))
+				last = pos
+				lock.Unlock()
+
+				return nil
+			})
+		}()
+
+		require.NoError(t, w.Write([]byte("second")))
+
+		deadline := time.Now().Add(5 * time.Second)
+		for len(values()) < 2 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+
+		cancel()
+		require.NoError(t, <-done)
+
+		assert.Equal(t, []string{"first", "second"}, values())
+
+		end, err := w.Pos()
+		require.NoError(t, err)
+
+		assert.Equal(t, end, last)
+		assert.True(t, caughtUp > 0)
+	})
+
+	n.It("stops with the error fn returns", func() {
+		w, err := New(path)
+		require.NoError(t, err)
+
+		require.NoError(t, w.Write([]byte("first")))
+		require.NoError(t, w.Close())
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		stop := errors.New("stop")
+
+		err = r.Tail(context.Background(), TailOptions{}, func(pos Position, value []byte) error {
+			return stop
+		})
+		assert.Equal(t, stop, err)
+	})
+
+	n.Meow()
+}
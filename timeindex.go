@@ -0,0 +1,327 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// The suffix of the time index kept beside each segment.
+const timeIndexSuffix = ".time"
+
+// Each point in a time index is its time in Unix nanoseconds followed by
+// its offset in the segment, both big endian.
+const timePointSize = 16
+
+// timePoint records that every entry starting before Offset in its
+// segment was written before Time.
+type timePoint struct {
+	Time   time.Time
+	Offset int64
+}
+
+func timeIndexPath(root string, index int) string {
+	return filepath.Join(root, strconv.Itoa(index)+timeIndexSuffix)
+}
+
+// timeIndex appends points to the time index of the active segment: one
+// after its first entry, one every interval after that, and a last one
+// when the segment is sealed. The index only speeds up SeekTime, so
+// it's never synced and failing to write it doesn't fail the write.
+type timeIndex struct {
+	f        File
+	interval time.Duration
+
+	// When the last point was added, and when the last entry was
+	// written after it, if any.
+	last    time.Time
+	written time.Time
+}
+
+// openTimeIndex opens the time index of the active segment, if the WAL
+// keeps them.
+func (wal *WALWriter) openTimeIndex() {
+	if wal.opts.TimeIndexInterval <= 0 {
+		return
+	}
+
+	f, err := wal.fs.OpenFile(timeIndexPath(wal.root, wal.index), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		wal.logger.Warn("failed to open time index", "segment", wal.index, "error", err)
+		return
+	}
+
+	wal.times = &timeIndex{f: f, interval: wal.opts.TimeIndexInterval}
+}
+
+// indexTime is called after each entry or tag is written, adding a
+// point at the end of the active segment if one is due.
+func (wal *WALWriter) indexTime() {
+	ti := wal.times
+	if ti == nil {
+		return
+	}
+
+	now := time.Now()
+
+	if ti.last.IsZero() || now.Sub(ti.last) >= ti.interval {
+		wal.addTimePoint(now)
+		return
+	}
+
+	ti.written = now
+}
+
+func (wal *WALWriter) addTimePoint(now time.Time) {
+	ti := wal.times
+
+	var buf [timePointSize]byte
+
+	binary.BigEndian.PutUint64(buf[:8], uint64(now.UnixNano()))
+	binary.BigEndian.PutUint64(buf[8:], uint64(wal.segment.Size()))
+
+	_, err := ti.f.Write(buf[:])
+	if err != nil {
+		wal.logger.Warn("failed to write time index", "segment", wal.index, "error", err)
+	}
+
+	ti.last = now
+	ti.written = time.Time{}
+}
+
+// closeTimeIndex adds the last point to the active segment's time index,
+// covering the entries written since the one before, and closes it.
+func (wal *WALWriter) closeTimeIndex() {
+	ti := wal.times
+	if ti == nil {
+		return
+	}
+
+	if !ti.written.IsZero() {
+		wal.addTimePoint(ti.written)
+	}
+
+	ti.f.Close()
+
+	wal.times = nil
+}
+
+// readTimeIndex returns the points in the time index of a segment. A
+// segment without an index, such as one written without
+// TimeIndexInterval or copied by Clone, is treated as having one point
+// at its end, timed by when it was last modified.
+func readTimeIndex(fs FS, root string, index int) ([]timePoint, error) {
+	f, err := fs.OpenFile(timeIndexPath(root, index), os.O_RDONLY, 0)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		fi, err := fs.Stat(filepath.Join(root, strconv.Itoa(index)))
+		if err != nil {
+			return nil, err
+		}
+
+		return []timePoint{{Time: fi.ModTime(), Offset: fi.Size()}}, nil
+	}
+
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	// A point cut short by a crash is ignored.
+	points := make([]timePoint, len(data)/timePointSize)
+
+	for i := range points {
+		buf := data[i*timePointSize:]
+
+		points[i] = timePoint{
+			Time:   time.Unix(0, int64(binary.BigEndian.Uint64(buf[:8]))),
+			Offset: int64(binary.BigEndian.Uint64(buf[8:16])),
+		}
+	}
+
+	return points, nil
+}
+
+// SeekTime moves the reader so that the next entry read is the first
+// one written at or after t, or one written shortly before it. The
+// segments, and then the points within one, are binary searched using
+// the time indexes kept when TimeIndexInterval is set, so the entries
+// written before t are mostly never read: how far before t the reader
+// may end up is bounded by the interval. Without indexes, only whole
+// segments last modified before t are skipped.
+func (r *WALReader) SeekTime(t time.Time) error {
+	if r.closed {
+		return ErrClosed
+	}
+
+	fs := fsOrOS(r.opts.FS)
+
+	first, last, err := rangeSegments(fs, r.root)
+	if err != nil {
+		return err
+	}
+
+	if first == -1 {
+		return ErrNoSegments
+	}
+
+	ti := &timeIndexes{fs: fs, root: r.root}
+
+	// Find the last segment whose first point is before t. The ones
+	// before it hold nothing written at or after t.
+	n := sort.Search(last-first+1, func(i int) bool {
+		points := ti.load(first + i)
+		return len(points) == 0 || !points[0].Time.Before(t)
+	})
+
+	if ti.err != nil {
+		return ti.err
+	}
+
+	if n == 0 {
+		return r.Seek(Position{first, 0})
+	}
+
+	index := first + n - 1
+	points := ti.load(index)
+
+	if ti.err != nil {
+		return ti.err
+	}
+
+	i := sort.Search(len(points), func(i int) bool {
+		return !points[i].Time.Before(t)
+	})
+
+	return r.Seek(Position{index, points[i-1].Offset})
+}
+
+// timeLimit returns the position of the first point in any segment's
+// time index at or after t. Every entry written by t starts before it.
+// If there's no such point, it returns the end of the WAL.
+func (r *WALReader) timeLimit(t time.Time) (Position, error) {
+	fs := fsOrOS(r.opts.FS)
+
+	first, last, err := rangeSegments(fs, r.root)
+	if err != nil {
+		return Position{}, err
+	}
+
+	if first == -1 {
+		return Position{}, ErrNoSegments
+	}
+
+	ti := &timeIndexes{fs: fs, root: r.root}
+
+	// Find the first segment whose last point is at or after t.
+	n := sort.Search(last-first+1, func(i int) bool {
+		points := ti.load(first + i)
+		return len(points) > 0 && !points[len(points)-1].Time.Before(t)
+	})
+
+	if ti.err != nil {
+		return Position{}, ti.err
+	}
+
+	if n > last-first {
+		fi, err := fs.Stat(filepath.Join(r.root, strconv.Itoa(last)))
+		if err != nil {
+			return Position{}, err
+		}
+
+		return Position{last, fi.Size()}, nil
+	}
+
+	index := first + n
+	points := ti.load(index)
+
+	i := sort.Search(len(points), func(i int) bool {
+		return !points[i].Time.Before(t)
+	})
+
+	return Position{index, points[i].Offset}, nil
+}
+
+// timeIndexes reads the time indexes of a WAL's segments as they're
+// needed, keeping the first error.
+type timeIndexes struct {
+	fs   FS
+	root string
+
+	points map[int][]timePoint
+	err    error
+}
+
+func (ti *timeIndexes) load(index int) []timePoint {
+	if ti.err != nil {
+		return nil
+	}
+
+	points, ok := ti.points[index]
+	if !ok {
+		if ti.points == nil {
+			ti.points = map[int][]timePoint{}
+		}
+
+		points, ti.err = readTimeIndex(ti.fs, ti.root, index)
+		ti.points[index] = points
+	}
+
+	return points
+}
+
+// remapTimeIndex rewrites the time index of a segment whose records have
+// moved, such as by compaction, to the offsets in offsets. Points at
+// offsets that aren't in it are dropped.
+func remapTimeIndex(root string, index int, offsets map[int64]int64) error {
+	path := timeIndexPath(root, index)
+
+	_, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	points, err := readTimeIndex(OSFS, root, index)
+	if err != nil {
+		return err
+	}
+
+	var buf []byte
+
+	for _, p := range points {
+		off, ok := offsets[p.Offset]
+		if !ok {
+			continue
+		}
+
+		buf = binary.BigEndian.AppendUint64(buf, uint64(p.Time.UnixNano()))
+		buf = binary.BigEndian.AppendUint64(buf, uint64(off))
+	}
+
+	tmp := path + ".tmp"
+
+	err = copyToFile(tmp, bytes.NewReader(buf))
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+	}
+
+	return err
+}
@@ -0,0 +1,172 @@
+package wal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestTimeIndex(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	// writeTimed writes count entries a few milliseconds apart, returning
+	// the time just before each was written.
+	writeTimed := func(wal *WALWriter, count int) []time.Time {
+		var times []time.Time
+
+		for i := 0; i < count; i++ {
+			time.Sleep(2 * time.Millisecond)
+
+			times = append(times, time.Now())
+
+			require.NoError(t, wal.Write([]byte(fmt.Sprintf("entry%d", i))))
+		}
+
+		return times
+	}
+
+	next := func(r *WALReader) string {
+		require.True(t, r.Next())
+		return string(r.Value())
+	}
+
+	n.It("seeks to the first entry written at or after a time", func() {
+		wal, err := New(path, WithSegmentSize(64), WithMaxSegments(100), WithTimeIndex(time.Nanosecond))
+		require.NoError(t, err)
+
+		times := writeTimed(wal, 10)
+
+		require.NoError(t, wal.Close())
+
+		first, last, err := rangeSegments(OSFS, path)
+		require.NoError(t, err)
+		require.True(t, last > first)
+
+		_, err = os.Stat(timeIndexPath(path, first))
+		require.NoError(t, err)
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		for i, ts := range times {
+			require.NoError(t, r.SeekTime(ts))
+			assert.Equal(t, fmt.Sprintf("entry%d", i), next(r))
+		}
+
+		require.NoError(t, r.SeekTime(times[0].Add(-time.Hour)))
+		assert.Equal(t, "entry0", next(r))
+
+		require.NoError(t, r.SeekTime(time.Now()))
+		assert.False(t, r.Next())
+		require.NoError(t, r.Error())
+	})
+
+	n.It("lands at most an interval before the time", func() {
+		wal, err := New(path, WithTimeIndex(time.Hour))
+		require.NoError(t, err)
+
+		times := writeTimed(wal, 5)
+
+		require.NoError(t, wal.Close())
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		// Only the first entry and the segment's end are indexed.
+		require.NoError(t, r.SeekTime(times[3]))
+		assert.Equal(t, "entry1", next(r))
+
+		require.NoError(t, r.SeekTime(time.Now()))
+		assert.False(t, r.Next())
+	})
+
+	n.It("indexes the active segment as it's written", func() {
+		wal, err := New(path, WithTimeIndex(time.Nanosecond))
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		times := writeTimed(wal, 3)
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.NoError(t, r.SeekTime(times[2]))
+		assert.Equal(t, "entry2", next(r))
+	})
+
+	n.It("skips whole segments without an index", func() {
+		// One entry per segment.
+		wal, err := New(path, WithSegmentSize(24), WithMaxSegments(100), WithCompression(false))
+		require.NoError(t, err)
+
+		writeTimed(wal, 2)
+
+		// Leave room for the coarse clock some filesystems time
+		// modifications with.
+		time.Sleep(20 * time.Millisecond)
+		mid := time.Now()
+		time.Sleep(20 * time.Millisecond)
+
+		require.NoError(t, wal.Write([]byte("late")))
+
+		require.NoError(t, wal.Close())
+
+		_, err = os.Stat(timeIndexPath(path, 0))
+		assert.True(t, os.IsNotExist(err))
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		// The second segment is sealed, and so modified, after mid.
+		require.NoError(t, r.SeekTime(mid))
+		assert.Equal(t, "entry1", next(r))
+	})
+
+	n.It("prunes the index with its segment", func() {
+		wal, err := New(path, WithSegmentSize(64), WithMaxSegments(2), WithTimeIndex(time.Nanosecond))
+		require.NoError(t, err)
+
+		writeTimed(wal, 10)
+
+		require.NoError(t, wal.Close())
+
+		first, _, err := rangeSegments(OSFS, path)
+		require.NoError(t, err)
+		require.True(t, first > 0)
+
+		_, err = os.Stat(timeIndexPath(path, first-1))
+		assert.True(t, os.IsNotExist(err))
+
+		_, err = os.Stat(timeIndexPath(path, first))
+		assert.NoError(t, err)
+	})
+
+	n.Meow()
+}
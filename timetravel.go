@@ -0,0 +1,39 @@
+package wal
+
+import "time"
+
+// OpenReaderAt opens a reader of the WAL in root positioned at from, as
+// by SeekTime, for looking into what was written around a given time.
+// If until isn't zero, the reader also stops, as it would at the end of
+// the WAL, after the entries written by until, so replaying a window of
+// time takes one call.
+//
+// Both ends are found with the time indexes kept when TimeIndexInterval
+// is set, so they're only as precise as that interval: the reader may
+// start with some entries written shortly before from and end with some
+// written shortly after until. Without indexes, whole segments are the
+// unit.
+func OpenReaderAt(root string, from, until time.Time, opts ReadOptions) (*WALReader, error) {
+	r, err := newReader(root, nil, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if !until.IsZero() {
+		limit, err := r.timeLimit(until)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+
+		r.tail = &limit
+	}
+
+	err = r.SeekTime(from)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
@@ -0,0 +1,104 @@
+package wal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestOpenReaderAt(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	// write writes 10 entries a few milliseconds apart across several
+	// segments, returning the time just before each was written.
+	write := func() []time.Time {
+		wal, err := New(path, WithSegmentSize(64), WithMaxSegments(100), WithTimeIndex(time.Nanosecond))
+		require.NoError(t, err)
+
+		var times []time.Time
+
+		for i := 0; i < 10; i++ {
+			time.Sleep(2 * time.Millisecond)
+
+			times = append(times, time.Now())
+
+			require.NoError(t, wal.Write([]byte(fmt.Sprintf("entry%d", i))))
+		}
+
+		require.NoError(t, wal.Close())
+
+		return times
+	}
+
+	read := func(r *WALReader) []string {
+		var values []string
+
+		for r.Next() {
+			values = append(values, string(r.Value()))
+		}
+
+		require.NoError(t, r.Error())
+
+		return values
+	}
+
+	n.It("reads from a time to the end", func() {
+		times := write()
+
+		r, err := OpenReaderAt(path, times[7], time.Time{}, DefaultReadOptions)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		assert.Equal(t, []string{"entry7", "entry8", "entry9"}, read(r))
+	})
+
+	n.It("reads a window of time", func() {
+		times := write()
+
+		r, err := OpenReaderAt(path, times[3], times[6], DefaultReadOptions)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		// The entry being written at the end of the window is the only
+		// one the index can't place, so it's included.
+		assert.Equal(t, []string{"entry3", "entry4", "entry5", "entry6"}, read(r))
+	})
+
+	n.It("reads to the end when the window does", func() {
+		times := write()
+
+		r, err := OpenReaderAt(path, times[8], time.Now().Add(time.Hour), DefaultReadOptions)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		assert.Equal(t, []string{"entry8", "entry9"}, read(r))
+	})
+
+	n.It("fails without segments", func() {
+		_, err := OpenReaderAt(path, time.Now(), time.Time{}, DefaultReadOptions)
+		assert.Error(t, err)
+	})
+
+	n.Meow()
+}
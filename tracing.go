@@ -0,0 +1,59 @@
+package wal
+
+import "context"
+
+// Tracer starts spans around WAL operations so their latency shows up
+// in distributed traces. It's the small subset of a tracer this package
+// needs, which keeps tracing libraries an optional dependency; the
+// walotel package adapts an OpenTelemetry TracerProvider to it.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is an operation started by a Tracer.
+type Span interface {
+	// SetError records that the operation failed.
+	SetError(err error)
+
+	// End completes the span.
+	End()
+}
+
+// The names of the spans started by the package.
+const (
+	SpanWrite    = "wal.Write"
+	SpanWriteTag = "wal.WriteTag"
+	SpanSync     = "wal.Sync"
+	SpanRotate   = "wal.Rotate"
+	SpanSeekTag  = "wal.SeekTag"
+	SpanRecover  = "wal.Recover"
+)
+
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetError(err error) {}
+
+func (nopSpan) End() {}
+
+func tracerOrNop(t Tracer) Tracer {
+	if t == nil {
+		return nopTracer{}
+	}
+
+	return t
+}
+
+// endSpan records err on span, if there is one, and ends it.
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.SetError(err)
+	}
+
+	span.End()
+}
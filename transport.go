@@ -0,0 +1,124 @@
+package wal
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Transport carries replication from a primary to a replica, so that a
+// WAL can be replicated over whatever already connects them, such as
+// NATS, SSH or an RPC system of the application's own. walgrpc and
+// walhttp each provide one, which serve as examples of writing others.
+//
+// A Transport is also a SegmentSource, so a replica's readers can fetch
+// segments it's missing from the primary with ReadOptions.Source.
+type Transport interface {
+	// Follow calls fn with each record of the primary's WAL after from,
+	// along with the position just after it, until ctx is done, fn
+	// returns an error, or the connection fails. If from's segment is
+	// -1, it starts at the beginning of the WAL. It returns nil when ctx
+	// is done, and fn's error unchanged if fn fails.
+	Follow(ctx context.Context, from Position, fn func(pos Position, value []byte) error) error
+
+	// FetchSegment writes the contents of the primary's sealed segment
+	// index to w.
+	FetchSegment(ctx context.Context, index int, w io.Writer) error
+
+	// Head reports where the primary's WAL currently ends, for seeing
+	// how far behind a replica is.
+	Head(ctx context.Context) (Position, error)
+}
+
+// How long a Replicator waits before reconnecting after its transport
+// fails.
+const DefaultReplicateRetryInterval = time.Second
+
+// Replicator follows a primary's WAL over a Transport, reconnecting
+// whenever the transport fails and resuming just after the last record
+// handled.
+type Replicator struct {
+	t Transport
+
+	lock sync.Mutex
+	pos  Position
+
+	// How long to wait before reconnecting after the transport fails.
+	RetryInterval time.Duration
+
+	// Logs each time the transport fails, along with where following
+	// will resume. If nil, nothing is logged.
+	Logger *slog.Logger
+}
+
+// NewReplicator returns a Replicator that follows the WAL at the other
+// end of t, starting after from. Pass Position{-1, -1} to start at the
+// beginning.
+func NewReplicator(t Transport, from Position) *Replicator {
+	return &Replicator{
+		t:             t,
+		pos:           from,
+		RetryInterval: DefaultReplicateRetryInterval,
+	}
+}
+
+// Pos returns the position just after the last record handled, which
+// is where following resumes from.
+func (r *Replicator) Pos() Position {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.pos
+}
+
+// Follow calls fn with each record and the position just after it until
+// ctx is done or fn returns an error, which Follow then returns. If the
+// transport fails, it logs the failure to Logger, waits RetryInterval
+// and resumes just after the last record fn handled without error. It
+// returns nil when ctx is done.
+func (r *Replicator) Follow(ctx context.Context, fn func(pos Position, value []byte) error) error {
+	for {
+		var ferr error
+
+		err := r.t.Follow(ctx, r.Pos(), func(pos Position, value []byte) error {
+			ferr = fn(pos, value)
+			if ferr != nil {
+				return ferr
+			}
+
+			r.lock.Lock()
+			r.pos = pos
+			r.lock.Unlock()
+
+			return nil
+		})
+
+		if ferr != nil {
+			return ferr
+		}
+
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err != nil {
+			loggerOrDiscard(r.Logger).Warn("replication transport failed", "position", r.Pos(), "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(r.RetryInterval):
+		}
+	}
+}
+
+// Replicate writes every record followed to w, keeping it in sync with
+// the primary's WAL until ctx is done.
+func (r *Replicator) Replicate(ctx context.Context, w *WALWriter) error {
+	return r.Follow(ctx, func(pos Position, value []byte) error {
+		return w.WriteContext(ctx, value)
+	})
+}
@@ -0,0 +1,173 @@
+package wal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+// dirTransport follows a WAL directory directly, dropping the
+// connection after every limit records.
+type dirTransport struct {
+	root  string
+	limit int
+
+	starts []Position
+}
+
+var errDropped = errors.New("connection dropped")
+
+func (t *dirTransport) Follow(ctx context.Context, from Position, fn func(Position, []byte) error) error {
+	t.starts = append(t.starts, from)
+
+	r, err := NewReader(t.root)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	if !from.None() {
+		err = r.Seek(from)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := 0; r.Next(); i++ {
+		if i == t.limit {
+			return errDropped
+		}
+
+		pos, err := r.Pos()
+		if err != nil {
+			return err
+		}
+
+		err = fn(pos, r.Value())
+		if err != nil {
+			return err
+		}
+	}
+
+	<-ctx.Done()
+
+	return nil
+}
+
+func (t *dirTransport) FetchSegment(ctx context.Context, index int, w io.Writer) error {
+	f, err := os.Open(filepath.Join(t.root, fmt.Sprint(index)))
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func (t *dirTransport) Head(ctx context.Context) (Position, error) {
+	return Position{-1, -1}, nil
+}
+
+func TestReplicator(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	primary := filepath.Join(dir, "primary")
+	replica := filepath.Join(dir, "replica")
+
+	n.Setup(func() {
+		os.RemoveAll(primary)
+		os.RemoveAll(replica)
+
+		w, err := New(primary)
+		require.NoError(t, err)
+
+		for i := 0; i < 5; i++ {
+			require.NoError(t, w.Write([]byte(fmt.Sprintf("entry%d", i))))
+		}
+
+		require.NoError(t, w.Close())
+	})
+
+	n.It("resumes after the last record when the transport fails", func() {
+		tr := &dirTransport{root: primary, limit: 2}
+
+		var buf bytes.Buffer
+
+		rep := NewReplicator(tr, Position{-1, -1})
+		rep.RetryInterval = 0
+		rep.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+
+		w, err := New(replica)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var positions []Position
+
+		err = rep.Follow(ctx, func(pos Position, value []byte) error {
+			positions = append(positions, pos)
+			if len(positions) == 5 {
+				cancel()
+			}
+
+			return w.Write(value)
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, []Position{{-1, -1}, positions[1], positions[3]}, tr.starts)
+		assert.Equal(t, positions[4], rep.Pos())
+
+		assert.Equal(t, 2, strings.Count(buf.String(), "replication transport failed"))
+		assert.Contains(t, buf.String(), errDropped.Error())
+
+		r, err := NewReader(replica)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		var values []string
+
+		for r.Next() {
+			values = append(values, string(r.Value()))
+		}
+
+		assert.Equal(t, []string{"entry0", "entry1", "entry2", "entry3", "entry4"}, values)
+	})
+
+	n.It("stops with the error of the callback", func() {
+		rep := NewReplicator(&dirTransport{root: primary, limit: -1}, Position{-1, -1})
+
+		stop := errors.New("stop")
+
+		err := rep.Follow(context.Background(), func(pos Position, value []byte) error {
+			return stop
+		})
+		assert.Equal(t, stop, err)
+
+		assert.Equal(t, Position{-1, -1}, rep.Pos())
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,136 @@
+package wal
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var errBadExpiringEntry = errors.New("malformed expiring entry")
+
+// WriteTTL writes data as an entry that expires after ttl. Readers
+// return it like any other entry, with its expiry in Header().Expires,
+// until it's dropped by CompactExpired. That lets an event buffer age
+// out individual entries before whole segments are pruned. Segments
+// holding such entries can't be read by versions of this package that
+// predate it.
+func (wal *WALWriter) WriteTTL(data []byte, ttl time.Duration) error {
+	return wal.WriteTTLContext(context.Background(), data, ttl)
+}
+
+// WriteTTLContext is like WriteTTL, but any span created for the write
+// is a child of the one in ctx.
+func (wal *WALWriter) WriteTTLContext(ctx context.Context, data []byte, ttl time.Duration) error {
+	body := make([]byte, 8, 8+len(data))
+
+	binary.BigEndian.PutUint64(body, uint64(time.Now().Add(ttl).UnixNano()))
+
+	return wal.writeEntry(ctx, expiringType, append(body, data...))
+}
+
+// splitExpiry splits the body of an expiring entry into its expiry and
+// its value.
+func splitExpiry(body []byte) (time.Time, []byte, error) {
+	if len(body) < 8 {
+		return time.Time{}, nil, errBadExpiringEntry
+	}
+
+	return time.Unix(0, int64(binary.BigEndian.Uint64(body))), body[8:], nil
+}
+
+// unexpired keeps every record but the expiring entries that have
+// expired by now.
+func unexpired(now time.Time) recordFilter {
+	return func(t byte, pos Position, value []byte) bool {
+		if t != expiringType {
+			return true
+		}
+
+		expires, _, err := splitExpiry(value)
+
+		return err != nil || expires.After(now)
+	}
+}
+
+// CompactExpired rewrites the segments of the WAL in root without the
+// entries written with WriteTTL that have expired by now, as Compact
+// does, taking the same lock. The WAL must not be open for writing; use
+// WALWriter.CompactExpired for one that is.
+func CompactExpired(root string, now time.Time, opts ...Option) (CompactStats, error) {
+	lockf, err := lockRoot(root, opts)
+	if err != nil {
+		return CompactStats{}, err
+	}
+
+	defer lockf.Close()
+
+	return compactSegments(root, unexpired(now))
+}
+
+// CompactExpired drops the expired entries from the WAL's sealed
+// segments, one segment at a time, blocking writes while each is
+// rewritten. The active segment is left alone. Call it periodically to
+// keep expired entries from taking up space. As with Compact, the
+// offsets of the entries in a compacted segment change; readers that
+// already have a segment open carry on reading the old copy.
+//
+// If WriteOptions.MaintenanceGate closes part way through, the segments
+// not yet compacted are left for the next call.
+func (wal *WALWriter) CompactExpired() (CompactStats, error) {
+	keep := unexpired(time.Now())
+
+	var (
+		stats CompactStats
+		index int
+		err   error
+	)
+
+	for index != -1 && wal.maintenanceOpen() {
+		index, err = wal.compactSealed(index, keep, &stats)
+		if err != nil {
+			return stats, err
+		}
+	}
+
+	return stats, writeCompactManifest(wal.root, &stats)
+}
+
+// compactSealed compacts segment index, or the first segment if that's
+// been pruned, returning the next one to compact, or -1 once only the
+// active segment is left.
+func (wal *WALWriter) compactSealed(index int, keep recordFilter, stats *CompactStats) (int, error) {
+	wal.lockIO()
+	defer wal.unlockIO()
+
+	if wal.closed {
+		return -1, ErrClosed
+	}
+
+	if index < wal.first {
+		index = wal.first
+	}
+
+	if index >= wal.index {
+		return -1, nil
+	}
+
+	before := *stats
+
+	err := compactSegment(wal.root, index, keep, stats)
+	if err != nil {
+		return -1, fmt.Errorf("segment %d: %w", index, err)
+	}
+
+	if stats.Segments == before.Segments {
+		return index + 1, nil
+	}
+
+	freed := stats.Bytes - before.Bytes
+
+	wal.segSizes[index] -= freed
+	wal.sealedBytes -= freed
+
+	return index + 1, syncDir(wal.root)
+}
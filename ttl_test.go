@@ -0,0 +1,190 @@
+package wal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestTTL(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+		os.RemoveAll(path + ".clone")
+	})
+
+	read := func(opts ReadOptions) ([]string, []time.Time) {
+		r, err := NewReaderWithOptions(path, opts)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		var (
+			values  []string
+			expires []time.Time
+		)
+
+		for r.Next() {
+			values = append(values, string(r.Value()))
+			expires = append(expires, r.Header().Expires)
+		}
+
+		require.NoError(t, r.Error())
+
+		return values, expires
+	}
+
+	// writeMixed writes entries to segments of about one entry each,
+	// alternating between ones that expire at once and ones that don't.
+	writeMixed := func(wal *WALWriter) {
+		for i := 0; i < 6; i++ {
+			if i%2 == 0 {
+				require.NoError(t, wal.WriteTTL([]byte(fmt.Sprintf("short%d", i)), time.Nanosecond))
+			} else {
+				require.NoError(t, wal.WriteTTL([]byte(fmt.Sprintf("long%d", i)), time.Hour))
+			}
+		}
+
+		require.NoError(t, wal.Write([]byte("plain")))
+	}
+
+	n.It("reads entries with their expiry", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		before := time.Now()
+
+		require.NoError(t, wal.WriteTTL([]byte("a"), time.Hour))
+		require.NoError(t, wal.Write([]byte("b")))
+		require.NoError(t, wal.Close())
+
+		for _, prefetch := range []int{0, 4} {
+			opts := DefaultReadOptions
+			opts.Prefetch = prefetch
+
+			values, expires := read(opts)
+
+			assert.Equal(t, []string{"a", "b"}, values)
+			assert.False(t, expires[0].Before(before.Add(time.Hour)))
+			assert.True(t, expires[1].IsZero())
+		}
+	})
+
+	n.It("drops expired entries offline", func() {
+		wal, err := New(path, WithSegmentSize(40), WithMaxSegments(100))
+		require.NoError(t, err)
+
+		writeMixed(wal)
+
+		require.NoError(t, wal.Close())
+
+		stats, err := CompactExpired(path, time.Now())
+		require.NoError(t, err)
+
+		assert.Equal(t, 3, stats.Entries)
+
+		values, _ := read(DefaultReadOptions)
+		assert.Equal(t, []string{"long1", "long3", "long5", "plain"}, values)
+
+		stats, err = CompactExpired(path, time.Now().Add(2*time.Hour))
+		require.NoError(t, err)
+
+		assert.Equal(t, 3, stats.Entries)
+
+		values, _ = read(DefaultReadOptions)
+		assert.Equal(t, []string{"plain"}, values)
+	})
+
+	n.It("drops expired entries from an open WAL's sealed segments", func() {
+		wal, err := New(path, WithSegmentSize(40), WithMaxSegments(100))
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		writeMixed(wal)
+
+		require.NoError(t, wal.WriteTTL([]byte("active"), time.Nanosecond))
+
+		before := wal.SizeOnDisk()
+
+		stats, err := wal.CompactExpired()
+		require.NoError(t, err)
+
+		assert.Equal(t, 3, stats.Entries)
+
+		assert.Equal(t, before-stats.Bytes, wal.SizeOnDisk())
+
+		values, _ := read(DefaultReadOptions)
+		assert.Equal(t, []string{"long1", "long3", "long5", "plain", "active"}, values)
+
+		require.NoError(t, wal.Write([]byte("more")))
+	})
+
+	n.It("only compacts an open WAL while the maintenance gate is open", func() {
+		var (
+			lock sync.Mutex
+			open bool
+		)
+
+		gate := func(time.Time) bool {
+			lock.Lock()
+			defer lock.Unlock()
+
+			return open
+		}
+
+		wal, err := New(path, WithSegmentSize(40), WithMaxSegments(100), WithMaintenanceGate(gate))
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		writeMixed(wal)
+
+		stats, err := wal.CompactExpired()
+		require.NoError(t, err)
+
+		assert.Equal(t, CompactStats{}, stats)
+
+		lock.Lock()
+		open = true
+		lock.Unlock()
+
+		stats, err = wal.CompactExpired()
+		require.NoError(t, err)
+
+		assert.Equal(t, 3, stats.Entries)
+	})
+
+	n.It("keeps expiries when cloned", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.WriteTTL([]byte("a"), time.Nanosecond))
+		require.NoError(t, wal.Close())
+
+		require.NoError(t, Clone(path, path+".clone"))
+
+		stats, err := CompactExpired(path+".clone", time.Now())
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, stats.Entries)
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,141 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// Encoder turns values into entries.
+type Encoder[T any] interface {
+	Encode(v T) ([]byte, error)
+}
+
+// Decoder turns entries back into values.
+type Decoder[T any] interface {
+	Decode(data []byte) (T, error)
+}
+
+// Codec encodes and decodes the values stored in a TypedWAL. JSONCodec
+// and GobCodec cover most types; walproto provides one for protobuf
+// messages.
+type Codec[T any] interface {
+	Encoder[T]
+	Decoder[T]
+}
+
+// JSONCodec encodes values with encoding/json.
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Encode(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// GobCodec encodes values with encoding/gob, for types that don't
+// marshal to JSON. Each entry carries its own type information, so it
+// can be decoded on its own but is larger than with a streamed encoder.
+type GobCodec[T any] struct{}
+
+func (GobCodec[T]) Encode(v T) ([]byte, error) {
+	var buf bytes.Buffer
+
+	err := gob.NewEncoder(&buf).Encode(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (GobCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+	return v, err
+}
+
+// TypedWAL stores values of type T, encoded with a Codec, so callers
+// don't have to marshal each value themselves. It reads and writes
+// through a pair, so values appended are visible to Next.
+type TypedWAL[T any] struct {
+	r     *PairedReader
+	w     *PairedWriter
+	codec Codec[T]
+
+	err error
+}
+
+// OpenTyped opens, or creates, the WAL in root for storing values of
+// type T.
+func OpenTyped[T any](root string, opts WriteOptions, codec Codec[T]) (*TypedWAL[T], error) {
+	r, w, err := NewPair(root, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TypedWAL[T]{r: r, w: w, codec: codec}, nil
+}
+
+// Append encodes v and writes it as an entry.
+func (t *TypedWAL[T]) Append(v T) error {
+	data, err := t.codec.Encode(v)
+	if err != nil {
+		return err
+	}
+
+	return t.w.Write(data)
+}
+
+// Next returns the next value. It returns false at the end of the WAL
+// or if an entry can't be read or decoded, in which case Err returns
+// why.
+func (t *TypedWAL[T]) Next() (T, bool) {
+	var zero T
+
+	if t.err != nil || !t.r.Next() {
+		if t.err == nil {
+			t.err = t.r.Error()
+		}
+
+		return zero, false
+	}
+
+	v, err := t.codec.Decode(t.r.Value())
+	if err != nil {
+		t.err = err
+		return zero, false
+	}
+
+	return v, true
+}
+
+// Err returns the error that stopped Next, if any.
+func (t *TypedWAL[T]) Err() error {
+	return t.err
+}
+
+// Writer returns the underlying writer, for writing tags.
+func (t *TypedWAL[T]) Writer() *PairedWriter {
+	return t.w
+}
+
+// Reader returns the underlying reader, for seeking.
+func (t *TypedWAL[T]) Reader() *PairedReader {
+	return t.r
+}
+
+func (t *TypedWAL[T]) Close() error {
+	rerr := t.r.Close()
+
+	err := t.w.Close()
+	if err == nil {
+		err = rerr
+	}
+
+	return err
+}
@@ -0,0 +1,138 @@
+package wal
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+type testEvent struct {
+	ID   int
+	Name string
+}
+
+type testEventCodec struct{}
+
+func (testEventCodec) Encode(v testEvent) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (testEventCodec) Decode(data []byte) (testEvent, error) {
+	var v testEvent
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+func TestTypedWAL(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	n.It("appends and iterates values", func() {
+		tw, err := OpenTyped[testEvent](path, DefaultWriteOptions, testEventCodec{})
+		require.NoError(t, err)
+
+		defer tw.Close()
+
+		require.NoError(t, tw.Append(testEvent{ID: 1, Name: "one"}))
+		require.NoError(t, tw.Append(testEvent{ID: 2, Name: "two"}))
+
+		v, ok := tw.Next()
+		require.True(t, ok)
+		assert.Equal(t, testEvent{ID: 1, Name: "one"}, v)
+
+		v, ok = tw.Next()
+		require.True(t, ok)
+		assert.Equal(t, testEvent{ID: 2, Name: "two"}, v)
+
+		_, ok = tw.Next()
+		assert.False(t, ok)
+		assert.NoError(t, tw.Err())
+	})
+
+	n.It("reads values written before reopening", func() {
+		tw, err := OpenTyped[testEvent](path, DefaultWriteOptions, testEventCodec{})
+		require.NoError(t, err)
+
+		require.NoError(t, tw.Append(testEvent{ID: 1, Name: "one"}))
+		require.NoError(t, tw.Close())
+
+		tw, err = OpenTyped[testEvent](path, DefaultWriteOptions, testEventCodec{})
+		require.NoError(t, err)
+
+		defer tw.Close()
+
+		v, ok := tw.Next()
+		require.True(t, ok)
+		assert.Equal(t, 1, v.ID)
+	})
+
+	n.It("stops with the error when a value can't be decoded", func() {
+		tw, err := OpenTyped[testEvent](path, DefaultWriteOptions, testEventCodec{})
+		require.NoError(t, err)
+
+		defer tw.Close()
+
+		require.NoError(t, tw.Writer().Write([]byte("not json")))
+		require.NoError(t, tw.Append(testEvent{ID: 2}))
+
+		_, ok := tw.Next()
+		assert.False(t, ok)
+
+		var serr *json.SyntaxError
+		assert.True(t, errors.As(tw.Err(), &serr))
+
+		_, ok = tw.Next()
+		assert.False(t, ok)
+	})
+
+	n.It("round trips values with the built-in codecs", func() {
+		ev := testEvent{ID: 3, Name: "three"}
+
+		codecs := map[string]Codec[testEvent]{
+			"json": JSONCodec[testEvent]{},
+			"gob":  GobCodec[testEvent]{},
+		}
+
+		for name, c := range codecs {
+			data, err := c.Encode(ev)
+			require.NoError(t, err, name)
+
+			got, err := c.Decode(data)
+			require.NoError(t, err, name)
+
+			assert.Equal(t, ev, got, name)
+		}
+	})
+
+	n.It("opens with a built-in codec", func() {
+		tw, err := OpenTyped[testEvent](path, DefaultWriteOptions, JSONCodec[testEvent]{})
+		require.NoError(t, err)
+
+		defer tw.Close()
+
+		require.NoError(t, tw.Append(testEvent{ID: 4}))
+
+		v, ok := tw.Next()
+		require.True(t, ok)
+		assert.Equal(t, 4, v.ID)
+	})
+
+	n.Meow()
+}
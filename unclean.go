@@ -0,0 +1,95 @@
+package wal
+
+import "fmt"
+
+// UncleanAction is what to do with a segment that wasn't closed
+// cleanly, such as after a crash.
+type UncleanAction int
+
+const (
+	// Log a warning and carry on writing after whatever the segment
+	// holds. A partial record at its end is reported by readers as
+	// corruption.
+	UncleanContinue UncleanAction = iota
+
+	// Read every record of the segment, failing the open with
+	// ErrUncleanShutdown if any is partial or corrupt.
+	UncleanVerify
+
+	// Read every record of the segment, truncating it at the first one
+	// that's partial or corrupt.
+	UncleanRepair
+
+	// Fail the open with ErrUncleanShutdown.
+	UncleanAbort
+)
+
+// UncleanPolicy is called when a WAL is opened and its newest segment,
+// index at path, wasn't closed cleanly. It returns what to do about it.
+type UncleanPolicy func(index int, path string) UncleanAction
+
+// WasCleanShutdown reports whether the WAL was closed cleanly the last
+// time it was written, judged by its newest segment when it was opened.
+// A new WAL counts as clean. With NoClosingMagic set, a WAL holding any
+// entries never does.
+func (wal *WALWriter) WasCleanShutdown() bool {
+	return wal.cleanOpen
+}
+
+// handleUnclean applies the UncleanPolicy to the active segment.
+func (wal *WALWriter) handleUnclean() error {
+	action := UncleanContinue
+	if wal.opts.NoClosingMagic {
+		action = UncleanRepair
+	}
+
+	if wal.opts.UncleanPolicy != nil {
+		action = wal.opts.UncleanPolicy(wal.index, wal.current)
+	}
+
+	switch action {
+	case UncleanAbort:
+		return fmt.Errorf("%w: segment %d", ErrUncleanShutdown, wal.index)
+	case UncleanVerify, UncleanRepair:
+		end, readErr, err := wal.scanActive()
+		if err != nil {
+			return err
+		}
+
+		if readErr == nil {
+			return nil
+		}
+
+		if action == UncleanVerify {
+			return fmt.Errorf("%w: segment %d: %w", ErrUncleanShutdown, wal.index, readErr)
+		}
+
+		wal.logger.Warn("truncating partial records at the end of segment",
+			"segment", wal.index, "offset", end, "error", readErr)
+
+		return wal.segment.truncateTo(end)
+	default:
+		wal.logger.Warn("segment was not closed cleanly", "segment", wal.index)
+		return nil
+	}
+}
+
+// scanActive reads the records of the active segment, returning the
+// end of the last intact one and the error that stopped the scan
+// before the end of the segment, if any.
+func (wal *WALWriter) scanActive() (int64, error, error) {
+	sr, err := NewSegmentReaderWithOptions(wal.current, ReadOptions{FS: wal.opts.FS})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	defer sr.Close()
+
+	for {
+		if _, ok := sr.nextRecord(); !ok {
+			break
+		}
+	}
+
+	return sr.Pos(), sr.Error(), nil
+}
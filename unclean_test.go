@@ -0,0 +1,189 @@
+package wal
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestUnclean(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	// Partial records a crash can leave: a cut off body, a header with
+	// only its length, and a bare header.
+	var (
+		torn       = []byte{1, 2, 3, 4, 'd', 50, 'x'}
+		noBody     = []byte{1, 2, 3, 4, 'd', 50}
+		headerOnly = []byte{1, 2, 3, 4, 'd'}
+	)
+
+	// crash leaves the WAL as a crash would, with tail, if any, written
+	// after the last record of the newest segment.
+	crash := func(tail []byte) {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("hello")))
+
+		if tail != nil {
+			_, err = wal.segment.f.Write(tail)
+			require.NoError(t, err)
+		}
+
+		wal.segment.f.Close()
+		wal.cacheFile.Close()
+		wal.lockf.Close()
+	}
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	n.It("reports whether the WAL was closed cleanly", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		assert.True(t, wal.WasCleanShutdown())
+
+		require.NoError(t, wal.Write([]byte("hello")))
+		require.NoError(t, wal.Close())
+
+		wal, err = New(path)
+		require.NoError(t, err)
+
+		assert.True(t, wal.WasCleanShutdown())
+		require.NoError(t, wal.Close())
+
+		crash(nil)
+
+		wal, err = New(path)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		assert.False(t, wal.WasCleanShutdown())
+	})
+
+	n.It("calls the policy with the unclean segment", func() {
+		crash(nil)
+
+		var (
+			called int
+			seen   string
+		)
+
+		wal, err := New(path, func(wo *WriteOptions) {
+			wo.UncleanPolicy = func(index int, p string) UncleanAction {
+				called++
+				seen = p
+				return UncleanContinue
+			}
+		})
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		assert.Equal(t, 1, called)
+		assert.Equal(t, wal.current, seen)
+	})
+
+	policy := func(action UncleanAction) Option {
+		return func(wo *WriteOptions) {
+			wo.UncleanPolicy = func(int, string) UncleanAction { return action }
+		}
+	}
+
+	n.It("can abort the open", func() {
+		crash(nil)
+
+		_, err := New(path, policy(UncleanAbort))
+		assert.True(t, errors.Is(err, ErrUncleanShutdown))
+
+		wal, err := New(path)
+		require.NoError(t, err)
+		require.NoError(t, wal.Close())
+	})
+
+	n.It("can verify the segment", func() {
+		crash(nil)
+
+		wal, err := New(path, policy(UncleanVerify))
+		require.NoError(t, err)
+		require.NoError(t, wal.Close())
+
+		crash(torn)
+
+		_, err = New(path, policy(UncleanVerify))
+		assert.True(t, errors.Is(err, ErrUncleanShutdown))
+	})
+
+	n.It("can repair the segment", func() {
+		crash(torn)
+
+		wal, err := New(path, policy(UncleanRepair))
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("after")))
+		require.NoError(t, wal.Close())
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		var values []string
+
+		for r.Next() {
+			values = append(values, string(r.Value()))
+		}
+
+		require.NoError(t, r.Error())
+		assert.Equal(t, []string{"hello", "after"}, values)
+	})
+
+	n.It("treats a record header without its body as torn", func() {
+		for _, tail := range [][]byte{noBody, headerOnly} {
+			crash(tail)
+
+			_, err := New(path, policy(UncleanVerify))
+			assert.True(t, errors.Is(err, ErrUncleanShutdown))
+
+			wal, err := New(path, policy(UncleanRepair))
+			require.NoError(t, err)
+
+			require.NoError(t, wal.Write([]byte("after")))
+			require.NoError(t, wal.Close())
+
+			r, err := NewReader(path)
+			require.NoError(t, err)
+
+			var values []string
+
+			for r.Next() {
+				values = append(values, string(r.Value()))
+			}
+
+			require.NoError(t, r.Error())
+			assert.Equal(t, []string{"hello", "after"}, values)
+
+			r.Close()
+
+			os.RemoveAll(path)
+		}
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,244 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/golang/snappy"
+)
+
+var errBadBlock = errors.New("unexpected record in an entry written in blocks")
+
+// ValueReader returns a reader of the current entry's value. If Next
+// left the entry's blocks undecoded, which it does with StreamValues
+// set, they're read again from the segment and decoded one at a time as
+// the reader is read. The reader is only valid until Next is called
+// again.
+func (r *SegmentReader) ValueReader() io.Reader {
+	if r.deferred == nil || r.value != nil {
+		return bytes.NewReader(r.value)
+	}
+
+	if r.vr != nil {
+		r.vr.Close()
+	}
+
+	r.vr = &blockValueReader{
+		fs:      r.fs,
+		path:    r.f.Name(),
+		ent:     *r.deferred,
+		left:    r.deferred.blocks,
+		off:     r.deferred.start,
+		skipCRC: r.skipCRC,
+		policy:  r.policy,
+		cs:      crc32.NewIEEE(),
+	}
+
+	return r.vr
+}
+
+// deferValue makes ent, whose blocks weren't decoded, the current entry
+// without reading its value.
+func (r *SegmentReader) deferValue(ent segmentEntry) {
+	// The final record's body is in the read buffer, which is reused.
+	ent.value = nil
+
+	r.deferred = &ent
+	r.value = nil
+	r.valueCRC = ent.crc
+	r.header = ent.header()
+}
+
+// valueSize returns the size of the current entry's value without
+// assembling a deferred one, which is counted by its stored size.
+func (r *SegmentReader) valueSize() int64 {
+	if r.deferred != nil && r.value == nil {
+		return r.header.Length
+	}
+
+	return int64(len(r.value))
+}
+
+// assembleValue reads the whole value of the deferred entry.
+func (r *SegmentReader) assembleValue() ([]byte, error) {
+	value, err := io.ReadAll(r.ValueReader())
+	if err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// releaseValue forgets the deferred entry, closing any reader of it.
+func (r *SegmentReader) releaseValue() {
+	if r.vr != nil {
+		r.vr.Close()
+		r.vr = nil
+	}
+
+	r.deferred = nil
+}
+
+// blockValueReader reads the value of an entry written in blocks,
+// decoding a block at a time from its own handle on the segment.
+type blockValueReader struct {
+	fs   FS
+	path string
+	ent  segmentEntry
+
+	// How many of the entry's records are still to be read, and where
+	// the next one starts.
+	left int
+	off  int64
+
+	skipCRC bool
+	policy  BufferPolicy
+	cs      hash.Hash32
+
+	f  File
+	br *bufio.Reader
+
+	buf, dec []byte
+
+	// What's been decoded but not yet read.
+	plain []byte
+
+	err error
+}
+
+func (v *blockValueReader) Read(p []byte) (int, error) {
+	for len(v.plain) == 0 {
+		if v.err != nil {
+			return 0, v.err
+		}
+
+		v.err = v.nextBlock()
+	}
+
+	n := copy(p, v.plain)
+	v.plain = v.plain[n:]
+
+	return n, nil
+}
+
+// nextBlock reads and decodes the entry's next record into plain.
+func (v *blockValueReader) nextBlock() error {
+	if v.left == 0 {
+		v.Close()
+		return io.EOF
+	}
+
+	if v.f == nil {
+		f, err := v.fs.OpenFile(v.path, os.O_RDONLY, 0)
+		if err != nil {
+			return err
+		}
+
+		v.f = f
+
+		_, err = f.Seek(v.off, io.SeekStart)
+		if err != nil {
+			return err
+		}
+
+		v.br = bufio.NewReader(f)
+	}
+
+	var hdr [5 + binary.MaxVarintLen64]byte
+
+	_, err := io.ReadFull(v.br, hdr[:5])
+	if err != nil {
+		return v.truncated(err)
+	}
+
+	cnt, err := binary.ReadUvarint(v.br)
+	if err != nil {
+		return v.truncated(err)
+	}
+
+	v.buf = v.policy.ensure(v.buf, int(cnt))
+	body := v.buf[:cnt]
+
+	_, err = io.ReadFull(v.br, body)
+	if err != nil {
+		return v.truncated(err)
+	}
+
+	n := binary.PutUvarint(hdr[5:], cnt)
+
+	t := hdr[4] &^ rawFlag
+
+	want := byte(blockType)
+	if v.left == 1 {
+		want = v.ent.entryType
+	}
+
+	if t != want {
+		return &CorruptError{Path: v.path, Offset: v.off, Err: errBadBlock}
+	}
+
+	if !v.skipCRC {
+		v.cs.Reset()
+		v.cs.Write(hdr[5 : 5+n])
+		v.cs.Write(body)
+
+		if v.cs.Sum32() != binary.BigEndian.Uint32(hdr[:4]) {
+			return &CorruptError{Path: v.path, Offset: v.off, Err: ErrCorruptCRC}
+		}
+	}
+
+	if hdr[4]&rawFlag != 0 {
+		v.plain = body
+	} else {
+		size, err := snappy.DecodedLen(body)
+		if err != nil {
+			return &CorruptError{Path: v.path, Offset: v.off, Err: err}
+		}
+
+		v.dec = v.policy.ensure(v.dec, size)
+
+		v.plain, err = snappy.Decode(v.dec, body)
+		if err != nil {
+			return &CorruptError{Path: v.path, Offset: v.off, Err: err}
+		}
+	}
+
+	v.off += int64(5+n) + int64(cnt)
+	v.left--
+
+	return nil
+}
+
+// truncated reports the segment ending part way through the entry.
+func (v *blockValueReader) truncated(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return &CorruptError{Path: v.path, Offset: v.off, Err: err}
+}
+
+// Close releases the reader's handle on the segment. Reading after it's
+// closed returns ErrClosed, unless the value was read to the end.
+func (v *blockValueReader) Close() error {
+	if v.err == nil {
+		v.err = ErrClosed
+	}
+
+	v.plain = nil
+
+	if v.f == nil {
+		return nil
+	}
+
+	err := v.f.Close()
+	v.f = nil
+
+	return err
+}
@@ -0,0 +1,154 @@
+package wal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestValueReader(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	var big bytes.Buffer
+
+	for i := 0; big.Len() < 20000; i++ {
+		fmt.Fprintf(&big, "line %d of a large value\n", i)
+	}
+
+	write := func() {
+		wal, err := New(path, WithBlockSize(1024))
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write(big.Bytes()))
+		require.NoError(t, wal.Write([]byte("small")))
+		require.NoError(t, wal.WriteTTL(big.Bytes(), time.Hour))
+		require.NoError(t, wal.Close())
+	}
+
+	opts := DefaultReadOptions
+	opts.StreamValues = true
+
+	n.It("streams a value written in blocks", func() {
+		write()
+
+		r, err := NewReaderWithOptions(path, opts)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.True(t, r.Next())
+		assert.Nil(t, r.seg.value)
+		assert.True(t, r.Header().Blocks > 1)
+
+		vr := r.ValueReader()
+
+		// Read in pieces smaller than a block.
+		var (
+			got bytes.Buffer
+			buf [100]byte
+		)
+
+		for {
+			n, err := vr.Read(buf[:])
+			got.Write(buf[:n])
+
+			if err == io.EOF {
+				break
+			}
+
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, big.String(), got.String())
+
+		require.True(t, r.Next())
+
+		value, err := io.ReadAll(r.ValueReader())
+		require.NoError(t, err)
+		assert.Equal(t, "small", string(value))
+
+		// Entries whose values are needed to read them are assembled.
+		require.True(t, r.Next())
+		assert.Equal(t, big.String(), string(r.Value()))
+		assert.False(t, r.Header().Expires.IsZero())
+
+		assert.False(t, r.Next())
+		require.NoError(t, r.Error())
+	})
+
+	n.It("still assembles the value on request", func() {
+		write()
+
+		r, err := NewReaderWithOptions(path, opts)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.True(t, r.Next())
+		assert.Equal(t, big.String(), string(r.Value()))
+
+		value, err := io.ReadAll(r.ValueReader())
+		require.NoError(t, err)
+		assert.Equal(t, big.String(), string(value))
+	})
+
+	n.It("stops a reader once the reader moves on", func() {
+		write()
+
+		r, err := NewReaderWithOptions(path, opts)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.True(t, r.Next())
+
+		vr := r.ValueReader()
+
+		var buf [10]byte
+
+		_, err = vr.Read(buf[:])
+		require.NoError(t, err)
+
+		require.True(t, r.Next())
+
+		_, err = vr.Read(buf[:])
+		assert.Equal(t, ErrClosed, err)
+	})
+
+	n.It("reads whole values without the option", func() {
+		write()
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.True(t, r.Next())
+
+		value, err := io.ReadAll(r.ValueReader())
+		require.NoError(t, err)
+		assert.Equal(t, big.String(), string(value))
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,206 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// VerifyReport describes the integrity of a WAL directory.
+type VerifyReport struct {
+	// True if no problems were found.
+	OK bool `json:"ok"`
+
+	Segments []SegmentReport `json:"segments"`
+
+	// The total number of entries and tags in intact records.
+	Entries int `json:"entries"`
+	Tags    int `json:"tags"`
+}
+
+// SegmentReport describes the integrity of one segment.
+type SegmentReport struct {
+	Index int   `json:"index"`
+	Size  int64 `json:"size"`
+
+	Entries int `json:"entries"`
+	Tags    int `json:"tags"`
+
+	// True if the segment ends with the marker written when it's
+	// closed. Only the newest segment may be missing it, while it's
+	// being written.
+	Sealed bool `json:"sealed"`
+
+	// True if the segment was also sealed with a checksum of its
+	// records, as WriteOptions.SealSegments does, and it matched.
+	Checksummed bool `json:"checksummed"`
+
+	// The blocks that don't match their checksums, for a segment
+	// checksummed with WriteOptions.ChecksumBlockSize. Corruption is
+	// confined to these, so records entirely outside them are intact.
+	BadBlocks []BlockRange `json:"bad_blocks,omitempty"`
+
+	// The problem found with the segment, if any, and the offset of the
+	// record where it was found.
+	Error       string `json:"error,omitempty"`
+	ErrorOffset int64  `json:"error_offset,omitempty"`
+
+	err error
+}
+
+var (
+	ErrSegmentMissing = errors.New("segment is missing")
+	ErrNotSealed      = errors.New("segment was not closed cleanly")
+
+	errTrailingData = errors.New("unexpected data after the last record")
+)
+
+// Verify reads every record of the WAL in root, checking their CRCs,
+// that no segment is missing from the sequence, and that every segment
+// but the newest was closed cleanly. Problems are described in the
+// report rather than returned; an error means the check couldn't run.
+func Verify(root string) (*VerifyReport, error) {
+	indexes, err := listSegments(root)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(indexes) == 0 {
+		return nil, ErrNoSegments
+	}
+
+	report := &VerifyReport{OK: true}
+
+	for i, index := range indexes {
+		// Report any gap in the sequence before this segment.
+		if i > 0 {
+			for missing := indexes[i-1] + 1; missing < index; missing++ {
+				report.OK = false
+				report.Segments = append(report.Segments, SegmentReport{
+					Index: missing,
+					Error: ErrSegmentMissing.Error(),
+					err:   ErrSegmentMissing,
+				})
+			}
+		}
+
+		seg := verifySegment(filepath.Join(root, strconv.Itoa(index)), index)
+
+		if seg.err == nil && !seg.Sealed && i < len(indexes)-1 {
+			seg.err = ErrNotSealed
+			seg.Error = ErrNotSealed.Error()
+			seg.ErrorOffset = seg.Size
+		}
+
+		if seg.err != nil {
+			report.OK = false
+		}
+
+		report.Entries += seg.Entries
+		report.Tags += seg.Tags
+		report.Segments = append(report.Segments, seg)
+	}
+
+	return report, nil
+}
+
+func verifySegment(path string, index int) SegmentReport {
+	return verifySegmentFS(OSFS, path, index)
+}
+
+func verifySegmentFS(fs FS, path string, index int) SegmentReport {
+	rep := SegmentReport{Index: index}
+
+	fail := func(err error, offset int64) SegmentReport {
+		rep.err = err
+		rep.Error = err.Error()
+		rep.ErrorOffset = offset
+		return rep
+	}
+
+	fi, err := fs.Stat(path)
+	if err != nil {
+		return fail(err, 0)
+	}
+
+	rep.Size = fi.Size()
+
+	rep.BadBlocks, _, err = checkBlockSums(fs, path)
+	if err != nil {
+		return fail(err, 0)
+	}
+
+	opts := DefaultReadOptions
+	opts.FS = fs
+
+	sr, err := NewSegmentReaderWithOptions(path, opts)
+	if err != nil {
+		return fail(err, 0)
+	}
+
+	defer sr.Close()
+
+	for {
+		start := sr.Pos()
+
+		t, ok := sr.nextRecord()
+		if !ok {
+			if err := sr.Error(); err != nil {
+				return fail(err, start)
+			}
+
+			break
+		}
+
+		if isTag(t) {
+			rep.Tags++
+		} else {
+			rep.Entries++
+		}
+	}
+
+	end := sr.Pos()
+
+	rep.Checksummed = sr.sealChecked
+
+	rep.Sealed, err = hasClosingMagic(sr.f, end, rep.Size)
+	if err != nil {
+		return fail(err, end)
+	}
+
+	// Anything other than the closing magic after the last record isn't
+	// a record we could read.
+	if !rep.Sealed && end != rep.Size {
+		return fail(errTrailingData, end)
+	}
+
+	if len(rep.BadBlocks) > 0 {
+		return fail(ErrBlockChecksum, rep.BadBlocks[0].Offset)
+	}
+
+	return rep
+}
+
+// hasClosingMagic reports whether the segment in f ends at offset end
+// with the closing magic.
+func hasClosingMagic(f File, end, size int64) (bool, error) {
+	if end+int64(len(closingMagic)) != size {
+		return false, nil
+	}
+
+	_, err := f.Seek(end, os.SEEK_SET)
+	if err != nil {
+		return false, err
+	}
+
+	tail := make([]byte, len(closingMagic))
+
+	_, err = f.Read(tail)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(tail, closingMagic), nil
+}
@@ -0,0 +1,95 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestVerify(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+
+		opts := DefaultWriteOptions
+		opts.SegmentSize = 20
+
+		w, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		require.NoError(t, w.Write([]byte("this is the first value")))
+		require.NoError(t, w.WriteTag([]byte("checkpoint")))
+		require.NoError(t, w.Write([]byte("this is the second value")))
+		require.NoError(t, w.Write([]byte("this is the third value")))
+	})
+
+	n.It("reports an intact WAL", func() {
+		rep, err := Verify(path)
+		require.NoError(t, err)
+
+		assert.True(t, rep.OK)
+		assert.Equal(t, 3, rep.Entries)
+		assert.Equal(t, 1, rep.Tags)
+
+		last := rep.Segments[len(rep.Segments)-1]
+		assert.False(t, last.Sealed)
+
+		for _, seg := range rep.Segments[:len(rep.Segments)-1] {
+			assert.True(t, seg.Sealed)
+			assert.Equal(t, "", seg.Error)
+		}
+	})
+
+	n.It("reports corrupt records", func() {
+		seg := filepath.Join(path, "1")
+
+		data, err := ioutil.ReadFile(seg)
+		require.NoError(t, err)
+
+		data[10] ^= 0xff
+
+		require.NoError(t, ioutil.WriteFile(seg, data, 0644))
+
+		rep, err := Verify(path)
+		require.NoError(t, err)
+
+		assert.False(t, rep.OK)
+		assert.Equal(t, 1, rep.Segments[1].Index)
+		assert.ErrorIs(t, rep.Segments[1].err, ErrCorruptCRC)
+		assert.Equal(t, int64(0), rep.Segments[1].ErrorOffset)
+	})
+
+	n.It("reports missing and unsealed segments", func() {
+		require.NoError(t, os.Remove(filepath.Join(path, "2")))
+
+		seg := filepath.Join(path, "1")
+
+		data, err := ioutil.ReadFile(seg)
+		require.NoError(t, err)
+
+		require.NoError(t, ioutil.WriteFile(seg, data[:len(data)-len(closingMagic)], 0644))
+
+		rep, err := Verify(path)
+		require.NoError(t, err)
+
+		assert.False(t, rep.OK)
+		assert.Equal(t, ErrNotSealed, rep.Segments[1].err)
+		assert.Equal(t, 2, rep.Segments[2].Index)
+		assert.Equal(t, ErrSegmentMissing.Error(), rep.Segments[2].Error)
+	})
+
+	n.Meow()
+}
@@ -1,14 +1,19 @@
 package wal
 
 import (
-	"encoding/base64"
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strconv"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -17,21 +22,323 @@ type WriteOptions struct {
 	// a new segment will be created.
 	SegmentSize int64
 
-	// The maximum number of segments to keep on disk.
+	// The maximum number of segments to keep on disk. If 0, segments are
+	// never pruned as the WAL rotates, only by calls to PruneBefore, for
+	// WALs that are kept forever or trimmed on the application's own
+	// schedule.
 	MaxSegments int
 
+	// If true, pruning never removes a segment holding entries that a
+	// reader registered with RegisterReader hasn't acknowledged, even if
+	// that means keeping more than MaxSegments. A reader that stops
+	// acknowledging holds back pruning until it's unregistered.
+	RetainForReaders bool
+
+	// If set, pruned segments are moved into this directory rather than
+	// removed, so that overly aggressive retention settings can be
+	// recovered from. A relative path is within the WAL's root. The
+	// directory is created when the WAL is opened, and nothing is ever
+	// removed from it.
+	PruneDir string
+
+	// If greater than 0, pruned segments are removed in the background
+	// at no more than this many bytes of segments a second, rather than
+	// all at once as they're pruned, so that pruning a lot, such as
+	// after lowering MaxSegments, doesn't starve writes of the disk.
+	// They stop counting towards the WAL's size as soon as they're
+	// pruned, and any still waiting to be removed when the WAL is closed
+	// are pruned again once it's next opened.
+	PruneBytesPerSecond int64
+
+	// If set, the heavy IO of maintenance, removing pruned segments,
+	// scrubbing and WALWriter.CompactExpired, only runs while this
+	// returns true for the current time, such as off-peak. Pruned
+	// segments stop counting towards the WAL's size as soon as they're
+	// pruned, but wait on disk until it does. Writes are never held up
+	// by it. MaintenanceWindow.Open is one; it's called from background
+	// goroutines, so must be safe to call concurrently.
+	//
+	// Compact and the other functions that rewrite the segments of a
+	// WAL that isn't open don't consult it, since they only run when
+	// they're called, which is already a choice of when.
+	MaintenanceGate func(now time.Time) bool
+
+	// Where the cache of tag positions is written. A relative path is
+	// within the WAL's root. If empty, it's "tags" in the root.
+	TagCachePath string
+
+	// If true, no tag cache is kept, in memory or on disk. TagPos then
+	// always searches the segments.
+	NoTagCache bool
+
+	// Where the lock file that keeps a second writer from opening the
+	// WAL is kept. A relative path is within the WAL's root. If empty,
+	// it's "lock" in the root.
+	LockPath string
+
+	// If true, each segment is sealed when it's rotated out by writing a
+	// checksum of its records at its end, which readers check, so that
+	// any later change to a sealed segment is detected rather than
+	// silently read. Segments sealed this way can't be read by versions
+	// of this package that predate the option.
+	SealSegments bool
+
+	// If true, sealed segments are also made read-only, on filesystems
+	// that support it.
+	SealReadOnly bool
+
+	// If true, segments aren't marked as cleanly closed with the closing
+	// magic, so they hold nothing but entries. Every open is then treated
+	// as possibly unclean: the records of the newest segment are checked
+	// and any partial or corrupt ones at its end are truncated away.
+	// Verify and Follower, which expect sealed segments to carry the
+	// marker, report such segments as not sealed.
+	NoClosingMagic bool
+
+	// If positive, a time index is kept beside each segment, recording
+	// where the entries written every interval start, so that
+	// WALReader.SeekTime can find them without reading the segments.
+	TimeIndexInterval time.Duration
+
+	// If positive, each segment is checksummed in blocks of this size
+	// when it's rotated out, and the checksums kept beside it, so that
+	// Verify can narrow corruption down to the blocks it's in and
+	// vouch for the rest of the segment. 4096 is a good choice.
+	ChecksumBlockSize int64
+
+	// Limits how much of the WAL each stream may hold, and overrides
+	// that limit for the streams named in StreamQuotas. A write that
+	// would take a stream over its quota fails with a QuotaError. While
+	// any stream has a quota, every segment is read when the WAL is
+	// opened to count what the streams hold.
+	StreamQuota  StreamQuota
+	StreamQuotas map[string]StreamQuota
+
+	// Runs a scrubber in the background that re-reads sealed segments
+	// looking for damage. See ScrubOptions.
+	Scrub ScrubOptions
+
+	// Decides what to do when the newest segment wasn't closed cleanly.
+	// If nil, the WAL carries on writing after whatever the segment
+	// holds, or repairs it if NoClosingMagic is set.
+	UncleanPolicy UncleanPolicy
+
+	// Set for WALs opened by a Manager, which syncs them and lends them
+	// buffers.
+	manager *Manager
+
 	// If 0, sync is done after every write. Otherwise this controls
 	// how often the WAL is sync'd to disk. Setting this can speed
 	// up the WAL by sacrifing safety.
 	SyncRate time.Duration
+
+	// If positive along with SyncRate, a write that leaves at least this
+	// many bytes of the active segment unsynced syncs it right away
+	// instead of waiting for the background sync. This caps how much a
+	// crash can lose when bursts of writes outpace SyncRate, while
+	// keeping syncs batched under normal load.
+	MaxUnsyncedBytes int64
+
+	// Controls how the buffer used to compress entries grows and shrinks.
+	// If unset, DefaultBufferPolicy is used.
+	BufferPolicy BufferPolicy
+
+	// Entries larger than this are compressed and written in blocks of
+	// this size, bounding the memory used to encode them. This makes it
+	// practical to use very large segments with very large entries. If 0,
+	// entries are always written whole. Segments holding entries written
+	// in blocks can't be read by versions of this package that predate
+	// the option, which is why it's off by default.
+	BlockSize int
+
+	// Entries at least this large are compressed and checksummed before
+	// the writer's lock is taken, with their blocks spread across
+	// EncodeWorkers goroutines, so a large write doesn't stall other
+	// writers. If 0, entries are always encoded under the lock.
+	ParallelEncodeThreshold int
+
+	// The number of goroutines used to encode a large entry. If 0,
+	// GOMAXPROCS is used.
+	EncodeWorkers int
+
+	// Receives metrics about writes, syncs, and segment management. If
+	// nil, no metrics are reported.
+	Metrics MetricsSink
+
+	// Starts spans around writes, syncs, and rotations. If nil, no
+	// spans are created.
+	Tracer Tracer
+
+	// If positive, syncs that take at least this long are counted in
+	// MetricSlowSyncs, logged, and passed to OnSlowSync, if it's set.
+	// Slow syncs are often the first sign of a failing disk or an
+	// overloaded volume. OnSlowSync is called from whichever goroutine
+	// synced, which may be a background one, so it should be quick.
+	SlowSyncThreshold time.Duration
+	OnSlowSync        func(SlowSync)
+
+	// If set, entries written with Write and WriteRaw record that they
+	// were first written on the node with this ID, as WriteOrigin does,
+	// with their own positions. Readers return it in Header().Origin.
+	NodeID string
+
+	// If set, a tag is written automatically as entries are, so recovery
+	// always has a recent point to restart from.
+	AutoTag AutoTagOptions
+
+	// Logs segment rotation and pruning, background sync failures, and
+	// segments found to have not been closed cleanly. If nil, nothing is
+	// logged.
+	Logger *slog.Logger
+
+	// If set, is given each segment once it has been sealed. Archiving
+	// is best effort: failures are logged and counted, but don't fail
+	// the write that caused the rotation. A BlobArchiver's copies are
+	// logged and counted as they finish in the background; any other
+	// Archiver is judged by what Archive returns, so one that copies in
+	// the background must report its own failures.
+	Archiver Archiver
+
+	// If true, entries are stored uncompressed, saving the CPU time and
+	// the buffer used to compress them. Segments written this way can't
+	// be read by versions of this package that predate the option.
+	NoCompression bool
+
+	// The filesystem the WAL is stored in. If nil, OSFS is used.
+	// Functions that take a directory path rather than options, such as
+	// Export and Clone, as well as Snapshot, CompactExpired and
+	// Archivers, always use the OS filesystem.
+	FS FS
 }
 
 const MaxSegmentSize = 16 * (1024 * 1024)
 
+// tagCachePath returns where the tag cache of the WAL in root is
+// written, or "" if it's disabled.
+func (wo *WriteOptions) tagCachePath(root string) string {
+	switch {
+	case wo.NoTagCache:
+		return ""
+	case wo.TagCachePath == "":
+		return filepath.Join(root, "tags")
+	case filepath.IsAbs(wo.TagCachePath):
+		return wo.TagCachePath
+	default:
+		return filepath.Join(root, wo.TagCachePath)
+	}
+}
+
+// Returned internally when the tag cache is disabled.
+var errNoTagCache = errors.New("tag cache is disabled")
+
+// lockPath returns where the lock file of the WAL in root is kept.
+func (wo *WriteOptions) lockPath(root string) string {
+	switch {
+	case wo.LockPath == "":
+		return filepath.Join(root, "lock")
+	case filepath.IsAbs(wo.LockPath):
+		return wo.LockPath
+	default:
+		return filepath.Join(root, wo.LockPath)
+	}
+}
+
+// lockWAL opens the lock file at path and takes the lock that keeps a
+// second writer out, returning ErrLocked if one already has it. The
+// lock is released when the returned Closer is closed. Only files on the
+// OS filesystem can be locked, so on others the file isn't kept.
+func lockWAL(fs FS, path string) (io.Closer, error) {
+	lockf, err := fs.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := lockf.(*os.File); !ok {
+		lockf.Close()
+		fs.Remove(path)
+
+		return io.NopCloser(nil), nil
+	}
+
+	err = lockFile(lockf)
+	if err != nil {
+		lockf.Close()
+		return nil, err
+	}
+
+	return lockf, nil
+}
+
 // Defaults to using 160MB of disk
 var DefaultWriteOptions = WriteOptions{
-	SegmentSize: MaxSegmentSize,
-	MaxSegments: 10,
+	SegmentSize:  MaxSegmentSize,
+	MaxSegments:  10,
+	BufferPolicy: DefaultBufferPolicy,
+}
+
+// EmbeddedWriteOptions suit constrained devices, such as IoT gateways
+// logging to flash. Buffers are kept small and large entries are
+// written in small blocks to bound memory, entries are stored
+// uncompressed, and every write is synced in the caller's goroutine so
+// the writer starts no goroutines of its own. It uses at most 4MB of
+// disk. Use EmbeddedReadOptions to read the WAL back.
+var EmbeddedWriteOptions = WriteOptions{
+	SegmentSize:   1024 * 1024,
+	MaxSegments:   4,
+	BufferPolicy:  embeddedBufferPolicy,
+	BlockSize:     4 * 1024,
+	NoCompression: true,
+}
+
+// Buffers start at 256 bytes and are released once they pass 4KB.
+var embeddedBufferPolicy = BufferPolicy{
+	InitialSize: 256,
+	MaxSize:     4 * 1024,
+}
+
+// Validate returns an error wrapping ErrInvalidOptions describing the
+// first setting that can't work, such as a SegmentSize of 0.
+func (wo *WriteOptions) Validate() error {
+	switch {
+	case wo.SegmentSize <= 0:
+		return fmt.Errorf("%w: SegmentSize must be positive, got %d", ErrInvalidOptions, wo.SegmentSize)
+	case wo.MaxSegments < 0:
+		return fmt.Errorf("%w: MaxSegments must not be negative, got %d", ErrInvalidOptions, wo.MaxSegments)
+	case wo.SyncRate < 0:
+		return fmt.Errorf("%w: SyncRate must not be negative, got %s", ErrInvalidOptions, wo.SyncRate)
+	case wo.PruneBytesPerSecond < 0:
+		return fmt.Errorf("%w: PruneBytesPerSecond must not be negative, got %d", ErrInvalidOptions, wo.PruneBytesPerSecond)
+	case wo.MaxUnsyncedBytes < 0:
+		return fmt.Errorf("%w: MaxUnsyncedBytes must not be negative, got %d", ErrInvalidOptions, wo.MaxUnsyncedBytes)
+	case wo.BlockSize < 0:
+		return fmt.Errorf("%w: BlockSize must not be negative, got %d", ErrInvalidOptions, wo.BlockSize)
+	case wo.ParallelEncodeThreshold < 0:
+		return fmt.Errorf("%w: ParallelEncodeThreshold must not be negative, got %d", ErrInvalidOptions, wo.ParallelEncodeThreshold)
+	case wo.TimeIndexInterval < 0:
+		return fmt.Errorf("%w: TimeIndexInterval must not be negative, got %s", ErrInvalidOptions, wo.TimeIndexInterval)
+	case wo.ChecksumBlockSize < 0 || wo.ChecksumBlockSize > maxChecksumBlockSize:
+		return fmt.Errorf("%w: ChecksumBlockSize must be between 0 and %d, got %d", ErrInvalidOptions, int64(maxChecksumBlockSize), wo.ChecksumBlockSize)
+	case !wo.StreamQuota.valid():
+		return fmt.Errorf("%w: StreamQuota limits must not be negative, got %+v", ErrInvalidOptions, wo.StreamQuota)
+	case !wo.streamQuotasValid():
+		return fmt.Errorf("%w: StreamQuotas limits must not be negative", ErrInvalidOptions)
+	case !wo.AutoTag.valid():
+		return fmt.Errorf("%w: AutoTag needs a tag and at least one positive limit, none negative", ErrInvalidOptions)
+	case wo.SlowSyncThreshold < 0:
+		return fmt.Errorf("%w: SlowSyncThreshold must not be negative, got %s", ErrInvalidOptions, wo.SlowSyncThreshold)
+	case wo.Scrub.Interval < 0:
+		return fmt.Errorf("%w: Scrub.Interval must not be negative, got %s", ErrInvalidOptions, wo.Scrub.Interval)
+	case wo.Scrub.BytesPerSecond < 0:
+		return fmt.Errorf("%w: Scrub.BytesPerSecond must not be negative, got %d", ErrInvalidOptions, wo.Scrub.BytesPerSecond)
+	case wo.Scrub.Repair < RepairNone || wo.Scrub.Repair > RepairRefetch:
+		return fmt.Errorf("%w: unknown Scrub.Repair %s", ErrInvalidOptions, wo.Scrub.Repair)
+	case wo.Scrub.Repair == RepairRefetch && wo.Scrub.Source == nil:
+		return fmt.Errorf("%w: Scrub.Repair of refetch requires a Scrub.Source", ErrInvalidOptions)
+	case wo.EncodeWorkers < 0:
+		return fmt.Errorf("%w: EncodeWorkers must not be negative, got %d", ErrInvalidOptions, wo.EncodeWorkers)
+	}
+
+	return wo.BufferPolicy.Validate()
 }
 
 // Calculate the WriteOptions based on how much disk space the WAL
@@ -80,19 +387,70 @@ type WALWriter struct {
 	segment *SegmentWriter
 
 	cache     tagCache
-	cacheFile *os.File
+	cacheFile File
 	cacheEnc  *json.Encoder
-}
 
-func rangeSegments(path string) (int, int, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return 0, 0, err
-	}
+	// The time index of the active segment, if one is kept.
+	times *timeIndex
 
-	defer f.Close()
+	// The scrubber, if one is running.
+	scrub *scrubber
+
+	// Removes pruned segments, if pruning is throttled.
+	pruner *pruner
+
+	// What each stream holds, if any stream has a quota.
+	quotas *streamQuotas
+
+	// What's been written since the last automatic tag.
+	autoTags autoTagState
+
+	// Held open to keep the WAL locked.
+	lockf io.Closer
 
-	files, err := f.Readdirnames(-1)
+	closed bool
+
+	// Whether the newest segment was closed cleanly when the WAL was
+	// opened.
+	cleanOpen bool
+
+	// Counts since the WAL was opened, for Stats.
+	entries   int64
+	tags      int64
+	rotations int64
+	prunes    int64
+
+	syncs syncStats
+
+	// How well records have compressed since the WAL was opened.
+	compression CompressionStats
+
+	// The positions acknowledged by registered readers, by name.
+	readers map[string]Position
+
+	// For Health: when the WAL was opened, whether the last write failed
+	// for lack of space, and when the operation holding the lock took
+	// it, in Unix nanoseconds.
+	opened    time.Time
+	full      int32
+	busySince int64
+
+	// The sizes of the sealed segments, and of the other files in the
+	// directory, for SizeOnDisk.
+	segSizes    map[int]int64
+	sealedBytes int64
+	cacheBytes  int64
+	otherBytes  int64
+
+	metrics MetricsSink
+	tracer  Tracer
+	logger  *slog.Logger
+
+	fs FS
+}
+
+func rangeSegments(fs FS, path string) (int, int, error) {
+	files, err := fs.ReadDirNames(path)
 	if err != nil {
 		return 0, 0, err
 	}
@@ -118,173 +476,914 @@ func rangeSegments(path string) (int, int, error) {
 	return first, last, nil
 }
 
-func New(root string) (*WALWriter, error) {
-	return NewWithOptions(root, DefaultWriteOptions)
+// New opens, or creates, the WAL in root using DefaultWriteOptions,
+// adjusted by any options given.
+func New(root string, opts ...Option) (*WALWriter, error) {
+	wo := DefaultWriteOptions
+
+	for _, opt := range opts {
+		opt(&wo)
+	}
+
+	return NewWithOptions(root, wo)
+}
+
+// Create creates a new WAL in root, which must not exist or be empty,
+// returning ErrDirNotEmpty otherwise.
+func Create(root string, opts WriteOptions) (*WALWriter, error) {
+	fs := fsOrOS(opts.FS)
+
+	names, err := fs.ReadDirNames(root)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if len(names) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrDirNotEmpty, root)
+	}
+
+	return NewWithOptions(root, opts)
+}
+
+// Open opens the existing WAL in root for writing. Unlike New, it
+// returns an error for which os.IsNotExist is true if root doesn't
+// exist, rather than creating an empty WAL at a mistyped path.
+func Open(root string, opts WriteOptions) (*WALWriter, error) {
+	fi, err := fsOrOS(opts.FS).Stat(root)
+	if err != nil {
+		return nil, err
+	}
+
+	if !fi.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: root, Err: syscall.ENOTDIR}
+	}
+
+	return NewWithOptions(root, opts)
+}
+
+// OpenReadOnly opens the existing WAL in root for reading. Nothing in
+// root is created or changed, so it's safe to use on a WAL another
+// process is writing, or on read-only media.
+func OpenReadOnly(root string, opts ReadOptions) (*WALReader, error) {
+	_, err := fsOrOS(opts.FS).Stat(root)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewReaderWithOptions(root, opts)
 }
 
 func NewWithOptions(root string, opts WriteOptions) (*WALWriter, error) {
-	err := os.Mkdir(root, 0755)
+	return newWithContext(context.Background(), root, opts)
+}
+
+// NewWithContext is like NewWithOptions, but gives up when ctx is done,
+// so that a WAL on a slow or unresponsive filesystem doesn't hold up
+// startup indefinitely. Filesystem calls can't be interrupted, so one
+// that's in progress when ctx is done carries on in the background, and
+// the WAL is closed if it finishes opening.
+func NewWithContext(ctx context.Context, root string, opts WriteOptions) (*WALWriter, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		wal *WALWriter
+		err error
+	}
+
+	ch := make(chan result, 1)
+
+	go func() {
+		wal, err := newWithContext(ctx, root, opts)
+		ch <- result{wal, err}
+	}()
+
+	select {
+	case res := <-ch:
+		return res.wal, res.err
+	case <-ctx.Done():
+		go func() {
+			if res := <-ch; res.wal != nil {
+				res.wal.Close()
+			}
+		}()
+
+		return nil, ctx.Err()
+	}
+}
+
+func newWithContext(ctx context.Context, root string, opts WriteOptions) (*WALWriter, error) {
+	err := opts.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	fs := fsOrOS(opts.FS)
+
+	err = fs.Mkdir(root, 0755)
 	if err != nil {
 		if !os.IsExist(err) {
 			return nil, err
 		}
 	}
 
-	first, last, err := rangeSegments(root)
+	err = ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	// Only one writer may have the WAL open at a time.
+	lockf, err := lockWAL(fs, opts.lockPath(root))
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.PruneDir != "" {
+		err = fs.Mkdir(pruneDir(root, opts.PruneDir), 0755)
+		if err != nil && !os.IsExist(err) {
+			lockf.Close()
+			return nil, err
+		}
+	}
+
+	wal, err := openWriter(ctx, fs, root, opts, lockf)
+	if err != nil {
+		lockf.Close()
+		return nil, err
+	}
+
+	return wal, nil
+}
+
+func openWriter(ctx context.Context, fs FS, root string, opts WriteOptions, lockf io.Closer) (*WALWriter, error) {
+	first, last, err := rangeSegments(fs, root)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.Err()
 	if err != nil {
 		return nil, err
 	}
 
 	if last == -1 {
 		last = 0
+	} else {
+		// A sealed segment takes no more entries, so if a crash left one
+		// newest, continue with the one it was being rotated to.
+		sealed, err := lastSealed(fs, filepath.Join(root, fmt.Sprintf("%d", last)))
+		if err != nil {
+			return nil, err
+		}
+
+		if sealed {
+			last++
+		}
 	}
 
 	if first == -1 {
 		first = 0
 	}
 
-	cache, err := os.Create(filepath.Join(root, "tags"))
+	cachePath := opts.tagCachePath(root)
+
+	segSizes, otherBytes, err := diskUsage(fs, root, last, cachePath)
 	if err != nil {
 		return nil, err
 	}
 
+	var cache File
+
+	if cachePath != "" {
+		cache, err = fs.OpenFile(cachePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	wal := &WALWriter{
-		root:      root,
-		current:   filepath.Join(root, fmt.Sprintf("%d", last)),
-		first:     first,
-		index:     last,
-		opts:      opts,
-		cacheFile: cache,
-		cacheEnc:  json.NewEncoder(cache),
+		root:       root,
+		current:    filepath.Join(root, fmt.Sprintf("%d", last)),
+		first:      first,
+		index:      last,
+		opts:       opts,
+		cacheFile:  cache,
+		lockf:      lockf,
+		opened:     time.Now(),
+		segSizes:   segSizes,
+		otherBytes: otherBytes,
+		metrics:    metricsOrNop(opts.Metrics),
+		tracer:     tracerOrNop(opts.Tracer),
+		logger:     loggerOrDiscard(opts.Logger),
+		fs:         fs,
+	}
+
+	if cache != nil {
+		wal.cacheEnc = json.NewEncoder(cache)
+		wal.cache.Tags = make(map[string]Position)
+	}
+
+	for _, size := range segSizes {
+		wal.sealedBytes += size
 	}
 
-	wal.cache.Tags = make(map[string]Position)
+	wal.autoTags.reset()
+
+	wal.syncs.slowThreshold = opts.SlowSyncThreshold
+	wal.syncs.onSlow = opts.OnSlowSync
 
-	seg, err := NewSegmentWriter(wal.current)
+	err = wal.loadQuotas()
 	if err != nil {
+		if cache != nil {
+			cache.Close()
+		}
+
+		return nil, err
+	}
+
+	seg, err := wal.openSegment()
+	if err == nil {
+		err = ctx.Err()
+		if err != nil {
+			seg.Close()
+		}
+	}
+
+	if err != nil {
+		if cache != nil {
+			cache.Close()
+		}
+
 		return nil, err
 	}
 
 	wal.segment = seg
 
-	if opts.SyncRate > 0 {
-		seg.SetSyncRate(opts.SyncRate)
+	wal.cleanOpen = seg.Clean() || seg.Size() == 0
+
+	if !wal.cleanOpen {
+		err = wal.handleUnclean()
+		if err != nil {
+			// Leave the segment as unclean as it was found, so the closing
+			// magic doesn't hide its partial records from the next open.
+			seg.SetClosingMagic(false)
+			seg.Close()
+
+			if cache != nil {
+				cache.Close()
+			}
+
+			return nil, err
+		}
 	}
 
+	wal.openTimeIndex()
+
+	wal.startScrubber()
+	wal.startPruner()
+
 	return wal, nil
 }
 
+func (wal *WALWriter) openSegment() (*SegmentWriter, error) {
+	seg, err := newSegmentWriter(wal.fs, wal.current)
+	if err != nil {
+		return nil, err
+	}
+
+	if wal.opts.BufferPolicy != (BufferPolicy{}) {
+		seg.SetBufferPolicy(wal.opts.BufferPolicy)
+	}
+
+	seg.SetBlockSize(wal.opts.BlockSize)
+	seg.SetCompression(!wal.opts.NoCompression)
+	seg.SetClosingMagic(!wal.opts.NoClosingMagic)
+
+	seg.metrics = wal.metrics
+	seg.tracer = wal.tracer
+	seg.logger = wal.logger
+	seg.syncs = &wal.syncs
+	seg.totalCompression = &wal.compression
+	seg.maxUnsynced = wal.opts.MaxUnsyncedBytes
+
+	if wal.opts.ChecksumBlockSize > 0 {
+		seg.keepBlockSums(wal.opts.ChecksumBlockSize)
+	}
+
+	switch {
+	case wal.opts.manager != nil:
+		seg.bgSync = wal.opts.SyncRate > 0
+		seg.pool = &wal.opts.manager.pool
+		seg.buf = nil
+	case wal.opts.SyncRate > 0:
+		seg.SetSyncRate(wal.opts.SyncRate)
+	}
+
+	return seg, nil
+}
+
 func (wal *WALWriter) rotateSegment() error {
+	wal.closeTimeIndex()
+
+	wal.sealSegment()
+
 	err := wal.segment.Close()
 	if err != nil {
 		return err
 	}
 
+	wal.protectSegment(wal.current)
+
+	wal.sumBlocks(wal.index, wal.segment)
+
+	size := wal.segment.Size()
+	if !wal.opts.NoClosingMagic {
+		size += int64(len(closingMagic))
+	}
+
+	wal.segSizes[wal.index] = size
+	wal.sealedBytes += size
+
+	wal.archive(wal.index, wal.current)
+
 	wal.index++
 
 	wal.current = filepath.Join(wal.root, fmt.Sprintf("%d", wal.index))
 
-	seg, err := NewSegmentWriter(wal.current)
+	seg, err := wal.openSegment()
 	if err != nil {
 		return err
 	}
 
 	wal.segment = seg
 
+	wal.openTimeIndex()
+
+	wal.rotations++
+
+	wal.logger.Info("rotated segment", "segment", wal.index)
+
+	wal.metrics.IncrCounter(MetricRotations, 1)
+	wal.metrics.SetGauge(MetricSegments, float64(wal.index-wal.first+1))
+
 	return nil
 }
 
+func (wal *WALWriter) archive(index int, path string) {
+	if wal.opts.Archiver == nil {
+		return
+	}
+
+	if aa, ok := wal.opts.Archiver.(asyncArchiver); ok {
+		err := aa.archiveAsync(index, path, func(err error) {
+			wal.archived(index, err)
+		})
+		if err != nil {
+			wal.archived(index, err)
+		}
+
+		return
+	}
+
+	wal.archived(index, wal.opts.Archiver.Archive(index, path))
+}
+
+// archived logs and counts how archiving segment index ended.
+func (wal *WALWriter) archived(index int, err error) {
+	if err != nil {
+		wal.logger.Error("failed to archive segment", "segment", index, "error", err)
+		wal.metrics.IncrCounter(MetricArchiveErrors, 1)
+		return
+	}
+
+	wal.metrics.IncrCounter(MetricArchivedSegments, 1)
+}
+
+// pruneSegments removes the oldest segments so that no more than total
+// remain, keeping them all if total is 0. The lock must be held.
 func (wal *WALWriter) pruneSegments(total int) error {
-	startAt := wal.index - total
+	if total <= 0 {
+		return nil
+	}
+
+	return wal.pruneThrough(wal.index - total)
+}
+
+// pruneThrough removes the segments up to and including startAt, except
+// those held back for registered readers. The lock must be held.
+func (wal *WALWriter) pruneThrough(startAt int) error {
+	if min, ok := wal.minReaderPos(); ok && wal.opts.RetainForReaders {
+		if min.Segment-1 < startAt {
+			startAt = min.Segment - 1
+		}
+	}
+
+	var queued []int
 
 	for i := startAt; i >= wal.first; i-- {
-		err := os.Remove(filepath.Join(wal.root, fmt.Sprintf("%d", i)))
+		// With pruning throttled or scheduled, the segment is
+		// forgotten now and removed by the pruner when it may be.
+		if wal.pruner != nil {
+			wal.forgetSegment(i)
+			queued = append([]int{i}, queued...)
+			continue
+		}
+
+		err := wal.removeSegment(i)
 		if err != nil {
 			if !os.IsNotExist(err) {
 				return err
 			}
+		} else {
+			wal.forgetSegment(i)
+			wal.prunedSegment(i)
 		}
 	}
 
+	if len(queued) > 0 {
+		wal.pruner.add(queued)
+	}
+
 	// Move the oldest horizon forward to our current first segment
-	wal.first = startAt + 1
+	if startAt+1 > wal.first {
+		wal.first = startAt + 1
+	}
+
+	wal.metrics.SetGauge(MetricSegments, float64(wal.index-wal.first+1))
 
 	return nil
 }
 
+// forgetSegment stops counting segment i towards the WAL's size and its
+// streams' quotas, as it's being pruned. The lock must be held.
+func (wal *WALWriter) forgetSegment(i int) {
+	wal.sealedBytes -= wal.segSizes[i]
+	delete(wal.segSizes, i)
+
+	if wal.quotas != nil {
+		wal.quotas.release(i)
+	}
+}
+
+// prunedSegment reports that segment i has been removed. The lock must
+// be held.
+func (wal *WALWriter) prunedSegment(i int) {
+	wal.prunes++
+
+	wal.logger.Info("pruned segment", "segment", i)
+	wal.metrics.IncrCounter(MetricPrunedSegments, 1)
+}
+
 const averageOverhead = 4 + 1 + 2
 
 func (wal *WALWriter) Write(data []byte) error {
-	wal.lock.Lock()
-	defer wal.lock.Unlock()
+	return wal.WriteContext(context.Background(), data)
+}
+
+// WriteContext is like Write, but any span created for the write is a
+// child of the one in ctx.
+func (wal *WALWriter) WriteContext(ctx context.Context, data []byte) error {
+	return wal.writeData(ctx, dataType, data)
+}
+
+// WriteRaw is like Write, but stores data uncompressed whether or not
+// compression is on, for data that won't shrink, such as data that's
+// already compressed, so no time is spent trying.
+func (wal *WALWriter) WriteRaw(data []byte) error {
+	return wal.WriteRawContext(context.Background(), data)
+}
+
+// WriteRawContext is like WriteRaw, but any span created for the write
+// is a child of the one in ctx.
+func (wal *WALWriter) WriteRawContext(ctx context.Context, data []byte) error {
+	return wal.writeData(ctx, dataType|rawFlag, data)
+}
+
+// writeEntry writes data as an entry of type t, reporting the write.
+func (wal *WALWriter) writeEntry(ctx context.Context, t byte, data []byte) error {
+	_, err := wal.writeEntryAt(ctx, t, data, nil)
+	return err
+}
+
+// writeEntryAt is like writeEntry, but also returns where the entry
+// starts. If prefix is set, the body of the entry is what it returns for
+// the entry's position, which isn't known until the entry is written,
+// followed by data.
+func (wal *WALWriter) writeEntryAt(ctx context.Context, t byte, data []byte, prefix func(Position) []byte) (Position, error) {
+	ctx, span := wal.tracer.Start(ctx, SpanWrite)
+
+	start := time.Now()
+
+	pos, err := wal.write(ctx, t, data, prefix)
+	err = wal.writeResult(err)
+
+	endSpan(span, err)
+
+	if err != nil {
+		wal.metrics.IncrCounter(MetricWriteErrors, 1)
+	} else {
+		wal.metrics.IncrCounter(MetricWrites, 1)
+		wal.metrics.IncrCounter(MetricWriteBytes, int64(len(data)))
+	}
+
+	wal.metrics.Timing(MetricWriteLatency, time.Since(start))
+
+	return pos, err
+}
+
+// write writes data as an entry of type t, returning where it starts.
+// If prefix is set, it's called with the lock held to give the start of
+// the body of the entry, as for writeEntryAt.
+func (wal *WALWriter) write(ctx context.Context, t byte, data []byte, prefix func(Position) []byte) (Position, error) {
+	var recs []encodedRecord
+
+	// The prefix is written as a block of its own ahead of data, so that
+	// only it, and not data, has to be encoded under the lock.
+	if prefix != nil {
+		recs = wal.encode(t, data)
+	} else {
+		recs = wal.encodeParallel(t, data)
+	}
+
+	wal.lockIO()
+	defer wal.unlockIO()
+
+	if wal.closed {
+		return Position{}, ErrClosed
+	}
 
 	newSize := int64(len(data)) + averageOverhead + wal.segment.Size()
 
 	if newSize > wal.opts.SegmentSize {
+		_, span := wal.tracer.Start(ctx, SpanRotate)
 		err := wal.rotateSegment()
+		endSpan(span, err)
 		if err != nil {
-			return err
+			return Position{}, err
 		}
 
 		err = wal.pruneSegments(wal.opts.MaxSegments)
 		if err != nil {
-			return err
+			return Position{}, err
+		}
+	}
+
+	var (
+		stream string
+		size   int64
+	)
+
+	if t&^rawFlag == streamType {
+		var err error
+
+		stream, size, err = wal.checkQuota(data)
+		if err != nil {
+			return Position{}, err
+		}
+	}
+
+	pos := Position{wal.index, wal.segment.Size()}
+
+	if prefix != nil {
+		rec := encodeRecord(crc32.NewIEEE(), blockType|rawFlag, prefix(pos), nil, nil)
+		recs = append([]encodedRecord{rec}, recs...)
+	}
+
+	var err error
+
+	if recs != nil {
+		err = wal.segment.writeEncoded(ctx, recs)
+	} else {
+		_, err = wal.segment.writeType(ctx, t, data)
+	}
+
+	if err == nil {
+		wal.entries++
+		wal.indexTime()
+
+		if stream != "" {
+			wal.quotas.charge(wal.index, stream, size)
+		}
+
+		wal.autoTag(ctx, len(data))
+	}
+
+	return pos, err
+}
+
+// encodeParallel encodes data as an entry of type t on several
+// goroutines, if it's large enough to be worth it, or returns nil.
+func (wal *WALWriter) encodeParallel(t byte, data []byte) []encodedRecord {
+	if wal.opts.ParallelEncodeThreshold <= 0 || len(data) < wal.opts.ParallelEncodeThreshold {
+		return nil
+	}
+
+	return wal.encode(t, data)
+}
+
+// encode encodes data as an entry of type t, as the WAL is set to.
+func (wal *WALWriter) encode(t byte, data []byte) []encodedRecord {
+	if wal.opts.NoCompression {
+		t |= rawFlag
+	}
+
+	return encodeEntry(t, data, wal.opts.BlockSize, wal.opts.EncodeWorkers)
+}
+
+type Position struct {
+	Segment int   `json:"segment"`
+	Offset  int64 `json:"offset"`
+}
+
+func (p *Position) None() bool {
+	return p.Segment == -1
+}
+
+// Valid returns true if p could be a position in a WAL. The zero
+// Position is valid and is the start of the first segment.
+func (p Position) Valid() bool {
+	return p.Segment >= 0 && p.Offset >= 0
+}
+
+func (p Position) less(o Position) bool {
+	if p.Segment != o.Segment {
+		return p.Segment < o.Segment
+	}
+
+	return p.Offset < o.Offset
+}
+
+// TagPos returns the position of the last time tag was written, or
+// ErrTagNotFound if it isn't in the WAL. Tags written since
+// the WAL was opened are found without reading any segments.
+func (wal *WALWriter) TagPos(tag []byte) (Position, error) {
+	return wal.tagPos(nil, tag)
+}
+
+// tagPos looks up tag in the WAL, or in a stream if stream is set.
+func (wal *WALWriter) tagPos(stream, tag []byte) (Position, error) {
+	wal.lock.Lock()
+
+	if wal.closed {
+		wal.lock.Unlock()
+		return Position{}, ErrClosed
+	}
+
+	pos, ok := wal.cache.Tags[tagKey(stream, tag)]
+	first := wal.first
+
+	wal.lock.Unlock()
+
+	if ok {
+		if pos.Segment < first {
+			return Position{-1, -1}, ErrTagNotFound
 		}
+
+		return pos, nil
 	}
 
-	_, err := wal.segment.Write(data)
-	return err
-}
+	r, err := newReader(wal.root, stream, ReadOptions{FS: wal.opts.FS})
+	if err != nil {
+		return Position{-1, -1}, err
+	}
 
-type Position struct {
-	Segment int   `json:"segment"`
-	Offset  int64 `json:"offset"`
-}
+	defer r.Close()
 
-func (p *Position) None() bool {
-	return p.Segment == -1
+	return r.SeekTag(tag)
 }
 
 func (wal *WALWriter) Pos() (Position, error) {
 	wal.lock.Lock()
 	defer wal.lock.Unlock()
 
+	if wal.closed {
+		return Position{}, ErrClosed
+	}
+
 	pos := wal.segment.Pos()
 
 	return Position{wal.index, pos}, nil
 }
 
 func (wal *WALWriter) WriteTag(tag []byte) error {
-	wal.lock.Lock()
-	defer wal.lock.Unlock()
+	return wal.WriteTagContext(context.Background(), tag)
+}
 
+// WriteTagContext is like WriteTag, but any span created for the write
+// is a child of the one in ctx.
+func (wal *WALWriter) WriteTagContext(ctx context.Context, tag []byte) error {
+	return wal.writeTag(ctx, tagType, tag, tagKey(nil, tag))
+}
+
+// writeTag writes a tag record of type t, recording its position under
+// each of keys in the tag cache.
+func (wal *WALWriter) writeTag(ctx context.Context, t byte, tag []byte, keys ...string) (err error) {
+	ctx, span := wal.tracer.Start(ctx, SpanWriteTag)
+	defer func() { endSpan(span, err) }()
+
+	wal.lockIO()
+	defer wal.unlockIO()
+
+	if wal.closed {
+		return ErrClosed
+	}
+
+	return wal.appendTag(ctx, t, tag, keys...)
+}
+
+// appendTag writes a tag record as writeTag does. The lock must be held.
+func (wal *WALWriter) appendTag(ctx context.Context, t byte, tag []byte, keys ...string) error {
 	// We truncate the cache and rewrite it after the segment
 	// has confirmed the tag so the cache is either absent
 	// or correct, never present but out of date.
-	truncErr := wal.cacheFile.Truncate(0)
+	truncErr := errNoTagCache
+	if wal.cacheFile != nil {
+		truncErr = wal.cacheFile.Truncate(0)
+	}
+
+	if truncErr == nil {
+		_, truncErr = wal.cacheFile.Seek(0, io.SeekStart)
+	}
+
+	if truncErr == nil {
+		wal.cacheBytes = 0
+	}
 
 	segPos := wal.segment.Pos()
 
-	err := wal.segment.WriteTag(tag)
+	_, err := wal.segment.writeType(ctx, t, tag)
 	if err != nil {
-		return err
+		return wal.writeResult(err)
 	}
 
+	wal.indexTime()
+
+	wal.writeResult(nil)
+
+	wal.tags += int64(len(keys))
+
+	for _, key := range keys {
+		if wal.opts.AutoTag.enabled() && key == tagKey(nil, wal.opts.AutoTag.Tag) {
+			wal.autoTags.reset()
+		}
+	}
+
+	wal.metrics.IncrCounter(MetricTags, int64(len(keys)))
+
 	if truncErr == nil {
-		key := base64.URLEncoding.EncodeToString(tag)
-		wal.cache.Tags[key] = Position{wal.index, segPos}
+		for _, key := range keys {
+			wal.cache.Tags[key] = Position{wal.index, segPos}
+		}
 
 		err = wal.cacheEnc.Encode(&wal.cache)
 		if err == nil {
 			wal.cacheFile.Sync()
 		}
+
+		wal.cacheBytes, _ = wal.cacheFile.Seek(0, io.SeekCurrent)
 	}
 
 	return nil
 }
 
+// Close seals the active segment, stops any background syncing, and
+// releases the WAL's files and lock. Closing a closed WAL does nothing;
+// other methods return ErrClosed.
 func (wal *WALWriter) Close() error {
-	return wal.segment.Close()
+	// The scrubber and pruner take the lock, so they're stopped before
+	// the lock is held.
+	wal.stopScrubber()
+	wal.stopPruner()
+
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	if wal.closed {
+		return nil
+	}
+
+	wal.closed = true
+
+	wal.closeTimeIndex()
+
+	err := wal.segment.Close()
+
+	if wal.cacheFile != nil {
+		if cerr := wal.cacheFile.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	if cerr := wal.lockf.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+type ReadOptions struct {
+	// The size of the buffer used when reading from segment files. Larger
+	// values speed up replaying large WALs, smaller ones reduce the memory
+	// used by each reader. If 0, 16KB is used. Values below 64 bytes are
+	// rounded up.
+	BufferSize int
+
+	// Controls how the buffers used to decompress entries grow and shrink.
+	// If unset, DefaultBufferPolicy is used.
+	BufferPolicy BufferPolicy
+
+	// If true, the kernel is told that segments will be read sequentially
+	// and, once a reader is done with a segment, that its pages can be
+	// dropped from the page cache. This keeps large scans from evicting
+	// the application's hot data. Only has an effect on Linux.
+	DropPageCache bool
+
+	// If set, the memory held by the reader's buffers counts against
+	// this budget, which can be shared by many readers.
+	Budget *MemoryBudget
+
+	// If true, entries are not checked against their CRC. This roughly
+	// doubles read throughput but means corruption goes undetected, so
+	// only use it for data whose integrity has already been verified,
+	// such as data just written by the same process. It's the same as
+	// setting Checksums to ChecksumNever.
+	SkipChecksums bool
+
+	// Which entries are checked against their CRC. The zero value checks
+	// them all.
+	Checksums ChecksumPolicy
+
+	// If greater than 0, entries are read and decoded on a background
+	// goroutine, up to this many ahead of the caller, overlapping IO and
+	// decompression with the caller's processing of each value. Values
+	// returned while prefetching are never reused by the reader.
+	Prefetch int
+
+	// If true, Next doesn't decode the blocks of data entries written in
+	// blocks, leaving them to be decoded a block at a time as they're
+	// read from ValueReader. Value still works, reading the blocks again
+	// to assemble the whole value. Ignored while prefetching.
+	StreamValues bool
+
+	// If set, segments that are missing, such as ones pruned since the
+	// reader started, or that turn out to be damaged, are fetched from
+	// here and read in place of the local ones, so that readers of a
+	// long history survive the WAL pruning it locally. BlobArchiver is
+	// one.
+	Source SegmentSource
+
+	// Where segments fetched from Source are kept, for this and later
+	// readers to use. A relative path is within the WAL's directory. If
+	// empty, "fetched" is used. Nothing removes them, so it's up to the
+	// application to clear out the ones it's done with.
+	CacheDir string
+
+	// Receives metrics about reads. If nil, no metrics are reported.
+	Metrics MetricsSink
+
+	// Starts spans around tag seeks and recovery. If nil, no spans are
+	// created.
+	Tracer Tracer
+
+	// Logs entries that fail to read, such as corrupt ones. If nil,
+	// nothing is logged.
+	Logger *slog.Logger
+
+	// The filesystem the WAL is stored in. If nil, OSFS is used.
+	FS FS
+}
+
+var DefaultReadOptions = ReadOptions{
+	BufferSize:   bufferSize,
+	BufferPolicy: DefaultBufferPolicy,
+}
+
+// EmbeddedReadOptions pair with EmbeddedWriteOptions, keeping the
+// reader's buffers small.
+var EmbeddedReadOptions = ReadOptions{
+	BufferSize:   512,
+	BufferPolicy: embeddedBufferPolicy,
+}
+
+func (ro *ReadOptions) bufferSize() int {
+	switch {
+	case ro.BufferSize <= 0:
+		return bufferSize
+	case ro.BufferSize < minBufferSize:
+		return minBufferSize
+	default:
+		return ro.BufferSize
+	}
+}
+
+func (ro *ReadOptions) bufferPolicy() BufferPolicy {
+	if ro.BufferPolicy == (BufferPolicy{}) {
+		return DefaultBufferPolicy
+	}
+
+	return ro.BufferPolicy
 }
 
 type WALReader struct {
@@ -299,13 +1398,36 @@ type WALReader struct {
 
 	lastSegPos int64
 
-	err error
+	opts ReadOptions
+
+	// If set, data past this position is not read because it may still
+	// be being written. Used by PairedReader.
+	tail *Position
+
+	// If set, only the entries of this stream are read.
+	stream []byte
+
+	// If set, tags are read along with entries. Used by AllRecords.
+	tags bool
+
+	err    error
+	closed bool
 }
 
 var ErrNoSegments = errors.New("no segments")
 
 func NewReader(root string) (*WALReader, error) {
-	r := &WALReader{root: root}
+	return NewReaderWithOptions(root, DefaultReadOptions)
+}
+
+func NewReaderWithOptions(root string, opts ReadOptions) (*WALReader, error) {
+	return newReader(root, nil, opts)
+}
+
+// newReader returns a reader of the WAL in root, or of one of its
+// streams if stream is set.
+func newReader(root string, stream []byte, opts ReadOptions) (*WALReader, error) {
+	r := &WALReader{root: root, opts: opts, stream: stream}
 
 	err := r.Reset()
 	if err != nil {
@@ -316,11 +1438,15 @@ func NewReader(root string) (*WALReader, error) {
 }
 
 func (wal *WALReader) Reset() error {
+	if wal.closed {
+		return ErrClosed
+	}
+
 	if wal.seg != nil {
 		wal.seg.Close()
 	}
 
-	first, last, err := rangeSegments(wal.root)
+	first, last, err := rangeSegments(fsOrOS(wal.opts.FS), wal.root)
 	if err != nil {
 		return err
 	}
@@ -329,14 +1455,12 @@ func (wal *WALReader) Reset() error {
 		return ErrNoSegments
 	}
 
-	cur := filepath.Join(wal.root, fmt.Sprintf("%d", first))
-
-	r, err := NewSegmentReader(cur)
+	r, err := wal.openSegment(first)
 	if err != nil {
 		return err
 	}
 
-	wal.current = cur
+	wal.current = filepath.Join(wal.root, fmt.Sprintf("%d", first))
 	wal.first = first
 	wal.last = last
 	wal.index = first
@@ -345,6 +1469,58 @@ func (wal *WALReader) Reset() error {
 	return nil
 }
 
+// SetBufferPolicy controls how the buffers used to read and decompress
+// entries grow and shrink, for the current and all future segments.
+func (wal *WALReader) SetBufferPolicy(p BufferPolicy) {
+	wal.opts.BufferPolicy = p
+
+	if wal.seg != nil {
+		wal.seg.SetBufferPolicy(p)
+	}
+}
+
+// Prefetch sets how many entries are read and decoded ahead of the
+// caller on a background goroutine, for the current and all future
+// segments. 0 disables prefetching.
+func (wal *WALReader) Prefetch(n int) {
+	wal.opts.Prefetch = n
+
+	if wal.seg != nil {
+		wal.seg.Prefetch(n)
+	}
+}
+
+func (wal *WALReader) openSegment(index int) (*SegmentReader, error) {
+	path := filepath.Join(wal.root, fmt.Sprintf("%d", index))
+
+	seg, err := NewSegmentReaderWithOptions(path, wal.opts)
+	if os.IsNotExist(err) && wal.opts.Source != nil {
+		seg, err = wal.openFetched(index, err)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	seg.stream = wal.stream
+	seg.tags = wal.tags
+
+	wal.limitSegment(seg, index)
+
+	return seg, nil
+}
+
+func (wal *WALReader) limitSegment(seg *SegmentReader, index int) {
+	switch {
+	case wal.tail == nil || index < wal.tail.Segment:
+		seg.setLimit(-1)
+	case index == wal.tail.Segment:
+		seg.setLimit(wal.tail.Offset)
+	default:
+		seg.setLimit(0)
+	}
+}
+
 func (wal *WALReader) Pos() (Position, error) {
 	if wal.seg == nil {
 		return Position{wal.index, wal.lastSegPos}, nil
@@ -353,11 +1529,22 @@ func (wal *WALReader) Pos() (Position, error) {
 	return Position{wal.index, wal.seg.Pos()}, nil
 }
 
+// Seek moves the reader to p. The position isn't checked, so seeking
+// into the middle of an entry causes the next read to fail with
+// ErrCorruptCRC; use Validate or SeekNearest for a position that may
+// not be at an entry. If p's segment has been pruned, Seek returns a
+// PrunedError.
 func (wal *WALReader) Seek(p Position) error {
-	path := filepath.Join(wal.root, fmt.Sprintf("%d", p.Segment))
+	if wal.closed {
+		return ErrClosed
+	}
 
-	seg, err := NewSegmentReader(path)
+	seg, err := wal.openSegment(p.Segment)
 	if err != nil {
+		if os.IsNotExist(err) {
+			err = wal.missingSegment(p, err)
+		}
+
 		return err
 	}
 
@@ -374,60 +1561,316 @@ func (wal *WALReader) Seek(p Position) error {
 	return nil
 }
 
+// missingSegment returns the error for the segment of p not existing: a
+// PrunedError if it's older than the oldest segment, or
+// ErrSegmentMissing if it's a gap, wrapping err either way.
+func (wal *WALReader) missingSegment(p Position, err error) error {
+	first, _, rerr := rangeSegments(fsOrOS(wal.opts.FS), wal.root)
+	if rerr == nil && first != -1 && p.Segment < first {
+		return &PrunedError{
+			Pos:    p,
+			Oldest: Position{first, 0},
+			Err:    err,
+		}
+	}
+
+	return fmt.Errorf("%w: %w", ErrSegmentMissing, err)
+}
+
+// SeekNearest moves the reader to the first entry at or after p,
+// scanning forward from p to the next entry's start if p is in the
+// middle of one, and returns the position it moved to. A position past
+// the last entry of a segment moves to the start of the next segment.
+// It returns ErrInvalidPosition if there's nothing at or after p.
+func (wal *WALReader) SeekNearest(p Position) (Position, error) {
+	if wal.closed {
+		return Position{-1, -1}, ErrClosed
+	}
+
+	if p.Segment < 0 {
+		return Position{-1, -1}, fmt.Errorf("%w: %d:%d", ErrInvalidPosition, p.Segment, p.Offset)
+	}
+
+	seg, err := wal.openSegment(p.Segment)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = wal.missingSegment(p, err)
+		}
+
+		return Position{-1, -1}, err
+	}
+
+	off, err := entryBoundary(seg.f, p.Offset)
+	if err != nil {
+		seg.Close()
+		return Position{-1, -1}, err
+	}
+
+	if off == -1 {
+		seg.Close()
+
+		next := Position{p.Segment + 1, 0}
+
+		_, err = fsOrOS(wal.opts.FS).Stat(filepath.Join(wal.root, strconv.Itoa(next.Segment)))
+		if err != nil {
+			if os.IsNotExist(err) {
+				err = fmt.Errorf("%w: %d:%d", ErrInvalidPosition, p.Segment, p.Offset)
+			}
+
+			return Position{-1, -1}, err
+		}
+
+		return next, wal.Seek(next)
+	}
+
+	err = seg.Seek(off)
+	if err != nil {
+		seg.Close()
+		return Position{-1, -1}, err
+	}
+
+	if wal.seg != nil {
+		wal.seg.Close()
+	}
+
+	wal.index = p.Segment
+	wal.seg = seg
+
+	return Position{p.Segment, off}, nil
+}
+
+// Validate checks that p is still a position in the WAL that Seek can
+// move to: its segment hasn't been pruned, and it's the start of an
+// entry or the end of the segment's entries. It returns a PrunedError,
+// ErrSegmentMissing or ErrInvalidPosition if not, so a saved position
+// can be checked before seeking to it.
+func (wal *WALReader) Validate(p Position) error {
+	if wal.closed {
+		return ErrClosed
+	}
+
+	if p.Segment < 0 || p.Offset < 0 {
+		return fmt.Errorf("%w: %d:%d", ErrInvalidPosition, p.Segment, p.Offset)
+	}
+
+	path := filepath.Join(wal.root, strconv.Itoa(p.Segment))
+
+	f, err := fsOrOS(wal.opts.FS).OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = wal.missingSegment(p, err)
+		}
+
+		return err
+	}
+
+	defer f.Close()
+
+	off, err := entryBoundary(f, p.Offset)
+	if err != nil {
+		return err
+	}
+
+	if off != p.Offset {
+		return fmt.Errorf("%w: %d:%d", ErrInvalidPosition, p.Segment, p.Offset)
+	}
+
+	return nil
+}
+
+// Contains reports whether Validate accepts p.
+func (wal *WALReader) Contains(p Position) bool {
+	return wal.Validate(p) == nil
+}
+
+// SeekTag returns the position of the last time tag was written. It
+// returns ErrTagNotFound if the tag isn't in any segment.
 func (wal *WALReader) SeekTag(tag []byte) (Position, error) {
+	return wal.SeekTagContext(context.Background(), tag)
+}
+
+// SeekTagContext is like SeekTag, but any span created for the seek is
+// a child of the one in ctx.
+func (wal *WALReader) SeekTagContext(ctx context.Context, tag []byte) (Position, error) {
+	_, span := tracerOrNop(wal.opts.Tracer).Start(ctx, SpanSeekTag)
+
+	pos, _, err := wal.seekTag(tag)
+
+	endSpan(span, err)
+
+	return pos, err
+}
+
+// FindTag returns the position of the last time tag was written, like
+// SeekTag, but leaves the reader where it was. It returns
+// ErrTagNotFound if the tag isn't in any segment.
+func (wal *WALReader) FindTag(tag []byte) (Position, error) {
+	_, span := tracerOrNop(wal.opts.Tracer).Start(context.Background(), SpanSeekTag)
+
+	pos, _, seg, err := wal.findTag(tag)
+	if err == nil {
+		seg.Close()
+	}
+
+	endSpan(span, err)
+
+	return pos, err
+}
+
+// seekTag moves the reader to just after the last time tag was written,
+// returning its position and the payload it was written with. If the
+// tag can't be found, the reader is left where it was.
+func (wal *WALReader) seekTag(tag []byte) (Position, []byte, error) {
+	pos, payload, seg, err := wal.findTag(tag)
+	if err != nil {
+		return pos, nil, err
+	}
+
+	// Leave the reader in the segment the tag was last written in, just
+	// after it.
+	if wal.seg != nil {
+		wal.seg.Close()
+	}
+
+	wal.seg = seg
+	wal.index = pos.Segment
+	wal.current = filepath.Join(wal.root, fmt.Sprintf("%d", wal.index))
+
+	return pos, payload, nil
+}
+
+// findTag returns the position of the last time tag was written, the
+// payload it was written with, and a reader of its segment positioned
+// just after it, which the caller must close. The reader's own state
+// isn't touched.
+func (wal *WALReader) findTag(tag []byte) (Position, []byte, *SegmentReader, error) {
 	lastPos := Position{-1, -1}
 
+	if wal.closed {
+		return lastPos, nil, nil, ErrClosed
+	}
+
+	var (
+		found   *SegmentReader
+		payload []byte
+	)
+
 	index := wal.first
 
 	for {
-		path := filepath.Join(wal.root, fmt.Sprintf("%d", index))
-
-		seg, err := NewSegmentReader(path)
+		seg, err := wal.openSegment(index)
 		if err != nil {
 			if os.IsNotExist(err) {
-				return lastPos, nil
+				if found == nil {
+					return lastPos, nil, nil, ErrTagNotFound
+				}
+
+				return lastPos, payload, found, nil
 			}
 
-			return lastPos, err
-		}
+			if found != nil {
+				found.Close()
+			}
 
-		wal.seg = seg
+			return lastPos, nil, nil, err
+		}
 
 		pos, err := seg.SeekTag(tag)
 		if err != nil {
-			return lastPos, err
+			seg.Close()
+
+			if found != nil {
+				found.Close()
+			}
+
+			return lastPos, nil, nil, err
 		}
 
 		if pos >= 0 {
+			if found != nil {
+				found.Close()
+			}
+
+			found = seg
 			lastPos = Position{index, pos}
+			payload = seg.tagPayload
+		} else {
+			seg.Close()
 		}
 
 		index++
 	}
-
-	return lastPos, nil
 }
 
+// Close releases the reader's files. Closing a closed reader does
+// nothing; Next returns false with Error reporting ErrClosed.
 func (r *WALReader) Close() error {
+	if r.closed {
+		return nil
+	}
+
+	r.closed = true
+
 	if r.seg == nil {
 		return nil
 	}
 
-	return r.seg.Close()
+	r.lastSegPos = r.seg.Pos()
+
+	err := r.seg.Close()
+	r.seg = nil
+
+	return err
 }
 
 func (r *WALReader) Next() bool {
+	m := metricsOrNop(r.opts.Metrics)
+
+	if !r.next() {
+		if err := r.Error(); err != nil {
+			m.IncrCounter(MetricReadErrors, 1)
+			loggerOrDiscard(r.opts.Logger).Warn("failed to read entry", "segment", r.index, "error", err)
+		}
+
+		return false
+	}
+
+	m.IncrCounter(MetricReads, 1)
+	m.IncrCounter(MetricReadBytes, r.seg.valueSize())
+
+	return true
+}
+
+func (r *WALReader) next() bool {
+	if r.closed {
+		r.err = ErrClosed
+		return false
+	}
+
+	r.limitSegment(r.seg, r.index)
+
 	if r.seg.Next() {
 		return true
 	}
 
+	// Stop at a damaged segment, such as one whose seal is broken,
+	// rather than skipping the rest of it, unless an intact copy can be
+	// read in its place.
+	if r.seg.Error() != nil {
+		if r.repairSegment(r.seg.Error()) {
+			return r.next()
+		}
+
+		return false
+	}
+
 	r.lastSegPos = r.seg.Pos()
 	idx := r.index
 
 	for {
 		idx++
 		if idx > r.last {
-			_, last, err := rangeSegments(r.root)
+			_, last, err := rangeSegments(fsOrOS(r.opts.FS), r.root)
 			if err != nil {
 				r.err = err
 				return false
@@ -438,26 +1881,31 @@ func (r *WALReader) Next() bool {
 			}
 		}
 
-		r.index = idx
-
-		path := filepath.Join(r.root, fmt.Sprintf("%d", r.index))
-
-		seg, err := NewSegmentReader(path)
+		seg, err := r.openSegment(idx)
 		if err != nil {
+			if os.IsNotExist(err) {
+				err = r.missingSegment(Position{idx, 0}, err)
+			}
+
 			r.err = err
 			return false
 		}
 
-		if seg.Next() {
+		ok := seg.Next()
+
+		// An empty newest segment is still being written, so move onto it
+		// rather than skipping past it.
+		if ok || idx == r.last || seg.Error() != nil {
 			if r.seg != nil {
 				r.seg.Close()
 			}
 			r.seg = seg
-			break
+			r.index = idx
+			return ok
 		}
-	}
 
-	return true
+		seg.Close()
+	}
 }
 
 func (r *WALReader) Value() []byte {
@@ -468,6 +1916,30 @@ func (r *WALReader) Value() []byte {
 	return r.seg.Value()
 }
 
+// ValueReader returns a reader of the current entry's value. With
+// ReadOptions.StreamValues set, an entry written in blocks is decoded a
+// block at a time as it's read, so a value of many megabytes never has
+// to be in memory all at once. Other values are read from Value. The
+// reader is only valid until Next is called again.
+func (r *WALReader) ValueReader() io.Reader {
+	if r.seg == nil {
+		return bytes.NewReader(nil)
+	}
+
+	return r.seg.ValueReader()
+}
+
+// Header describes how the current entry is stored in the reader's
+// current segment, for tools that need its framing rather than just its
+// value.
+func (r *WALReader) Header() RecordHeader {
+	if r.seg == nil {
+		return RecordHeader{}
+	}
+
+	return r.seg.Header()
+}
+
 func (r *WALReader) Error() error {
 	if r.err != nil {
 		return r.err
@@ -1,12 +1,23 @@
 package wal
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"io"
 	"io/ioutil"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -165,6 +176,31 @@ func TestWal(t *testing.T) {
 		assert.Equal(t, 1, wal.first)
 	})
 
+	n.It("never moves the oldest horizon backwards when pruning", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		for i := 0; i < 3; i++ {
+			err = wal.Write([]byte("this is data"))
+			require.NoError(t, err)
+
+			err = wal.rotateSegment()
+			require.NoError(t, err)
+		}
+
+		err = wal.pruneSegments(1)
+		require.NoError(t, err)
+
+		assert.Equal(t, 3, wal.first)
+
+		err = wal.pruneSegments(10)
+		require.NoError(t, err)
+
+		assert.Equal(t, 3, wal.first)
+	})
+
 	n.It("supports asking for and seeking to a position", func() {
 		wal, err := New(path)
 		require.NoError(t, err)
@@ -457,6 +493,247 @@ func TestWal(t *testing.T) {
 		assert.Equal(t, "more data", string(r.Value()))
 	})
 
+	n.It("reports a tag that isn't in the WAL", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		err = wal.Write([]byte("data"))
+		require.NoError(t, err)
+
+		_, err = wal.TagPos([]byte("missing"))
+		assert.Equal(t, ErrTagNotFound, err)
+
+		err = wal.Close()
+		require.NoError(t, err)
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		pos, err := r.SeekTag([]byte("missing"))
+		assert.Equal(t, ErrTagNotFound, err)
+		assert.False(t, pos.Valid())
+	})
+
+	n.It("finds a tag without moving the reader", func() {
+		wal, err := New(path, WithSegmentSize(64), WithMaxSegments(100))
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("first")))
+		require.NoError(t, wal.Write([]byte("this is data that needs a segment of its own, being long")))
+		require.NoError(t, wal.WriteTag([]byte("commit")))
+		require.NoError(t, wal.Write([]byte("after")))
+
+		want, err := wal.TagPos([]byte("commit"))
+		require.NoError(t, err)
+		require.True(t, want.Segment > 0)
+
+		require.NoError(t, wal.Close())
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.True(t, r.Next())
+		assert.Equal(t, "first", string(r.Value()))
+
+		before, err := r.Pos()
+		require.NoError(t, err)
+
+		pos, err := r.FindTag([]byte("commit"))
+		require.NoError(t, err)
+		assert.Equal(t, want, pos)
+
+		_, err = r.FindTag([]byte("missing"))
+		assert.Equal(t, ErrTagNotFound, err)
+
+		// A failed seek leaves the reader where it was too.
+		_, err = r.SeekTag([]byte("missing"))
+		assert.Equal(t, ErrTagNotFound, err)
+
+		after, err := r.Pos()
+		require.NoError(t, err)
+		assert.Equal(t, before, after)
+
+		require.True(t, r.Next())
+		assert.Equal(t, "this is data that needs a segment of its own, being long", string(r.Value()))
+	})
+
+	n.It("looks up tags from the writer", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		err = wal.WriteTag([]byte("old"))
+		require.NoError(t, err)
+
+		err = wal.Close()
+		require.NoError(t, err)
+
+		wal, err = New(path)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		err = wal.Write([]byte("data"))
+		require.NoError(t, err)
+
+		want, err := wal.Pos()
+		require.NoError(t, err)
+
+		err = wal.WriteTag([]byte("new"))
+		require.NoError(t, err)
+
+		pos, err := wal.TagPos([]byte("new"))
+		require.NoError(t, err)
+		assert.Equal(t, want, pos)
+
+		pos, err = wal.TagPos([]byte("old"))
+		require.NoError(t, err)
+		assert.True(t, pos.Valid())
+		assert.True(t, pos.less(want))
+	})
+
+	n.It("gives up opening when the context is done", func() {
+		fs := &stallingFS{FS: NewMemFS(), release: make(chan struct{})}
+
+		opts := DefaultWriteOptions
+		opts.FS = fs
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err := NewWithContext(ctx, path, opts)
+		assert.Equal(t, context.DeadlineExceeded, err)
+
+		close(fs.release)
+
+		wal, err := NewWithContext(context.Background(), path, opts)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("data")))
+		require.NoError(t, wal.Close())
+	})
+
+	n.It("doesn't open with a canceled context", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := NewWithContext(ctx, path, DefaultWriteOptions)
+		assert.Equal(t, context.Canceled, err)
+
+		_, err = os.Stat(path)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	n.It("releases every file on close", func() {
+		fs := &trackingFS{FS: NewMemFS()}
+
+		opts := DefaultWriteOptions
+		opts.FS = fs
+		opts.SyncRate = time.Millisecond
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("data")))
+		require.NoError(t, wal.WriteTag([]byte("tag")))
+
+		require.NoError(t, wal.Close())
+		assert.Equal(t, int64(0), atomic.LoadInt64(&fs.open))
+
+		assert.NoError(t, wal.Close())
+
+		_, err = wal.Pos()
+		assert.Equal(t, ErrClosed, err)
+
+		_, err = wal.TagPos([]byte("tag"))
+		assert.Equal(t, ErrClosed, err)
+
+		ro := DefaultReadOptions
+		ro.FS = fs
+
+		r, err := NewReaderWithOptions(path, ro)
+		require.NoError(t, err)
+
+		require.True(t, r.Next())
+
+		require.NoError(t, r.Close())
+		assert.Equal(t, int64(0), atomic.LoadInt64(&fs.open))
+
+		assert.NoError(t, r.Close())
+
+		assert.False(t, r.Next())
+		assert.Equal(t, ErrClosed, r.Error())
+	})
+
+	n.It("creates only new WALs", func() {
+		wal, err := Create(path, DefaultWriteOptions)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("data")))
+		require.NoError(t, wal.Close())
+
+		_, err = Create(path, DefaultWriteOptions)
+		assert.True(t, errors.Is(err, ErrDirNotEmpty))
+	})
+
+	n.It("opens only existing WALs", func() {
+		_, err := Open(path, DefaultWriteOptions)
+		assert.True(t, os.IsNotExist(err))
+
+		_, err = os.Stat(path)
+		assert.True(t, os.IsNotExist(err))
+
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("data")))
+		require.NoError(t, wal.Close())
+
+		wal, err = Open(path, DefaultWriteOptions)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Close())
+	})
+
+	n.It("opens read only without changing anything", func() {
+		_, err := OpenReadOnly(path, DefaultReadOptions)
+		assert.True(t, os.IsNotExist(err))
+
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("data")))
+		require.NoError(t, wal.WriteTag([]byte("tag")))
+
+		before, err := ioutil.ReadFile(filepath.Join(path, "tags"))
+		require.NoError(t, err)
+
+		r, err := OpenReadOnly(path, DefaultReadOptions)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.True(t, r.Next())
+		assert.Equal(t, "data", string(r.Value()))
+
+		after, err := ioutil.ReadFile(filepath.Join(path, "tags"))
+		require.NoError(t, err)
+
+		assert.Equal(t, before, after)
+
+		require.NoError(t, wal.Close())
+	})
+
+	n.It("validates positions", func() {
+		assert.True(t, Position{}.Valid())
+		assert.True(t, Position{Segment: 2, Offset: 10}.Valid())
+		assert.False(t, Position{Segment: -1, Offset: -1}.Valid())
+		assert.False(t, Position{Segment: 0, Offset: -5}.Valid())
+	})
+
 	n.It("can find a tag in any segment", func() {
 		wal, err := New(path)
 		require.NoError(t, err)
@@ -567,6 +844,41 @@ func TestWal(t *testing.T) {
 		assert.Equal(t, pos, tc.Tags[key])
 	})
 
+	n.It("rewrites the tag cache from the start of the file", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		err = wal.WriteTag([]byte("first"))
+		require.NoError(t, err)
+
+		err = wal.Write([]byte("this is data"))
+		require.NoError(t, err)
+
+		pos, err := wal.Pos()
+		require.NoError(t, err)
+
+		err = wal.WriteTag([]byte("second"))
+		require.NoError(t, err)
+
+		data, err := os.ReadFile(filepath.Join(path, "tags"))
+		require.NoError(t, err)
+
+		require.NotEmpty(t, data)
+		assert.NotEqual(t, byte(0), data[0])
+
+		var tc tagCache
+
+		err = json.Unmarshal(data, &tc)
+		require.NoError(t, err)
+
+		key := base64.URLEncoding.EncodeToString([]byte("second"))
+
+		assert.Equal(t, pos, tc.Tags[key])
+		assert.Len(t, tc.Tags, 2)
+	})
+
 	n.It("allows the reader to continue after hitting the end", func() {
 		wal, err := New(path)
 		require.NoError(t, err)
@@ -593,5 +905,1030 @@ func TestWal(t *testing.T) {
 		assert.Equal(t, "more data", string(r.Value()))
 	})
 
-	n.Meow()
+	n.It("accepts read options", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		err = wal.Write([]byte("this is data"))
+		require.NoError(t, err)
+
+		err = wal.Close()
+		require.NoError(t, err)
+
+		ro := DefaultReadOptions
+		ro.BufferSize = 1024 * 1024
+
+		r, err := NewReaderWithOptions(path, ro)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		assert.Equal(t, 1024*1024, r.seg.r.Size())
+
+		require.True(t, r.Next())
+
+		assert.Equal(t, "this is data", string(r.Value()))
+	})
+
+	n.It("rounds tiny read buffers up and stops at the closing magic", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		err = wal.Write([]byte("this is data"))
+		require.NoError(t, err)
+
+		err = wal.Close()
+		require.NoError(t, err)
+
+		ro := DefaultReadOptions
+		ro.BufferSize = 1
+
+		r, err := NewReaderWithOptions(path, ro)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		assert.Equal(t, minBufferSize, r.seg.r.Size())
+
+		require.True(t, r.Next())
+		assert.Equal(t, "this is data", string(r.Value()))
+
+		assert.False(t, r.Next())
+		require.NoError(t, r.Error())
+	})
+
+	n.It("writes large entries whole by default", func() {
+		wal, err := New(path)
+		require.NoError(t, err)
+
+		big := make([]byte, 4*1024*1024)
+		_, err = rand.Read(big)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write(big))
+		require.NoError(t, wal.Close())
+
+		sr, err := NewSegmentReader(filepath.Join(path, "0"))
+		require.NoError(t, err)
+
+		defer sr.Close()
+
+		e, err := sr.readRecord()
+		require.NoError(t, err)
+
+		assert.Equal(t, byte(dataType), e.entryType)
+	})
+
+	n.It("encodes large entries in parallel", func() {
+		opts := DefaultWriteOptions
+		opts.BlockSize = 1024
+		opts.ParallelEncodeThreshold = 4096
+		opts.EncodeWorkers = 4
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		big := make([]byte, 64*1024+10)
+		_, err = rand.Read(big)
+		require.NoError(t, err)
+
+		err = wal.Write(big)
+		require.NoError(t, err)
+
+		err = wal.Write([]byte("small"))
+		require.NoError(t, err)
+
+		err = wal.Close()
+		require.NoError(t, err)
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.True(t, r.Next())
+
+		assert.Equal(t, big, r.Value())
+
+		require.True(t, r.Next())
+
+		assert.Equal(t, "small", string(r.Value()))
+	})
+
+	n.It("stores entries uncompressed with the embedded options", func() {
+		wal, err := NewWithOptions(path, EmbeddedWriteOptions)
+		require.NoError(t, err)
+
+		big := bytes.Repeat([]byte("0123456789"), 1000)
+
+		require.NoError(t, wal.Write([]byte("plain entry")))
+		require.NoError(t, wal.WriteTag([]byte("tag")))
+		require.NoError(t, wal.Write(big))
+		require.NoError(t, wal.Close())
+
+		data, err := ioutil.ReadFile(filepath.Join(path, "0"))
+		require.NoError(t, err)
+
+		assert.True(t, bytes.Contains(data, []byte("plain entry")))
+
+		// Compressed and uncompressed entries can be mixed.
+		wal, err = New(path)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write(big))
+		require.NoError(t, wal.Close())
+
+		r, err := NewReaderWithOptions(path, EmbeddedReadOptions)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.True(t, r.Next())
+		assert.Equal(t, "plain entry", string(r.Value()))
+
+		require.True(t, r.Next())
+		assert.Equal(t, big, r.Value())
+
+		require.True(t, r.Next())
+		assert.Equal(t, big, r.Value())
+
+		assert.False(t, r.Next())
+		require.NoError(t, r.Error())
+
+		_, err = r.SeekTag([]byte("tag"))
+		require.NoError(t, err)
+	})
+
+	n.It("encodes uncompressed entries in parallel", func() {
+		opts := EmbeddedWriteOptions
+		opts.ParallelEncodeThreshold = 4096
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		big := make([]byte, 64*1024+10)
+		_, err = rand.Read(big)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write(big))
+		require.NoError(t, wal.Close())
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.True(t, r.Next())
+		assert.Equal(t, big, r.Value())
+	})
+
+	n.It("reports metrics to the configured sink", func() {
+		var m testMetrics
+
+		opts := DefaultWriteOptions
+		opts.SegmentSize = 20
+		opts.MaxSegments = 1
+		opts.Metrics = &m
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		err = wal.Write([]byte("this is data"))
+		require.NoError(t, err)
+
+		err = wal.Write([]byte("in the second segment because this is a bigger value"))
+		require.NoError(t, err)
+
+		err = wal.WriteTag([]byte("commit"))
+		require.NoError(t, err)
+
+		err = wal.Close()
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(2), m.counter(MetricWrites))
+		assert.Equal(t, int64(64), m.counter(MetricWriteBytes))
+		assert.Equal(t, int64(1), m.counter(MetricTags))
+		assert.Equal(t, int64(1), m.counter(MetricRotations))
+		assert.Equal(t, int64(1), m.counter(MetricPrunedSegments))
+		assert.Equal(t, float64(1), m.gauge(MetricSegments))
+		assert.True(t, m.timings(MetricSyncLatency) > 0)
+
+		ro := DefaultReadOptions
+		ro.Metrics = &m
+
+		r, err := NewReaderWithOptions(path, ro)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		for r.Next() {
+		}
+
+		assert.Equal(t, int64(1), m.counter(MetricReads))
+		assert.Equal(t, int64(52), m.counter(MetricReadBytes))
+	})
+
+	n.It("creates spans with the configured tracer", func() {
+		var tr testTracer
+
+		opts := DefaultWriteOptions
+		opts.SegmentSize = 20
+		opts.Tracer = &tr
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		err = wal.Write([]byte("this is data"))
+		require.NoError(t, err)
+
+		err = wal.Write([]byte("in the second segment because this is a bigger value"))
+		require.NoError(t, err)
+
+		err = wal.WriteTag([]byte("commit"))
+		require.NoError(t, err)
+
+		err = wal.Close()
+		require.NoError(t, err)
+
+		ro := DefaultReadOptions
+		ro.Tracer = &tr
+
+		r, err := BeginRecoveryWithOptions(path, []byte("commit"), ro)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		assert.Equal(t, []string{
+			SpanWrite, SpanSync,
+			SpanWrite, SpanRotate, SpanSync,
+			SpanWriteTag, SpanSync,
+			SpanRecover, SpanSeekTag,
+		}, tr.names())
+	})
+
+	n.It("logs segment management to the configured logger", func() {
+		var buf bytes.Buffer
+
+		opts := DefaultWriteOptions
+		opts.SegmentSize = 100
+		opts.MaxSegments = 1
+		opts.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		err = wal.Write([]byte("this is data that is longer than the closing magic"))
+		require.NoError(t, err)
+
+		// Drop the lock and reopen without closing to look like a crash.
+		wal.lockf.Close()
+
+		wal2, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		err = wal2.Write([]byte("in the second segment because this is a bigger value"))
+		require.NoError(t, err)
+
+		wal2.Close()
+		wal.Close()
+
+		out := buf.String()
+
+		assert.Contains(t, out, "segment was not closed cleanly")
+		assert.Contains(t, out, "rotated segment")
+		assert.Contains(t, out, "pruned segment")
+	})
+
+	n.It("gives sealed segments to the archiver", func() {
+		var archived []int
+
+		opts := DefaultWriteOptions
+		opts.SegmentSize = 20
+		opts.MaxSegments = 1
+		opts.Archiver = archiverFunc(func(index int, path string) error {
+			_, err := os.Stat(path)
+			require.NoError(t, err)
+
+			archived = append(archived, index)
+			return nil
+		})
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		err = wal.Write([]byte("this is data"))
+		require.NoError(t, err)
+
+		err = wal.Write([]byte("in the second segment because this is a bigger value"))
+		require.NoError(t, err)
+
+		err = wal.Write([]byte("in the third segment because this is a bigger value"))
+		require.NoError(t, err)
+
+		assert.Equal(t, []int{0, 1}, archived)
+	})
+
+	n.It("archives to a blob store and restores from it", func() {
+		restored := filepath.Join(dir, "restored")
+		defer os.RemoveAll(restored)
+
+		stores := []BlobStore{
+			NewMemoryBlobStore(),
+			NewDirBlobStore(filepath.Join(dir, "blobs")),
+		}
+
+		for _, store := range stores {
+			os.RemoveAll(path)
+			os.RemoveAll(restored)
+
+			a := NewBlobArchiver(store, BlobArchiveOptions{Prefix: "wal/", Compress: true})
+
+			opts := DefaultWriteOptions
+			opts.SegmentSize = 20
+			opts.MaxSegments = 1
+			opts.Archiver = a
+
+			wal, err := NewWithOptions(path, opts)
+			require.NoError(t, err)
+
+			err = wal.Write([]byte("this is data"))
+			require.NoError(t, err)
+
+			err = wal.Write([]byte("in the second segment because this is a bigger value"))
+			require.NoError(t, err)
+
+			require.NoError(t, wal.Close())
+			require.NoError(t, a.Wait())
+
+			names, err := store.List(context.Background(), "wal/")
+			require.NoError(t, err)
+
+			assert.Equal(t, []string{"wal/00000000000000000000.gz"}, names)
+
+			err = RestoreBlobs(context.Background(), store, "wal/", restored)
+			require.NoError(t, err)
+
+			r, err := NewReader(restored)
+			require.NoError(t, err)
+
+			require.True(t, r.Next())
+			assert.Equal(t, "this is data", string(r.Value()))
+
+			r.Close()
+		}
+	})
+
+	n.It("stops compressing a segment the store didn't read", func() {
+		store := failingBlobStore{BlobStore: NewMemoryBlobStore(), err: errors.New("store unavailable")}
+
+		a := NewBlobArchiver(store, BlobArchiveOptions{Compress: true})
+
+		opts := DefaultWriteOptions
+		opts.SegmentSize = 20
+		opts.MaxSegments = 1
+		opts.Archiver = a
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		err = wal.Write([]byte("this is data"))
+		require.NoError(t, err)
+
+		err = wal.Write([]byte("in the second segment because this is a bigger value"))
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Close())
+		assert.Equal(t, store.err, a.Wait())
+
+		compressing := func() bool {
+			buf := make([]byte, 1<<20)
+			return bytes.Contains(buf[:runtime.Stack(buf, true)], []byte("(*BlobArchiver).body"))
+		}
+
+		deadline := time.Now().Add(time.Second)
+		for compressing() && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		assert.False(t, compressing(), "still compressing the segment")
+	})
+
+	n.It("logs and counts archive copies as they finish", func() {
+		var (
+			m   testMetrics
+			buf bytes.Buffer
+		)
+
+		store := failingBlobStore{BlobStore: NewMemoryBlobStore(), err: errors.New("store unavailable")}
+
+		a := NewBlobArchiver(store, BlobArchiveOptions{})
+
+		opts := DefaultWriteOptions
+		opts.SegmentSize = 20
+		opts.MaxSegments = 1
+		opts.Metrics = &m
+		opts.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+		opts.Archiver = a
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		err = wal.Write([]byte("this is data"))
+		require.NoError(t, err)
+
+		err = wal.Write([]byte("in the second segment because this is a bigger value"))
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Close())
+		assert.Equal(t, store.err, a.Wait())
+
+		assert.Equal(t, int64(1), m.counter(MetricArchiveErrors))
+		assert.Equal(t, int64(0), m.counter(MetricArchivedSegments))
+		assert.Contains(t, buf.String(), "store unavailable")
+	})
+
+	n.It("doesn't hold up rotations while the store is busy", func() {
+		store := &blockingBlobStore{BlobStore: NewMemoryBlobStore(), release: make(chan struct{})}
+
+		a := NewBlobArchiver(store, BlobArchiveOptions{})
+
+		opts := DefaultWriteOptions
+		opts.SegmentSize = 20
+		opts.MaxSegments = 100
+		opts.Archiver = a
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		written := make(chan error, 1)
+
+		go func() {
+			for i := 0; i < 4; i++ {
+				err := wal.Write([]byte("in a segment of its own because it's big"))
+				if err != nil {
+					written <- err
+					return
+				}
+			}
+
+			written <- nil
+		}()
+
+		select {
+		case err := <-written:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("writes waited for the store")
+		}
+
+		close(store.release)
+
+		require.NoError(t, wal.Close())
+		require.NoError(t, a.Wait())
+
+		names, err := store.List(context.Background(), "")
+		require.NoError(t, err)
+
+		assert.Equal(t, 4, len(names))
+	})
+
+	n.It("stores archived segments no faster than the archive's rate", func() {
+		a := NewBlobArchiver(NewMemoryBlobStore(), BlobArchiveOptions{Concurrency: 4, BytesPerSecond: 1000})
+
+		opts := DefaultWriteOptions
+		opts.SegmentSize = 100
+		opts.MaxSegments = 100
+		opts.NoCompression = true
+		opts.Archiver = a
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		start := time.Now()
+
+		for i := 0; i < 4; i++ {
+			require.NoError(t, wal.Write(bytes.Repeat([]byte("x"), 100)))
+		}
+
+		require.NoError(t, wal.Close())
+		require.NoError(t, a.Wait())
+
+		// The copies share the rate, so the last of the segments after
+		// the first waits for all of them.
+		assert.True(t, time.Since(start) >= 200*time.Millisecond, "archived in %s", time.Since(start))
+	})
+
+	n.It("stores segments in the configured filesystem", func() {
+		fs := &countingFS{FS: OSFS, opened: map[string]int{}}
+
+		opts := DefaultWriteOptions
+		opts.FS = fs
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("this is data")))
+		require.NoError(t, wal.Close())
+
+		ro := DefaultReadOptions
+		ro.FS = fs
+
+		r, err := NewReaderWithOptions(path, ro)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.True(t, r.Next())
+		assert.Equal(t, "this is data", string(r.Value()))
+
+		assert.Equal(t, 2, fs.opened["0"])
+		assert.Equal(t, 1, fs.opened["tags"])
+	})
+
+	n.It("validates positions before seeking to them", func() {
+		opts := DefaultWriteOptions
+		opts.SegmentSize = 100
+		opts.MaxSegments = 2
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("this is data")))
+
+		pos, err := wal.Pos()
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("more data")))
+
+		end, err := wal.Pos()
+		require.NoError(t, err)
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		assert.NoError(t, r.Validate(Position{pos.Segment, 0}))
+		assert.NoError(t, r.Validate(pos))
+		assert.NoError(t, r.Validate(end))
+		assert.True(t, r.Contains(pos))
+
+		err = r.Validate(Position{pos.Segment, pos.Offset + 1})
+		assert.True(t, errors.Is(err, ErrInvalidPosition))
+		assert.False(t, r.Contains(Position{pos.Segment, pos.Offset + 1}))
+
+		err = r.Validate(Position{end.Segment, end.Offset + 10})
+		assert.True(t, errors.Is(err, ErrInvalidPosition))
+
+		for i := 0; i < 4; i++ {
+			require.NoError(t, wal.Write([]byte("this is data that fills a segment on its own, more or less")))
+		}
+
+		require.NoError(t, wal.Close())
+
+		err = r.Validate(pos)
+		assert.True(t, errors.Is(err, ErrSegmentMissing))
+	})
+
+	n.It("only accepts the first block of an entry written in blocks", func() {
+		opts := DefaultWriteOptions
+		opts.BlockSize = 10
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write(bytes.Repeat([]byte("x"), 35)))
+
+		end, err := wal.Pos()
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Close())
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		assert.NoError(t, r.Validate(Position{end.Segment, 0}))
+		assert.NoError(t, r.Validate(end))
+
+		sr, err := NewSegmentReader(filepath.Join(path, strconv.Itoa(end.Segment)))
+		require.NoError(t, err)
+
+		defer sr.Close()
+
+		ent, err := sr.readNext()
+		require.NoError(t, err)
+		require.Equal(t, byte(blockType), ent.entryType)
+
+		err = r.Validate(Position{end.Segment, sr.readPos})
+		assert.True(t, errors.Is(err, ErrInvalidPosition))
+	})
+
+	n.It("seeks to the nearest entry at or after a position", func() {
+		opts := DefaultWriteOptions
+		opts.SegmentSize = 100
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("this is data")))
+
+		pos, err := wal.Pos()
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("more data")))
+
+		end, err := wal.Pos()
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("this is data that fills a segment on its own, more or less")))
+		require.NoError(t, wal.Write([]byte("in the next segment")))
+
+		require.NoError(t, wal.Close())
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		got, err := r.SeekNearest(Position{pos.Segment, 1})
+		require.NoError(t, err)
+		assert.Equal(t, pos, got)
+
+		require.True(t, r.Next())
+		assert.Equal(t, "more data", string(r.Value()))
+
+		got, err = r.SeekNearest(pos)
+		require.NoError(t, err)
+		assert.Equal(t, pos, got)
+
+		got, err = r.SeekNearest(Position{end.Segment, end.Offset + 1})
+		require.NoError(t, err)
+		assert.Equal(t, Position{end.Segment + 1, 0}, got)
+
+		require.True(t, r.Next())
+		assert.Equal(t, "this is data that fills a segment on its own, more or less", string(r.Value()))
+
+		_, err = r.SeekNearest(Position{end.Segment + 1, 1000})
+		assert.True(t, errors.Is(err, ErrInvalidPosition))
+	})
+
+	n.It("describes how the current entry is stored", func() {
+		opts := DefaultWriteOptions
+		opts.BlockSize = 10
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("small")))
+
+		pos, err := wal.Pos()
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write(bytes.Repeat([]byte("x"), 35)))
+
+		wal.segment.SetCompression(false)
+
+		require.NoError(t, wal.Write([]byte("raw")))
+
+		require.NoError(t, wal.Close())
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.True(t, r.Next())
+
+		hdr := r.Header()
+		assert.Equal(t, RecordData, hdr.Type)
+		assert.Equal(t, int64(0), hdr.Offset)
+		assert.Equal(t, r.seg.CRC(), hdr.CRC)
+		assert.True(t, hdr.Compressed)
+		assert.Equal(t, 1, hdr.Blocks)
+		assert.Equal(t, pos.Offset-hdr.Offset-6, hdr.Length)
+
+		require.True(t, r.Next())
+
+		hdr = r.Header()
+		assert.Equal(t, pos.Offset, hdr.Offset)
+		assert.Equal(t, 4, hdr.Blocks)
+
+		require.True(t, r.Next())
+
+		hdr = r.Header()
+		assert.False(t, hdr.Compressed)
+		assert.Equal(t, int64(3), hdr.Length)
+		assert.Equal(t, 1, hdr.Blocks)
+	})
+
+	n.It("stores raw writes uncompressed", func() {
+		opts := DefaultWriteOptions
+		opts.BlockSize = 10
+
+		wal, err := NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		data := bytes.Repeat([]byte("x"), 35)
+
+		require.NoError(t, wal.WriteRaw(data))
+		require.NoError(t, wal.Write(data))
+
+		s, err := wal.Stream("a")
+		require.NoError(t, err)
+
+		require.NoError(t, s.WriteRaw([]byte("stream")))
+
+		require.NoError(t, wal.Close())
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.True(t, r.Next())
+
+		hdr := r.Header()
+		assert.False(t, hdr.Compressed)
+		assert.Equal(t, 4, hdr.Blocks)
+		assert.Equal(t, int64(35), hdr.Length)
+		assert.Equal(t, data, r.Value())
+
+		require.True(t, r.Next())
+
+		assert.True(t, r.Header().Compressed)
+		assert.Equal(t, data, r.Value())
+
+		sr, err := NewStreamReader(path, "a", DefaultReadOptions)
+		require.NoError(t, err)
+
+		defer sr.Close()
+
+		require.True(t, sr.Next())
+
+		assert.False(t, sr.Header().Compressed)
+		assert.Equal(t, "stream", string(sr.Value()))
+	})
+
+	n.It("can relocate or disable the tag cache", func() {
+		cachePath := filepath.Join(dir, "wal-tags")
+		defer os.Remove(cachePath)
+
+		wal, err := New(path, WithTagCachePath(cachePath))
+		require.NoError(t, err)
+
+		require.NoError(t, wal.WriteTag([]byte("a")))
+		require.NoError(t, wal.Close())
+
+		_, err = os.Stat(filepath.Join(path, "tags"))
+		assert.True(t, os.IsNotExist(err))
+
+		data, err := ioutil.ReadFile(cachePath)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), base64.URLEncoding.EncodeToString([]byte("a")))
+
+		wal, err = New(path, WithoutTagCache())
+		require.NoError(t, err)
+
+		defer wal.Close()
+
+		require.NoError(t, wal.WriteTag([]byte("b")))
+
+		_, err = os.Stat(filepath.Join(path, "tags"))
+		assert.True(t, os.IsNotExist(err))
+
+		pos, err := wal.TagPos([]byte("b"))
+		require.NoError(t, err)
+		assert.True(t, pos.Valid())
+	})
+
+	n.It("can leave segments without the closing magic", func() {
+		wal, err := New(path, WithSegmentSize(100), WithoutClosingMagic())
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("this is data that fills a segment on its own, more or less")))
+		require.NoError(t, wal.Write([]byte("this is data that fills a segment on its own, more or less")))
+
+		fi, err := os.Stat(filepath.Join(path, strconv.Itoa(wal.index-1)))
+		require.NoError(t, err)
+		assert.Equal(t, fi.Size(), wal.segSizes[wal.index-1])
+
+		require.NoError(t, wal.Close())
+
+		for _, index := range []int{wal.index - 1, wal.index} {
+			data, err := ioutil.ReadFile(filepath.Join(path, strconv.Itoa(index)))
+			require.NoError(t, err)
+			assert.False(t, bytes.HasSuffix(data, closingMagic))
+		}
+
+		// Leave a torn record at the end, as a crash part way through a
+		// write would.
+		f, err := os.OpenFile(filepath.Join(path, strconv.Itoa(wal.index)), os.O_WRONLY|os.O_APPEND, 0644)
+		require.NoError(t, err)
+
+		_, err = f.Write([]byte{1, 2, 3, 4, 'd', 50, 'x'})
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		wal, err = New(path, WithSegmentSize(100), WithoutClosingMagic())
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Write([]byte("after")))
+		require.NoError(t, wal.Close())
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		var values []string
+
+		for r.Next() {
+			values = append(values, string(r.Value()))
+		}
+
+		require.NoError(t, r.Error())
+		assert.Equal(t, 3, len(values))
+		assert.Equal(t, "after", values[2])
+	})
+
+	n.Meow()
+}
+
+type testMetrics struct {
+	lock     sync.Mutex
+	counters map[string]int64
+	gauges   map[string]float64
+	timed    map[string]int
+}
+
+func (m *testMetrics) IncrCounter(name string, delta int64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.counters == nil {
+		m.counters = make(map[string]int64)
+	}
+
+	m.counters[name] += delta
+}
+
+func (m *testMetrics) SetGauge(name string, value float64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.gauges == nil {
+		m.gauges = make(map[string]float64)
+	}
+
+	m.gauges[name] = value
+}
+
+func (m *testMetrics) Timing(name string, d time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.timed == nil {
+		m.timed = make(map[string]int)
+	}
+
+	m.timed[name]++
+}
+
+func (m *testMetrics) counter(name string) int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.counters[name]
+}
+
+func (m *testMetrics) gauge(name string) float64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.gauges[name]
+}
+
+func (m *testMetrics) timings(name string) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.timed[name]
+}
+
+type testTracer struct {
+	lock  sync.Mutex
+	spans []string
+}
+
+func (tr *testTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+
+	tr.spans = append(tr.spans, name)
+
+	return ctx, nopSpan{}
+}
+
+func (tr *testTracer) names() []string {
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+
+	return tr.spans
+}
+
+// failingBlobStore fails every Put part way through reading what's put.
+type failingBlobStore struct {
+	BlobStore
+
+	err error
+}
+
+func (s failingBlobStore) Put(ctx context.Context, name string, r io.Reader) error {
+	r.Read(make([]byte, 1))
+	return s.err
+}
+
+// blockingBlobStore holds every Put until release is closed.
+type blockingBlobStore struct {
+	BlobStore
+
+	release chan struct{}
+}
+
+func (s *blockingBlobStore) Put(ctx context.Context, name string, r io.Reader) error {
+	<-s.release
+	return s.BlobStore.Put(ctx, name, r)
+}
+
+type archiverFunc func(index int, path string) error
+
+func (f archiverFunc) Archive(index int, path string) error {
+	return f(index, path)
+}
+
+// countingFS counts the files opened through it.
+type countingFS struct {
+	FS
+
+	lock   sync.Mutex
+	opened map[string]int
+}
+
+func (c *countingFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	c.lock.Lock()
+	c.opened[filepath.Base(name)]++
+	c.lock.Unlock()
+
+	return c.FS.OpenFile(name, flag, perm)
+}
+
+// stallingFS blocks listing directories until release is closed, like
+// an unresponsive network filesystem.
+type stallingFS struct {
+	FS
+
+	release chan struct{}
+}
+
+func (s *stallingFS) ReadDirNames(name string) ([]string, error) {
+	<-s.release
+	return s.FS.ReadDirNames(name)
+}
+
+// trackingFS counts the files open through it.
+type trackingFS struct {
+	FS
+
+	open int64
+}
+
+func (t *trackingFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := t.FS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	atomic.AddInt64(&t.open, 1)
+
+	return &trackedFile{File: f, fs: t}, nil
+}
+
+type trackedFile struct {
+	File
+
+	fs     *trackingFS
+	closed bool
+}
+
+func (f *trackedFile) Close() error {
+	if !f.closed {
+		f.closed = true
+		atomic.AddInt64(&f.fs.open, -1)
+	}
+
+	return f.File.Close()
 }
@@ -0,0 +1,147 @@
+package walgrpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/evanphx/wal"
+	"google.golang.org/grpc/encoding"
+)
+
+// The messages are simple enough that they're encoded by hand rather
+// than with protobuf, which keeps generated code out of the package.
+const codecName = "walrepl"
+
+func init() {
+	encoding.RegisterCodec(codec{})
+}
+
+// FollowRequest asks the server to stream records starting at From. If
+// From.Segment is -1, or From is the zero Position, streaming starts at
+// the beginning of the WAL, wherever pruning has left it.
+type FollowRequest struct {
+	From wal.Position
+}
+
+// Record is a single entry from the WAL. Pos is the position just
+// after the entry, which is where to resume from to receive the entries
+// that follow it.
+type Record struct {
+	Pos   wal.Position
+	Value []byte
+}
+
+// segmentRequest asks the server for the contents of a sealed segment.
+type segmentRequest struct {
+	Index int
+}
+
+// segmentChunk carries the next bytes of a segment.
+type segmentChunk struct {
+	Data []byte
+}
+
+// headRequest asks the server where its WAL ends, which it replies to
+// with a wal.Position.
+type headRequest struct{}
+
+var errShortMessage = errors.New("walgrpc: short message")
+
+type codec struct{}
+
+func (codec) Name() string {
+	return codecName
+}
+
+func appendPosition(buf []byte, p wal.Position) []byte {
+	buf = binary.AppendVarint(buf, int64(p.Segment))
+	return binary.AppendVarint(buf, p.Offset)
+}
+
+func readPosition(data []byte) (wal.Position, []byte, error) {
+	seg, n := binary.Varint(data)
+	if n <= 0 {
+		return wal.Position{}, nil, errShortMessage
+	}
+
+	data = data[n:]
+
+	off, n := binary.Varint(data)
+	if n <= 0 {
+		return wal.Position{}, nil, errShortMessage
+	}
+
+	return wal.Position{Segment: int(seg), Offset: off}, data[n:], nil
+}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	switch m := v.(type) {
+	case *FollowRequest:
+		return appendPosition(nil, m.From), nil
+	case *Record:
+		buf := make([]byte, 0, 2*binary.MaxVarintLen64+len(m.Value))
+		buf = appendPosition(buf, m.Pos)
+		return append(buf, m.Value...), nil
+	case *segmentRequest:
+		return binary.AppendVarint(nil, int64(m.Index)), nil
+	case *segmentChunk:
+		return m.Data, nil
+	case *headRequest:
+		return []byte{}, nil
+	case *wal.Position:
+		return appendPosition(nil, *m), nil
+	default:
+		return nil, fmt.Errorf("walgrpc: unable to marshal %T", v)
+	}
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	switch m := v.(type) {
+	case *FollowRequest:
+		pos, _, err := readPosition(data)
+		if err != nil {
+			return err
+		}
+
+		m.From = pos
+
+		return nil
+	case *Record:
+		pos, rest, err := readPosition(data)
+		if err != nil {
+			return err
+		}
+
+		m.Pos = pos
+		m.Value = append(m.Value[:0], rest...)
+
+		return nil
+	case *segmentRequest:
+		index, n := binary.Varint(data)
+		if n <= 0 {
+			return errShortMessage
+		}
+
+		m.Index = int(index)
+
+		return nil
+	case *segmentChunk:
+		m.Data = append(m.Data[:0], data...)
+
+		return nil
+	case *headRequest:
+		return nil
+	case *wal.Position:
+		pos, _, err := readPosition(data)
+		if err != nil {
+			return err
+		}
+
+		*m = pos
+
+		return nil
+	default:
+		return fmt.Errorf("walgrpc: unable to unmarshal %T", v)
+	}
+}
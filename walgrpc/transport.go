@@ -0,0 +1,121 @@
+package walgrpc
+
+import (
+	"context"
+	"io"
+
+	"github.com/evanphx/wal"
+	"google.golang.org/grpc"
+)
+
+// Transport is a wal.Transport over a gRPC connection to a Server.
+type Transport struct {
+	conn grpc.ClientConnInterface
+}
+
+var _ wal.Transport = (*Transport)(nil)
+
+// NewTransport returns a Transport to the Server at the other end of
+// conn.
+func NewTransport(conn grpc.ClientConnInterface) *Transport {
+	return &Transport{conn: conn}
+}
+
+// Follow streams the records after from to fn, until ctx is done, fn
+// returns an error, or the stream fails.
+func (t *Transport) Follow(ctx context.Context, from wal.Position, fn func(wal.Position, []byte) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := t.conn.NewStream(ctx, &serviceDesc.Streams[0], followMethod, grpc.CallContentSubtype(codecName))
+	if err != nil {
+		return t.stopped(ctx, err)
+	}
+
+	err = stream.SendMsg(&FollowRequest{From: from})
+	if err != nil {
+		return t.stopped(ctx, err)
+	}
+
+	err = stream.CloseSend()
+	if err != nil {
+		return t.stopped(ctx, err)
+	}
+
+	for {
+		var rec Record
+
+		err = stream.RecvMsg(&rec)
+		if err != nil {
+			return t.stopped(ctx, err)
+		}
+
+		err = fn(rec.Pos, rec.Value)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// stopped returns nil if the stream failed with err because ctx is done,
+// and err otherwise.
+func (t *Transport) stopped(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	return err
+}
+
+// FetchSegment writes the contents of the server's sealed segment index
+// to w. It fails if the segment is still being written.
+func (t *Transport) FetchSegment(ctx context.Context, index int, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := t.conn.NewStream(ctx, &serviceDesc.Streams[1], segmentMethod, grpc.CallContentSubtype(codecName))
+	if err != nil {
+		return err
+	}
+
+	err = stream.SendMsg(&segmentRequest{Index: index})
+	if err != nil {
+		return err
+	}
+
+	err = stream.CloseSend()
+	if err != nil {
+		return err
+	}
+
+	for {
+		var chunk segmentChunk
+
+		err = stream.RecvMsg(&chunk)
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(chunk.Data)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Head returns the end of the newest segment on the server, or
+// wal.Position{Segment: -1, Offset: -1} if it has none.
+func (t *Transport) Head(ctx context.Context) (wal.Position, error) {
+	var pos wal.Position
+
+	err := t.conn.Invoke(ctx, headMethod, &headRequest{}, &pos, grpc.CallContentSubtype(codecName))
+	if err != nil {
+		return wal.Position{Segment: -1, Offset: -1}, err
+	}
+
+	return pos, nil
+}
@@ -0,0 +1,309 @@
+// Package walgrpc replicates a WAL between processes over gRPC. A Server
+// streams the records of a WAL directory, with their positions, to any
+// number of Clients, each of which follows the stream and resumes from
+// where it left off after a disconnect. A Server also serves its sealed
+// segments and where its WAL ends, so that a Transport can be used with
+// wal.Replicator and as a wal.SegmentSource.
+package walgrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/evanphx/wal"
+	"google.golang.org/grpc"
+)
+
+// The full name of the gRPC service.
+const ServiceName = "wal.Replication"
+
+const (
+	followMethod  = "/" + ServiceName + "/Follow"
+	segmentMethod = "/" + ServiceName + "/Segment"
+	headMethod    = "/" + ServiceName + "/Head"
+)
+
+type service interface {
+	follow(req *FollowRequest, stream grpc.ServerStream) error
+	segment(req *segmentRequest, stream grpc.ServerStream) error
+	head(ctx context.Context, req *headRequest) (*wal.Position, error)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*service)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Head",
+			Handler:    headHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Follow",
+			Handler:       followHandler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Segment",
+			Handler:       segmentHandler,
+			ServerStreams: true,
+		},
+	},
+}
+
+func followHandler(srv interface{}, stream grpc.ServerStream) error {
+	var req FollowRequest
+
+	err := stream.RecvMsg(&req)
+	if err != nil {
+		return err
+	}
+
+	return srv.(service).follow(&req, stream)
+}
+
+func segmentHandler(srv interface{}, stream grpc.ServerStream) error {
+	var req segmentRequest
+
+	err := stream.RecvMsg(&req)
+	if err != nil {
+		return err
+	}
+
+	return srv.(service).segment(&req, stream)
+}
+
+func headHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	var req headRequest
+
+	err := dec(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	if interceptor == nil {
+		return srv.(service).head(ctx, &req)
+	}
+
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: headMethod}
+
+	return interceptor(ctx, &req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(service).head(ctx, req.(*headRequest))
+	})
+}
+
+// How often a server checks for new data once a follower has caught up.
+const DefaultPollInterval = 100 * time.Millisecond
+
+// The largest chunk of a segment a server sends at once.
+const segmentChunkSize = 64 * 1024
+
+var errSegmentActive = errors.New("walgrpc: segment is still being written")
+
+type Server struct {
+	root string
+
+	// How often to check for new data once a follower has caught up.
+	PollInterval time.Duration
+}
+
+// NewServer returns a Server that streams the WAL in root.
+func NewServer(root string) *Server {
+	return &Server{
+		root:         root,
+		PollInterval: DefaultPollInterval,
+	}
+}
+
+// Register adds the replication service to g.
+func (s *Server) Register(g *grpc.Server) {
+	g.RegisterService(&serviceDesc, s)
+}
+
+func (s *Server) follow(req *FollowRequest, stream grpc.ServerStream) error {
+	r, err := wal.NewReader(s.root)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	// The zero Position means the start too, since segment 0 is gone
+	// once the WAL has been pruned.
+	if !req.From.None() && req.From != (wal.Position{}) {
+		err = r.Seek(req.From)
+		if err != nil {
+			return err
+		}
+	}
+
+	opts := wal.TailOptions{PollInterval: s.PollInterval}
+
+	return r.Tail(stream.Context(), opts, func(pos wal.Position, value []byte) error {
+		return stream.SendMsg(&Record{Pos: pos, Value: value})
+	})
+}
+
+// newest returns the index and size of the newest segment in root, which
+// is the only one that may still be being written, or an index of -1 if
+// there are none.
+func (s *Server) newest() (int, int64, error) {
+	entries, err := os.ReadDir(s.root)
+	if err != nil {
+		return -1, 0, err
+	}
+
+	last := -1
+
+	for _, ent := range entries {
+		i, err := strconv.Atoi(ent.Name())
+		if err == nil && i > last {
+			last = i
+		}
+	}
+
+	if last == -1 {
+		return -1, 0, nil
+	}
+
+	fi, err := os.Stat(filepath.Join(s.root, strconv.Itoa(last)))
+	if err != nil {
+		return -1, 0, err
+	}
+
+	return last, fi.Size(), nil
+}
+
+func (s *Server) segment(req *segmentRequest, stream grpc.ServerStream) error {
+	last, _, err := s.newest()
+	if err != nil {
+		return err
+	}
+
+	if req.Index >= last {
+		return errSegmentActive
+	}
+
+	f, err := os.Open(filepath.Join(s.root, strconv.Itoa(req.Index)))
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	buf := make([]byte, segmentChunkSize)
+
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			serr := stream.SendMsg(&segmentChunk{Data: buf[:n]})
+			if serr != nil {
+				return serr
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) head(ctx context.Context, req *headRequest) (*wal.Position, error) {
+	last, size, err := s.newest()
+	if err != nil {
+		return nil, err
+	}
+
+	if last == -1 {
+		return &wal.Position{Segment: -1, Offset: -1}, nil
+	}
+
+	return &wal.Position{Segment: last, Offset: size}, nil
+}
+
+// How long a client waits before reconnecting after the stream fails.
+const DefaultRetryInterval = time.Second
+
+type Client struct {
+	t   *Transport
+	pos wal.Position
+
+	// How long to wait before reconnecting after the stream fails.
+	RetryInterval time.Duration
+}
+
+// NewClient returns a Client that follows the WAL served over conn,
+// starting at from. Pass wal.Position{Segment: -1}, or the zero
+// Position, to start at the beginning.
+func NewClient(conn grpc.ClientConnInterface, from wal.Position) *Client {
+	return &Client{
+		t:             NewTransport(conn),
+		pos:           from,
+		RetryInterval: DefaultRetryInterval,
+	}
+}
+
+// Pos returns the position just after the last record received, which
+// is where following resumes from.
+func (c *Client) Pos() wal.Position {
+	return c.pos
+}
+
+// Follow calls fn with each record until ctx is done or fn returns an
+// error. If the stream fails, it reconnects and resumes just after the
+// last record fn handled without error. It returns nil when ctx is done.
+func (c *Client) Follow(ctx context.Context, fn func(*Record) error) error {
+	for {
+		err := c.followOnce(ctx, fn)
+		if err, ok := err.(callbackError); ok {
+			return err.err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(c.RetryInterval):
+		}
+	}
+}
+
+// Replicate writes every record followed to w, keeping it in sync with
+// the served WAL until ctx is done.
+func (c *Client) Replicate(ctx context.Context, w *wal.WALWriter) error {
+	return c.Follow(ctx, func(rec *Record) error {
+		return w.Write(rec.Value)
+	})
+}
+
+// callbackError marks errors returned by the caller's function, which
+// stop following rather than causing a reconnect.
+type callbackError struct {
+	err error
+}
+
+func (e callbackError) Error() string {
+	return e.err.Error()
+}
+
+func (c *Client) followOnce(ctx context.Context, fn func(*Record) error) error {
+	return c.t.Follow(ctx, c.pos, func(pos wal.Position, value []byte) error {
+		err := fn(&Record{Pos: pos, Value: value})
+		if err != nil {
+			return callbackError{err}
+		}
+
+		c.pos = pos
+
+		return nil
+	})
+}
@@ -0,0 +1,260 @@
+package walgrpc
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/evanphx/wal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestReplication(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	primary := filepath.Join(dir, "primary")
+	replica := filepath.Join(dir, "replica")
+
+	n.Setup(func() {
+		os.RemoveAll(primary)
+		os.RemoveAll(replica)
+	})
+
+	dial := func(lis *bufconn.Listener) *grpc.ClientConn {
+		conn, err := grpc.NewClient("passthrough:///bufnet",
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return lis.DialContext(ctx)
+			}),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		)
+		require.NoError(t, err)
+
+		return conn
+	}
+
+	n.It("streams records and resumes from the last position", func() {
+		w, err := wal.New(primary)
+		require.NoError(t, err)
+
+		defer w.Close()
+
+		err = w.Write([]byte("first"))
+		require.NoError(t, err)
+
+		err = w.Write([]byte("second"))
+		require.NoError(t, err)
+
+		lis := bufconn.Listen(1024 * 1024)
+
+		g := grpc.NewServer()
+		NewServer(primary).Register(g)
+
+		go g.Serve(lis)
+		defer g.Stop()
+
+		conn := dial(lis)
+		defer conn.Close()
+
+		c := NewClient(conn, wal.Position{Segment: -1, Offset: -1})
+
+		var got []string
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		err = c.Follow(ctx, func(rec *Record) error {
+			got = append(got, string(rec.Value))
+			if len(got) == 2 {
+				cancel()
+			}
+
+			return nil
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"first", "second"}, got)
+
+		err = w.Write([]byte("third"))
+		require.NoError(t, err)
+
+		rw, err := wal.New(replica)
+		require.NoError(t, err)
+
+		ctx, cancel = context.WithCancel(context.Background())
+
+		var replicated []string
+
+		err = c.Follow(ctx, func(rec *Record) error {
+			replicated = append(replicated, string(rec.Value))
+			cancel()
+			return rw.Write(rec.Value)
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"third"}, replicated)
+
+		require.NoError(t, rw.Close())
+
+		r, err := wal.NewReader(replica)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.True(t, r.Next())
+		assert.Equal(t, "third", string(r.Value()))
+	})
+
+	n.It("stops when the callback fails without skipping the record", func() {
+		w, err := wal.New(primary)
+		require.NoError(t, err)
+
+		defer w.Close()
+
+		err = w.Write([]byte("first"))
+		require.NoError(t, err)
+
+		lis := bufconn.Listen(1024 * 1024)
+
+		g := grpc.NewServer()
+		NewServer(primary).Register(g)
+
+		go g.Serve(lis)
+		defer g.Stop()
+
+		conn := dial(lis)
+		defer conn.Close()
+
+		c := NewClient(conn, wal.Position{Segment: -1, Offset: -1})
+
+		err = c.Follow(context.Background(), func(rec *Record) error {
+			return context.Canceled
+		})
+		assert.Equal(t, context.Canceled, err)
+
+		assert.Equal(t, wal.Position{Segment: -1, Offset: -1}, c.Pos())
+	})
+
+	n.It("starts at the first segment when following from the zero position", func() {
+		opts := wal.DefaultWriteOptions
+		opts.SegmentSize = 20
+		opts.MaxSegments = 1
+
+		w, err := wal.NewWithOptions(primary, opts)
+		require.NoError(t, err)
+
+		defer w.Close()
+
+		err = w.Write([]byte("first"))
+		require.NoError(t, err)
+
+		err = w.Write([]byte("in the second segment because this is a bigger value"))
+		require.NoError(t, err)
+
+		_, err = os.Stat(filepath.Join(primary, "0"))
+		require.True(t, os.IsNotExist(err))
+
+		lis := bufconn.Listen(1024 * 1024)
+
+		g := grpc.NewServer()
+		NewServer(primary).Register(g)
+
+		go g.Serve(lis)
+		defer g.Stop()
+
+		conn := dial(lis)
+		defer conn.Close()
+
+		c := NewClient(conn, wal.Position{})
+
+		var got []string
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		err = c.Follow(ctx, func(rec *Record) error {
+			got = append(got, string(rec.Value))
+			cancel()
+			return nil
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"in the second segment because this is a bigger value"}, got)
+	})
+
+	n.It("serves sealed segments and the head through a Transport", func() {
+		opts := wal.DefaultWriteOptions
+		opts.SegmentSize = 20
+
+		w, err := wal.NewWithOptions(primary, opts)
+		require.NoError(t, err)
+
+		defer w.Close()
+
+		require.NoError(t, w.Write([]byte("this is data")))
+		require.NoError(t, w.Write([]byte("in the second segment because it is big")))
+
+		lis := bufconn.Listen(1024 * 1024)
+
+		g := grpc.NewServer()
+		NewServer(primary).Register(g)
+
+		go g.Serve(lis)
+		defer g.Stop()
+
+		conn := dial(lis)
+		defer conn.Close()
+
+		tr := NewTransport(conn)
+
+		var buf bytes.Buffer
+
+		require.NoError(t, tr.FetchSegment(context.Background(), 0, &buf))
+
+		disk, err := ioutil.ReadFile(filepath.Join(primary, "0"))
+		require.NoError(t, err)
+
+		assert.Equal(t, disk, buf.Bytes())
+
+		assert.Error(t, tr.FetchSegment(context.Background(), 1, &buf))
+
+		head, err := tr.Head(context.Background())
+		require.NoError(t, err)
+
+		fi, err := os.Stat(filepath.Join(primary, "1"))
+		require.NoError(t, err)
+
+		assert.Equal(t, wal.Position{Segment: 1, Offset: fi.Size()}, head)
+
+		rep := wal.NewReplicator(tr, wal.Position{Segment: -1, Offset: -1})
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var got []string
+
+		err = rep.Follow(ctx, func(pos wal.Position, value []byte) error {
+			got = append(got, string(value))
+			if len(got) == 2 {
+				cancel()
+			}
+
+			return nil
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"this is data", "in the second segment because it is big"}, got)
+	})
+
+	n.Meow()
+}
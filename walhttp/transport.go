@@ -0,0 +1,191 @@
+package walhttp
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/evanphx/wal"
+)
+
+// StatusError is a response from a Handler other than the one expected.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("walhttp: %d %s: %s", e.Code, http.StatusText(e.Code), e.Message)
+}
+
+// Transport is a wal.Transport to a Handler, using /tail to follow
+// records and /segments to fetch segments and find where the WAL ends.
+type Transport struct {
+	url    string
+	client *http.Client
+}
+
+var _ wal.Transport = (*Transport)(nil)
+
+// NewTransport returns a Transport to the Handler mounted at url. If
+// client is nil, http.DefaultClient is used.
+func NewTransport(url string, client *http.Client) *Transport {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &Transport{
+		url:    strings.TrimSuffix(url, "/"),
+		client: client,
+	}
+}
+
+// get requests path from the handler, returning the response if it has
+// a status of 200.
+func (t *Transport) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", t.url+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+		return nil, &StatusError{
+			Code:    resp.StatusCode,
+			Message: strings.TrimSpace(string(msg)),
+		}
+	}
+
+	return resp, nil
+}
+
+// Follow tails the records after from, calling fn with each, until ctx
+// is done, fn returns an error, or the connection fails.
+func (t *Transport) Follow(ctx context.Context, from wal.Position, fn func(wal.Position, []byte) error) error {
+	path := "/tail"
+
+	if !from.None() {
+		path = fmt.Sprintf("/tail?segment=%d&offset=%d", from.Segment, from.Offset)
+	}
+
+	resp, err := t.get(ctx, path)
+	if err != nil {
+		return t.stopped(ctx, err)
+	}
+
+	defer resp.Body.Close()
+
+	var (
+		pos   wal.Position
+		value []byte
+		have  bool
+	)
+
+	scan := bufio.NewScanner(resp.Body)
+	scan.Buffer(nil, 64*1024*1024)
+
+	for scan.Scan() {
+		line := scan.Text()
+
+		switch {
+		case line == "":
+			if !have {
+				continue
+			}
+
+			have = false
+
+			err = fn(pos, value)
+			if err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "id: "):
+			pos, err = parsePosition(line[4:])
+			if err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "data: "):
+			value, err = base64.StdEncoding.DecodeString(line[6:])
+			if err != nil {
+				return err
+			}
+
+			have = true
+		}
+	}
+
+	err = scan.Err()
+	if err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return t.stopped(ctx, err)
+}
+
+// stopped returns nil if the request failed with err because ctx is
+// done, and err otherwise.
+func (t *Transport) stopped(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	return err
+}
+
+// FetchSegment writes the contents of the handler's sealed segment index
+// to w. It returns a StatusError with a Code of 409 if the segment is
+// still being written, or 404 if there's no such segment.
+func (t *Transport) FetchSegment(ctx context.Context, index int, w io.Writer) error {
+	resp, err := t.get(ctx, "/segments/"+strconv.Itoa(index))
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Head returns the end of the handler's newest segment, or
+// wal.Position{Segment: -1, Offset: -1} if it has none.
+func (t *Transport) Head(ctx context.Context) (wal.Position, error) {
+	none := wal.Position{Segment: -1, Offset: -1}
+
+	resp, err := t.get(ctx, "/segments")
+	if err != nil {
+		return none, err
+	}
+
+	defer resp.Body.Close()
+
+	var segs []Segment
+
+	err = json.NewDecoder(resp.Body).Decode(&segs)
+	if err != nil {
+		return none, err
+	}
+
+	if len(segs) == 0 {
+		return none, nil
+	}
+
+	last := segs[len(segs)-1]
+
+	return wal.Position{Segment: last.Index, Offset: last.Size}, nil
+}
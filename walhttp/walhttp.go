@@ -0,0 +1,257 @@
+// Package walhttp serves a WAL over HTTP, for simple replication and
+// debugging with nothing more than curl.
+//
+// The handler serves the following endpoints, relative to where it's
+// mounted:
+//
+//	GET /segments          JSON list of the segments on disk
+//	GET /segments/{index}  the raw contents of a sealed segment
+//	GET /tail              records as server-sent events
+//
+// /tail starts at the beginning of the WAL, or at the position given by
+// the segment and offset query parameters or the Last-Event-ID header.
+// Each event's id is the position just after its record, formatted as
+// "segment:offset", so a client can resume from the last id it saw. The
+// event's data is the record's value, base64 encoded.
+//
+// A Transport follows a Handler as a wal.Transport, for use with
+// wal.Replicator.
+package walhttp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evanphx/wal"
+)
+
+// How often /tail checks for new data once a client has caught up.
+const DefaultPollInterval = 100 * time.Millisecond
+
+type Handler struct {
+	root string
+	mux  *http.ServeMux
+
+	// How often /tail checks for new data once a client has caught up.
+	PollInterval time.Duration
+}
+
+// NewHandler returns a Handler that serves the WAL in root.
+func NewHandler(root string) *Handler {
+	h := &Handler{
+		root:         root,
+		mux:          http.NewServeMux(),
+		PollInterval: DefaultPollInterval,
+	}
+
+	h.mux.HandleFunc("/segments", h.listSegments)
+	h.mux.HandleFunc("/segments/", h.fetchSegment)
+	h.mux.HandleFunc("/tail", h.tail)
+
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.mux.ServeHTTP(w, req)
+}
+
+// Segment describes a segment file in the WAL.
+type Segment struct {
+	Index  int   `json:"index"`
+	Size   int64 `json:"size"`
+	Sealed bool  `json:"sealed"`
+}
+
+func (h *Handler) segments() ([]Segment, error) {
+	f, err := os.Open(h.root)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	var segs []Segment
+
+	for _, fi := range infos {
+		i, err := strconv.Atoi(fi.Name())
+		if err == nil {
+			segs = append(segs, Segment{Index: i, Size: fi.Size()})
+		}
+	}
+
+	sort.Slice(segs, func(i, j int) bool {
+		return segs[i].Index < segs[j].Index
+	})
+
+	// Only the newest segment is still being written to.
+	for i := 0; i < len(segs)-1; i++ {
+		segs[i].Sealed = true
+	}
+
+	return segs, nil
+}
+
+func (h *Handler) listSegments(w http.ResponseWriter, req *http.Request) {
+	segs, err := h.segments()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if segs == nil {
+		segs = []Segment{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(segs)
+}
+
+func (h *Handler) fetchSegment(w http.ResponseWriter, req *http.Request) {
+	index, err := strconv.Atoi(strings.TrimPrefix(req.URL.Path, "/segments/"))
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	segs, err := h.segments()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, seg := range segs {
+		if seg.Index != index {
+			continue
+		}
+
+		if !seg.Sealed {
+			http.Error(w, "segment is still being written, use /tail", http.StatusConflict)
+			return
+		}
+
+		f, err := os.Open(filepath.Join(h.root, strconv.Itoa(index)))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		defer f.Close()
+
+		fi, err := f.Stat()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, req, "", fi.ModTime(), f)
+
+		return
+	}
+
+	http.NotFound(w, req)
+}
+
+// tailStart returns where the client asked to start tailing from.
+func tailStart(req *http.Request) (wal.Position, error) {
+	if id := req.Header.Get("Last-Event-ID"); id != "" {
+		return parsePosition(id)
+	}
+
+	q := req.URL.Query()
+
+	if q.Get("segment") == "" {
+		return wal.Position{Segment: -1, Offset: -1}, nil
+	}
+
+	seg, err := strconv.Atoi(q.Get("segment"))
+	if err != nil {
+		return wal.Position{}, err
+	}
+
+	off, err := strconv.ParseInt(q.Get("offset"), 10, 64)
+	if err != nil && q.Get("offset") != "" {
+		return wal.Position{}, err
+	}
+
+	return wal.Position{Segment: seg, Offset: off}, nil
+}
+
+func parsePosition(s string) (wal.Position, error) {
+	idx := strings.IndexByte(s, ':')
+	if idx == -1 {
+		return wal.Position{}, fmt.Errorf("malformed position: %s", s)
+	}
+
+	seg, err := strconv.Atoi(s[:idx])
+	if err != nil {
+		return wal.Position{}, err
+	}
+
+	off, err := strconv.ParseInt(s[idx+1:], 10, 64)
+	if err != nil {
+		return wal.Position{}, err
+	}
+
+	return wal.Position{Segment: seg, Offset: off}, nil
+}
+
+func (h *Handler) tail(w http.ResponseWriter, req *http.Request) {
+	from, err := tailStart(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r, err := wal.NewReader(h.root)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	defer r.Close()
+
+	if !from.None() {
+		err = r.Seek(from)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	opts := wal.TailOptions{PollInterval: h.PollInterval}
+
+	if flusher, ok := w.(http.Flusher); ok {
+		opts.CaughtUp = flusher.Flush
+	}
+
+	// Once the stream has started, there's no way to report an error
+	// other than ending it.
+	r.Tail(req.Context(), opts, func(pos wal.Position, value []byte) error {
+		_, err := fmt.Fprintf(w, "id: %d:%d\ndata: %s\n\n",
+			pos.Segment, pos.Offset, base64.StdEncoding.EncodeToString(value))
+		return err
+	})
+}
@@ -0,0 +1,211 @@
+package walhttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/evanphx/wal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+func TestHandler(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	write := func(opts wal.WriteOptions, values ...string) *wal.WALWriter {
+		w, err := wal.NewWithOptions(path, opts)
+		require.NoError(t, err)
+
+		for _, v := range values {
+			require.NoError(t, w.Write([]byte(v)))
+		}
+
+		return w
+	}
+
+	n.It("lists segments and serves sealed ones", func() {
+		opts := wal.DefaultWriteOptions
+		opts.SegmentSize = 20
+
+		w := write(opts, "this is data", "in the second segment because it is big")
+		defer w.Close()
+
+		srv := httptest.NewServer(NewHandler(path))
+		defer srv.Close()
+
+		resp, err := http.Get(srv.URL + "/segments")
+		require.NoError(t, err)
+
+		var segs []Segment
+		err = json.NewDecoder(resp.Body).Decode(&segs)
+		resp.Body.Close()
+		require.NoError(t, err)
+
+		require.Equal(t, 2, len(segs))
+		assert.True(t, segs[0].Sealed)
+		assert.False(t, segs[1].Sealed)
+
+		resp, err = http.Get(srv.URL + "/segments/0")
+		require.NoError(t, err)
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+
+		disk, err := ioutil.ReadFile(filepath.Join(path, "0"))
+		require.NoError(t, err)
+
+		assert.Equal(t, disk, body)
+
+		resp, err = http.Get(srv.URL + "/segments/1")
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	})
+
+	n.It("tails records as server-sent events", func() {
+		w := write(wal.DefaultWriteOptions, "first", "second")
+		defer w.Close()
+
+		srv := httptest.NewServer(NewHandler(path))
+		defer srv.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, "GET", srv.URL+"/tail", nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		defer resp.Body.Close()
+
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		var ids, data []string
+
+		scan := bufio.NewScanner(resp.Body)
+		for scan.Scan() && len(data) < 2 {
+			line := scan.Text()
+
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				ids = append(ids, line[4:])
+			case strings.HasPrefix(line, "data: "):
+				data = append(data, line[6:])
+			}
+		}
+
+		assert.Equal(t, []string{"Zmlyc3Q=", "c2Vjb25k"}, data)
+
+		cancel()
+
+		// Resume after the first record.
+		req, err = http.NewRequest("GET", srv.URL+"/tail", nil)
+		require.NoError(t, err)
+
+		req.Header.Set("Last-Event-ID", ids[0])
+
+		ctx, cancel = context.WithCancel(context.Background())
+		defer cancel()
+
+		resp, err = http.DefaultClient.Do(req.WithContext(ctx))
+		require.NoError(t, err)
+
+		defer resp.Body.Close()
+
+		scan = bufio.NewScanner(resp.Body)
+		for scan.Scan() {
+			line := scan.Text()
+			if strings.HasPrefix(line, "data: ") {
+				assert.Equal(t, "c2Vjb25k", line[6:])
+				break
+			}
+		}
+	})
+
+	n.It("replicates through a Transport", func() {
+		opts := wal.DefaultWriteOptions
+		opts.SegmentSize = 20
+
+		w := write(opts, "this is data", "in the second segment because it is big")
+		defer w.Close()
+
+		srv := httptest.NewServer(NewHandler(path))
+		defer srv.Close()
+
+		tr := NewTransport(srv.URL, nil)
+
+		var buf bytes.Buffer
+
+		require.NoError(t, tr.FetchSegment(context.Background(), 0, &buf))
+
+		disk, err := ioutil.ReadFile(filepath.Join(path, "0"))
+		require.NoError(t, err)
+
+		assert.Equal(t, disk, buf.Bytes())
+
+		err = tr.FetchSegment(context.Background(), 1, &buf)
+
+		var se *StatusError
+		require.True(t, errors.As(err, &se))
+		assert.Equal(t, http.StatusConflict, se.Code)
+
+		head, err := tr.Head(context.Background())
+		require.NoError(t, err)
+
+		fi, err := os.Stat(filepath.Join(path, "1"))
+		require.NoError(t, err)
+
+		assert.Equal(t, wal.Position{Segment: 1, Offset: fi.Size()}, head)
+
+		rep := wal.NewReplicator(tr, wal.Position{Segment: -1, Offset: -1})
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var (
+			got  []string
+			last wal.Position
+		)
+
+		err = rep.Follow(ctx, func(pos wal.Position, value []byte) error {
+			got = append(got, string(value))
+			last = pos
+			if len(got) == 2 {
+				cancel()
+			}
+
+			return nil
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"this is data", "in the second segment because it is big"}, got)
+		assert.Equal(t, last, rep.Pos())
+	})
+
+	n.Meow()
+}
@@ -0,0 +1,41 @@
+// Package walotel adapts OpenTelemetry tracing to the wal package's
+// Tracer interface.
+package walotel
+
+import (
+	"context"
+
+	"github.com/evanphx/wal"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/evanphx/wal"
+
+// NewTracer returns a wal.Tracer that creates its spans using tp. Set it
+// as the Tracer in wal.WriteOptions or wal.ReadOptions.
+func NewTracer(tp trace.TracerProvider) wal.Tracer {
+	return &tracer{t: tp.Tracer(instrumentationName)}
+}
+
+type tracer struct {
+	t trace.Tracer
+}
+
+func (t *tracer) Start(ctx context.Context, name string) (context.Context, wal.Span) {
+	ctx, s := t.t.Start(ctx, name)
+	return ctx, span{s}
+}
+
+type span struct {
+	s trace.Span
+}
+
+func (s span) SetError(err error) {
+	s.s.RecordError(err)
+	s.s.SetStatus(codes.Error, err.Error())
+}
+
+func (s span) End() {
+	s.s.End()
+}
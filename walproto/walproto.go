@@ -0,0 +1,30 @@
+// Package walproto provides a wal.Codec for protobuf messages, so they
+// can be stored in a wal.TypedWAL.
+package walproto
+
+import (
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec encodes messages of type T, which should be a pointer to a
+// generated message such as *pb.Event.
+type Codec[T proto.Message] struct{}
+
+func (Codec[T]) Encode(v T) ([]byte, error) {
+	return proto.Marshal(v)
+}
+
+func (Codec[T]) Decode(data []byte) (T, error) {
+	var zero T
+
+	// Generated messages report their type even through a nil pointer,
+	// which gives a way to allocate a new one.
+	v := zero.ProtoReflect().Type().New().Interface().(T)
+
+	err := proto.Unmarshal(data, v)
+	if err != nil {
+		return zero, err
+	}
+
+	return v, nil
+}
@@ -0,0 +1,69 @@
+package walproto
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/evanphx/wal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestCodec(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+	})
+
+	n.It("round trips messages", func() {
+		var c Codec[*timestamppb.Timestamp]
+
+		ts := timestamppb.New(time.Unix(1700000000, 42))
+
+		data, err := c.Encode(ts)
+		require.NoError(t, err)
+
+		got, err := c.Decode(data)
+		require.NoError(t, err)
+
+		assert.True(t, proto.Equal(ts, got))
+	})
+
+	n.It("rejects invalid data", func() {
+		var c Codec[*timestamppb.Timestamp]
+
+		_, err := c.Decode([]byte{0xff, 0xff})
+		assert.Error(t, err)
+	})
+
+	n.It("stores messages in a typed WAL", func() {
+		tw, err := wal.OpenTyped[*timestamppb.Timestamp](path, wal.DefaultWriteOptions, Codec[*timestamppb.Timestamp]{})
+		require.NoError(t, err)
+
+		defer tw.Close()
+
+		ts := timestamppb.New(time.Unix(1700000000, 0))
+
+		require.NoError(t, tw.Append(ts))
+
+		got, ok := tw.Next()
+		require.True(t, ok)
+		assert.True(t, proto.Equal(ts, got))
+	})
+
+	n.Meow()
+}
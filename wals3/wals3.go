@@ -0,0 +1,93 @@
+// Package wals3 archives sealed WAL segments to S3, or any S3
+// compatible store, and restores a WAL directory from them.
+package wals3
+
+import (
+	"context"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/evanphx/wal"
+)
+
+// API is the subset of *s3.Client used by the package.
+type API interface {
+	PutObject(ctx context.Context, in *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	GetObject(ctx context.Context, in *s3.GetObjectInput, opts ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	ListObjectsV2(ctx context.Context, in *s3.ListObjectsV2Input, opts ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+}
+
+// Options control where and how segments are archived.
+type Options = wal.BlobArchiveOptions
+
+// Store is a wal.BlobStore backed by an S3 bucket.
+type Store struct {
+	client API
+	bucket string
+}
+
+func NewStore(client API, bucket string) *Store {
+	return &Store{client: client, bucket: bucket}
+}
+
+func (s *Store) Put(ctx context.Context, name string, r io.Reader) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(name),
+		Body:   r,
+	})
+
+	return err
+}
+
+func (s *Store) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(name),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out.Body, nil
+}
+
+func (s *Store) List(ctx context.Context, prefix string) ([]string, error) {
+	in := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}
+
+	var names []string
+
+	for {
+		out, err := s.client.ListObjectsV2(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range out.Contents {
+			names = append(names, aws.ToString(obj.Key))
+		}
+
+		if !aws.ToBool(out.IsTruncated) {
+			return names, nil
+		}
+
+		in.ContinuationToken = out.NextContinuationToken
+	}
+}
+
+// NewArchiver returns a wal.Archiver that uploads sealed segments to
+// bucket in the background. Set it as the Archiver in wal.WriteOptions
+// and call Wait before exiting to let uploads finish.
+func NewArchiver(client API, bucket string, opts Options) *wal.BlobArchiver {
+	return wal.NewBlobArchiver(NewStore(client, bucket), opts)
+}
+
+// Restore downloads every segment archived under prefix in bucket into
+// dir, recreating a WAL directory that can be opened with wal.NewReader.
+func Restore(ctx context.Context, client API, bucket, prefix, dir string) error {
+	return wal.RestoreBlobs(ctx, NewStore(client, bucket), prefix, dir)
+}
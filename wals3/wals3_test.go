@@ -0,0 +1,145 @@
+package wals3
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/evanphx/wal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+type fakeS3 struct {
+	lock    sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) PutObject(ctx context.Context, in *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	data, err := ioutil.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.objects[aws.ToString(in.Key)] = data
+
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) GetObject(ctx context.Context, in *s3.GetObjectInput, opts ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	data := f.objects[aws.ToString(in.Key)]
+
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func (f *fakeS3) ListObjectsV2(ctx context.Context, in *s3.ListObjectsV2Input, opts ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var keys []string
+
+	for k := range f.objects {
+		if strings.HasPrefix(k, aws.ToString(in.Prefix)) {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Strings(keys)
+
+	out := &s3.ListObjectsV2Output{IsTruncated: aws.Bool(false)}
+
+	for _, k := range keys {
+		out.Contents = append(out.Contents, types.Object{Key: aws.String(k)})
+	}
+
+	return out, nil
+}
+
+func TestS3(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wal")
+	restored := filepath.Join(dir, "restored")
+
+	n.Setup(func() {
+		os.RemoveAll(path)
+		os.RemoveAll(restored)
+	})
+
+	for _, compress := range []bool{false, true} {
+		compress := compress
+
+		n.It("archives sealed segments and restores them", func() {
+			fake := &fakeS3{objects: make(map[string][]byte)}
+
+			a := NewArchiver(fake, "bucket", Options{
+				Prefix:      "wal/",
+				Compress:    compress,
+				Concurrency: 2,
+			})
+
+			opts := wal.DefaultWriteOptions
+			opts.SegmentSize = 20
+			opts.MaxSegments = 1
+			opts.Archiver = a
+
+			w, err := wal.NewWithOptions(path, opts)
+			require.NoError(t, err)
+
+			err = w.Write([]byte("this is data"))
+			require.NoError(t, err)
+
+			err = w.Write([]byte("in the second segment because this is a bigger value"))
+			require.NoError(t, err)
+
+			err = w.Write([]byte("in the third segment because this is a bigger value"))
+			require.NoError(t, err)
+
+			require.NoError(t, w.Close())
+
+			require.NoError(t, a.Wait())
+
+			assert.Equal(t, 2, len(fake.objects))
+
+			err = Restore(context.Background(), fake, "bucket", "wal/", restored)
+			require.NoError(t, err)
+
+			r, err := wal.NewReader(restored)
+			require.NoError(t, err)
+
+			defer r.Close()
+
+			require.True(t, r.Next())
+			assert.Equal(t, "this is data", string(r.Value()))
+
+			require.True(t, r.Next())
+			assert.Equal(t, "in the second segment because this is a bigger value", string(r.Value()))
+
+			assert.False(t, r.Next())
+		})
+	}
+
+	n.Meow()
+}
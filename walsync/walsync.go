@@ -0,0 +1,612 @@
+// Package walsync ships a WAL from a primary to a warm-standby replica
+// over any stream connection. The replica opens the connection by
+// reporting the position it already holds, and the primary streams only
+// the segment bytes after it, in checksummed chunks. When a segment is
+// sealed on the primary, the replica applies it with a wal.Follower, so
+// the replica's directory only ever contains intact segments. A replica
+// that reconnects resumes from the last chunk it received.
+//
+// The protocol is:
+//
+//	replica: "WALSYNC1" varint(segment) uvarint(offset)
+//	primary: 'c' uvarint(segment) uvarint(offset) uvarint(len) data crc32(data)
+//	         's' uvarint(segment)
+//	         'x' uvarint(len) message
+//
+// A segment of -1 in the handshake asks for the oldest segment the
+// primary has. A 'c' frame carries the next bytes of a segment, an 's'
+// frame says the segment is complete, and an 'x' frame reports an error
+// that ends the stream.
+package walsync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/evanphx/wal"
+)
+
+const magic = "WALSYNC1"
+
+const (
+	chunkFrame = 'c'
+	sealFrame  = 's'
+	errorFrame = 'x'
+)
+
+var (
+	ErrBadHandshake = errors.New("walsync: bad handshake")
+	ErrBadFrame     = errors.New("walsync: bad frame")
+	ErrChecksum     = errors.New("walsync: chunk checksum mismatch")
+	ErrOutOfSync    = errors.New("walsync: chunk does not follow the replica's position")
+	ErrUnavailable  = errors.New("walsync: requested segment is no longer available")
+)
+
+// RemoteError is an error reported by the primary.
+type RemoteError struct {
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return "walsync: primary: " + e.Message
+}
+
+// How often a primary checks for new data once a replica has caught up.
+const DefaultPollInterval = 100 * time.Millisecond
+
+// The largest chunk a primary sends.
+const DefaultChunkSize = 64 * 1024
+
+// closeOnDone closes conn when ctx is done, so that blocked reads and
+// writes return. The returned function stops watching.
+func closeOnDone(ctx context.Context, conn io.ReadWriter) func() {
+	c, ok := conn.(io.Closer)
+	if !ok {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+type Primary struct {
+	root string
+
+	// How often to check for new data once a replica has caught up.
+	PollInterval time.Duration
+
+	// The largest chunk to send at once.
+	ChunkSize int
+}
+
+// NewPrimary returns a Primary that ships the WAL in root.
+func NewPrimary(root string) *Primary {
+	return &Primary{
+		root:         root,
+		PollInterval: DefaultPollInterval,
+		ChunkSize:    DefaultChunkSize,
+	}
+}
+
+// Serve reads a replica's handshake from conn and streams the WAL to it
+// until ctx is done or the connection fails.
+func (p *Primary) Serve(ctx context.Context, conn io.ReadWriter) error {
+	defer closeOnDone(ctx, conn)()
+
+	br := bufio.NewReader(conn)
+
+	hdr := make([]byte, len(magic))
+
+	_, err := io.ReadFull(br, hdr)
+	if err != nil {
+		return err
+	}
+
+	if string(hdr) != magic {
+		return ErrBadHandshake
+	}
+
+	index, err := binary.ReadVarint(br)
+	if err != nil {
+		return ErrBadHandshake
+	}
+
+	offset, err := binary.ReadUvarint(br)
+	if err != nil {
+		return ErrBadHandshake
+	}
+
+	bw := bufio.NewWriter(conn)
+
+	err = p.stream(ctx, bw, int(index), int64(offset))
+	if err != nil && ctx.Err() == nil {
+		if err == ErrUnavailable {
+			writeError(bw, err.Error())
+			bw.Flush()
+		}
+
+		return err
+	}
+
+	return ctx.Err()
+}
+
+func (p *Primary) stream(ctx context.Context, bw *bufio.Writer, index int, offset int64) error {
+	chunkSize := p.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	buf := make([]byte, chunkSize)
+
+	if index < 0 {
+		first, _, err := p.segmentRange()
+		if err != nil {
+			return err
+		}
+
+		index = first
+		if index < 0 {
+			index = 0
+		}
+
+		offset = 0
+	}
+
+	for {
+		f, err := os.Open(p.segmentPath(index))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return err
+			}
+
+			// A segment the primary has moved past has been pruned, but
+			// one it hasn't reached yet is waited for.
+			_, last, err := p.segmentRange()
+			if err != nil {
+				return err
+			}
+
+			if index < last {
+				return ErrUnavailable
+			}
+
+			err = p.wait(ctx)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		err = p.streamSegment(ctx, bw, f, index, offset, buf)
+		f.Close()
+
+		if err != nil {
+			return err
+		}
+
+		index++
+		offset = 0
+	}
+}
+
+// streamSegment sends the segment in f from offset until it's sealed.
+func (p *Primary) streamSegment(ctx context.Context, bw *bufio.Writer, f *os.File, index int, offset int64, buf []byte) error {
+	for {
+		// A segment is sealed once the next one exists, because the writer
+		// closes a segment before creating the next. Checking before
+		// reading means that reaching the end of a sealed segment is
+		// reaching the end of its data.
+		_, err := os.Stat(p.segmentPath(index + 1))
+		sealed := err == nil
+
+		// Until then, the writer may only have closed it for now, and
+		// would replace the closing magic with new entries when it
+		// reopened it, so stop short of it.
+		end := int64(-1)
+
+		if !sealed {
+			end, err = cleanEnd(f)
+			if err != nil {
+				return err
+			}
+		}
+
+		for end == -1 || offset < end {
+			chunk := buf
+			if end != -1 && end-offset < int64(len(chunk)) {
+				chunk = chunk[:end-offset]
+			}
+
+			n, err := f.ReadAt(chunk, offset)
+			if n > 0 {
+				err := writeChunk(bw, index, offset, buf[:n])
+				if err != nil {
+					return err
+				}
+
+				offset += int64(n)
+			}
+
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if sealed {
+			err = writeFrame(bw, sealFrame, uint64(index))
+			if err != nil {
+				return err
+			}
+
+			return bw.Flush()
+		}
+
+		err = bw.Flush()
+		if err != nil {
+			return err
+		}
+
+		err = p.wait(ctx)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// The bytes the wal package ends a cleanly closed segment with.
+var closingMagic = []byte("\x00this segment was closed properly\x42")
+
+// cleanEnd returns where the entries in the segment in f end, leaving out
+// a closing magic at the end of it, or as much of one as has been written
+// so far.
+func cleanEnd(f *os.File) (int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	start := fi.Size() - int64(len(closingMagic))
+	if start < 0 {
+		start = 0
+	}
+
+	tail := make([]byte, fi.Size()-start)
+
+	n, err := f.ReadAt(tail, start)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	tail = tail[:n]
+
+	for i := range tail {
+		if bytes.HasPrefix(closingMagic, tail[i:]) {
+			return start + int64(i), nil
+		}
+	}
+
+	return start + int64(n), nil
+}
+
+func (p *Primary) wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(p.PollInterval):
+		return nil
+	}
+}
+
+// segmentRange returns the indexes of the oldest and newest segments,
+// or -1 for both if there are none.
+func (p *Primary) segmentRange() (int, int, error) {
+	f, err := os.Open(p.root)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer f.Close()
+
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	first, last := -1, -1
+
+	for _, name := range names {
+		i, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+
+		if first == -1 || i < first {
+			first = i
+		}
+
+		if i > last {
+			last = i
+		}
+	}
+
+	return first, last, nil
+}
+
+func (p *Primary) segmentPath(index int) string {
+	return filepath.Join(p.root, strconv.Itoa(index))
+}
+
+func writeFrame(w *bufio.Writer, t byte, vals ...uint64) error {
+	buf := []byte{t}
+
+	for _, v := range vals {
+		buf = binary.AppendUvarint(buf, v)
+	}
+
+	_, err := w.Write(buf)
+	return err
+}
+
+func writeChunk(w *bufio.Writer, index int, offset int64, data []byte) error {
+	err := writeFrame(w, chunkFrame, uint64(index), uint64(offset), uint64(len(data)))
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(binary.BigEndian.AppendUint32(nil, crc32.ChecksumIEEE(data)))
+	return err
+}
+
+func writeError(w *bufio.Writer, msg string) error {
+	err := writeFrame(w, errorFrame, uint64(len(msg)))
+	if err != nil {
+		return err
+	}
+
+	_, err = w.WriteString(msg)
+	return err
+}
+
+// Replica keeps a warm-standby copy of a primary's WAL in a local
+// directory. Sealed segments are applied with a wal.Follower, while the
+// segment the primary is still writing is staged in a partial file that
+// isn't part of the WAL until it's sealed.
+type Replica struct {
+	root string
+	f    *wal.Follower
+
+	// The segment being received and how much of it has arrived.
+	index  int
+	offset int64
+}
+
+const partialPrefix = "partial."
+
+// NewReplica opens, or creates, the replica directory root, picking up
+// any partially received segment.
+func NewReplica(root string) (*Replica, error) {
+	f, err := wal.NewFollower(root)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Replica{root: root, f: f, index: f.Next()}
+
+	matches, err := filepath.Glob(filepath.Join(root, partialPrefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range matches {
+		index, err := strconv.Atoi(filepath.Base(path)[len(partialPrefix):])
+		if err != nil {
+			continue
+		}
+
+		// Anything other than the next segment is left over from before
+		// a segment was applied.
+		if r.index != -1 && index != r.index {
+			os.Remove(path)
+			continue
+		}
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		r.index = index
+		r.offset = fi.Size()
+	}
+
+	return r, nil
+}
+
+// Pos returns the position the replica has received up to, which is
+// what it reports to the primary when it connects. A Segment of -1 means
+// nothing has been received.
+func (r *Replica) Pos() wal.Position {
+	return wal.Position{Segment: r.index, Offset: r.offset}
+}
+
+// Sync sends the replica's position over conn and applies what the
+// primary streams back until ctx is done or the connection fails. Call
+// it again with a new connection to resume.
+func (r *Replica) Sync(ctx context.Context, conn io.ReadWriter) error {
+	defer closeOnDone(ctx, conn)()
+
+	hs := append([]byte(magic), binary.AppendVarint(nil, int64(r.index))...)
+	hs = binary.AppendUvarint(hs, uint64(r.offset))
+
+	_, err := conn.Write(hs)
+	if err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(conn)
+
+	var partial *os.File
+
+	defer func() {
+		if partial != nil {
+			partial.Close()
+		}
+	}()
+
+	for {
+		err := r.apply(br, &partial)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			return err
+		}
+	}
+}
+
+func (r *Replica) apply(br *bufio.Reader, partial **os.File) error {
+	t, err := br.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	switch t {
+	case chunkFrame:
+		index, err := binary.ReadUvarint(br)
+		if err != nil {
+			return ErrBadFrame
+		}
+
+		offset, err := binary.ReadUvarint(br)
+		if err != nil {
+			return ErrBadFrame
+		}
+
+		size, err := binary.ReadUvarint(br)
+		if err != nil {
+			return ErrBadFrame
+		}
+
+		data := make([]byte, size+4)
+
+		_, err = io.ReadFull(br, data)
+		if err != nil {
+			return err
+		}
+
+		if crc32.ChecksumIEEE(data[:size]) != binary.BigEndian.Uint32(data[size:]) {
+			return ErrChecksum
+		}
+
+		// The first chunk received sets which segment the replica starts
+		// with.
+		if r.index == -1 && offset == 0 {
+			r.index = int(index)
+		}
+
+		if int(index) != r.index || int64(offset) != r.offset {
+			return ErrOutOfSync
+		}
+
+		if *partial == nil {
+			*partial, err = os.OpenFile(r.partialPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = (*partial).Write(data[:size])
+		if err != nil {
+			return err
+		}
+
+		r.offset += int64(size)
+	case sealFrame:
+		index, err := binary.ReadUvarint(br)
+		if err != nil {
+			return ErrBadFrame
+		}
+
+		if int(index) != r.index {
+			return ErrOutOfSync
+		}
+
+		if *partial != nil {
+			err = (*partial).Close()
+			*partial = nil
+
+			if err != nil {
+				return err
+			}
+		}
+
+		path := r.partialPath()
+
+		err = r.f.ApplySegmentFile(r.index, path)
+		if err != nil {
+			return err
+		}
+
+		os.Remove(path)
+
+		r.index++
+		r.offset = 0
+	case errorFrame:
+		size, err := binary.ReadUvarint(br)
+		if err != nil {
+			return ErrBadFrame
+		}
+
+		msg := make([]byte, size)
+
+		_, err = io.ReadFull(br, msg)
+		if err != nil {
+			return err
+		}
+
+		if string(msg) == ErrUnavailable.Error() {
+			return ErrUnavailable
+		}
+
+		return &RemoteError{Message: string(msg)}
+	default:
+		return fmt.Errorf("%w: unknown frame type %q", ErrBadFrame, t)
+	}
+
+	return nil
+}
+
+func (r *Replica) partialPath() string {
+	return filepath.Join(r.root, partialPrefix+strconv.Itoa(r.index))
+}
@@ -0,0 +1,234 @@
+package walsync
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/evanphx/wal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektra/neko"
+)
+
+// waitFor polls cond until it's true, failing the test if that takes
+// too long.
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSync(t *testing.T) {
+	n := neko.Start(t)
+
+	dir, err := ioutil.TempDir("", "walsync")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	primary := filepath.Join(dir, "primary")
+	replica := filepath.Join(dir, "replica")
+
+	n.Setup(func() {
+		os.RemoveAll(primary)
+		os.RemoveAll(replica)
+	})
+
+	opts := wal.DefaultWriteOptions
+	opts.SegmentSize = 20
+
+	// connect runs a sync between p and r over an in-memory connection
+	// until stop is called.
+	connect := func(p *Primary, r *Replica) (stop func() error) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		a, b := net.Pipe()
+
+		go p.Serve(ctx, a)
+
+		errs := make(chan error, 1)
+
+		go func() {
+			errs <- r.Sync(ctx, b)
+		}()
+
+		return func() error {
+			cancel()
+			return <-errs
+		}
+	}
+
+	// readAll returns the values in root, or none if nothing has been
+	// applied yet.
+	readAll := func(root string) []string {
+		r, err := wal.NewReader(root)
+		if err != nil {
+			return nil
+		}
+
+		defer r.Close()
+
+		var vals []string
+
+		for r.Next() {
+			vals = append(vals, string(r.Value()))
+		}
+
+		return vals
+	}
+
+	n.It("ships sealed segments to the replica", func() {
+		w, err := wal.NewWithOptions(primary, opts)
+		require.NoError(t, err)
+
+		defer w.Close()
+
+		require.NoError(t, w.Write([]byte("this is the first value")))
+		require.NoError(t, w.Write([]byte("this is the second value")))
+		require.NoError(t, w.Write([]byte("this is the third value")))
+
+		p := NewPrimary(primary)
+		p.PollInterval = time.Millisecond
+
+		r, err := NewReplica(replica)
+		require.NoError(t, err)
+
+		assert.Equal(t, -1, r.Pos().Segment)
+
+		stop := connect(p, r)
+
+		waitFor(t, func() bool {
+			return len(readAll(replica)) == 2
+		})
+
+		assert.Equal(t, context.Canceled, stop())
+
+		assert.Equal(t, []string{"this is the first value", "this is the second value"}, readAll(replica))
+	})
+
+	n.It("resumes from the replica's position", func() {
+		w, err := wal.NewWithOptions(primary, opts)
+		require.NoError(t, err)
+
+		defer w.Close()
+
+		require.NoError(t, w.Write([]byte("this is the first value")))
+		require.NoError(t, w.Write([]byte("this is the second value")))
+
+		p := NewPrimary(primary)
+		p.PollInterval = time.Millisecond
+		p.ChunkSize = 7
+
+		r, err := NewReplica(replica)
+		require.NoError(t, err)
+
+		stop := connect(p, r)
+
+		waitFor(t, func() bool {
+			fi, err := os.Stat(filepath.Join(replica, "partial.2"))
+			return len(readAll(replica)) == 1 && err == nil && fi.Size() > 0
+		})
+
+		stop()
+
+		// The segment being written has been partly received.
+		pos := r.Pos()
+		assert.True(t, pos.Offset > 0)
+
+		require.NoError(t, w.Write([]byte("this is the third value")))
+
+		r, err = NewReplica(replica)
+		require.NoError(t, err)
+
+		assert.Equal(t, pos, r.Pos())
+
+		stop = connect(p, r)
+
+		waitFor(t, func() bool {
+			return len(readAll(replica)) == 2
+		})
+
+		stop()
+
+		assert.Equal(t, []string{"this is the first value", "this is the second value"}, readAll(replica))
+	})
+
+	n.It("doesn't ship the closing magic of a segment that's reopened", func() {
+		o := opts
+		o.SegmentSize = 1024
+
+		w, err := wal.NewWithOptions(primary, o)
+		require.NoError(t, err)
+
+		require.NoError(t, w.Write([]byte("this is the first value")))
+		require.NoError(t, w.Close())
+
+		p := NewPrimary(primary)
+		p.PollInterval = time.Millisecond
+
+		r, err := NewReplica(replica)
+		require.NoError(t, err)
+
+		stop := connect(p, r)
+		defer stop()
+
+		waitFor(t, func() bool {
+			fi, err := os.Stat(filepath.Join(replica, "partial.0"))
+			return err == nil && fi.Size() > 0
+		})
+
+		w, err = wal.NewWithOptions(primary, o)
+		require.NoError(t, err)
+
+		defer w.Close()
+
+		require.NoError(t, w.Write([]byte("this is the second value")))
+		require.NoError(t, w.Write(make([]byte, 1024)))
+
+		waitFor(t, func() bool {
+			return len(readAll(replica)) == 2
+		})
+
+		assert.Equal(t, []string{"this is the first value", "this is the second value"}, readAll(replica))
+	})
+
+	n.It("reports segments that have been pruned", func() {
+		o := opts
+		o.MaxSegments = 1
+
+		w, err := wal.NewWithOptions(primary, o)
+		require.NoError(t, err)
+
+		defer w.Close()
+
+		require.NoError(t, w.Write([]byte("this is the first value")))
+		require.NoError(t, w.Write([]byte("this is the second value")))
+		require.NoError(t, w.Write([]byte("this is the third value")))
+
+		r, err := NewReplica(replica)
+		require.NoError(t, err)
+
+		// Pretend the replica already holds the first segment.
+		r.index = 0
+
+		a, b := net.Pipe()
+
+		go NewPrimary(primary).Serve(context.Background(), a)
+
+		err = r.Sync(context.Background(), b)
+		assert.Equal(t, ErrUnavailable, err)
+	})
+
+	n.Meow()
+}